@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A Reservation is quota tentatively consumed for an operation by Acquire.
+// The operation may proceed at the reservation's time, after which the caller
+// either commits the reservation, if the operation was performed, or rolls it
+// back, if the operation was abandoned, e.g., due to a local error before a
+// request was sent, so that the quota is returned to the limiter:
+//
+//	res, err := ratelimit.Acquire(lim, time.Now())
+//	if err != nil {
+//		return err
+//	}
+//	time.Sleep(time.Until(res.Time))
+//	req, err := build()
+//	if err != nil {
+//		res.Rollback(time.Now())
+//		return err
+//	}
+//	res.Commit()
+//
+// A reservation is settled by whichever of Commit or Rollback is called first;
+// subsequent calls have no effect. If the limiter is configured with
+// ReclaimAfter, a reservation which is not settled in time is rolled back by
+// the limiter, so that a holder which crashed or stalled does not strand its
+// quota.
+type Reservation struct {
+	Time    time.Time // when the operation may proceed
+	refund  func(time.Time) bool
+	settled atomic.Bool
+}
+
+// Create a reservation; if the refund function is nil, the quota consumed
+// cannot be returned
+func newReservation(t time.Time, refund func(time.Time) bool) *Reservation {
+	return &Reservation{Time: t, refund: refund}
+}
+
+// Commit confirms that the operation was performed and that the quota it
+// consumed is spent
+func (r *Reservation) Commit() {
+	r.settled.Store(true)
+}
+
+// Rollback returns the quota consumed by an operation which was not
+// performed, relative to the provided time. The result is true if the quota was returned; it cannot be when
+// the reservation consumed none, when the limiter has since been updated with
+// state which already accounts for it, or when the limiter does not support
+// returning quota.
+func (r *Reservation) Rollback(rel time.Time) bool {
+	if !r.settled.CompareAndSwap(false, true) || r.refund == nil {
+		return false
+	}
+	return r.refund(rel)
+}
+
+// An Acquirer is a limiter which supports two-phase consumption of its quota
+type Acquirer interface {
+	// Acquire tentatively consumes quota for an operation relative to the provided time.
+	Acquire(time.Time, ...Option) (*Reservation, error)
+}
+
+// Ensure our implementations conform to the Acquirer interface
+var (
+	_ Acquirer = (*headers)(nil)
+)
+
+// Acquire tentatively consumes quota for an operation from the provided
+// limiter. If the limiter does not implement Acquirer, quota is consumed via
+// Next and cannot be returned; rolling back the reservation has no effect.
+func Acquire(lim Limiter, rel time.Time, opts ...Option) (*Reservation, error) {
+	if v, ok := lim.(Acquirer); ok {
+		return v.Acquire(rel, opts...)
+	}
+	t, err := lim.Next(rel, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newReservation(t, nil), nil
+}
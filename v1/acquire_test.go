@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquire(t *testing.T) {
+	now := time.Now()
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst})
+
+	// rolled back, the quota is returned
+	res, err := Acquire(lim, now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, res.Time)
+		assert.Equal(t, 9, lim.State(now).Remaining)
+		assert.True(t, res.Rollback(now))
+		assert.Equal(t, 10, lim.State(now).Remaining)
+		assert.False(t, res.Rollback(now)) // already settled
+		assert.Equal(t, 10, lim.State(now).Remaining)
+	}
+
+	// committed, the quota is spent
+	res, err = Acquire(lim, now)
+	if assert.NoError(t, err) {
+		res.Commit()
+		assert.False(t, res.Rollback(now))
+		assert.Equal(t, 9, lim.State(now).Remaining)
+	}
+
+	// an update which replaces the quota already accounts for the operation
+	res, err = Acquire(lim, now)
+	if assert.NoError(t, err) {
+		assert.NoError(t, lim.Update(now, WithAttrs(Attrs{
+			"X-Ratelimit-Limit":     []string{"10"},
+			"X-Ratelimit-Remaining": []string{"9"},
+			"X-Ratelimit-Reset":     []string{"60"},
+		})))
+		assert.False(t, res.Rollback(now))
+		assert.Equal(t, 9, lim.State(now).Remaining)
+	}
+
+	// a delayed operation which consumed nothing returns nothing
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 1, Mode: Burst})
+	_, err = lim.Next(now)
+	assert.NoError(t, err)
+	res, err = Acquire(lim, now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Minute), res.Time)
+		assert.False(t, res.Rollback(now))
+		assert.Equal(t, 0, lim.State(now).Remaining)
+	}
+
+	// the quota is returned relative to the time of the rollback
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, History: 10})
+	res, err = Acquire(lim, now)
+	if assert.NoError(t, err) {
+		assert.True(t, res.Rollback(now.Add(time.Second*5)))
+		evs := lim.History(1)
+		if assert.Len(t, evs, 1) {
+			assert.Equal(t, Refunded, evs[0].Kind)
+			assert.Equal(t, now.Add(time.Second*5), evs[0].Time)
+		}
+	}
+
+	// limiters which don't support it consume quota irrevocably
+	res, err = Acquire(NewLinear(Config{Start: now, Window: time.Minute, Events: 60}), now)
+	if assert.NoError(t, err) {
+		assert.False(t, res.Rollback(now))
+	}
+}
+
+func TestReclaim(t *testing.T) {
+	now := time.Now()
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ReclaimAfter: time.Second * 10})
+
+	var res []*Reservation
+	for i := 0; i < 3; i++ {
+		r, err := Acquire(lim, now)
+		if assert.NoError(t, err, "#%d", i) {
+			res = append(res, r)
+		}
+	}
+	res[0].Commit()
+	assert.True(t, res[1].Rollback(now))
+	assert.Equal(t, 8, lim.State(now).Remaining)
+
+	// reservations are not reclaimed before they are stale
+	assert.Equal(t, 0, lim.Reclaim(now.Add(time.Second*9)))
+	assert.Equal(t, 8, lim.State(now).Remaining)
+
+	// the reservation which was never settled is reclaimed
+	assert.Equal(t, 1, lim.Reclaim(now.Add(time.Second*10)))
+	assert.Equal(t, 9, lim.State(now).Remaining)
+	assert.False(t, res[2].Rollback(now)) // already settled by reclamation
+
+	// stale reservations are reclaimed when quota is consumed
+	_, err := Acquire(lim, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, lim.State(now).Remaining)
+	_, err = lim.Next(now.Add(time.Second * 20))
+	assert.NoError(t, err)
+	assert.Equal(t, 8, lim.State(now).Remaining)
+
+	// without a threshold, reservations are held until they are settled
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst})
+	_, err = Acquire(lim, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, lim.Reclaim(now.Add(time.Hour)))
+	assert.Equal(t, 9, lim.State(now).Remaining)
+}
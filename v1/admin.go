@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Look up a registered limiter
+func (r *Registry) lookup(name string) (Limiter, error) {
+	l, ok := r.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotRegistered, name)
+	}
+	return l, nil
+}
+
+// Pause holds new callers to Wait on the named limiter until it is resumed
+func (r *Registry) Pause(name string) error {
+	l, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	v, ok := l.(interface{ Pause() })
+	if !ok {
+		return fmt.Errorf("%w: %s cannot be paused", errors.ErrUnsupported, name)
+	}
+	v.Pause()
+	return nil
+}
+
+// Resume releases the callers held while the named limiter was paused
+func (r *Registry) Resume(name string) error {
+	l, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	v, ok := l.(interface{ Resume() })
+	if !ok {
+		return fmt.Errorf("%w: %s cannot be resumed", errors.ErrUnsupported, name)
+	}
+	v.Resume()
+	return nil
+}
+
+// Backoff imposes a backoff period on the named limiter which ends at the
+// provided time. If the time is zero, an incrementally increasing backoff is
+// imposed instead, as if an operation had failed. The time at which the
+// backoff ends is returned.
+func (r *Registry) Backoff(name string, until time.Time) (time.Time, error) {
+	l, err := r.lookup(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if until.IsZero() {
+		v, ok := l.(interface {
+			Backoff(time.Time) (time.Time, error)
+		})
+		if !ok {
+			return time.Time{}, fmt.Errorf("%w: %s cannot back off", errors.ErrUnsupported, name)
+		}
+		return v.Backoff(time.Now())
+	}
+	v, ok := l.(interface{ BackoffUntil(time.Time) error })
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %s cannot back off", errors.ErrUnsupported, name)
+	}
+	return until, v.BackoffUntil(until)
+}
+
+// ClearBackoff clears any backoff period in effect on the named limiter
+func (r *Registry) ClearBackoff(name string) error {
+	l, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	v, ok := l.(interface{ InvalidateBackoff() error })
+	if !ok {
+		return fmt.Errorf("%w: %s cannot clear its backoff", errors.ErrUnsupported, name)
+	}
+	return v.InvalidateBackoff()
+}
+
+// SetTarget overrides the proportion of its rate or quota that the named
+// limiter targets; zero restores its configured behavior
+func (r *Registry) SetTarget(name string, v float64) error {
+	l, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	t, ok := l.(interface{ SetTarget(float64) })
+	if !ok {
+		return fmt.Errorf("%w: %s does not support a target", errors.ErrUnsupported, name)
+	}
+	t.SetTarget(v)
+	return nil
+}
+
+// AdminHandler serves an API to override the behavior of the limiters in a
+// registry at runtime, or the default registry if it is nil. Requests are
+// made with POST and identify the limiter with the 'name' query parameter and
+// the override with the 'action' parameter, which is one of:
+//
+//   - pause: hold new callers until the limiter is resumed
+//   - resume: release the callers held while paused
+//   - backoff: back off for the duration in the 'for' parameter, e.g. '5m',
+//     or incrementally if it is omitted
+//   - clear: clear any backoff in effect
+//   - target: target the proportion of the rate in the 'value' parameter
+//
+// The response is a JSON snapshot of the limiter, as served by DebugHandler.
+func AdminHandler(reg *Registry) http.Handler {
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := req.URL.Query()
+		name, action := q.Get("name"), q.Get("action")
+
+		var err error
+		switch action {
+		case "pause":
+			err = reg.Pause(name)
+		case "resume":
+			err = reg.Resume(name)
+		case "backoff":
+			var until time.Time
+			if v := q.Get("for"); v != "" {
+				d, perr := time.ParseDuration(v)
+				if perr != nil {
+					http.Error(w, "Invalid backoff duration: "+v, http.StatusBadRequest)
+					return
+				}
+				until = time.Now().Add(d)
+			}
+			_, err = reg.Backoff(name, until)
+		case "clear":
+			err = reg.ClearBackoff(name)
+		case "target":
+			v, perr := strconv.ParseFloat(q.Get("value"), 64)
+			if perr != nil || v < 0 {
+				http.Error(w, "Invalid target: "+q.Get("value"), http.StatusBadRequest)
+				return
+			}
+			err = reg.SetTarget(name, v)
+		default:
+			http.Error(w, "Invalid action: "+action, http.StatusBadRequest)
+			return
+		}
+		switch {
+		case errors.Is(err, ErrNotRegistered):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errors.ErrUnsupported):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		l, _ := reg.Get(name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(debugSnapshot(NamedLimiter{Name: name, Limiter: l}, time.Now(), defaultDebugHistory))
+	})
+}
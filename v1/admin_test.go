@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPause(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst})
+	lim.Pause()
+	assert.True(t, lim.Paused())
+
+	// a caller is held while paused and canceled by its context
+	cxt, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := lim.Wait(cxt, time.Now())
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.Equal(t, 0, lim.Pending())
+
+	// a caller is released when resumed
+	done := make(chan error)
+	go func() {
+		_, err := lim.Wait(context.Background(), time.Now())
+		done <- err
+	}()
+	assert.Eventually(t, func() bool { return lim.Pending() == 1 }, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Caller was not held")
+	default:
+	}
+	lim.Resume()
+	assert.False(t, lim.Paused())
+	assert.NoError(t, <-done)
+}
+
+func TestAdminHandler(t *testing.T) {
+	reg := NewRegistry()
+	hdr := NewHeaders(Config{Window: time.Minute, Events: 10})
+	lin := NewLinear(Config{Window: time.Minute, Events: 60})
+	reg.Register("headers", hdr)
+	reg.Register("linear", lin)
+	reg.Register("keyed", NewKeyed(func(string) Limiter { return NewLinear(Config{Window: time.Minute, Events: 60}) }))
+	handler := AdminHandler(reg)
+
+	tests := []struct {
+		Method string
+		URL    string
+		Status int
+		Check  func(*testing.T)
+	}{
+		{"GET", "/?name=headers&action=pause", http.StatusMethodNotAllowed, nil},
+		{"POST", "/?name=missing&action=pause", http.StatusNotFound, nil},
+		{"POST", "/?name=headers&action=explode", http.StatusBadRequest, nil},
+		{"POST", "/?name=headers&action=pause", http.StatusOK, func(t *testing.T) { assert.True(t, hdr.Paused()) }},
+		{"POST", "/?name=headers&action=resume", http.StatusOK, func(t *testing.T) { assert.False(t, hdr.Paused()) }},
+		{"POST", "/?name=headers&action=backoff&for=5m", http.StatusOK, func(t *testing.T) {
+			assert.WithinDuration(t, time.Now().Add(time.Minute*5), hdr.BackoffEnd(), time.Second)
+		}},
+		{"POST", "/?name=headers&action=backoff&for=soon", http.StatusBadRequest, nil},
+		{"POST", "/?name=headers&action=clear", http.StatusOK, func(t *testing.T) { assert.True(t, hdr.BackoffEnd().IsZero()) }},
+		{"POST", "/?name=linear&action=backoff", http.StatusOK, func(t *testing.T) { assert.True(t, lin.BackoffEnd().After(time.Now())) }},
+		{"POST", "/?name=linear&action=target&value=0.5", http.StatusOK, func(t *testing.T) { assert.Equal(t, 30, lin.State(time.Now()).Limit) }},
+		{"POST", "/?name=linear&action=target&value=fast", http.StatusBadRequest, nil},
+		{"POST", "/?name=keyed&action=target&value=0.5", http.StatusNotImplemented, nil},
+	}
+	for _, e := range tests {
+		t.Run(e.Method+" "+e.URL, func(t *testing.T) {
+			rsp := httptest.NewRecorder()
+			handler.ServeHTTP(rsp, httptest.NewRequest(e.Method, e.URL, nil))
+			assert.Equal(t, e.Status, rsp.Code, rsp.Body.String())
+			if e.Check != nil {
+				e.Check(t)
+			}
+		})
+	}
+}
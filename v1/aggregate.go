@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+)
+
+// An Aggregator coalesces bursts of events, such as outbound notifications,
+// so that they are delivered no faster than a limiter permits. Events are
+// grouped by key, e.g., a user ID, which is provided to the limiter via
+// WithKey. When an event is added and its key may be delivered immediately,
+// it is; otherwise it is held along with any other events which arrive for
+// the same key until the limiter permits a delivery, at which point all of
+// them are delivered together as one aggregate:
+//
+//	// at most one email per user per 10 minutes, summarizing the rest
+//	store := NewMemoryStore()
+//	lim := NewKeyed(func(key string) Limiter {
+//		return NewShared(Config{Window: 10 * time.Minute, Events: 1}, store, key)
+//	})
+//	agg := NewAggregator(cxt, lim, func(cxt context.Context, user string, events []Event) error {
+//		return sendSummary(cxt, user, events)
+//	})
+//	err := agg.Add(user, event)
+//
+// Each delivery is executed as if by Do; if it fails with a RetryError, the
+// same aggregate is delivered again after the indicated time, up to the
+// number of attempts configured via WithAttempts. Other failures are not
+// retried, and the delivery function is responsible for reporting them.
+type Aggregator[E any] struct {
+	cxt     context.Context
+	lim     Limiter
+	conf    ExecutorConfig
+	deliver func(context.Context, string, []E) error
+	wg      sync.WaitGroup
+
+	sync.Mutex
+	pending map[string][]E      // the events awaiting delivery, by key
+	active  map[string]struct{} // the keys which have a delivery scheduled
+	closed  bool
+}
+
+// NewAggregator creates an aggregator which delivers events with the provided
+// function until the context is canceled. Events which have not been
+// delivered when the context is canceled are discarded.
+func NewAggregator[E any](cxt context.Context, lim Limiter, deliver func(context.Context, string, []E) error, opts ...ExecutorOption) *Aggregator[E] {
+	return &Aggregator[E]{
+		cxt:     cxt,
+		lim:     lim,
+		conf:    ExecutorConfig{}.With(opts),
+		deliver: deliver,
+		pending: make(map[string][]E),
+		active:  make(map[string]struct{}),
+	}
+}
+
+// Add adds an event to be delivered under the provided key. If the aggregator
+// is closed, Add fails with ErrDraining.
+func (a *Aggregator[E]) Add(key string, e E) error {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return ErrDraining
+	}
+	a.pending[key] = append(a.pending[key], e)
+	if _, ok := a.active[key]; !ok {
+		a.active[key] = struct{}{}
+		a.wg.Add(1)
+		go a.run(key)
+	}
+	return nil
+}
+
+// Pending returns the number of events awaiting delivery under a key
+func (a *Aggregator[E]) Pending(key string) int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.pending[key])
+}
+
+// Close stops accepting events and blocks until the events already added have
+// been delivered or the context is canceled.
+func (a *Aggregator[E]) Close() {
+	a.Lock()
+	a.closed = true
+	a.Unlock()
+	a.wg.Wait()
+}
+
+// Deliver the events for a key as the limiter permits, until none remain
+func (a *Aggregator[E]) run(key string) {
+	defer a.wg.Done()
+	for {
+		var events []E
+		do(a.cxt, a.lim, a.conf, func(cxt context.Context) (Attrs, error) {
+			if events == nil { // take the aggregate once we're permitted to deliver it; retries deliver the same one
+				a.Lock()
+				events = a.pending[key]
+				delete(a.pending, key)
+				a.Unlock()
+			}
+			return nil, a.deliver(cxt, key, events)
+		}, WithKey(key))
+
+		a.Lock()
+		if len(a.pending[key]) == 0 || a.cxt.Err() != nil {
+			delete(a.pending, key)
+			delete(a.active, key)
+			a.Unlock()
+			return
+		}
+		a.Unlock()
+	}
+}
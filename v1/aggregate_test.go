@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregator(t *testing.T) {
+	start := time.Now()
+	store := NewMemoryStore()
+	lim := NewKeyed(func(key string) Limiter {
+		return NewShared(Config{Window: time.Millisecond * 100, Events: 1, Start: start}, store, key)
+	})
+
+	var mu sync.Mutex
+	delivered := make(map[string][][]int)
+	agg := NewAggregator(context.Background(), lim, func(cxt context.Context, key string, events []int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered[key] = append(delivered[key], events)
+		return nil
+	})
+
+	for i := 1; i <= 4; i++ {
+		assert.NoError(t, agg.Add("a", i))
+		time.Sleep(time.Millisecond * 5) // let the first event be delivered alone
+	}
+	assert.NoError(t, agg.Add("b", 1))
+	agg.Close()
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*100, "the aggregate waits for the limiter")
+
+	assert.Equal(t, map[string][][]int{
+		"a": {{1}, {2, 3, 4}},
+		"b": {{1}},
+	}, delivered)
+	assert.Equal(t, 0, agg.Pending("a"))
+	assert.ErrorIs(t, agg.Add("a", 5), ErrDraining)
+}
+
+func TestAggregatorCanceled(t *testing.T) {
+	cxt, cancel := context.WithCancel(context.Background())
+	lim := NewShared(Config{Window: time.Hour, Events: 1}, NewMemoryStore(), "")
+
+	var delivered [][]int
+	agg := NewAggregator(cxt, lim, func(cxt context.Context, key string, events []int) error {
+		delivered = append(delivered, events)
+		return nil
+	})
+	assert.NoError(t, agg.Add("", 1))
+	for agg.Pending("") > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, agg.Add("", 2))
+	assert.NoError(t, agg.Add("", 3))
+	assert.Equal(t, 2, agg.Pending(""))
+
+	cancel()
+	agg.Close()
+	assert.Equal(t, [][]int{{1}}, delivered, "undelivered events are discarded")
+	assert.Equal(t, 0, agg.Pending(""))
+}
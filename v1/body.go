@@ -0,0 +1,286 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The most of a response body which is read for classification
+const maxClassifiedBody = 64 << 10
+
+// Read the beginning of a response body, up to maxClassifiedBody, and restore
+// it so that the response may be consumed as if it had not been read
+func peekBody(rsp *http.Response) []byte {
+	if rsp.Body == nil || rsp.Body == http.NoBody {
+		return nil
+	}
+	buf, _ := io.ReadAll(io.LimitReader(rsp.Body, maxClassifiedBody))
+	rsp.Body = struct {
+		io.Reader
+		io.Closer
+	}{
+		io.MultiReader(bytes.NewReader(buf), rsp.Body),
+		rsp.Body,
+	}
+	return buf
+}
+
+// A JSONRule describes how a service signals throttling in a JSON response
+// body. For example, a body like
+//
+//	{"error":{"code":"rate_limited","retry_after":30}}
+//
+// is matched by the rule:
+//
+//	JSONRule{Match: "error.code", Values: []string{"rate_limited"}, RetryAfter: "error.retry_after"}
+//
+// Selectors are paths of object keys separated by dots, optionally preceded by
+// "$." and including array indexes, e.g., "errors[0].code", or "[*]" to
+// consider every element of an array, e.g., "errors[*].extensions.code".
+type JSONRule struct {
+	// Selects the value which indicates throttling
+	Match string
+	// The values of the selected value which indicate throttling; if empty, any value other than false, zero, null, or an empty string does
+	Values []string
+	// Selects the time to wait before resuming, if the service provides it, as a number or numeric string
+	RetryAfter string
+	// The unit of the time selected by RetryAfter; if zero, seconds
+	RetryAfterUnit time.Duration
+}
+
+// jsonClassifier implements a classifier which inspects JSON response bodies
+// for provider-specific throttling markers
+type jsonClassifier struct {
+	fallback Classifier
+	rules    []jsonRule
+}
+
+type jsonRule struct {
+	JSONRule
+	match [][]string
+	retry [][]string
+}
+
+// NewJSONClassifier creates a body classifier which finds a response to be
+// throttled when its JSON body matches any of the provided rules. Responses
+// which match no rule, have no body, or whose bodies are not JSON are
+// classified by the fallback, or DefaultClassifier if it is nil. An error is
+// returned if a selector is malformed.
+func NewJSONClassifier(fallback Classifier, rules ...JSONRule) (*jsonClassifier, error) {
+	if fallback == nil {
+		fallback = DefaultClassifier
+	}
+	c := &jsonClassifier{
+		fallback: fallback,
+		rules:    make([]jsonRule, 0, len(rules)),
+	}
+	for _, e := range rules {
+		r := jsonRule{JSONRule: e}
+		var err error
+		r.match, err = parseSelector(e.Match)
+		if err != nil {
+			return nil, err
+		}
+		if e.RetryAfter != "" {
+			r.retry, err = parseSelector(e.RetryAfter)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.rules = append(c.rules, r)
+	}
+	return c, nil
+}
+
+// Classify defers to the fallback classifier, since no body is available
+func (c *jsonClassifier) Classify(attrs Attrs, status int, err error) Verdict {
+	return c.fallback.Classify(attrs, status, err)
+}
+
+func (c *jsonClassifier) ClassifyBody(attrs Attrs, status int, body []byte, err error) Verdict {
+	if err != nil || len(body) == 0 || !isJSON(attrs) {
+		return c.fallback.Classify(attrs, status, err)
+	}
+	var doc any
+	if json.Unmarshal(body, &doc) != nil {
+		return c.fallback.Classify(attrs, status, err)
+	}
+	for _, r := range c.rules {
+		if v, ok := r.eval(doc); ok {
+			return v
+		}
+	}
+	return c.fallback.Classify(attrs, status, err)
+}
+
+// Evaluate the rule against a document
+func (r jsonRule) eval(doc any) (Verdict, bool) {
+	matched := false
+	for _, e := range selectAll(doc, r.match) {
+		if r.matches(e) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return Verdict{}, false
+	}
+	v := Verdict{Outcome: Throttled}
+	unit := r.RetryAfterUnit
+	if unit <= 0 {
+		unit = time.Second
+	}
+	for _, e := range selectAll(doc, r.retry) {
+		if f, ok := jsonNumber(e); ok && f > 0 {
+			v.RetryAfter = time.Duration(f * float64(unit))
+			break
+		}
+	}
+	return v, true
+}
+
+// Determine whether a selected value indicates throttling
+func (r jsonRule) matches(v any) bool {
+	if len(r.Values) == 0 {
+		switch x := v.(type) {
+		case nil:
+			return false
+		case bool:
+			return x
+		case float64:
+			return x != 0
+		case string:
+			return x != ""
+		default:
+			return true
+		}
+	}
+	s, ok := jsonString(v)
+	if !ok {
+		return false
+	}
+	for _, e := range r.Values {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Determine whether the attributes describe a JSON body; if there is no
+// content type, the body is assumed to be JSON
+func isJSON(attrs Attrs) bool {
+	_, v := findAttr(attrs, []string{"Content-Type"})
+	if v == "" {
+		return true
+	}
+	t, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		return false
+	}
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
+
+// Represent a scalar JSON value as a string
+func jsonString(v any) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(x), true
+	default:
+		return "", false
+	}
+}
+
+// Interpret a JSON value as a number, including numeric strings
+func jsonNumber(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// The wildcard array selector
+const selectEvery = "*"
+
+// Parse a selector into its path segments. Each segment is an object key, or,
+// when preceded by "[", an array index or the wildcard.
+func parseSelector(s string) ([][]string, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(s, "$"), ".")
+	if p == "" {
+		return nil, fmt.Errorf("%w: Empty selector: %q", ErrInvalidConfig, s)
+	}
+	var res [][]string
+	for _, e := range strings.Split(p, ".") {
+		key, rest, _ := strings.Cut(e, "[")
+		if key == "" && rest == "" {
+			return nil, fmt.Errorf("%w: Empty selector segment: %q", ErrInvalidConfig, s)
+		}
+		if key != "" {
+			res = append(res, []string{key})
+		}
+		for rest != "" {
+			var idx string
+			var ok bool
+			idx, rest, ok = strings.Cut(rest, "]")
+			if !ok || (rest != "" && rest[0] != '[') {
+				return nil, fmt.Errorf("%w: Malformed array index in selector: %q", ErrInvalidConfig, s)
+			}
+			if idx != selectEvery {
+				if _, err := strconv.Atoi(idx); err != nil {
+					return nil, fmt.Errorf("%w: Invalid array index in selector: %q", ErrInvalidConfig, s)
+				}
+			}
+			res = append(res, []string{"[", idx})
+			rest = strings.TrimPrefix(rest, "[")
+		}
+	}
+	return res, nil
+}
+
+// Select every value in the document addressed by the path
+func selectAll(doc any, path [][]string) []any {
+	if len(path) == 0 {
+		return nil
+	}
+	cur := []any{doc}
+	for _, seg := range path {
+		var next []any
+		for _, e := range cur {
+			if len(seg) == 1 {
+				if m, ok := e.(map[string]any); ok {
+					if v, ok := m[seg[0]]; ok {
+						next = append(next, v)
+					}
+				}
+				continue
+			}
+			a, ok := e.([]any)
+			if !ok {
+				continue
+			}
+			if seg[1] == selectEvery {
+				next = append(next, a...)
+			} else if i, _ := strconv.Atoi(seg[1]); i >= 0 && i < len(a) {
+				next = append(next, a[i])
+			}
+		}
+		cur = next
+	}
+	return cur
+}
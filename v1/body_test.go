@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		Selector string
+		Expect   [][]string
+		Err      bool
+	}{
+		{
+			Selector: "error.code",
+			Expect:   [][]string{{"error"}, {"code"}},
+		},
+		{
+			Selector: "$.error.code",
+			Expect:   [][]string{{"error"}, {"code"}},
+		},
+		{
+			Selector: "errors[0].extensions.code",
+			Expect:   [][]string{{"errors"}, {"[", "0"}, {"extensions"}, {"code"}},
+		},
+		{
+			Selector: "errors[*][1]",
+			Expect:   [][]string{{"errors"}, {"[", "*"}, {"[", "1"}},
+		},
+		{
+			Selector: "",
+			Err:      true,
+		},
+		{
+			Selector: "error..code",
+			Err:      true,
+		},
+		{
+			Selector: "errors[x]",
+			Err:      true,
+		},
+		{
+			Selector: "errors[0",
+			Err:      true,
+		},
+	}
+	for i, e := range tests {
+		res, err := parseSelector(e.Selector)
+		if e.Err {
+			assert.ErrorIs(t, err, ErrInvalidConfig, "#%d", i)
+		} else if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, e.Expect, res, "#%d", i)
+		}
+	}
+}
+
+func TestJSONClassifier(t *testing.T) {
+	cls, err := NewJSONClassifier(nil,
+		JSONRule{Match: "error.code", Values: []string{"rate_limited"}, RetryAfter: "error.retry_after"},
+		JSONRule{Match: "$.errors[*].extensions.code", Values: []string{"THROTTLED"}},
+		JSONRule{Match: "throttled", RetryAfter: "wait_ms", RetryAfterUnit: time.Millisecond},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	jsonAttrs := Attrs{"Content-Type": {"application/json; charset=utf-8"}}
+	tests := []struct {
+		Attrs  Attrs
+		Status int
+		Body   string
+		Expect Verdict
+	}{
+		{
+			Attrs:  jsonAttrs,
+			Status: http.StatusOK,
+			Body:   `{"error":{"code":"rate_limited","retry_after":30}}`,
+			Expect: Verdict{Outcome: Throttled, RetryAfter: 30 * time.Second},
+		},
+		{
+			Attrs:  jsonAttrs,
+			Status: http.StatusOK,
+			Body:   `{"error":{"code":"rate_limited","retry_after":"1.5"}}`,
+			Expect: Verdict{Outcome: Throttled, RetryAfter: 1500 * time.Millisecond},
+		},
+		{
+			Attrs:  jsonAttrs,
+			Status: http.StatusOK,
+			Body:   `{"error":{"code":"not_found"}}`,
+			Expect: Verdict{Outcome: Succeeded},
+		},
+		{
+			Status: http.StatusOK,
+			Body:   `{"data":null,"errors":[{"message":"Nope"},{"extensions":{"code":"THROTTLED"}}]}`,
+			Expect: Verdict{Outcome: Throttled},
+		},
+		{
+			Attrs:  jsonAttrs,
+			Status: http.StatusOK,
+			Body:   `{"throttled":true,"wait_ms":250}`,
+			Expect: Verdict{Outcome: Throttled, RetryAfter: 250 * time.Millisecond},
+		},
+		{
+			Attrs:  jsonAttrs,
+			Status: http.StatusOK,
+			Body:   `{"throttled":false,"wait_ms":250}`,
+			Expect: Verdict{Outcome: Succeeded},
+		},
+		{ // not JSON, so the fallback decides
+			Attrs:  Attrs{"Content-Type": {"text/plain"}},
+			Status: http.StatusServiceUnavailable,
+			Body:   `{"throttled":true}`,
+			Expect: Verdict{Outcome: ServerError},
+		},
+		{
+			Attrs:  jsonAttrs,
+			Status: http.StatusTooManyRequests,
+			Body:   `{"truncated":`,
+			Expect: Verdict{Outcome: Throttled},
+		},
+	}
+	for i, e := range tests {
+		v := cls.ClassifyBody(e.Attrs, e.Status, []byte(e.Body), nil)
+		assert.Equal(t, e.Expect, v, "#%d", i)
+	}
+}
+
+func TestTransportBodyClassifier(t *testing.T) {
+	const body = `{"ok":false,"error":"ratelimited","retry_after":30}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	cls, err := NewJSONClassifier(nil, JSONRule{Match: "error", Values: []string{"ratelimited"}, RetryAfter: "retry_after"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst})
+	client := &http.Client{Transport: NewTransport(lim, nil, WithClassifier(cls))}
+	rsp, err := client.Get(srv.URL)
+	if assert.NoError(t, err) {
+		defer rsp.Body.Close()
+		data, err := io.ReadAll(rsp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(data)) // the body is restored
+		assert.True(t, lim.BackoffEnd().After(time.Now().Add(29*time.Second)))
+	}
+}
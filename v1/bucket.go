@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buckets implements a keyed, header-driven rate limiter for services which
+// map many request routes onto shared server-side buckets that are discovered
+// at runtime, most notably Discord:
+//
+// https://discord.com/developers/docs/topics/rate-limits
+//
+// The key for an operation (provided via WithKey) identifies its route. Until
+// the service reports which bucket a route belongs to via the
+// 'X-RateLimit-Bucket' header, the route is limited on its own. A response
+// carrying 'X-RateLimit-Global: true' imposes a backoff across all buckets.
+type buckets struct {
+	sync.Mutex
+	dur      Durationer
+	maxWait  time.Duration
+	limiters *keyed
+	routes   map[string]string // route → bucket
+	global   time.Time         // global backoff, if any
+	waiters
+}
+
+func NewBuckets(conf Config) *buckets {
+	var dur Durationer
+	if d := conf.Durationer; d != nil {
+		dur = d
+	} else {
+		dur = Seconds
+	}
+	return &buckets{
+		dur:     dur,
+		maxWait: conf.MaxWait,
+		limiters: NewKeyed(func(string) Limiter {
+			return NewHeaders(conf)
+		}),
+		routes: make(map[string]string),
+	}
+}
+
+// Bucket returns the bucket a route is currently mapped to. Routes which have
+// not been mapped are their own bucket.
+func (l *buckets) Bucket(route string) string {
+	l.Lock()
+	defer l.Unlock()
+	return l.bucket(route)
+}
+
+func (l *buckets) bucket(route string) string {
+	if v, ok := l.routes[route]; ok {
+		return v
+	}
+	return route
+}
+
+func (l *buckets) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	conf := Options{}.With(opts)
+	l.Lock()
+	g, b := l.global, l.bucket(conf.Key)
+	l.Unlock()
+	if g.After(rel) {
+		return g, nil
+	}
+	return l.limiters.Limiter(b).Next(rel, opts...)
+}
+
+func (l *buckets) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	if err := overloaded(l.maxWait, rel, l.estimatedWait(rel, Options{}.With(opts).Key)); err != nil {
+		return time.Time{}, err
+	}
+	t, err := l.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, t)
+}
+
+// Estimate the delay a new operation on a route would incur without consuming
+// any budget
+func (l *buckets) estimatedWait(rel time.Time, route string) time.Duration {
+	l.Lock()
+	g, b := l.global, l.bucket(route)
+	l.Unlock()
+	if g.After(rel) {
+		return g.Sub(rel)
+	}
+	lim := l.limiters.Limiter(b)
+	if e, ok := lim.(interface{ EstimatedWait(time.Time) time.Duration }); ok {
+		return e.EstimatedWait(rel)
+	}
+	if next, err := Peek(lim, rel); err == nil && next.After(rel) {
+		return next.Sub(rel)
+	}
+	return 0
+}
+
+func (l *buckets) Update(rel time.Time, opts ...Option) error {
+	conf := Options{}.With(opts)
+	if conf.Attrs == nil {
+		return fmt.Errorf("%w: Header attributes are required", ErrMissingAttrs)
+	}
+
+	// a global limit applies to every bucket; there is no bucket state to update
+	if _, v := findAttr(conf.Attrs, globalHeaders); strings.EqualFold(v, "true") {
+		n, v := findAttr(conf.Attrs, retryAfterHeaders)
+		if v == "" {
+			return fmt.Errorf("No retry header for global limit: %w", ErrMissingHeaders)
+		}
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s = %s: %v", ErrInvalidHeaders, n, v, err)
+		}
+		w := rel.Add(fracDuration(l.dur, x))
+		l.Lock()
+		if w.After(l.global) {
+			l.global = w
+		}
+		l.Unlock()
+		return RetryError{
+			RetryAfter: w,
+		}
+	}
+
+	l.Lock()
+	if _, v := findAttr(conf.Attrs, bucketHeaders); v != "" {
+		l.routes[conf.Key] = v
+	}
+	b := l.bucket(conf.Key)
+	l.Unlock()
+
+	return l.limiters.Limiter(b).Update(rel, opts...)
+}
+
+// State describes the global backoff, if any. Use BucketState to obtain the
+// state of the bucket a route is mapped to.
+func (l *buckets) State(rel time.Time) State {
+	l.Lock()
+	defer l.Unlock()
+	if l.global.After(rel) {
+		return State{Reset: l.global}
+	}
+	return State{}
+}
+
+// BucketState describes the bucket the provided route is mapped to
+func (l *buckets) BucketState(route string, rel time.Time) State {
+	return l.limiters.Limiter(l.Bucket(route)).State(rel)
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuckets(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	reset := now.Add(time.Second * 5)
+	lim := NewBuckets(Config{Start: now, Window: time.Second, Events: 5, Mode: Burst})
+
+	// two routes discover they share a bucket
+	for _, route := range []string{"GET /channels/1", "POST /channels/1"} {
+		err := lim.Update(now, WithKey(route), WithAttrs(Attrs{
+			"X-Ratelimit-Bucket":    []string{"abcd1234"},
+			"X-Ratelimit-Limit":     []string{"5"},
+			"X-Ratelimit-Remaining": []string{"1"},
+			"X-Ratelimit-Reset":     []string{strconv.FormatInt(reset.Unix(), 10)},
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, "abcd1234", lim.Bucket(route))
+	}
+	assert.Equal(t, "GET /guilds/1", lim.Bucket("GET /guilds/1"))
+
+	next, err := lim.Next(now, WithKey("GET /channels/1"), WithAttrs(Attrs{}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, next)
+	}
+	next, err = lim.Next(now, WithKey("POST /channels/1"), WithAttrs(Attrs{}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, reset, next) // the shared bucket is exhausted
+	}
+	next, err = lim.Next(now, WithKey("GET /guilds/1"), WithAttrs(Attrs{}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, next)
+	}
+
+	// a global limit applies to every route
+	err = lim.Update(now, WithKey("GET /guilds/1"), WithAttrs(Attrs{
+		"X-Ratelimit-Global": []string{"true"},
+		"Retry-After":        []string{"1.5"},
+	}))
+	var rerr RetryError
+	if assert.ErrorAs(t, err, &rerr) {
+		assert.Equal(t, now.Add(time.Millisecond*1500), rerr.RetryAfter)
+	}
+	next, err = lim.Next(now, WithKey("GET /guilds/1"), WithAttrs(Attrs{}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Millisecond*1500), next)
+	}
+	assert.Equal(t, now.Add(time.Millisecond*1500), lim.State(now).Reset)
+}
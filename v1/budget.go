@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// budget implements a rate limiter for a planned job which reserves a total
+// allotment of operations, such as 5,000 calls over the next 6 hours, from a
+// parent limiter. The budget paces its operations evenly over what remains of
+// its period, so that a job which falls behind, e.g., because the parent
+// delayed it, spreads its remaining allotment over the time remaining rather
+// than bursting to catch up. Every operation is also subject to the parent,
+// which tracks the overall consumption of its quota.
+type budget struct {
+	sync.Mutex
+	parent Limiter
+	events int
+	start  time.Time
+	end    time.Time
+	used   int
+	last   time.Time // the time at which the last operation was permitted
+	waiters
+}
+
+// NewBudget creates a budget which permits the provided number of operations
+// over the period beginning at the reference time, paced evenly, and no sooner
+// than the parent permits them. Once the allotment is used or the period has
+// ended, operations fail with ErrBudgetSpent. Several budgets may share a
+// parent to coordinate planned jobs against one quota.
+func NewBudget(parent Limiter, events int, period time.Duration, rel time.Time) *budget {
+	return &budget{
+		parent: parent,
+		events: events,
+		start:  rel,
+		end:    rel.Add(period),
+	}
+}
+
+// Used returns the number of operations the budget has permitted
+func (l *budget) Used() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.used
+}
+
+// Deadline returns the time at which the budget's period ends
+func (l *budget) Deadline() time.Time {
+	return l.end
+}
+
+// Determine when the next operation is permitted by the budget's own pacing,
+// not counting the parent; the caller must hold the lock
+func (l *budget) pace(rel time.Time) (time.Time, error) {
+	if l.used >= l.events || !rel.Before(l.end) {
+		return time.Time{}, fmt.Errorf("Could not compute next operation: %w", ErrBudgetSpent)
+	}
+	if l.used == 0 {
+		return later(rel, l.start), nil
+	}
+	// spread the remainder over the time which remains after the last operation
+	t := l.last.Add(l.end.Sub(l.last) / time.Duration(l.events-l.used+1))
+	return later(rel, t), nil
+}
+
+// Determine the later of two times
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (l *budget) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	l.Lock()
+	defer l.Unlock()
+	t, err := l.pace(rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	p, err := l.parent.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t = later(t, p)
+	l.used++
+	l.last = t
+	return t, nil
+}
+
+// Peek returns the time at which the next operation could proceed relative to
+// the provided time, as Next does, without consuming any of the allotment or
+// the parent's quota
+func (l *budget) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	l.Lock()
+	t, err := l.pace(rel)
+	l.Unlock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	p, err := Peek(l.parent, rel, opts...)
+	if err != nil {
+		return t, nil // the parent cannot forecast; our own pacing will have to do
+	}
+	return later(t, p), nil
+}
+
+func (l *budget) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	t, err := l.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, t)
+}
+
+func (l *budget) Update(rel time.Time, opts ...Option) error {
+	return l.parent.Update(rel, opts...)
+}
+
+// State describes the budget's allotment: the operations permitted over its
+// period, those which remain, and the end of the period as the reset. Once the
+// period has ended, nothing remains.
+func (l *budget) State(rel time.Time) State {
+	l.Lock()
+	defer l.Unlock()
+	rem := l.events - l.used
+	if !rel.Before(l.end) {
+		rem = 0
+	}
+	return State{
+		Limit:     l.events,
+		Remaining: max(0, rem),
+		Reset:     l.end,
+	}
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	parent := NewHeaders(Config{Start: now, Window: time.Hour * 24, Events: 100, Mode: Burst})
+	lim := NewBudget(parent, 4, time.Hour*4, now)
+
+	// the allotment is paced evenly over the period
+	for i, e := range []time.Time{now, now.Add(time.Hour), now.Add(time.Hour * 2)} {
+		next, err := lim.Next(now)
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, e, next, "#%d", i)
+		}
+	}
+	assert.Equal(t, State{Limit: 4, Remaining: 1, Reset: now.Add(time.Hour * 4)}, lim.State(now))
+	assert.Equal(t, 97, parent.State(now).Remaining)
+
+	// peeking consumes neither the allotment nor the parent's quota
+	next, err := lim.Peek(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Hour*3), next)
+	}
+	assert.Equal(t, 3, lim.Used())
+	assert.Equal(t, 97, parent.State(now).Remaining)
+
+	next, err = lim.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Hour*3), next)
+	}
+	_, err = lim.Next(now)
+	assert.ErrorIs(t, err, ErrBudgetSpent)
+
+	// a job which falls behind spreads the remainder over the time remaining
+	lim = NewBudget(parent, 4, time.Hour*4, now)
+	_, err = lim.Next(now)
+	assert.NoError(t, err)
+	late := now.Add(time.Hour * 2)
+	for i, e := range []time.Time{late, late.Add(time.Minute * 40)} {
+		next, err := lim.Next(late)
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, e, next, "#%d", i)
+		}
+	}
+
+	// once the period ends, nothing remains
+	assert.Equal(t, 0, lim.State(now.Add(time.Hour*4)).Remaining)
+	_, err = lim.Next(now.Add(time.Hour * 4))
+	assert.ErrorIs(t, err, ErrBudgetSpent)
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// capped implements a rate limiter which never exceeds either the quota
+// managed by an inner limiter or a local, linear cap. This is useful to
+// remain well below a quota published by a server while still reacting to the
+// state it reports.
+type capped struct {
+	inner Limiter
+	cap   *linear
+	waiters
+}
+
+// CappedBy creates a limiter which permits operations no sooner than both the
+// inner limiter and a linear limiter using the provided configuration would.
+// Updates are delivered to the inner limiter.
+func CappedBy(inner Limiter, local Config) *capped {
+	return &capped{
+		inner: inner,
+		cap:   NewLinear(local),
+	}
+}
+
+func (l *capped) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	c, err := l.cap.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := l.inner.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if c.After(t) {
+		return c, nil
+	} else {
+		return t, nil
+	}
+}
+
+func (l *capped) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	t, err := l.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := overloaded(l.cap.MaxWait, rel, t.Sub(rel)); err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, t)
+}
+
+func (l *capped) Update(rel time.Time, opts ...Option) error {
+	return l.inner.Update(rel, opts...)
+}
+
+// State returns the state of whichever of the inner limiter and the local cap
+// is more constrained.
+func (l *capped) State(rel time.Time) State {
+	a, b := l.inner.State(rel), l.cap.State(rel)
+	if b.Remaining < a.Remaining {
+		return b
+	} else {
+		return a
+	}
+}
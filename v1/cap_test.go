@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCappedBy(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Name      string
+		Remaining float64
+		Expect    time.Time
+	}{
+		{"Local", 100, now.Add(time.Second * 12)},    // the server permits it immediately, the local cap does not
+		{"Server", 0, now.Add(time.Second * 30)},     // the server quota is exhausted until its reset
+		{"Critical", 0.5, now.Add(time.Second * 30)}, // below the critical watermark, the server quota governs
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			inner := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, Mode: Burst})
+			inner.impl.Update(100, e.Remaining, now.Add(time.Second*30))
+			lim := CappedBy(inner, Config{Start: now, Window: time.Minute, Events: 5})
+			next, err := lim.Next(now)
+			if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, next)
+			}
+		})
+	}
+}
+
+func TestCappedByUpdate(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	inner := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, ResetSemantics: Delta})
+	lim := CappedBy(inner, Config{Start: now, Window: time.Minute, Events: 50})
+	err := lim.Update(now, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"100"},
+		"Ratelimit-Remaining": []string{"10"},
+		"Ratelimit-Reset":     []string{"30"},
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, State{Limit: 100, Remaining: 10, Reset: now.Add(time.Second * 30)}, lim.State(now))
+	}
+	// the local cap is more constrained once most of its window has elapsed
+	assert.Equal(t, 50, lim.State(now.Add(time.Second*55)).Limit)
+}
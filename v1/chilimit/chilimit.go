@@ -0,0 +1,19 @@
+// Package chilimit adapts the rate limiting server middleware to chi routers.
+// Limits may be applied to an entire router with Use, or to individual routes
+// and groups with With, each using its own limiter:
+//
+//	r.Use(chilimit.Middleware(global))
+//	r.With(chilimit.Middleware(uploads)).Post("/uploads", handler)
+package chilimit
+
+import (
+	"net/http"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+// Middleware produces chi middleware which limits requests under the provided
+// limiter. See ratelimit.Gate for details.
+func Middleware(lim ratelimit.Limiter, opts ...ratelimit.GateOption) func(http.Handler) http.Handler {
+	return ratelimit.Middleware(lim, opts...)
+}
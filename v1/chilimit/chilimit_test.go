@@ -0,0 +1,32 @@
+package chilimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	lim := ratelimit.NewShared(ratelimit.Config{Window: time.Hour, Events: 1}, ratelimit.NewMemoryStore(), "")
+	r := chi.NewRouter()
+	r.With(Middleware(lim)).Get("/limited", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/unlimited", func(w http.ResponseWriter, r *http.Request) {})
+
+	for i, e := range []struct {
+		Path   string
+		Status int
+	}{
+		{"/limited", http.StatusOK},
+		{"/limited", http.StatusTooManyRequests},
+		{"/unlimited", http.StatusOK},
+	} {
+		rsp := httptest.NewRecorder()
+		r.ServeHTTP(rsp, httptest.NewRequest("GET", e.Path, nil))
+		assert.Equal(t, e.Status, rsp.Code, "#%d", i)
+	}
+}
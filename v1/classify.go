@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"net/http"
+	"time"
+)
+
+// The outcome of an operation, as far as rate limiting is concerned
+type Outcome int
+
+const (
+	Succeeded   Outcome = iota // the operation succeeded, or failed for reasons which say nothing about the quota
+	Throttled                  // the service refused the operation because a rate limit was exceeded
+	ServerError                // the service failed, likely transiently
+	Fatal                      // the operation failed before a response could be trusted, so nothing is learned from it
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Succeeded:
+		return "succeeded"
+	case Throttled:
+		return "throttled"
+	case ServerError:
+		return "server-error"
+	case Fatal:
+		return "fatal"
+	default:
+		return "unknown"
+	}
+}
+
+// A Verdict describes the outcome of an operation as determined by a
+// Classifier
+type Verdict struct {
+	Outcome Outcome
+	// How long the service asked that operations wait before resuming, when it says so somewhere other than the Retry-After header, such as in the body of a response; if zero, the headers are consulted as usual
+	RetryAfter time.Duration
+}
+
+// A Classifier decides the outcome of an operation from its response: the
+// attributes, like headers, its status, and the error which prevented it from
+// completing, if any. Limiters which learn from responses provided to Update
+// consult a classifier rather than examining responses themselves, so that
+// services which signal throttling unconventionally, e.g., in their response
+// bodies, may be accommodated.
+type Classifier interface {
+	Classify(attrs Attrs, status int, err error) Verdict
+}
+
+// ClassifierFunc adapts a function to the Classifier interface
+type ClassifierFunc func(Attrs, int, error) Verdict
+
+func (f ClassifierFunc) Classify(attrs Attrs, status int, err error) Verdict {
+	return f(attrs, status, err)
+}
+
+// DefaultClassifier is used when no other classifier is configured. A
+// response is throttled if its status is 429 Too Many Requests or it has a
+// Retry-After header, and a server error if its status is 5xx. An operation
+// which produced an error is fatal.
+var DefaultClassifier Classifier = ClassifierFunc(classify)
+
+func classify(attrs Attrs, status int, err error) Verdict {
+	if err != nil {
+		return Verdict{Outcome: Fatal}
+	}
+	if _, v := findAttr(attrs, retryAfterHeaders); v != "" || status == http.StatusTooManyRequests {
+		return Verdict{Outcome: Throttled}
+	}
+	if status >= 500 {
+		return Verdict{Outcome: ServerError}
+	}
+	return Verdict{Outcome: Succeeded}
+}
+
+// Determine the verdict for the response provided to Update: the one provided
+// via WithVerdict, if any, otherwise that of the classifier, or the default
+// classifier if it is nil
+func (c Options) verdict(cls Classifier) Verdict {
+	if c.Verdict != nil {
+		return *c.Verdict
+	}
+	if cls == nil {
+		cls = DefaultClassifier
+	}
+	return cls.Classify(c.Attrs, c.Status, c.Err)
+}
+
+// A BodyClassifier is a classifier which may also consult the body of a
+// response, for services which signal throttling only there. The Transport
+// reads the beginning of each response body for a body classifier and
+// restores it before the response is returned.
+type BodyClassifier interface {
+	Classifier
+	ClassifyBody(attrs Attrs, status int, body []byte, err error) Verdict
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultClassifier(t *testing.T) {
+	tests := []struct {
+		Attrs  Attrs
+		Status int
+		Err    error
+		Expect Outcome
+	}{
+		{
+			Status: http.StatusOK,
+			Expect: Succeeded,
+		},
+		{
+			Status: http.StatusNotFound,
+			Expect: Succeeded,
+		},
+		{
+			Status: http.StatusTooManyRequests,
+			Expect: Throttled,
+		},
+		{
+			Attrs:  Attrs{"Retry-After": {"30"}},
+			Status: http.StatusServiceUnavailable,
+			Expect: Throttled,
+		},
+		{
+			Status: http.StatusBadGateway,
+			Expect: ServerError,
+		},
+		{
+			Err:    errors.New("Connection reset"),
+			Expect: Fatal,
+		},
+	}
+	for i, e := range tests {
+		v := DefaultClassifier.Classify(e.Attrs, e.Status, e.Err)
+		assert.Equal(t, e.Expect, v.Outcome, "#%d", i)
+	}
+}
+
+func TestClassifier(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a service which signals throttling in the response body, with a 200 status
+	throttled := false
+	cls := ClassifierFunc(func(attrs Attrs, status int, err error) Verdict {
+		if throttled {
+			return Verdict{Outcome: Throttled, RetryAfter: 30 * time.Second}
+		}
+		return DefaultClassifier.Classify(attrs, status, err)
+	})
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst, Classifier: cls})
+
+	throttled = true
+	err := lim.Update(now, WithStatus(http.StatusOK))
+	var rerr RetryError
+	if assert.ErrorAs(t, err, &rerr) {
+		assert.Equal(t, now.Add(30*time.Second), rerr.RetryAfter)
+	}
+	assert.Equal(t, now.Add(30*time.Second), lim.BackoffEnd())
+
+	// without a throttling verdict, the headers are required as usual
+	throttled = false
+	err = lim.Update(now, WithStatus(http.StatusOK))
+	assert.ErrorIs(t, err, ErrMissingAttrs)
+
+	// nothing is learned from operations which failed outright
+	err = lim.Update(now, WithError(errors.New("Connection reset")))
+	assert.NoError(t, err)
+
+	// a verdict provided explicitly takes precedence over the classifier
+	err = lim.Update(now, WithVerdict(Verdict{Outcome: Fatal}), WithAttrs(Attrs{"Retry-After": {"60"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(30*time.Second), lim.BackoffEnd())
+}
+
+func TestTransportClassifier(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Rate-Limited", "true")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cls := ClassifierFunc(func(attrs Attrs, status int, err error) Verdict {
+		if _, v := findAttr(attrs, []string{"X-Rate-Limited"}); v == "true" {
+			return Verdict{Outcome: Throttled, RetryAfter: time.Minute}
+		}
+		return DefaultClassifier.Classify(attrs, status, err)
+	})
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst})
+	client := &http.Client{Transport: NewTransport(lim, nil, WithClassifier(cls))}
+	rsp, err := client.Get(srv.URL)
+	if assert.NoError(t, err) {
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		assert.True(t, lim.BackoffEnd().After(time.Now()))
+	}
+}
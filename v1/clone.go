@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Cloner is a limiter which can produce an independent copy of itself and
+// its current state. Copying a limiter's value directly would copy its locks,
+// which is unsafe.
+type Cloner interface {
+	// Clone produces an independent copy of the limiter. The copy has no callers waiting in it and is neither paused nor closed.
+	Clone() Limiter
+}
+
+// Ensure our implementations conform to the Cloner interface
+var (
+	_ Cloner = (*headers)(nil)
+	_ Cloner = (*linear)(nil)
+	_ Cloner = (*estimator)(nil)
+	_ Cloner = (*graphqlCost)(nil)
+	_ Cloner = (*none)(nil)
+)
+
+// Clone produces an independent copy of the provided limiter and its current
+// state, e.g., to seed a limiter for each of several workers from a single
+// observation, or to capture a limiter's state in a test. If the limiter does
+// not implement Cloner, the error wraps errors.ErrUnsupported.
+func Clone(lim Limiter) (Limiter, error) {
+	if v, ok := lim.(Cloner); ok {
+		return v.Clone(), nil
+	}
+	return nil, fmt.Errorf("Could not clone %T: %w", lim, errors.ErrUnsupported)
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClone(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta})
+	err := lim.Update(now, WithAttrs(Attrs{
+		"X-Ratelimit-Limit":     []string{"10"},
+		"X-Ratelimit-Remaining": []string{"5"},
+		"X-Ratelimit-Reset":     []string{"30"},
+	}))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the copy is seeded with the state observed by the original
+	c, err := Clone(lim)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, lim.State(now), c.State(now))
+
+	// but the two consume quota independently
+	for i := 0; i < 3; i++ {
+		_, err := c.Next(now)
+		assert.NoError(t, err, "#%d", i)
+	}
+	assert.Equal(t, 5, lim.State(now).Remaining)
+	assert.Equal(t, 2, c.State(now).Remaining)
+
+	// and are paused and closed independently
+	lim.Pause()
+	assert.False(t, c.(*headers).Paused())
+	lim.Resume()
+
+	// the copy reclaims its own stale reservations as the original would
+	r := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ReclaimAfter: time.Second})
+	rc := r.Clone().(*headers)
+	_, err = rc.Acquire(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rc.Reclaim(now.Add(time.Second)))
+
+	// backoffs are copied as well
+	l := NewLinear(Config{Start: now, Window: time.Minute, Events: 60})
+	l.BackoffUntil(now.Add(time.Hour))
+	n, err := l.Clone().Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Hour), n)
+	}
+
+	// limiters which cannot be copied say so
+	_, err = Clone(NewKeyed(func(string) Limiter { return None() }))
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
@@ -0,0 +1,544 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The names of modes in configuration documents
+var modeNames = map[string]Mode{
+	"meter":  Meter,
+	"burst":  Burst,
+	"smooth": Smooth,
+	"sliced": Sliced,
+}
+
+// The names of reconciliation strategies in configuration documents
+var reconcileNames = map[string]Reconciliation{
+	"trust-server":    TrustServer,
+	"trust-local-min": TrustLocalMin,
+	"decay":           Decay,
+}
+
+// A duration which is expressed in documents as a string, like "90s"
+type duration time.Duration
+
+func (d *duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%w: Invalid duration %q; expected a value like \"90s\" or \"1h30m\"", ErrInvalidConfig, s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%w: Invalid duration %s; expected a string like \"90s\" or \"1h30m\"", ErrInvalidConfig, data)
+	}
+	return d.set(s)
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *duration) UnmarshalYAML(n *yaml.Node) error {
+	v, err := time.ParseDuration(n.Value)
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!str" || err != nil {
+		return fmt.Errorf("%w: line %d: Invalid duration %q; expected a string like \"90s\" or \"1h30m\"", ErrInvalidConfig, n.Line, n.Value)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// An additional limit, as it is expressed in documents
+type limitDocument struct {
+	Events int      `json:"events,omitempty" yaml:"events,omitempty"`
+	Window duration `json:"window,omitempty" yaml:"window,omitempty"`
+}
+
+// A configuration, as it is expressed in documents. Fields which cannot be
+// expressed in a document, like the logger, are omitted.
+type configDocument struct {
+	Start             *time.Time      `json:"start,omitempty" yaml:"start,omitempty"`
+	Rate              string          `json:"rate,omitempty" yaml:"rate,omitempty"`
+	Window            duration        `json:"window,omitempty" yaml:"window,omitempty"`
+	Align             bool            `json:"align,omitempty" yaml:"align,omitempty"`
+	Location          string          `json:"location,omitempty" yaml:"location,omitempty"`
+	Events            int             `json:"events,omitempty" yaml:"events,omitempty"`
+	Limits            []limitDocument `json:"limits,omitempty" yaml:"limits,omitempty"`
+	Mode              string          `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Strict            bool            `json:"strict,omitempty" yaml:"strict,omitempty"`
+	Lenient           bool            `json:"lenient,omitempty" yaml:"lenient,omitempty"`
+	MaxDelay          duration        `json:"max_delay,omitempty" yaml:"max_delay,omitempty"`
+	BoundDelay        bool            `json:"bound_delay,omitempty" yaml:"bound_delay,omitempty"`
+	MaxWait           duration        `json:"max_wait,omitempty" yaml:"max_wait,omitempty"`
+	Backoff           duration        `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+	Probe             duration        `json:"probe,omitempty" yaml:"probe,omitempty"`
+	InFlight          bool            `json:"in_flight,omitempty" yaml:"in_flight,omitempty"`
+	Reconcile         string          `json:"reconcile,omitempty" yaml:"reconcile,omitempty"`
+	ReconcileDecay    duration        `json:"reconcile_decay,omitempty" yaml:"reconcile_decay,omitempty"`
+	Jitter            float64         `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	LowWatermark      float64         `json:"low_watermark,omitempty" yaml:"low_watermark,omitempty"`
+	CriticalWatermark float64         `json:"critical_watermark,omitempty" yaml:"critical_watermark,omitempty"`
+	Reserve           float64         `json:"reserve,omitempty" yaml:"reserve,omitempty"`
+	Debt              int             `json:"debt,omitempty" yaml:"debt,omitempty"`
+	Shares            int             `json:"shares,omitempty" yaml:"shares,omitempty"`
+	ShareIndex        int             `json:"share_index,omitempty" yaml:"share_index,omitempty"`
+	BurstFraction     float64         `json:"burst_fraction,omitempty" yaml:"burst_fraction,omitempty"`
+	Slice             duration        `json:"slice,omitempty" yaml:"slice,omitempty"`
+	SoftLimit         float64         `json:"soft_limit,omitempty" yaml:"soft_limit,omitempty"`
+	SoftTarget        float64         `json:"soft_target,omitempty" yaml:"soft_target,omitempty"`
+	MaxTarget         float64         `json:"max_target,omitempty" yaml:"max_target,omitempty"`
+	MinTarget         float64         `json:"min_target,omitempty" yaml:"min_target,omitempty"`
+	TargetLatency     duration        `json:"target_latency,omitempty" yaml:"target_latency,omitempty"`
+	RestoreRate       float64         `json:"restore_rate,omitempty" yaml:"restore_rate,omitempty"`
+	ReclaimAfter      duration        `json:"reclaim_after,omitempty" yaml:"reclaim_after,omitempty"`
+	History           int             `json:"history,omitempty" yaml:"history,omitempty"`
+}
+
+// ParseConfig parses a configuration from a JSON or YAML document. Durations
+// are expressed as strings, like "90s" or "1h30m"; fields are named in snake
+// case, like max_wait; and modes by name, like "burst". The events and window
+// may instead be expressed together as a rate, like "100/min" or "600/10m":
+//
+//	rate: 100/min
+//	mode: smooth
+//	limits:
+//	  - events: 10000
+//	    window: 24h
+//
+// Unknown fields are rejected, as are values which describe impossible
+// limits. Every problem found is reported, and each wraps ErrInvalidConfig.
+func ParseConfig(data []byte) (Config, error) {
+	var doc configDocument
+	if err := decodeDocument(data, &doc); err != nil {
+		return Config{}, err
+	}
+	return doc.config()
+}
+
+// ParseConfigs parses a set of named configurations from a JSON or YAML
+// document, which maps names to configurations as they are expressed for
+// ParseConfig:
+//
+//	search:
+//	  window: 1s
+//	  events: 10
+//	uploads:
+//	  window: 1h
+//	  events: 100
+//	  mode: burst
+//
+// Every problem found in any configuration is reported, prefixed by its name.
+func ParseConfigs(data []byte) (map[string]Config, error) {
+	var docs map[string]configDocument
+	if err := decodeDocument(data, &docs); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(docs))
+	for k := range docs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var errs []error
+	res := make(map[string]Config, len(docs))
+	for _, k := range names {
+		conf, err := docs[k].config()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, err))
+		} else {
+			res[k] = conf
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return res, nil
+}
+
+// Describe an error decoding a configuration; errors which already wrap
+// ErrInvalidConfig, like those of durations, are not wrapped again
+func invalidConfig(err error) error {
+	if errors.Is(err, ErrInvalidConfig) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+}
+
+// Decode a JSON or YAML document, rejecting unknown fields
+func decodeDocument(data []byte, v any) error {
+	if t := bytes.TrimSpace(data); len(t) > 0 && t[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(t))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return invalidConfig(err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(t))
+		dec.KnownFields(true)
+		if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) { // an empty document is an empty configuration
+			return invalidConfig(err)
+		}
+	}
+	return nil
+}
+
+// Reject the fields of a YAML node which the type it is decoded into does not
+// declare, as a decoder does with KnownFields; decoding a node is not strict
+func knownFields(n *yaml.Node, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for _, e := range n.Content {
+			if err := knownFields(e, t); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			f, ok := yamlField(t, k.Value)
+			if !ok {
+				return fmt.Errorf("line %d: field %s not found in type %v", k.Line, k.Value, t)
+			}
+			if err := knownFields(n.Content[i+1], f.Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Find the field of a struct type which a YAML key names
+func yamlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if n, _, _ := strings.Cut(f.Tag.Get("yaml"), ","); n == name || (n == "" && strings.EqualFold(f.Name, name)) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Validate the document and produce the configuration it describes
+func (d configDocument) config() (Config, error) {
+	var errs []error
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, ConfigError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	conf := Config{
+		Window:            time.Duration(d.Window),
+		Align:             d.Align,
+		Events:            d.Events,
+		Strict:            d.Strict,
+		Lenient:           d.Lenient,
+		MaxDelay:          time.Duration(d.MaxDelay),
+		BoundDelay:        d.BoundDelay,
+		MaxWait:           time.Duration(d.MaxWait),
+		Backoff:           time.Duration(d.Backoff),
+		Probe:             time.Duration(d.Probe),
+		InFlight:          d.InFlight,
+		ReconcileDecay:    time.Duration(d.ReconcileDecay),
+		Jitter:            d.Jitter,
+		LowWatermark:      d.LowWatermark,
+		CriticalWatermark: d.CriticalWatermark,
+		Reserve:           d.Reserve,
+		Debt:              d.Debt,
+		Shares:            d.Shares,
+		ShareIndex:        d.ShareIndex,
+		BurstFraction:     d.BurstFraction,
+		Slice:             time.Duration(d.Slice),
+		SoftLimit:         d.SoftLimit,
+		SoftTarget:        d.SoftTarget,
+		MaxTarget:         d.MaxTarget,
+		MinTarget:         d.MinTarget,
+		TargetLatency:     time.Duration(d.TargetLatency),
+		RestoreRate:       d.RestoreRate,
+		ReclaimAfter:      time.Duration(d.ReclaimAfter),
+		History:           d.History,
+	}
+
+	if d.Start != nil {
+		conf.Start = *d.Start
+	}
+	if d.Rate != "" {
+		if d.Events != 0 || d.Window != 0 {
+			invalid("rate", "Must not be combined with events or window")
+		} else if r, err := ParseRate(d.Rate); err != nil {
+			invalid("rate", "%s", strings.TrimPrefix(err.Error(), ErrInvalidConfig.Error()+": "))
+		} else if r.Mode != Meter && d.Mode != "" {
+			invalid("mode", "Must not be combined with a rate which has a burst")
+		} else {
+			conf.Events, conf.Window, conf.Mode = r.Events, r.Window, r.Mode
+			d.Events, d.Window = r.Events, duration(r.Window)
+		}
+	}
+	if d.Events < 0 {
+		invalid("events", "Must not be negative")
+	}
+	if d.Window < 0 {
+		invalid("window", "Must not be negative")
+	} else if d.Events > 0 && d.Window == 0 && d.RestoreRate <= 0 {
+		invalid("window", "Must be set when events are, unless they are restored continuously")
+	}
+	for i, e := range d.Limits {
+		if e.Events <= 0 {
+			invalid(fmt.Sprintf("limits[%d].events", i), "Must be positive")
+		}
+		if e.Window <= 0 {
+			invalid(fmt.Sprintf("limits[%d].window", i), "Must be positive")
+		}
+		conf.Limits = append(conf.Limits, Limit{Events: e.Events, Window: time.Duration(e.Window)})
+	}
+	if d.Mode != "" {
+		if m, ok := modeNames[strings.ToLower(d.Mode)]; ok {
+			conf.Mode = m
+		} else {
+			invalid("mode", "Unknown mode %q; expected one of meter, burst, smooth, or sliced", d.Mode)
+		}
+	}
+	if d.Reconcile != "" {
+		if r, ok := reconcileNames[strings.ToLower(d.Reconcile)]; ok {
+			conf.Reconcile = r
+		} else {
+			invalid("reconcile", "Unknown strategy %q; expected one of trust-server, trust-local-min, or decay", d.Reconcile)
+		}
+	}
+	if d.Location != "" {
+		if loc, err := time.LoadLocation(d.Location); err != nil {
+			invalid("location", "Unknown location %q", d.Location)
+		} else {
+			conf.Location = loc
+		}
+	}
+	for _, e := range []struct {
+		Field string
+		Value duration
+	}{
+		{"max_delay", d.MaxDelay},
+		{"max_wait", d.MaxWait},
+		{"backoff", d.Backoff},
+		{"probe", d.Probe},
+		{"reconcile_decay", d.ReconcileDecay},
+		{"target_latency", d.TargetLatency},
+		{"slice", d.Slice},
+		{"reclaim_after", d.ReclaimAfter},
+	} {
+		if e.Value < 0 {
+			invalid(e.Field, "Must not be negative")
+		}
+	}
+	for _, e := range []struct {
+		Field string
+		Value float64
+	}{
+		{"jitter", d.Jitter},
+		{"low_watermark", d.LowWatermark},
+		{"critical_watermark", d.CriticalWatermark},
+		{"burst_fraction", d.BurstFraction},
+		{"soft_limit", d.SoftLimit},
+		{"soft_target", d.SoftTarget},
+		{"max_target", d.MaxTarget},
+		{"min_target", d.MinTarget},
+	} {
+		if e.Value < 0 || e.Value > 1 {
+			invalid(e.Field, "Must be a proportion between 0 and 1")
+		}
+	}
+	if d.CriticalWatermark > 0 && d.LowWatermark > 0 && d.CriticalWatermark > d.LowWatermark {
+		invalid("critical_watermark", "Must not exceed low_watermark")
+	}
+	if d.MinTarget > 0 && d.MaxTarget > 0 && d.MinTarget > d.MaxTarget {
+		invalid("min_target", "Must not exceed max_target")
+	}
+	if d.Reserve < 0 {
+		invalid("reserve", "Must not be negative")
+	} else if d.Reserve >= 1 && d.Events > 0 && int(d.Reserve) >= d.Events {
+		invalid("reserve", "Must leave some of the %d events available", d.Events)
+	}
+	if d.RestoreRate < 0 {
+		invalid("restore_rate", "Must not be negative")
+	}
+	if d.Debt < 0 {
+		invalid("debt", "Must not be negative")
+	}
+	if d.Shares < 0 {
+		invalid("shares", "Must not be negative")
+	}
+	if d.ShareIndex < 0 || (d.ShareIndex > 0 && d.ShareIndex >= max(1, d.Shares)) {
+		invalid("share_index", "Must be less than shares")
+	}
+	if d.History < 0 {
+		invalid("history", "Must not be negative")
+	}
+
+	if len(errs) > 0 {
+		return Config{}, errors.Join(errs...)
+	}
+	return conf, nil
+}
+
+// Validate reports the problems with a configuration which describes
+// impossible limits, such as negative events or a reserve which leaves none of
+// them available, as ParseConfig does. Every problem found is reported, as a
+// ConfigError, which wraps ErrInvalidConfig.
+//
+// A configuration with no window is valid and imposes no limit, and one with
+// a window but no events is valid and, for limiters which enforce their
+// configuration, like those created by NewLinear, permits no operations.
+func (c Config) Validate() error {
+	d := documentOf(c)
+	d.Location = "" // the location need not be one that can be loaded by name
+	_, err := d.config()
+	return err
+}
+
+// Produce the document which describes a configuration
+func documentOf(c Config) configDocument {
+	d := configDocument{
+		Window:            duration(c.Window),
+		Align:             c.Align,
+		Events:            c.Events,
+		Strict:            c.Strict,
+		Lenient:           c.Lenient,
+		MaxDelay:          duration(c.MaxDelay),
+		BoundDelay:        c.BoundDelay,
+		MaxWait:           duration(c.MaxWait),
+		Backoff:           duration(c.Backoff),
+		Probe:             duration(c.Probe),
+		InFlight:          c.InFlight,
+		ReconcileDecay:    duration(c.ReconcileDecay),
+		Jitter:            c.Jitter,
+		LowWatermark:      c.LowWatermark,
+		CriticalWatermark: c.CriticalWatermark,
+		Reserve:           c.Reserve,
+		Debt:              c.Debt,
+		Shares:            c.Shares,
+		ShareIndex:        c.ShareIndex,
+		BurstFraction:     c.BurstFraction,
+		Slice:             duration(c.Slice),
+		SoftLimit:         c.SoftLimit,
+		SoftTarget:        c.SoftTarget,
+		MaxTarget:         c.MaxTarget,
+		MinTarget:         c.MinTarget,
+		TargetLatency:     duration(c.TargetLatency),
+		RestoreRate:       c.RestoreRate,
+		ReclaimAfter:      duration(c.ReclaimAfter),
+		History:           c.History,
+	}
+	if !c.Start.IsZero() {
+		d.Start = &c.Start
+	}
+	if c.Location != nil {
+		d.Location = c.Location.String()
+	}
+	for k, v := range modeNames {
+		if v == c.Mode && c.Mode != Meter {
+			d.Mode = k
+		}
+	}
+	for k, v := range reconcileNames {
+		if v == c.Reconcile && c.Reconcile != TrustServer {
+			d.Reconcile = k
+		}
+	}
+	for _, e := range c.Limits {
+		d.Limits = append(d.Limits, limitDocument{Events: e.Events, Window: duration(e.Window)})
+	}
+	return d
+}
+
+// MarshalJSON encodes a configuration as a document which may be parsed by
+// ParseConfig. Fields which cannot be expressed in a document, like the
+// logger and the schedule, are omitted.
+func (c Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(documentOf(c))
+}
+
+// MarshalYAML encodes a configuration as a document which may be parsed by
+// ParseConfig, as MarshalJSON does
+func (c Config) MarshalYAML() (any, error) {
+	return documentOf(c), nil
+}
+
+// UnmarshalJSON decodes a configuration from a document, as ParseConfig
+// does, or from a string describing a rate, like "100/min", so that
+// configurations may be embedded in other documents:
+//
+//	{"limits": {"search": "10/s", "uploads": {"rate": "100/h", "mode": "burst"}}}
+func (c *Config) UnmarshalJSON(data []byte) error {
+	if t := bytes.TrimSpace(data); len(t) > 0 && t[0] == '"' {
+		var s string
+		if err := json.Unmarshal(t, &s); err != nil {
+			return err
+		}
+		return c.UnmarshalText([]byte(s))
+	}
+	var doc configDocument
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return invalidConfig(err)
+	}
+	conf, err := doc.config()
+	if err != nil {
+		return err
+	}
+	*c = conf
+	return nil
+}
+
+// UnmarshalYAML decodes a configuration from a document or a rate, as
+// UnmarshalJSON does
+func (c *Config) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return c.UnmarshalText([]byte(n.Value))
+	}
+	var doc configDocument
+	if err := knownFields(n, reflect.TypeOf(doc)); err != nil {
+		return invalidConfig(err)
+	}
+	if err := n.Decode(&doc); err != nil {
+		return invalidConfig(err)
+	}
+	conf, err := doc.config()
+	if err != nil {
+		return err
+	}
+	*c = conf
+	return nil
+}
+
+// UnmarshalText decodes a configuration from a rate, like "100/min", as
+// ParseRate does, so that configurations may be read from environment
+// variables and flags
+func (c *Config) UnmarshalText(text []byte) error {
+	conf, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*c = conf
+	return nil
+}
@@ -0,0 +1,239 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		Doc    string
+		Expect Config
+		Errs   []string
+	}{
+		{
+			`{"window": "1m", "events": 100, "mode": "burst"}`,
+			Config{Window: time.Minute, Events: 100, Mode: Burst},
+			nil,
+		},
+		{
+			`
+window: 1m
+events: 100
+mode: Smooth
+max_wait: 30s
+backoff: 1m30s
+jitter: 0.1
+limits:
+  - events: 10000
+    window: 24h
+`,
+			Config{Window: time.Minute, Events: 100, Mode: Smooth, MaxWait: time.Second * 30, Backoff: time.Second * 90, Jitter: 0.1, Limits: []Limit{{Events: 10000, Window: time.Hour * 24}}},
+			nil,
+		},
+		{
+			`{"window": "1m", "events": 100, "reconcile": "decay", "reconcile_decay": "500ms"}`,
+			Config{Window: time.Minute, Events: 100, Reconcile: Decay, ReconcileDecay: time.Millisecond * 500},
+			nil,
+		},
+		{
+			`{"events": 1000, "restore_rate": 50}`,
+			Config{Events: 1000, RestoreRate: 50},
+			nil,
+		},
+		{
+			`{"events": 1000, "restore_rate": -1}`,
+			Config{},
+			[]string{"window: Must be set when events are", "restore_rate: Must not be negative"},
+		},
+		{
+			`{"window": "1m", "events": 100, "reconcile": "optimistic"}`,
+			Config{},
+			[]string{`reconcile: Unknown strategy "optimistic"`},
+		},
+		{
+			``,
+			Config{},
+			nil,
+		},
+		{
+			`{"window": "1m", "events": 100, "reclaim_after": "30s"}`,
+			Config{Window: time.Minute, Events: 100, ReclaimAfter: time.Second * 30},
+			nil,
+		},
+		{
+			`{"window": 60, "events": 100}`,
+			Config{},
+			[]string{`Invalid duration 60`},
+		},
+		{
+			"window: 60\nevents: 100\n",
+			Config{},
+			[]string{`line 1: Invalid duration "60"`},
+		},
+		{
+			"window: 1m\nevnts: 100\n",
+			Config{},
+			[]string{`field evnts not found`},
+		},
+		{
+			`{"window": "1m", "evnts": 100}`,
+			Config{},
+			[]string{`unknown field "evnts"`},
+		},
+		{
+			`
+events: 100
+mode: fast
+jitter: 2
+limits:
+  - events: 0
+    window: 1h
+shares: 2
+share_index: 2
+`,
+			Config{},
+			[]string{
+				"window: Must be set when events are",
+				"limits[0].events: Must be positive",
+				`mode: Unknown mode "fast"`,
+				"jitter: Must be a proportion between 0 and 1",
+				"share_index: Must be less than shares",
+			},
+		},
+	}
+	for i, e := range tests {
+		conf, err := ParseConfig([]byte(e.Doc))
+		if len(e.Errs) > 0 {
+			if assert.ErrorIs(t, err, ErrInvalidConfig, "#%d", i) {
+				for _, x := range e.Errs {
+					assert.Contains(t, err.Error(), x, "#%d", i)
+				}
+			}
+		} else if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, e.Expect, conf, "#%d", i)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Config Config
+		Fields []string
+	}{
+		{Config{}, nil},
+		{Config{Window: time.Minute, Events: 10, Location: time.FixedZone("X", 3600)}, nil},
+		{Config{Window: time.Minute}, nil}, // permits nothing
+		{Config{Events: 10}, []string{"window"}},
+		{Config{Window: -time.Minute, Events: -1}, []string{"events", "window"}},
+		{Config{Window: time.Minute, Events: 10, Reserve: 10, LowWatermark: 2}, []string{"low_watermark", "reserve"}},
+	}
+	for i, e := range tests {
+		err := e.Config.Validate()
+		if len(e.Fields) == 0 {
+			assert.NoError(t, err, "#%d", i)
+			continue
+		}
+		assert.ErrorIs(t, err, ErrInvalidConfig, "#%d", i)
+		var fields []string
+		for _, x := range err.(interface{ Unwrap() []error }).Unwrap() {
+			var cerr ConfigError
+			if assert.ErrorAs(t, x, &cerr, "#%d", i) {
+				fields = append(fields, cerr.Field)
+			}
+		}
+		assert.ElementsMatch(t, e.Fields, fields, "#%d", i)
+	}
+
+	assert.NotPanics(t, func() { MustLinear(Config{Window: time.Minute, Events: 10}) })
+	assert.Panics(t, func() { MustLinear(Config{Events: 10}) })
+}
+
+func TestJitter(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, now.Add(time.Second), jitter(0, now, now.Add(time.Second)))
+	assert.Equal(t, now, jitter(0.5, now, now))
+	for i := 0; i < 100; i++ {
+		v := jitter(0.5, now, now.Add(time.Second))
+		assert.False(t, v.Before(now.Add(time.Second)))
+		assert.False(t, v.After(now.Add(time.Millisecond*1500)))
+	}
+}
+
+func TestBackoffPeriod(t *testing.T) {
+	now := time.Now()
+	for _, lim := range []interface {
+		Backoff(time.Time) (time.Time, error)
+	}{
+		NewLinear(Config{Window: time.Minute, Events: 10, Backoff: time.Second}),
+		NewHeaders(Config{Window: time.Minute, Events: 10, Backoff: time.Second}),
+	} {
+		until, err := lim.Backoff(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(time.Second), until)
+		}
+		until, err = lim.Backoff(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(time.Second*4), until)
+		}
+	}
+}
+
+func TestConfigEncoding(t *testing.T) {
+	var doc struct {
+		Limits map[string]Config `json:"limits" yaml:"limits"`
+	}
+	err := json.Unmarshal([]byte(`{"limits": {"search": "10/s", "uploads": {"rate": "100/h", "mode": "burst", "max_wait": "30s"}}}`), &doc)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]Config{
+			"search":  {Events: 10, Window: time.Second},
+			"uploads": {Events: 100, Window: time.Hour, Mode: Burst, MaxWait: time.Second * 30},
+		}, doc.Limits)
+	}
+
+	doc.Limits = nil
+	err = yaml.Unmarshal([]byte("limits:\n  search: 10/s\n  uploads:\n    rate: 100/h\n    mode: burst\n"), &doc)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]Config{
+			"search":  {Events: 10, Window: time.Second},
+			"uploads": {Events: 100, Window: time.Hour, Mode: Burst},
+		}, doc.Limits)
+	}
+
+	err = json.Unmarshal([]byte(`{"limits": {"search": {"rate": "10/s", "events": 5}}}`), &doc)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	err = json.Unmarshal([]byte(`{"limits": {"search": "often"}}`), &doc)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	err = yaml.Unmarshal([]byte("limits:\n  search:\n    rate: 10/s\n    burst: 5\n"), &doc)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	err = yaml.Unmarshal([]byte("limits:\n  search:\n    rate: 10/s\n    limits:\n      - events: 100\n        window: 1m\n        period: 1m\n"), &doc)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	var conf Config
+	if assert.NoError(t, conf.UnmarshalText([]byte("1000/day"))) {
+		assert.Equal(t, Config{Events: 1000, Window: time.Hour * 24}, conf)
+	}
+
+	// configurations survive a round trip
+	start := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	orig := Config{Start: start, Window: time.Minute, Events: 100, Mode: Smooth, Limits: []Limit{{Events: 1000, Window: time.Hour}}, MaxDelay: time.Second, Jitter: 0.1, Location: time.UTC}
+	data, err := json.Marshal(orig)
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"start": "2024-04-12T00:00:00Z", "window": "1m0s", "events": 100, "mode": "smooth", "limits": [{"events": 1000, "window": "1h0m0s"}], "max_delay": "1s", "jitter": 0.1, "location": "UTC"}`, string(data))
+		conf, err := ParseConfig(data)
+		if assert.NoError(t, err) {
+			assert.Equal(t, orig, conf)
+		}
+	}
+	data, err = yaml.Marshal(orig)
+	if assert.NoError(t, err) {
+		conf, err := ParseConfig(data)
+		if assert.NoError(t, err) {
+			assert.Equal(t, orig, conf)
+		}
+	}
+}
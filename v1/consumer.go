@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// A PacedConsumer pulls messages from a source, such as a Kafka topic or a
+// work queue, and processes them at the rate permitted by a limiter. The
+// limiter is waited on before each message is pulled, so messages are not
+// consumed faster than they can be processed. This is useful for consumers
+// which fan out to rate limited APIs:
+//
+//	c := NewPacedConsumer(lim, reader.FetchMessage, func(cxt context.Context, m kafka.Message) ([]Option, error) {
+//		rsp, err := send(cxt, m)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return []Option{WithResponse(rsp)}, reader.CommitMessages(cxt, m)
+//	})
+//	err := c.Run(cxt)
+//
+// The options returned by the handler are provided to the limiter's Update,
+// so feedback from downstream, like a 429 status or rate limit headers,
+// adjusts the pace of consumption. If the handler or the update produces a
+// RetryError, the message is handled again after the indicated time, up to
+// the number of attempts configured via WithAttempts.
+type PacedConsumer[M any] struct {
+	lim    Limiter
+	conf   ExecutorConfig
+	pull   func(context.Context) (M, error)
+	handle func(context.Context, M) ([]Option, error)
+}
+
+// NewPacedConsumer creates a consumer which pulls messages with the pull
+// function and processes them with the handler.
+func NewPacedConsumer[M any](lim Limiter, pull func(context.Context) (M, error), handle func(context.Context, M) ([]Option, error), opts ...ExecutorOption) *PacedConsumer[M] {
+	return &PacedConsumer[M]{
+		lim:    lim,
+		conf:   ExecutorConfig{}.With(opts),
+		pull:   pull,
+		handle: handle,
+	}
+}
+
+// Run consumes messages until the context is canceled, in which case it
+// returns nil, or until pulling or handling a message fails, in which case
+// it returns the error. A handler which should not stop the consumer when it
+// fails must handle its own errors.
+func (c *PacedConsumer[M]) Run(cxt context.Context) error {
+	for {
+		err := c.next(cxt)
+		if cxt.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Wait on the limiter, then pull and handle a single message
+func (c *PacedConsumer[M]) next(cxt context.Context) error {
+	_, err := c.lim.Wait(cxt, time.Now())
+	if err != nil {
+		return err
+	}
+	m, err := c.pull(cxt)
+	if err != nil {
+		return err
+	}
+	for n := 1; ; n++ {
+		opts, err := c.handle(cxt, m)
+		if err == nil || len(opts) > 0 {
+			uerr := c.lim.Update(time.Now(), opts...)
+			if err == nil && errors.As(uerr, &RetryError{}) {
+				err = uerr
+			}
+		}
+		var rerr RetryError
+		if !errors.As(err, &rerr) {
+			return err
+		}
+		if c.conf.Attempts > 0 && n >= c.conf.Attempts {
+			return err
+		}
+		if d := time.Until(rerr.RetryAfter); d > 0 {
+			if err := pause(cxt, d); err != nil {
+				return err
+			}
+		}
+		// subsequent attempts to handle the message are also paced
+		if _, err := c.lim.Wait(cxt, time.Now()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errSourceClosed = errors.New("Source closed")
+
+// Pull messages from a slice, failing once it is exhausted
+func pullFrom[M any](msgs []M) func(context.Context) (M, error) {
+	return func(context.Context) (M, error) {
+		var m M
+		if len(msgs) == 0 {
+			return m, errSourceClosed
+		}
+		m, msgs = msgs[0], msgs[1:]
+		return m, nil
+	}
+}
+
+func TestPacedConsumer(t *testing.T) {
+	lim := NewLinear(Config{Window: time.Second, Events: 20})
+
+	var handled []int
+	var ops []time.Time
+	c := NewPacedConsumer(lim, pullFrom([]int{1, 2, 3, 4}), func(cxt context.Context, m int) ([]Option, error) {
+		handled = append(handled, m)
+		ops = append(ops, time.Now())
+		return nil, nil
+	})
+	err := c.Run(context.Background())
+	assert.ErrorIs(t, err, errSourceClosed)
+	assert.Equal(t, []int{1, 2, 3, 4}, handled)
+	for i := 1; i < len(ops); i++ {
+		assert.GreaterOrEqual(t, ops[i].Sub(ops[i-1]), time.Millisecond*40, "#%d", i)
+	}
+}
+
+func TestPacedConsumerFeedback(t *testing.T) {
+	lim := NewEstimator(Config{Window: time.Second, Events: 1000})
+
+	var handled []int
+	c := NewPacedConsumer(lim, pullFrom([]int{1, 2}), func(cxt context.Context, m int) ([]Option, error) {
+		handled = append(handled, m)
+		if len(handled) == 1 {
+			return []Option{WithStatus(http.StatusTooManyRequests)}, nil
+		}
+		return []Option{WithStatus(http.StatusOK)}, nil
+	})
+	err := c.Run(context.Background())
+	assert.ErrorIs(t, err, errSourceClosed)
+	assert.Equal(t, []int{1, 1, 2}, handled, "a throttled message is handled again")
+	assert.Less(t, lim.Rate(), 1000.0, "throttling slows consumption")
+
+	// when attempts are exhausted, the consumer stops
+	handled = nil
+	c = NewPacedConsumer(NewEstimator(Config{Window: time.Second, Events: 1000}), pullFrom([]int{1, 2}), func(cxt context.Context, m int) ([]Option, error) {
+		handled = append(handled, m)
+		return []Option{WithStatus(http.StatusTooManyRequests)}, nil
+	}, WithAttempts(2))
+	err = c.Run(context.Background())
+	assert.ErrorAs(t, err, &RetryError{})
+	assert.Equal(t, []int{1, 1}, handled)
+}
+
+func TestPacedConsumerCanceled(t *testing.T) {
+	cxt, cancel := context.WithCancel(context.Background())
+	c := NewPacedConsumer(NewLinear(Config{Window: time.Second, Events: 1000}), func(cxt context.Context) (int, error) {
+		return 1, nil
+	}, func(cxt context.Context, m int) ([]Option, error) {
+		cancel()
+		return nil, nil
+	})
+	assert.NoError(t, c.Run(cxt))
+}
@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultAnnounceInterval = time.Second * 5
+
+// Peers which have not announced themselves for this many intervals are
+// assumed to have left
+const peerExpiry = 3
+
+// An Announcement is broadcast periodically by each coordinated limiter to
+// make its presence known to its peers
+type Announcement struct {
+	ID   string    // identifies the announcing instance
+	Time time.Time // when the announcement was made
+}
+
+// A Broadcaster exchanges announcements between coordinated limiters. It may
+// be implemented over UDP multicast, a gossip library, or any pub/sub service.
+// Announcements published by an instance may be delivered back to it.
+type Broadcaster interface {
+	Publish(context.Context, Announcement) error
+	Subscribe(context.Context) (<-chan Announcement, error)
+}
+
+// Coordination configures how a coordinated limiter discovers its peers
+type Coordination struct {
+	// Uniquely identifies this instance among its peers
+	ID string
+	// Exchanges announcements with peers
+	Broadcaster Broadcaster
+	// How often this instance announces itself; defaults to 5 seconds
+	Interval time.Duration
+}
+
+// coordinated implements a rate limiter which shares a quota approximately
+// among a dynamic set of identical instances without a central store. Each
+// instance announces itself periodically and limits itself linearly to 1/N of
+// the quota, where N is the number of instances it has heard from recently,
+// including itself.
+type coordinated struct {
+	sync.Mutex
+	conf  Config
+	coord Coordination
+	impl  atomic.Pointer[linear]
+	peers map[string]Announcement
+	waiters
+}
+
+// NewCoordinated creates a coordinated limiter and begins exchanging
+// announcements with its peers until the context is canceled.
+func NewCoordinated(cxt context.Context, conf Config, coord Coordination) (*coordinated, error) {
+	if coord.Interval <= 0 {
+		coord.Interval = defaultAnnounceInterval
+	}
+	recv, err := coord.Broadcaster.Subscribe(cxt)
+	if err != nil {
+		return nil, fmt.Errorf("Could not subscribe to announcements: %w", err)
+	}
+	l := &coordinated{
+		conf:  conf,
+		coord: coord,
+		peers: make(map[string]Announcement),
+	}
+	l.impl.Store(NewLinear(conf))
+	go l.run(cxt, recv)
+	return l, nil
+}
+
+// Peers returns the number of instances sharing the quota, including this one
+func (l *coordinated) Peers() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.peers) + 1
+}
+
+func (l *coordinated) run(cxt context.Context, recv <-chan Announcement) {
+	ticker := time.NewTicker(l.coord.Interval)
+	defer ticker.Stop()
+	l.announce(cxt, time.Now())
+	for {
+		select {
+		case <-cxt.Done():
+			return
+		case a, ok := <-recv:
+			if !ok {
+				return
+			}
+			l.observe(a)
+		case t := <-ticker.C:
+			l.expire(t)
+			l.announce(cxt, t)
+		}
+	}
+}
+
+func (l *coordinated) announce(cxt context.Context, now time.Time) {
+	l.coord.Broadcaster.Publish(cxt, Announcement{ID: l.coord.ID, Time: now})
+}
+
+func (l *coordinated) observe(a Announcement) {
+	if a.ID == l.coord.ID {
+		return // our own announcement
+	}
+	l.Lock()
+	_, known := l.peers[a.ID]
+	l.peers[a.ID] = a
+	l.Unlock()
+	if !known {
+		l.rescale()
+	}
+}
+
+func (l *coordinated) expire(now time.Time) {
+	var changed bool
+	l.Lock()
+	for k, v := range l.peers {
+		if now.Sub(v.Time) > l.coord.Interval*peerExpiry {
+			delete(l.peers, k)
+			changed = true
+		}
+	}
+	l.Unlock()
+	if changed {
+		l.rescale()
+	}
+}
+
+// Scale our allowance to our share of the quota. The limiter's state, such as
+// a backoff in effect and the alignment of its windows, carries over.
+func (l *coordinated) rescale() {
+	l.Lock()
+	defer l.Unlock()
+	l.impl.Store(l.impl.Load().withEvents(max(1, l.conf.Events/(len(l.peers)+1))))
+}
+
+func (l *coordinated) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.impl.Load().Next(rel, opts...)
+}
+
+// Peek returns the time at which the next operation could proceed relative to
+// the provided time, as Next does, without consuming any quota
+func (l *coordinated) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.impl.Load().Peek(rel, opts...)
+}
+
+func (l *coordinated) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	if err := overloaded(l.conf.MaxWait, rel, l.EstimatedWait(rel)); err != nil {
+		return time.Time{}, err
+	}
+	t, err := l.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, t)
+}
+
+// EstimatedWait returns the delay a new operation would incur relative to the
+// provided time.
+func (l *coordinated) EstimatedWait(rel time.Time) time.Duration {
+	return l.impl.Load().EstimatedWait(rel)
+}
+
+func (l *coordinated) Update(rel time.Time, opts ...Option) error {
+	// Coordinated implementation does not use post-operation state
+	return nil
+}
+
+func (l *coordinated) State(rel time.Time) State {
+	return l.impl.Load().State(rel)
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// An in-process broadcaster which delivers every announcement to every
+// subscriber
+type fanout struct {
+	sync.Mutex
+	subs []chan Announcement
+}
+
+func (b *fanout) Publish(cxt context.Context, a Announcement) error {
+	b.Lock()
+	defer b.Unlock()
+	for _, e := range b.subs {
+		select {
+		case e <- a:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *fanout) Subscribe(cxt context.Context) (<-chan Announcement, error) {
+	b.Lock()
+	defer b.Unlock()
+	c := make(chan Announcement, 100)
+	b.subs = append(b.subs, c)
+	return c, nil
+}
+
+func TestCoordinated(t *testing.T) {
+	cxt, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &fanout{}
+	conf := Config{Window: time.Minute, Events: 60}
+	var lims []*coordinated
+	for _, id := range []string{"a", "b", "c"} {
+		lim, err := NewCoordinated(cxt, conf, Coordination{ID: id, Broadcaster: b, Interval: time.Millisecond * 10})
+		if assert.NoError(t, err) {
+			lims = append(lims, lim)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for _, lim := range lims {
+		for lim.Peers() < 3 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Equal(t, 3, lim.Peers())
+		assert.Equal(t, 20, lim.State(time.Now()).Limit)
+	}
+}
+
+func TestCoordinatedRescale(t *testing.T) {
+	cxt, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lim, err := NewCoordinated(cxt, Config{Window: time.Minute, Events: 60}, Coordination{ID: "a", Broadcaster: &fanout{}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	until := time.Now().Add(time.Hour)
+	assert.NoError(t, lim.impl.Load().BackoffUntil(until))
+
+	lim.observe(Announcement{ID: "b", Time: time.Now()})
+	assert.Equal(t, 2, lim.Peers())
+	assert.Equal(t, 30, lim.State(time.Now()).Limit)
+	// the backoff in effect survives the change in membership
+	assert.Equal(t, until, lim.impl.Load().BackoffEnd())
+}
@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A NamedLimiter associates a limiter with a name by which it is identified,
+// e.g., in debugging output
+type NamedLimiter struct {
+	Name    string
+	Limiter Limiter
+}
+
+const defaultDebugHistory = 20
+
+// The JSON representation of a limiter served by DebugHandler
+type debugLimiter struct {
+	Name    string       `json:"name"`
+	State   debugState   `json:"state"`
+	Backoff *time.Time   `json:"backoff,omitempty"`
+	Paused  bool         `json:"paused,omitempty"`
+	Stats   *debugStats  `json:"stats,omitempty"`
+	History []debugEvent `json:"history,omitempty"`
+}
+
+type debugState struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	Low       bool      `json:"low"`
+	Critical  bool      `json:"critical"`
+}
+
+type debugStats struct {
+	Rate    float64       `json:"rate"`
+	Delay   time.Duration `json:"delay"`
+	Backoff float64       `json:"backoff"`
+}
+
+type debugEvent struct {
+	Time      time.Time     `json:"time"`
+	Kind      string        `json:"kind"`
+	Delay     time.Duration `json:"delay,omitempty"`
+	Limit     int           `json:"limit"`
+	Remaining float64       `json:"remaining"`
+	Reset     time.Time     `json:"reset"`
+}
+
+// Produce the JSON representation of a limiter, including whatever optional
+// information it provides
+func debugSnapshot(e NamedLimiter, rel time.Time, n int) debugLimiter {
+	st := e.Limiter.State(rel)
+	res := debugLimiter{
+		Name:  e.Name,
+		State: debugState{Limit: st.Limit, Remaining: st.Remaining, Reset: st.Reset, Low: st.Low, Critical: st.Critical},
+	}
+	if v, ok := e.Limiter.(interface{ BackoffEnd() time.Time }); ok {
+		if t := v.BackoffEnd(); t.After(rel) {
+			res.Backoff = &t
+		}
+	}
+	if v, ok := e.Limiter.(interface{ Paused() bool }); ok {
+		res.Paused = v.Paused()
+	}
+	if v, ok := e.Limiter.(interface{ Stats() Stats }); ok {
+		s := v.Stats()
+		res.Stats = &debugStats{Rate: s.Rate, Delay: s.Delay, Backoff: s.Backoff}
+	}
+	if v, ok := e.Limiter.(interface{ History(int) []Event }); ok {
+		for _, x := range v.History(n) {
+			res.History = append(res.History, debugEvent{Time: x.Time, Kind: x.Kind.String(), Delay: x.Delay, Limit: x.Limit, Remaining: x.Remaining, Reset: x.Reset})
+		}
+	}
+	return res
+}
+
+// DebugHandler serves JSON snapshots of the provided limiters, suitable for
+// mounting under /debug. Each snapshot includes the limiter's state and, if
+// the limiter provides them, its backoff, whether it is paused, statistics,
+// and recent history.
+//
+// If no limiters are provided, those in the default registry at the time of
+// each request are served.
+//
+// The number of history events included may be set with the 'history' query
+// parameter; it defaults to 20. A single limiter may be selected by name with
+// the 'name' query parameter.
+func DebugHandler(limiters ...NamedLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		limiters := limiters
+		if len(limiters) == 0 {
+			limiters = Registered()
+		}
+		n := defaultDebugHistory
+		if v := req.URL.Query().Get("history"); v != "" {
+			x, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid history count: "+v, http.StatusBadRequest)
+				return
+			}
+			n = x
+		}
+		name := req.URL.Query().Get("name")
+		now := time.Now()
+		res := make([]debugLimiter, 0, len(limiters))
+		for _, e := range limiters {
+			if name == "" || name == e.Name {
+				res = append(res, debugSnapshot(e, now, n))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Limiters []debugLimiter `json:"limiters"`
+		}{res})
+	})
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugHandler(t *testing.T) {
+	hdr := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst, History: 5})
+	hdr.Next(time.Now())
+	hdr.BackoffUntil(time.Now().Add(time.Minute))
+	lin := NewLinear(Config{Window: time.Minute, Events: 10})
+	handler := DebugHandler(NamedLimiter{"headers", hdr}, NamedLimiter{"linear", lin})
+
+	type result struct {
+		Limiters []struct {
+			Name    string
+			State   struct{ Limit, Remaining int }
+			Backoff *time.Time
+			Stats   *struct{ Rate float64 }
+			History []struct{ Kind string }
+		}
+	}
+	tests := []struct {
+		URL   string
+		Names []string
+		Kinds []string
+	}{
+		{"/debug/ratelimit", []string{"headers", "linear"}, []string{"granted", "backed-off"}},
+		{"/debug/ratelimit?history=1", []string{"headers", "linear"}, []string{"backed-off"}},
+		{"/debug/ratelimit?name=headers", []string{"headers"}, []string{"granted", "backed-off"}},
+	}
+	for _, e := range tests {
+		t.Run(e.URL, func(t *testing.T) {
+			rsp := httptest.NewRecorder()
+			handler.ServeHTTP(rsp, httptest.NewRequest("GET", e.URL, nil))
+			assert.Equal(t, "application/json", rsp.Header().Get("Content-Type"))
+			var res result
+			if !assert.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &res)) {
+				return
+			}
+			var names, kinds []string
+			for _, l := range res.Limiters {
+				names = append(names, l.Name)
+			}
+			assert.Equal(t, e.Names, names)
+			h := res.Limiters[0]
+			assert.Equal(t, 10, h.State.Limit)
+			assert.Equal(t, 9, h.State.Remaining)
+			assert.NotNil(t, h.Backoff)
+			assert.NotNil(t, h.Stats)
+			for _, x := range h.History {
+				kinds = append(kinds, x.Kind)
+			}
+			assert.Equal(t, e.Kinds, kinds)
+		})
+	}
+
+	rsp := httptest.NewRecorder()
+	handler.ServeHTTP(rsp, httptest.NewRequest("GET", "/debug/ratelimit?history=many", nil))
+	assert.Equal(t, 400, rsp.Code)
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+)
+
+// A Dedup coalesces identical operations which are in flight at the same
+// time, identified by a fingerprint provided by the caller, so that they wait
+// on the limiter once, consume one operation from the quota, and share one
+// result. This is useful to avoid spending quota on duplicate lookups, as
+// when many callers refresh the same cache entry at once.
+type Dedup[T any] struct {
+	sync.Mutex
+	lim   Limiter
+	conf  ExecutorConfig
+	calls map[string]*dedupCall[T]
+}
+
+type dedupCall[T any] struct {
+	done chan struct{}
+	dups int // the number of callers which joined the operation
+	res  T
+	err  error
+}
+
+// NewDedup creates a Dedup which executes operations under the provided
+// limiter, as Do does.
+func NewDedup[T any](lim Limiter, opts ...ExecutorOption) *Dedup[T] {
+	return &Dedup[T]{
+		lim:   lim,
+		conf:  ExecutorConfig{}.With(opts),
+		calls: make(map[string]*dedupCall[T]),
+	}
+}
+
+// Do executes the operation as Do does, unless an operation with the same
+// key is already in flight, in which case it waits for that operation and
+// returns its result. The operation produces a result, which is shared by
+// every caller, as well as the attributes used to update the limiter.
+//
+// The in-flight operation executes under the context of the caller which
+// initiated it; if that context is canceled, every caller waiting on the
+// operation receives the resulting error. A caller whose own context is
+// canceled while waiting returns ErrCanceled.
+func (d *Dedup[T]) Do(cxt context.Context, key string, fn func(context.Context) (T, Attrs, error)) (T, error) {
+	d.Lock()
+	if c, ok := d.calls[key]; ok {
+		c.dups++
+		d.Unlock()
+		select {
+		case <-c.done:
+			return c.res, c.err
+		case <-cxt.Done():
+			var zero T
+			return zero, ErrCanceled
+		}
+	}
+	c := &dedupCall[T]{done: make(chan struct{})}
+	d.calls[key] = c
+	d.Unlock()
+
+	c.err = do(cxt, d.lim, d.conf, func(cxt context.Context) (Attrs, error) {
+		var attrs Attrs
+		var err error
+		c.res, attrs, err = fn(cxt)
+		return attrs, err
+	})
+
+	d.Lock()
+	delete(d.calls, key)
+	d.Unlock()
+	close(c.done)
+	return c.res, c.err
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst})
+	dd := NewDedup[string](lim)
+
+	var n atomic.Int32
+	release := make(chan struct{})
+	fn := func(cxt context.Context) (string, Attrs, error) {
+		n.Add(1)
+		<-release
+		return "result", nil, nil
+	}
+
+	var wg sync.WaitGroup
+	res := make([]string, 5)
+	for i := range res {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := dd.Do(context.Background(), "key", fn)
+			if assert.NoError(t, err) {
+				res[i] = v
+			}
+		}()
+	}
+	for { // wait for every caller to join the in-flight operation
+		dd.Lock()
+		c := dd.calls["key"]
+		ok := c != nil && c.dups == len(res)-1
+		dd.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, []string{"result", "result", "result", "result", "result"}, res)
+	assert.Equal(t, int32(1), n.Load(), "operations are coalesced")
+	assert.Equal(t, 9, lim.State(time.Now()).Remaining, "one operation is consumed")
+
+	// once complete, a subsequent operation is executed again
+	v, err := dd.Do(context.Background(), "key", func(cxt context.Context) (string, Attrs, error) {
+		return "another", nil, nil
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "another", v)
+	}
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// An Operation is executed under a rate limit. It returns the attributes of
+// its result, such as the headers of a response, which are used to update the
+// limiter, or nil if it has none.
+type Operation func(context.Context) (Attrs, error)
+
+// Do waits on the limiter, executes the operation, and feeds the attributes
+// it produces back to the limiter via Update. If either the operation or the
+// update produces a RetryError, the operation is retried after the indicated
+// time, up to the number of attempts configured via WithAttempts.
+//
+// Errors from Update other than RetryError, such as missing headers, do not
+// cause the operation to fail.
+func Do(cxt context.Context, lim Limiter, fn Operation, opts ...ExecutorOption) error {
+	return do(cxt, lim, ExecutorConfig{}.With(opts), fn)
+}
+
+// The options, if any, are provided to the limiter for every wait and update
+func do(cxt context.Context, lim Limiter, conf ExecutorConfig, fn Operation, opts ...Option) error {
+	for n := 1; ; n++ {
+		_, err := lim.Wait(cxt, time.Now(), opts...)
+		if err != nil {
+			return err
+		}
+		attrs, err := fn(cxt)
+		if attrs != nil {
+			uerr := lim.Update(time.Now(), append(opts, WithAttrs(attrs))...)
+			if err == nil && errors.As(uerr, &RetryError{}) {
+				err = uerr
+			}
+		}
+		var rerr RetryError
+		if !errors.As(err, &rerr) {
+			return err
+		}
+		if conf.Attempts > 0 && n >= conf.Attempts {
+			return err
+		}
+		if d := time.Until(rerr.RetryAfter); d > 0 {
+			if err := pause(cxt, d); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 1000, Mode: Burst, Durationer: Milliseconds})
+
+	// the first attempt is throttled by the service, the second succeeds
+	var n int
+	err := Do(context.Background(), lim, func(cxt context.Context) (Attrs, error) {
+		n++
+		if n == 1 {
+			return Attrs{"Retry-After": []string{"5"}}, nil
+		}
+		return Attrs{
+			"Ratelimit-Limit":     []string{"1000"},
+			"Ratelimit-Remaining": []string{"998"},
+			"Ratelimit-Reset":     []string{"60000"},
+		}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 998, lim.State(time.Now()).Remaining)
+
+	// attempts are bounded
+	n = 0
+	err = Do(context.Background(), lim, func(cxt context.Context) (Attrs, error) {
+		n++
+		return Attrs{"Retry-After": []string{"1"}}, nil
+	}, WithAttempts(3))
+	assert.ErrorAs(t, err, &RetryError{})
+	assert.Equal(t, 3, n)
+}
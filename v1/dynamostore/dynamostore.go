@@ -0,0 +1,146 @@
+// Package dynamostore implements a rate limiter Store backed by DynamoDB.
+// State is modified with conditional writes against a version attribute, so
+// concurrent decrements from different processes never overwrite one another.
+//
+// The table must have a string partition key, named "key" unless configured
+// otherwise. Items carry an "expires" attribute, in Unix seconds, which may be
+// used as the table's TTL attribute to remove state for past windows. If it
+// is, any debt a shared limiter incurred by borrowing from subsequent windows
+// (see Config.Debt) is forgiven once the window it was incurred in has reset.
+package dynamostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+const defaultKeyAttr = "key"
+
+// Client is the subset of a DynamoDB client used by the store; it is
+// satisfied by *dynamodb.Client.
+type Client interface {
+	GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// Store persists limiter state in a DynamoDB table
+type Store struct {
+	client  Client
+	table   string
+	keyAttr string
+}
+
+// New creates a store which persists state in the provided table. The name
+// of the table's partition key attribute may be provided; if it is empty, the
+// default "key" is used.
+func New(client Client, table, keyAttr string) *Store {
+	if keyAttr == "" {
+		keyAttr = defaultKeyAttr
+	}
+	return &Store{
+		client:  client,
+		table:   table,
+		keyAttr: keyAttr,
+	}
+}
+
+// Modify atomically reads, modifies, and persists the state for a key. If the
+// state is modified concurrently by another client, the operation is retried
+// until it succeeds or the context is canceled.
+func (s *Store) Modify(cxt context.Context, key string, fn func(*ratelimit.State) error) error {
+	for {
+		if err := cxt.Err(); err != nil {
+			return ratelimit.ErrCanceled
+		}
+
+		rsp, err := s.client.GetItem(cxt, &dynamodb.GetItemInput{
+			TableName:      aws.String(s.table),
+			Key:            map[string]types.AttributeValue{s.keyAttr: &types.AttributeValueMemberS{Value: key}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("Could not read state: %w", err)
+		}
+		state, version, err := decode(rsp.Item)
+		if err != nil {
+			return fmt.Errorf("Could not decode state: %w", err)
+		}
+
+		err = fn(&state)
+		if err != nil {
+			return err
+		}
+
+		item := encode(state, version+1)
+		item[s.keyAttr] = &types.AttributeValueMemberS{Value: key}
+		put := &dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item:      item,
+		}
+		if version == 0 {
+			put.ConditionExpression = aws.String("attribute_not_exists(#k)")
+			put.ExpressionAttributeNames = map[string]string{"#k": s.keyAttr}
+		} else {
+			put.ConditionExpression = aws.String("#v = :v")
+			put.ExpressionAttributeNames = map[string]string{"#v": "version"}
+			put.ExpressionAttributeValues = map[string]types.AttributeValue{":v": number(version)}
+		}
+
+		_, err = s.client.PutItem(cxt, put)
+		var cerr *types.ConditionalCheckFailedException
+		if errors.As(err, &cerr) {
+			continue // somebody else got there first; try again
+		} else if err != nil {
+			return fmt.Errorf("Could not write state: %w", err)
+		}
+		return nil
+	}
+}
+
+func number(v int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+}
+
+// Encode state as item attributes
+func encode(s ratelimit.State, version int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"limit":     number(int64(s.Limit)),
+		"remaining": number(int64(s.Remaining)),
+		"reset":     number(s.Reset.UnixNano()),
+		"expires":   number(s.Reset.Unix() + 1),
+		"version":   number(version),
+	}
+}
+
+// Decode state and its version from item attributes. A missing item is a zero
+// state at version zero.
+func decode(item map[string]types.AttributeValue) (ratelimit.State, int64, error) {
+	if len(item) == 0 {
+		return ratelimit.State{}, 0, nil
+	}
+	var vals [4]int64
+	for i, n := range []string{"limit", "remaining", "reset", "version"} {
+		a, ok := item[n].(*types.AttributeValueMemberN)
+		if !ok {
+			return ratelimit.State{}, 0, fmt.Errorf("Attribute is missing or not a number: %s", n)
+		}
+		v, err := strconv.ParseInt(a.Value, 10, 64)
+		if err != nil {
+			return ratelimit.State{}, 0, fmt.Errorf("Attribute is invalid: %s: %w", n, err)
+		}
+		vals[i] = v
+	}
+	return ratelimit.State{
+		Limit:     int(vals[0]),
+		Remaining: int(vals[1]),
+		Reset:     time.Unix(0, vals[2]).UTC(),
+	}, vals[3], nil
+}
@@ -0,0 +1,77 @@
+package dynamostore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// A trivial in-memory table which understands the conditions used by the store
+type fakeClient struct {
+	sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func (c *fakeClient) GetItem(cxt context.Context, in *dynamodb.GetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+	k := in.Key[defaultKeyAttr].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: c.items[k]}, nil
+}
+
+func (c *fakeClient) PutItem(cxt context.Context, in *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+	k := in.Item[defaultKeyAttr].(*types.AttributeValueMemberS).Value
+	curr, exists := c.items[k]
+	switch aws.ToString(in.ConditionExpression) {
+	case "attribute_not_exists(#k)":
+		if exists {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	case "#v = :v":
+		if !exists || curr["version"].(*types.AttributeValueMemberN).Value != in.ExpressionAttributeValues[":v"].(*types.AttributeValueMemberN).Value {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+	c.items[k] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestShared(t *testing.T) {
+	store := New(&fakeClient{items: make(map[string]map[string]types.AttributeValue)}, "ratelimits", "")
+	conf := ratelimit.Config{Window: time.Minute, Events: 10}
+	now := time.Date(2024, 4, 12, 0, 0, 30, 0, time.UTC)
+	reset := time.Date(2024, 4, 12, 0, 1, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var permitted int
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lim := ratelimit.NewShared(conf, store, "example")
+			for j := 0; j < 4; j++ {
+				next, err := lim.Next(now)
+				if assert.NoError(t, err) && next.Equal(now) {
+					mu.Lock()
+					permitted++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, permitted)
+
+	lim := ratelimit.NewShared(conf, store, "example")
+	assert.Equal(t, ratelimit.State{Limit: 10, Remaining: 0, Reset: reset}, lim.State(now))
+}
@@ -0,0 +1,26 @@
+// Package echolimit adapts the rate limiting server middleware to echo.
+// Limits may be applied to an entire server or group with Use, or to
+// individual routes, each using its own limiter:
+//
+//	e.Use(echolimit.Middleware(global))
+//	e.POST("/uploads", handler, echolimit.Middleware(uploads))
+package echolimit
+
+import (
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware produces echo middleware which limits requests under the
+// provided limiter. See ratelimit.Gate for details.
+func Middleware(lim ratelimit.Limiter, opts ...ratelimit.GateOption) echo.MiddlewareFunc {
+	gate := ratelimit.NewGate(lim, opts...)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !gate.Admit(c.Response(), c.Request()) {
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
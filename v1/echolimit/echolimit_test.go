@@ -0,0 +1,32 @@
+package echolimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	lim := ratelimit.NewShared(ratelimit.Config{Window: time.Hour, Events: 1}, ratelimit.NewMemoryStore(), "")
+	e := echo.New()
+	e.GET("/limited", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, Middleware(lim))
+	e.GET("/unlimited", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	for i, x := range []struct {
+		Path   string
+		Status int
+	}{
+		{"/limited", http.StatusOK},
+		{"/limited", http.StatusTooManyRequests},
+		{"/unlimited", http.StatusOK},
+	} {
+		rsp := httptest.NewRecorder()
+		e.ServeHTTP(rsp, httptest.NewRequest("GET", x.Path, nil))
+		assert.Equal(t, x.Status, rsp.Code, "#%d", i)
+	}
+}
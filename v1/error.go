@@ -6,12 +6,56 @@ import (
 	"time"
 )
 
+// Errors produced by limiters are, or wrap, one of these so that they can be
+// identified with errors.Is.
 var (
-	ErrCanceled       = errors.New("Canceled")
-	ErrMissingAttrs   = errors.New("Missing attributes")
+	// A wait was interrupted because its context was canceled
+	ErrCanceled = errors.New("Canceled")
+	// The limiter is draining and no longer admits new callers to Wait
+	ErrDraining = errors.New("Draining")
+	// The limiter was closed while a caller was waiting, or before it began to
+	ErrClosed = errors.New("Closed")
+	// An operation would be delayed longer than the maximum wait; see OverloadError
+	ErrOverloaded = errors.New("Overloaded")
+	// An update was attempted without the attributes it requires
+	ErrMissingAttrs = errors.New("Missing attributes")
+	// The attributes provided to an update do not include rate limiting headers
 	ErrMissingHeaders = errors.New("Missing rate-limiting headers")
+	// A rate limiting header is present but cannot be parsed
+	ErrInvalidHeaders = errors.New("Rate limit header is invalid")
+	// The quota is exhausted until the window resets
+	ErrExhausted = errors.New("Quota exhausted")
+	// An item cannot be enqueued because the queue is full
+	ErrQueueFull = errors.New("Queue is full")
+	// An enqueued item was dropped to make room for others
+	ErrDropped = errors.New("Dropped from queue")
+	// No limiter is registered under the name provided
+	ErrNotRegistered = errors.New("No such limiter")
+	// A configuration is malformed or describes impossible limits
+	ErrInvalidConfig = errors.New("Invalid configuration")
+	// An operation was refused outright by a bypass function, regardless of quota
+	ErrRejected = errors.New("Rejected")
+	// A budget's allotment has been used or its period has ended
+	ErrBudgetSpent = errors.New("Budget spent")
+	// A remote service has requested that we back off; see RetryError
+	ErrBackoff = errors.New("Backoff requested")
 )
 
+// ConfigError describes a field of a configuration which is invalid. Fields
+// are named as they are in documents, e.g., max_wait.
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%v: %s: %s", ErrInvalidConfig, e.Field, e.Reason)
+}
+
 // RetryError represents a rate limiting error from a remote service that
 // indicates when we should attempt our operation again.
 type RetryError struct {
@@ -23,6 +67,11 @@ func (e RetryError) Unwrap() error {
 	return e.Cause
 }
 
+// Is reports that every RetryError is an ErrBackoff
+func (e RetryError) Is(target error) bool {
+	return target == ErrBackoff
+}
+
 func (e RetryError) Error() string {
 	if c := e.Cause; c != nil {
 		return c.Error()
@@ -30,3 +79,32 @@ func (e RetryError) Error() string {
 		return fmt.Sprintf("Retry after: %v", e.RetryAfter)
 	}
 }
+
+// OverloadError is returned by Wait when an operation would be delayed longer
+// than the configured maximum wait. It indicates when the operation would have
+// been permitted.
+type OverloadError struct {
+	Next time.Time
+}
+
+func (e OverloadError) Unwrap() error {
+	return ErrOverloaded
+}
+
+func (e OverloadError) Error() string {
+	return fmt.Sprintf("%v: next operation permitted at %v", ErrOverloaded, e.Next)
+}
+
+// ExhaustedError is returned by Next in strict mode when the quota is
+// exhausted. It indicates when the window resets.
+type ExhaustedError struct {
+	Reset time.Time
+}
+
+func (e ExhaustedError) Unwrap() error {
+	return ErrExhausted
+}
+
+func (e ExhaustedError) Error() string {
+	return fmt.Sprintf("%v: window resets at %v", ErrExhausted, e.Reset)
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		Name   string
+		Func   func() error
+		Expect error
+	}{
+		{"MissingAttrs", func() error {
+			return NewHeaders(Config{}).Update(now)
+		}, ErrMissingAttrs},
+		{"MissingHeaders", func() error {
+			return NewHeaders(Config{}).Update(now, WithAttrs(Attrs{}))
+		}, ErrMissingHeaders},
+		{"InvalidHeaders", func() error {
+			return NewHeaders(Config{}).Update(now, WithAttrs(Attrs{"Ratelimit-Limit": []string{"ten"}}))
+		}, ErrInvalidHeaders},
+		{"InvalidBucketHeaders", func() error {
+			return NewBuckets(Config{}).Update(now, WithAttrs(Attrs{"X-Ratelimit-Global": []string{"true"}, "Retry-After": []string{"soon"}}))
+		}, ErrInvalidHeaders},
+		{"InvalidDate", func() error {
+			_, err := Dates.ParseTime(now, "tomorrow")
+			return err
+		}, ErrInvalidHeaders},
+		{"InvalidResetDate", func() error {
+			return NewHeaders(Config{Durationer: Dates}).Update(now, WithAttrs(Attrs{
+				"Ratelimit-Limit":     []string{"10"},
+				"Ratelimit-Remaining": []string{"5"},
+				"Ratelimit-Reset":     []string{"tomorrow"},
+			}))
+		}, ErrInvalidHeaders},
+		{"InvalidRate", func() error {
+			_, err := ParseRate("100 a minute")
+			return err
+		}, ErrInvalidConfig},
+		{"InvalidRateWindow", func() error {
+			_, _, err := parseRate("100/fortnight")
+			return err
+		}, ErrInvalidConfig},
+		{"InvalidDuration", func() error {
+			var d duration
+			return d.set("soon")
+		}, ErrInvalidConfig},
+		{"InvalidJSONDuration", func() error {
+			var d duration
+			return d.UnmarshalJSON([]byte("60"))
+		}, ErrInvalidConfig},
+		{"InvalidYAMLDuration", func() error {
+			_, err := ParseConfig([]byte("window: 60\nevents: 100\n"))
+			return err
+		}, ErrInvalidConfig},
+		{"Backoff", func() error {
+			return NewHeaders(Config{}).Update(now, WithAttrs(Attrs{"Retry-After": []string{"10"}}))
+		}, ErrBackoff},
+		{"Overloaded", func() error {
+			_, err := NewLinear(Config{Window: time.Hour, Events: 1, MaxWait: time.Second}).Wait(context.Background(), time.Now())
+			return err
+		}, ErrOverloaded},
+		{"Canceled", func() error {
+			_, err := NewLinear(Config{Window: time.Hour, Events: 1}).Wait(canceled, time.Now())
+			return err
+		}, ErrCanceled},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			assert.ErrorIs(t, e.Func(), e.Expect)
+		})
+	}
+}
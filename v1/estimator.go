@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bww/go-util/v1/ext"
+)
+
+const (
+	defaultEstimatedRate = 1.0  // absent any configuration, begin at one operation per second
+	estimatorIncrease    = 1.01 // each success increases the rate by 1%
+	estimatorDecrease    = 0.5  // each throttled response halves the rate...
+	estimatorMargin      = 0.9  // ...unless we observed a sustainable rate, in which case we target 90% of it
+	minEstimatedRate     = 1.0 / 3600
+)
+
+// estimator implements a rate limiter for services which document no limits
+// and provide no rate limiting headers, only throttled responses: 429 Too
+// Many Requests, possibly with Retry-After. It infers an effective rate from
+// the responses provided to Update and spaces operations out evenly at that
+// rate.
+//
+// Successful responses increase the rate slightly, at most once per interval
+// between operations. A throttled response reduces it: to a margin below the
+// rate actually sustained since the previous throttled response, if one was
+// observed, otherwise by half. Further throttled responses received during the
+// resulting backoff are attributed to the same episode and do not reduce it
+// again. The rate thereby converges on one the service tolerates.
+//
+// The initial rate is Events per Window, if configured, otherwise one per
+// second. Responses must carry their status, via WithStatus or WithResponse;
+// a response with a Retry-After header is also considered throttled. Other
+// signals of throttling may be recognized by configuring a Classifier.
+type estimator struct {
+	dur     Durationer
+	classes Classifier
+	window  time.Duration
+	maxWait time.Duration
+	waiters
+
+	sync.Mutex
+	rate      float64   // the estimated rate, in operations per second
+	last      time.Time // the time of the last permitted operation
+	throttled time.Time // the time of the last throttled response, if any
+	successes int       // the number of successful responses since then
+	grown     time.Time // when the rate was last increased
+	backoff   time.Time
+}
+
+func NewEstimator(conf Config) *estimator {
+	rate := defaultEstimatedRate
+	if conf.Events > 0 && conf.Window > 0 {
+		rate = float64(conf.Events) / conf.Window.Seconds()
+	}
+	var dur Durationer
+	if d := conf.Durationer; d != nil {
+		dur = d
+	} else {
+		dur = Seconds
+	}
+	return &estimator{
+		dur:     dur,
+		classes: conf.Classifier,
+		window:  ext.Coalesce(conf.Window, time.Minute),
+		maxWait: conf.MaxWait,
+		rate:    rate,
+	}
+}
+
+// Clone produces an independent copy of the limiter, including the rate it
+// has estimated
+func (l *estimator) Clone() Limiter {
+	l.Lock()
+	defer l.Unlock()
+	return &estimator{
+		dur:       l.dur,
+		classes:   l.classes,
+		window:    l.window,
+		maxWait:   l.maxWait,
+		rate:      l.rate,
+		last:      l.last,
+		throttled: l.throttled,
+		successes: l.successes,
+		grown:     l.grown,
+		backoff:   l.backoff,
+	}
+}
+
+// Rate returns the estimated rate, in operations per second
+func (l *estimator) Rate() float64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.rate
+}
+
+// The interval between operations at the estimated rate; the caller must hold
+// the lock
+func (l *estimator) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.rate)
+}
+
+// Determine when the next operation may proceed, reserving that slot if
+// consume is set
+func (l *estimator) next(rel time.Time, consume bool) time.Time {
+	l.Lock()
+	defer l.Unlock()
+	t := rel
+	if l.backoff.After(t) {
+		t = l.backoff
+	}
+	if !l.last.IsZero() {
+		if n := l.last.Add(l.interval()); n.After(t) {
+			t = n
+		}
+	}
+	if consume {
+		l.last = t
+	}
+	return t
+}
+
+func (l *estimator) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.next(rel, true), nil
+}
+
+// Peek returns the time at which the next operation could proceed relative to
+// the provided time, as Next does, without reserving it
+func (l *estimator) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.next(rel, false), nil
+}
+
+func (l *estimator) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	if err := overloaded(l.maxWait, rel, l.EstimatedWait(rel)); err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, l.next(rel, true))
+}
+
+// EstimatedWait returns the delay a new operation would incur relative to the
+// provided time, without reserving it
+func (l *estimator) EstimatedWait(rel time.Time) time.Duration {
+	return l.next(rel, false).Sub(rel)
+}
+
+// Update adjusts the estimated rate from the status of a response. A throttled
+// response produces a RetryError describing when operations may resume.
+func (l *estimator) Update(rel time.Time, opts ...Option) error {
+	conf := Options{}.With(opts)
+	v := conf.verdict(l.classes)
+	var retry time.Time
+	if n, x := findAttr(conf.Attrs, retryAfterHeaders); x != "" {
+		f, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s = %s: %v", ErrInvalidHeaders, n, x, err)
+		}
+		retry = rel.Add(fracDuration(l.dur, f))
+	} else if v.Outcome == Throttled && v.RetryAfter > 0 {
+		retry = rel.Add(v.RetryAfter)
+	}
+	if v.Outcome == Fatal {
+		return nil // says nothing about the rate the service tolerates
+	} else if v.Outcome != Throttled {
+		l.Lock()
+		l.successes++
+		if rel.Sub(l.grown) >= l.interval() {
+			l.rate *= estimatorIncrease
+			l.grown = rel
+		}
+		l.Unlock()
+		return nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if rel.Before(l.backoff) {
+		// we've already reduced the rate for the throttling that produced this response
+		if retry.After(l.backoff) {
+			l.backoff = retry
+		}
+		return RetryError{RetryAfter: l.backoff}
+	}
+	rate := l.rate * estimatorDecrease
+	if !l.throttled.IsZero() && rel.After(l.throttled) {
+		// the rate we actually sustained between throttled responses is close to the real limit
+		if s := float64(l.successes) / rel.Sub(l.throttled).Seconds(); s*estimatorMargin > rate {
+			rate = math.Min(l.rate, s*estimatorMargin)
+		}
+	}
+	l.rate = math.Max(minEstimatedRate, rate)
+	l.throttled = rel
+	l.successes = 0
+	l.backoff = ext.Coalesce(retry, rel.Add(l.interval()))
+	return RetryError{RetryAfter: l.backoff}
+}
+
+// State describes the estimated rate as a number of operations per window,
+// which are all remaining unless a backoff is in effect
+func (l *estimator) State(rel time.Time) State {
+	l.Lock()
+	defer l.Unlock()
+	lim := int(l.rate * l.window.Seconds())
+	if l.backoff.After(rel) {
+		return State{Limit: lim, Remaining: 0, Reset: l.backoff}
+	}
+	return State{Limit: lim, Remaining: lim, Reset: rel.Add(l.window)}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimator(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+
+	// a service which permits 2 requests per one-second window and otherwise
+	// responds with a bare 429
+	var start time.Time
+	var count int
+	service := func(rel time.Time) int {
+		if w := rel.Truncate(time.Second); !w.Equal(start) {
+			start, count = w, 0
+		}
+		if count++; count > 2 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	}
+
+	lim := NewEstimator(Config{Window: time.Second, Events: 10})
+	assert.Equal(t, 10.0, lim.Rate())
+
+	var throttled, late int // throttled responses in total, and in the second half
+	rel := now
+	for i := 0; i < 2000; i++ {
+		next, err := lim.Next(rel)
+		assert.NoError(t, err)
+		rel = next
+		status := service(rel)
+		if status == http.StatusTooManyRequests {
+			throttled++
+			if i > 1000 {
+				late++
+			}
+		}
+		err = lim.Update(rel, WithStatus(status))
+		if status == http.StatusTooManyRequests {
+			assert.ErrorIs(t, err, ErrBackoff)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	// the rate converges near the real limit and throttling becomes rare
+	assert.InDelta(t, 2.0, lim.Rate(), 0.5)
+	assert.Less(t, late, 50, "%d of %d throttled", late, throttled)
+
+	// Retry-After is honored
+	err := lim.Update(rel, WithAttrs(Attrs{"Retry-After": {"30"}}))
+	assert.ErrorIs(t, err, ErrBackoff)
+	next, _ := lim.Next(rel)
+	assert.Equal(t, rel.Add(time.Second*30), next)
+	assert.Equal(t, 0, lim.State(rel).Remaining)
+}
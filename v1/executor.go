@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+)
+
+// Executor configuration
+type ExecutorConfig struct {
+	// The maximum number of operations which may execute concurrently; if <= 0, concurrency is unbounded
+	Concurrency int
+	// The maximum number of times an operation is attempted when it is rate limited; if <= 0, there is no limit
+	Attempts int
+}
+
+// With applies additional options to the receiver
+func (c ExecutorConfig) With(opts []ExecutorOption) ExecutorConfig {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+// A functional executor option
+type ExecutorOption func(ExecutorConfig) ExecutorConfig
+
+// WithConcurrency bounds the number of operations which execute concurrently
+func WithConcurrency(n int) ExecutorOption {
+	return func(c ExecutorConfig) ExecutorConfig {
+		c.Concurrency = n
+		return c
+	}
+}
+
+// WithAttempts bounds the number of times a rate limited operation is attempted
+func WithAttempts(n int) ExecutorOption {
+	return func(c ExecutorConfig) ExecutorConfig {
+		c.Attempts = n
+		return c
+	}
+}
+
+// An Executor runs operations in a pool with bounded concurrency, waiting on
+// a limiter before each operation is executed. When an operation fails with a
+// RetryError, it is retried after the indicated time. It is used much like an
+// errgroup.Group:
+//
+//	ex := NewExecutor(lim, WithConcurrency(8))
+//	for _, e := range work {
+//		ex.Go(cxt, func(cxt context.Context) error { ... })
+//	}
+//	err := ex.Wait()
+type Executor struct {
+	lim  Limiter
+	conf ExecutorConfig
+	sem  chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+	err  error
+}
+
+func NewExecutor(lim Limiter, opts ...ExecutorOption) *Executor {
+	conf := ExecutorConfig{}.With(opts)
+	var sem chan struct{}
+	if conf.Concurrency > 0 {
+		sem = make(chan struct{}, conf.Concurrency)
+	}
+	return &Executor{
+		lim:  lim,
+		conf: conf,
+		sem:  sem,
+	}
+}
+
+// Go executes an operation in a new goroutine once a concurrency slot is
+// available; it blocks until then or until the context is canceled. The first
+// error returned by any operation is reported by Wait.
+func (e *Executor) Go(cxt context.Context, fn func(context.Context) error) {
+	if e.sem != nil {
+		select {
+		case e.sem <- struct{}{}:
+		case <-cxt.Done():
+			e.fail(ErrCanceled)
+			return
+		}
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		if e.sem != nil {
+			defer func() { <-e.sem }()
+		}
+		if err := do(cxt, e.lim, e.conf, func(cxt context.Context) (Attrs, error) { return nil, fn(cxt) }); err != nil {
+			e.fail(err)
+		}
+	}()
+}
+
+// Wait blocks until all operations have completed and returns the first error
+// produced by any of them.
+func (e *Executor) Wait() error {
+	e.wg.Wait()
+	return e.err
+}
+
+func (e *Executor) fail(err error) {
+	e.once.Do(func() {
+		e.err = err
+	})
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutor(t *testing.T) {
+	lim := NewLinear(Config{Window: time.Second, Events: 1000})
+	ex := NewExecutor(lim, WithConcurrency(2), WithAttempts(3))
+
+	var running, peak, attempts int32
+	for i := 0; i < 10; i++ {
+		ex.Go(context.Background(), func(cxt context.Context) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			if atomic.AddInt32(&attempts, 1) <= 2 {
+				return RetryError{RetryAfter: time.Now().Add(time.Millisecond)}
+			}
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+	}
+	assert.NoError(t, ex.Wait())
+	assert.LessOrEqual(t, peak, int32(2))
+	assert.Equal(t, int32(12), attempts)
+
+	// operations which are rate limited too many times fail
+	errFatal := errors.New("Fatal")
+	ex = NewExecutor(lim, WithAttempts(2))
+	ex.Go(context.Background(), func(cxt context.Context) error {
+		return RetryError{Cause: errFatal, RetryAfter: time.Now()}
+	})
+	assert.ErrorIs(t, ex.Wait(), errFatal)
+}
+
+func TestExecutorHeaders(t *testing.T) {
+	// a header-based limiter can be waited on before any request exists to
+	// describe; only Update requires attributes
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst})
+	ex := NewExecutor(lim)
+	var n int32
+	for i := 0; i < 3; i++ {
+		ex.Go(context.Background(), func(cxt context.Context) error {
+			atomic.AddInt32(&n, 1)
+			return nil
+		})
+	}
+	assert.NoError(t, ex.Wait())
+	assert.Equal(t, int32(3), n)
+	assert.Equal(t, 7, lim.State(time.Now()).Remaining)
+	assert.ErrorIs(t, lim.Update(time.Now()), ErrMissingAttrs)
+}
@@ -0,0 +1,87 @@
+// Package filestore implements a rate limiter Store which persists state in
+// local files, so that processes on the same host, such as cron jobs and CLI
+// invocations, can share a quota without a network service.
+//
+// Each key is stored in its own file in the store's directory. Access is
+// serialized across processes with advisory file locks, which are only
+// supported on Unix systems.
+package filestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+// Store persists limiter state in a directory
+type Store struct {
+	dir string
+}
+
+// New creates a store in the provided directory, creating it if necessary
+func New(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create store directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Determine the path of the file for a key
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
+
+// Modify atomically reads, modifies, and persists the state for a key while
+// holding an exclusive lock on its file. The context is not consulted while
+// waiting for the lock.
+func (s *Store) Modify(cxt context.Context, key string, fn func(*ratelimit.State) error) error {
+	f, err := os.OpenFile(s.path(key), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("Could not open state: %w", err)
+	}
+	defer f.Close()
+
+	err = lock(f)
+	if err != nil {
+		return fmt.Errorf("Could not lock state: %w", err)
+	}
+	defer unlock(f)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("Could not read state: %w", err)
+	}
+	var state ratelimit.State
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &state)
+		if err != nil {
+			return fmt.Errorf("Could not decode state: %w", err)
+		}
+	}
+
+	err = fn(&state)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Could not encode state: %w", err)
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("Could not write state: %w", err)
+	}
+	_, err = f.WriteAt(data, 0)
+	if err != nil {
+		return fmt.Errorf("Could not write state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package filestore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShared(t *testing.T) {
+	store, err := New(t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	conf := ratelimit.Config{Window: time.Minute, Events: 10}
+	now := time.Date(2024, 4, 12, 0, 0, 30, 0, time.UTC)
+	reset := time.Date(2024, 4, 12, 0, 1, 0, 0, time.UTC)
+
+	// several limiters, as if in different processes, share one quota
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var permitted int
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lim := ratelimit.NewShared(conf, store, "example")
+			for j := 0; j < 4; j++ {
+				next, err := lim.Next(now)
+				if assert.NoError(t, err) && next.Equal(now) {
+					mu.Lock()
+					permitted++
+					mu.Unlock()
+				} else {
+					assert.Equal(t, reset, next)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, permitted)
+
+	lim := ratelimit.NewShared(conf, store, "example")
+	assert.Equal(t, ratelimit.State{Limit: 10, Remaining: 0, Reset: reset}, lim.State(now))
+	assert.Equal(t, ratelimit.State{Limit: 10, Remaining: 10, Reset: reset.Add(time.Minute)}, lim.State(reset))
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package filestore
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("File locking is not supported on this platform")
+
+func lock(f *os.File) error {
+	return errUnsupported
+}
+
+func unlock(f *os.File) error {
+	return errUnsupported
+}
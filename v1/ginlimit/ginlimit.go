@@ -0,0 +1,25 @@
+// Package ginlimit adapts the rate limiting server middleware to gin. Limits
+// may be applied to an entire engine or group with Use, or to individual
+// routes, each using its own limiter:
+//
+//	r.Use(ginlimit.Middleware(global))
+//	r.POST("/uploads", ginlimit.Middleware(uploads), handler)
+package ginlimit
+
+import (
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware produces a gin handler which limits requests under the provided
+// limiter. Denied requests are aborted. See ratelimit.Gate for details.
+func Middleware(lim ratelimit.Limiter, opts ...ratelimit.GateOption) gin.HandlerFunc {
+	gate := ratelimit.NewGate(lim, opts...)
+	return func(c *gin.Context) {
+		if !gate.Admit(c.Writer, c.Request) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+package ginlimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lim := ratelimit.NewShared(ratelimit.Config{Window: time.Hour, Events: 1}, ratelimit.NewMemoryStore(), "")
+	r := gin.New()
+	r.GET("/limited", Middleware(lim), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/unlimited", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i, e := range []struct {
+		Path   string
+		Status int
+	}{
+		{"/limited", http.StatusOK},
+		{"/limited", http.StatusTooManyRequests},
+		{"/unlimited", http.StatusOK},
+	} {
+		rsp := httptest.NewRecorder()
+		r.ServeHTTP(rsp, httptest.NewRequest("GET", e.Path, nil))
+		assert.Equal(t, e.Status, rsp.Code, "#%d", i)
+	}
+}
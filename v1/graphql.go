@@ -0,0 +1,376 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bww/go-util/v1/ext"
+)
+
+// The weight of each observed cost in the average cost of operations
+const costWeight = 0.2
+
+// graphqlCost implements a rate limiter for GraphQL services which limit
+// clients by the cost of their queries in points rather than by the number of
+// requests they make, most notably Shopify and GitHub:
+//
+// https://shopify.dev/docs/api/usage/limits#graphql-admin-api-rate-limits
+// https://docs.github.com/en/graphql/overview/rate-limits-and-query-limits-for-the-graphql-api
+//
+// Each operation consumes its projected cost, provided via WithCost, e.g., the
+// requested cost of the query, or otherwise the average actual cost of recent
+// operations. Points are restored continuously at the restore rate, up to the
+// capacity, as Shopify does, or in full at the end of each window, as GitHub
+// does. The capacity, the points available, the restore rate, and the actual
+// cost of each query are learned from response bodies provided to Update via
+// WithResponseBody, as reported by Shopify or by GitHub, when a query selects
+// its rateLimit:
+//
+//	{"extensions":{"cost":{"requestedQueryCost":101,"actualQueryCost":46,"throttleStatus":{"maximumAvailable":1000,"currentlyAvailable":954,"restoreRate":50}}}}
+//	{"data":{"rateLimit":{"limit":5000,"cost":1,"remaining":4999,"resetAt":"2024-01-01T01:00:00Z"}}}
+//
+// Otherwise they are learned from conventional rate limit headers, which
+// GitHub denominates in points.
+type graphqlCost struct {
+	sync.Mutex
+	dur           Durationer
+	reset         resetParser
+	capacity      float64       // the points available when full
+	rate          float64       // the points restored per second, if > 0
+	window        time.Duration // the duration of a window, if points are restored in full at its end and it is known
+	available     float64       // the points available as of the time below; negative when operations were granted points not yet restored
+	at            time.Time     // when the points available were determined
+	next          time.Time     // when the points are next restored in full, if points are restored at the end of a window and it is known
+	cost          float64       // the average actual cost of recent operations, if any have been observed
+	backoff       time.Time     // the end of the backoff requested by the service, if any
+	lowWater      float64
+	criticalWater float64
+	maxWait       time.Duration
+	strict        bool
+	classes       Classifier
+	waiters
+}
+
+// NewGraphQLCost creates a GraphQL cost limiter with a capacity of Events
+// points which are restored at the RestoreRate or, if it is zero, at the end of
+// each Window, until the service reports otherwise.
+func NewGraphQLCost(conf Config) *graphqlCost {
+	var dur Durationer
+	if d := conf.Durationer; d != nil {
+		dur = d
+	} else {
+		dur = Seconds
+	}
+	l := &graphqlCost{
+		dur:           dur,
+		reset:         newResetParser(conf.ResetSemantics, conf.ResetHeaders),
+		capacity:      float64(conf.Events),
+		rate:          conf.RestoreRate,
+		available:     float64(conf.Events),
+		lowWater:      ext.Coalesce(conf.LowWatermark, defaultLowWatermark),
+		criticalWater: ext.Coalesce(conf.CriticalWatermark, defaultCriticalWatermark),
+		maxWait:       conf.MaxWait,
+		strict:        conf.Strict,
+		classes:       conf.Classifier,
+	}
+	if conf.RestoreRate <= 0 && conf.Window > 0 {
+		l.window = conf.Window
+		l.next = conf.firstReset()
+	}
+	return l
+}
+
+// Clone produces an independent copy of the limiter, including the points
+// available and the average cost it has observed
+func (l *graphqlCost) Clone() Limiter {
+	l.Lock()
+	defer l.Unlock()
+	return &graphqlCost{
+		dur:           l.dur,
+		reset:         l.reset,
+		capacity:      l.capacity,
+		rate:          l.rate,
+		window:        l.window,
+		available:     l.available,
+		at:            l.at,
+		next:          l.next,
+		cost:          l.cost,
+		backoff:       l.backoff,
+		lowWater:      l.lowWater,
+		criticalWater: l.criticalWater,
+		maxWait:       l.maxWait,
+		strict:        l.strict,
+		classes:       l.classes,
+	}
+}
+
+// Cost returns the cost projected for an operation whose cost is not provided
+// via WithCost: the average actual cost of recent operations, or one point if
+// none have been observed
+func (l *graphqlCost) Cost() float64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.projected(0)
+}
+
+// Determine the projected cost of an operation; the caller must hold the lock
+func (l *graphqlCost) projected(cost float64) float64 {
+	if cost > 0 {
+		return cost
+	}
+	if l.cost > 0 {
+		return l.cost
+	}
+	return 1
+}
+
+// Bring the points available forward to the reference time; the caller must
+// hold the lock
+func (l *graphqlCost) restore(rel time.Time) {
+	if !rel.After(l.at) {
+		return
+	}
+	if l.rate > 0 {
+		if l.available < l.capacity {
+			l.available = min(l.capacity, l.available+l.rate*rel.Sub(l.at).Seconds())
+		}
+	} else if !l.next.IsZero() && !rel.Before(l.next) {
+		l.available = l.capacity + min(0, l.available) // points granted in advance are owed to the new window
+		if l.window > 0 {
+			n := rel.Sub(l.next)/l.window + 1
+			l.next = l.next.Add(n * l.window)
+		} else {
+			l.next = time.Time{} // the next reset is unknown until the service reports it
+		}
+	}
+	l.at = rel
+}
+
+// Determine when an operation of the provided cost may proceed, consuming its
+// cost if requested; the caller must hold the lock
+func (l *graphqlCost) take(rel time.Time, cost float64, consume bool) (time.Time, error) {
+	if l.capacity > 0 && cost > l.capacity {
+		return time.Time{}, fmt.Errorf("Could not compute next operation: cost %v exceeds capacity %v: %w", cost, l.capacity, ErrExhausted)
+	}
+	l.restore(rel)
+	t := rel
+	if d := cost - l.available; d > 0 {
+		switch {
+		case l.rate > 0:
+			t = rel.Add(time.Duration(d / l.rate * float64(time.Second)))
+		case l.next.IsZero():
+			return time.Time{}, fmt.Errorf("Could not compute next operation: %w", ExhaustedError{})
+		case d <= l.capacity:
+			t = l.next
+		case l.window > 0:
+			t = l.next.Add(time.Duration(math.Ceil(d/l.capacity)-1) * l.window)
+		default:
+			return time.Time{}, fmt.Errorf("Could not compute next operation: %w", ExhaustedError{Reset: l.next})
+		}
+	}
+	t = later(t, l.backoff)
+	if l.strict && t.After(rel) {
+		return time.Time{}, fmt.Errorf("Could not compute next operation: %w", ExhaustedError{Reset: t})
+	}
+	if consume {
+		l.available -= cost
+	}
+	return t, nil
+}
+
+func (l *graphqlCost) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	conf := Options{}.With(opts)
+	l.Lock()
+	defer l.Unlock()
+	return l.take(rel, l.projected(conf.Cost), true)
+}
+
+// Peek returns the time at which the next operation could proceed relative to
+// the provided time, as Next does, without consuming any points
+func (l *graphqlCost) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	conf := Options{}.With(opts)
+	l.Lock()
+	defer l.Unlock()
+	return l.take(rel, l.projected(conf.Cost), false)
+}
+
+func (l *graphqlCost) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	if t, err := l.Peek(rel, opts...); err == nil {
+		if err := overloaded(l.maxWait, rel, t.Sub(rel)); err != nil {
+			return time.Time{}, err
+		}
+	}
+	t, err := l.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, t)
+}
+
+// BackoffEnd returns the time at which the current backoff period ends, which
+// may be in the past, or zero if none has been imposed
+func (l *graphqlCost) BackoffEnd() time.Time {
+	l.Lock()
+	defer l.Unlock()
+	return l.backoff
+}
+
+// The limiter's state is learned from response bodies, so the Transport
+// provides them
+func (l *graphqlCost) updatesFromBody() {}
+
+// The cost information reported by a GraphQL service
+type queryCost struct {
+	Capacity  float64
+	Available float64
+	Rate      float64   // the points restored per second, if reported
+	Reset     time.Time // when the points are restored in full, if reported
+	Actual    float64   // the actual cost of the query, if reported
+}
+
+// The parts of a GraphQL response which describe its cost
+type costResponse struct {
+	Data struct {
+		RateLimit *struct { // GitHub, when the query selects it
+			Limit     float64   `json:"limit"`
+			Cost      float64   `json:"cost"`
+			Remaining float64   `json:"remaining"`
+			ResetAt   time.Time `json:"resetAt"`
+		} `json:"rateLimit"`
+	} `json:"data"`
+	Extensions struct {
+		Cost *struct { // Shopify
+			RequestedQueryCost float64  `json:"requestedQueryCost"`
+			ActualQueryCost    *float64 `json:"actualQueryCost"` // null when the query was throttled
+			ThrottleStatus     struct {
+				MaximumAvailable   float64 `json:"maximumAvailable"`
+				CurrentlyAvailable float64 `json:"currentlyAvailable"`
+				RestoreRate        float64 `json:"restoreRate"`
+			} `json:"throttleStatus"`
+		} `json:"cost"`
+	} `json:"extensions"`
+}
+
+// Parse the cost information from a response body, if it has any
+func parseQueryCost(body []byte) (queryCost, bool) {
+	var rsp costResponse
+	if json.Unmarshal(body, &rsp) != nil {
+		return queryCost{}, false
+	}
+	if c := rsp.Extensions.Cost; c != nil && c.ThrottleStatus.MaximumAvailable > 0 {
+		res := queryCost{
+			Capacity:  c.ThrottleStatus.MaximumAvailable,
+			Available: c.ThrottleStatus.CurrentlyAvailable,
+			Rate:      c.ThrottleStatus.RestoreRate,
+		}
+		if c.ActualQueryCost != nil {
+			res.Actual = *c.ActualQueryCost
+		}
+		return res, true
+	}
+	if r := rsp.Data.RateLimit; r != nil && r.Limit > 0 {
+		return queryCost{
+			Capacity:  r.Limit,
+			Available: r.Remaining,
+			Reset:     r.ResetAt,
+			Actual:    r.Cost,
+		}, true
+	}
+	return queryCost{}, false
+}
+
+// Update replaces the limiter's state with the cost information in the
+// response provided, preferring its body to its headers. Points the limiter
+// granted after the request was sent are not accounted for in the service's
+// report, so they are presumed to be reflected in later reports.
+func (l *graphqlCost) Update(rel time.Time, opts ...Option) error {
+	conf := Options{}.With(opts)
+	v := conf.verdict(l.classes)
+	if v.Outcome == Fatal {
+		return nil // nothing can be learned
+	}
+	if conf.Body == nil && conf.Attrs == nil {
+		return fmt.Errorf("%w: Response body or header attributes are required", ErrMissingAttrs)
+	}
+
+	var retry time.Time
+	q, ok := parseQueryCost(conf.Body)
+	if !ok && conf.Attrs != nil {
+		h, err := parseHeaders(rel, conf.Attrs, l.dur, l.reset)
+		if err == nil {
+			if h.RetryAfter.IsZero() {
+				q, ok = queryCost{Capacity: float64(h.Limit), Available: h.Remaining, Reset: h.Reset}, true
+			} else {
+				retry = h.RetryAfter
+			}
+		} else if v.Outcome != Throttled || v.RetryAfter <= 0 {
+			return err
+		}
+	}
+	if retry.IsZero() && v.Outcome == Throttled && v.RetryAfter > 0 {
+		retry = rel.Add(v.RetryAfter)
+	}
+	if !ok && retry.IsZero() {
+		return fmt.Errorf("No query cost in response: %w", ErrMissingHeaders)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if ok {
+		l.capacity, l.available, l.at = q.Capacity, q.Available, rel
+		if q.Rate > 0 {
+			l.rate = q.Rate
+		}
+		if !q.Reset.IsZero() {
+			l.next = q.Reset
+		}
+		if q.Actual > 0 {
+			if l.cost > 0 {
+				l.cost += costWeight * (q.Actual - l.cost)
+			} else {
+				l.cost = q.Actual
+			}
+		}
+	}
+	if !retry.IsZero() {
+		if retry.After(l.backoff) {
+			l.backoff = retry
+		}
+		return RetryError{
+			RetryAfter: retry,
+		}
+	}
+	return nil
+}
+
+// State describes the points available. In quotas which are restored
+// continuously, the reset is when the points will have been restored in full.
+func (l *graphqlCost) State(rel time.Time) State {
+	l.Lock()
+	defer l.Unlock()
+	l.restore(rel)
+	rst := l.next
+	if l.rate > 0 {
+		rst = rel.Add(time.Duration(max(0, l.capacity-l.available) / l.rate * float64(time.Second)))
+	}
+	var p float64
+	if l.capacity > 0 {
+		p = l.available / l.capacity
+	}
+	return State{
+		Limit:     int(l.capacity),
+		Remaining: max(0, int(l.available)),
+		Reset:     rst,
+		Low:       l.capacity > 0 && p < l.lowWater,
+		Critical:  l.capacity > 0 && p < l.criticalWater,
+	}
+}
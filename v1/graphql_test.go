@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLCostRestoreRate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lim := NewGraphQLCost(Config{Events: 1000, RestoreRate: 50})
+
+	// the service reports the points available, the restore rate, and the actual cost
+	body := `{"data":{},"extensions":{"cost":{"requestedQueryCost":101,"actualQueryCost":46,"throttleStatus":{"maximumAvailable":1000,"currentlyAvailable":154,"restoreRate":50}}}}`
+	err := lim.Update(now, WithResponseBody([]byte(body)))
+	assert.NoError(t, err)
+	assert.Equal(t, 46.0, lim.Cost())
+	assert.Equal(t, State{Limit: 1000, Remaining: 154, Reset: now.Add(16920 * time.Millisecond)}, lim.State(now))
+
+	// operations are paced by their projected cost
+	tests := []struct {
+		Cost   float64
+		Expect time.Time
+	}{
+		{Cost: 100, Expect: now},
+		{Cost: 54, Expect: now},
+		{Cost: 100, Expect: now.Add(2 * time.Second)},
+		{Cost: 0, Expect: now.Add(2*time.Second + 920*time.Millisecond)}, // the average actual cost
+	}
+	for i, e := range tests {
+		p, err := lim.Peek(now, WithCost(e.Cost))
+		assert.NoError(t, err, "#%d", i)
+		n, err := lim.Next(now, WithCost(e.Cost))
+		assert.NoError(t, err, "#%d", i)
+		assert.Equal(t, e.Expect, n, "#%d", i)
+		assert.Equal(t, n, p, "#%d", i)
+	}
+
+	// points are restored over time, up to the capacity
+	assert.Equal(t, 4, lim.State(now.Add(3*time.Second)).Remaining)
+	assert.Equal(t, 1000, lim.State(now.Add(time.Hour)).Remaining)
+
+	// a query which costs more than the capacity can never be performed
+	_, err = lim.Next(now, WithCost(1001))
+	assert.ErrorIs(t, err, ErrExhausted)
+}
+
+func TestGraphQLCostWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lim := NewGraphQLCost(Config{Events: 5000})
+
+	body := `{"data":{"viewer":{"login":"octocat"},"rateLimit":{"limit":5000,"cost":10,"remaining":15,"resetAt":"2024-01-01T00:30:00Z"}}}`
+	err := lim.Update(now, WithResponseBody([]byte(body)))
+	assert.NoError(t, err)
+	assert.Equal(t, State{Limit: 5000, Remaining: 15, Reset: now.Add(30 * time.Minute), Low: true, Critical: true}, lim.State(now))
+
+	n, err := lim.Next(now)
+	assert.NoError(t, err)
+	assert.Equal(t, now, n)
+	n, err = lim.Next(now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(30*time.Minute), n) // the remaining points don't cover the average cost
+
+	// points are restored in full at the reset, less those granted in advance
+	assert.Equal(t, State{Limit: 5000, Remaining: 4995}, lim.State(now.Add(31*time.Minute)))
+
+	// conventional headers are used when the body has no cost information
+	err = lim.Update(now, WithResponseBody([]byte(`{"data":{}}`)), WithAttrs(Attrs{
+		"X-Ratelimit-Limit":     {"5000"},
+		"X-Ratelimit-Remaining": {"4000"},
+		"X-Ratelimit-Reset":     {"1704070800"},
+	}))
+	assert.NoError(t, err)
+	st := lim.State(now)
+	assert.Equal(t, 4000, st.Remaining)
+	assert.WithinDuration(t, now.Add(time.Hour), st.Reset, 0)
+
+	err = lim.Update(now, WithResponseBody([]byte(`{"data":{}}`)))
+	assert.ErrorIs(t, err, ErrMissingHeaders)
+	err = lim.Update(now)
+	assert.ErrorIs(t, err, ErrMissingAttrs)
+}
+
+func TestGraphQLCostTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":{},"extensions":{"cost":{"requestedQueryCost":10,"actualQueryCost":8,"throttleStatus":{"maximumAvailable":100,"currentlyAvailable":42,"restoreRate":5}}}}`)
+	}))
+	defer srv.Close()
+
+	lim := NewGraphQLCost(Config{Events: 100, RestoreRate: 5})
+	client := &http.Client{Transport: NewTransport(lim, nil)}
+	rsp, err := client.Post(srv.URL, "application/json", nil)
+	if assert.NoError(t, err) {
+		data, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "throttleStatus")
+		assert.Equal(t, 42, lim.State(time.Now()).Remaining)
+		assert.Equal(t, 8.0, lim.Cost())
+	}
+}
@@ -2,9 +2,13 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bww/go-util/v1/ext"
@@ -20,129 +24,834 @@ import (
 // header names or time/duration formats, it would be reasonable to update this
 // implementation to accommodate them.
 type headers struct {
-	impl limiter
-	dur  Durationer
+	impl    limiter
+	dur     Durationer
+	reset   resetParser
+	shares  int
+	maxWait time.Duration
+	jitter  float64
+	lenient bool
+	classes Classifier
+	reclaim time.Duration // reservations which are not settled this long after their time are rolled back, if > 0
+	waiters
+
+	pmu      sync.Mutex
+	policies []policy      // the policies the service enforces, when there are several
+	enforced int           // the index of the policy enforced by the underlying limiter
+	probe    time.Duration // the period after which another probe is permitted, if we are probing
+	probed   time.Time     // when the outstanding probe was permitted, if there is one
+	known    chan struct{} // closed when the quota becomes known, if we are probing
+
+	rmu      sync.Mutex
+	reserved []*Reservation // the reservations which may not be settled yet, if we reclaim them
 }
 
 func NewHeaders(conf Config) *headers {
+	shares := max(1, conf.Shares)
 	var dur Durationer
 	if d := conf.Durationer; d != nil {
 		dur = d
 	} else {
 		dur = Seconds
 	}
+	lim, rem, rst := conf.Events/shares, float64(conf.Events/shares), conf.firstReset()
+	if st := conf.Initial; st != nil {
+		lim, rem = st.Limit/shares, float64(st.Remaining)/float64(shares)
+		rst = ext.Coalesce(st.Reset, rst)
+	}
+	var known chan struct{}
+	if conf.Probe > 0 && conf.Initial == nil {
+		known = make(chan struct{})
+	}
 	return &headers{
 		impl: limiter{
-			limit:         conf.Events,
-			remaining:     conf.Events,
-			reset:         ext.Coalesce(conf.Start, time.Now()).Add(conf.Window),
+			limit:         lim,
+			remaining:     rem,
+			reset:         rst,
+			window:        conf.Window,
 			mode:          conf.Mode,
 			maxMeter:      conf.MaxDelay,
-			backoffPeriod: defaultBackoffPeriod,
+			backoffPeriod: ext.Coalesce(conf.Backoff, defaultBackoffPeriod),
+			lowWater:      ext.Coalesce(conf.LowWatermark, defaultLowWatermark),
+			criticalWater: ext.Coalesce(conf.CriticalWatermark, defaultCriticalWatermark),
+			reserve:       conf.Reserve,
+			burst:         ext.Coalesce(conf.BurstFraction, defaultBurstFraction),
+			slice:         conf.Slice,
+			strict:        conf.Strict,
+			bound:         conf.BoundDelay,
+			soft:          conf.SoftLimit,
+			softTarget:    conf.SoftTarget,
+			onSoft:        conf.OnSoftLimit,
+			history:       newHistory(conf.History),
+			log:           conf.Logger,
+			reconcile:     conf.Reconcile,
+			track:         conf.InFlight,
+			target:        conf.MaxTarget,
+			minTarget:     conf.MinTarget,
+			maxTarget:     conf.MaxTarget,
+			targetLatency: conf.TargetLatency,
+			restore:       conf.RestoreRate,
+			restoredAt:    time.Now(),
+			decay:         ext.Coalesce(conf.ReconcileDecay, defaultReconcileDecay),
 		},
-		dur: dur,
+		dur:     dur,
+		reset:   newResetParser(conf.ResetSemantics, conf.ResetHeaders),
+		shares:  shares,
+		maxWait: conf.MaxWait,
+		jitter:  conf.Jitter,
+		lenient: conf.Lenient,
+		classes: conf.Classifier,
+		reclaim: conf.ReclaimAfter,
+		probe:   conf.Probe,
+		known:   known,
 	}
 }
 
+// Next does not consult attributes; they are only required by Update
 func (l *headers) Next(rel time.Time, opts ...Option) (time.Time, error) {
-	conf := Options{}.With(opts)
-	if conf.Attrs == nil {
-		return time.Time{}, fmt.Errorf("%w: Header attributes are required", ErrMissingAttrs)
+	if t, _, ok := l.held(rel, true); ok {
+		if l.impl.strict {
+			return time.Time{}, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: t})
+		}
+		return t, nil
+	}
+	return l.next(rel, Options{}.With(opts))
+}
+
+// Peek returns the time at which the next operation could proceed relative to
+// the provided time, as Next does, without consuming any quota
+func (l *headers) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	if t, _, ok := l.held(rel, false); ok {
+		if l.impl.strict {
+			return time.Time{}, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: t})
+		}
+		return t, nil
+	}
+	d, x := l.peek(rel, Options{}.With(opts).pacing())
+	if x && l.impl.strict {
+		return time.Time{}, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: rel.Add(d)})
 	}
-	delay, err := l.impl.Delay(rel)
+	return rel.Add(d), nil
+}
+
+// Compute the delay before the next operation, and whether it is the result
+// of the quota being exhausted, without consuming any quota. When several
+// policies apply, the delay is that of the most constraining.
+func (l *headers) peek(rel time.Time, p pacing) (time.Duration, bool) {
+	if d, x, ok := l.peekPolicies(rel, p); ok {
+		return d, x
+	}
+	d, _, x, _, _ := l.impl.delay(rel, false, p)
+	return d, x
+}
+
+// Compute the next time an operation may proceed, consuming quota and
+// tracking the operation as in flight, if we are tracking them
+func (l *headers) next(rel time.Time, o Options) (time.Time, error) {
+	t, s, err := l.take(rel, o.pacing())
 	if err != nil {
-		return time.Time{}, fmt.Errorf("Could not compute next window: %w", err)
+		return time.Time{}, err
+	}
+	l.launched(s, o)
+	return t, nil
+}
+
+// Track an operation which consumed a slot as in flight, if we are tracking
+// them, and provide the function which completes it to the caller, if it was
+// requested via WithCompletion. The function is returned as well; it is nil
+// if the operation is not tracked.
+func (l *headers) launched(s slot, o Options) func() {
+	done := l.impl.launch(s, o.Completion != nil)
+	if done != nil && o.Completion != nil {
+		*o.Completion = done
+	}
+	return done
+}
+
+// Compute the next time an operation may proceed, consuming quota, and
+// produce the slot consumed, if any
+func (l *headers) take(rel time.Time, p pacing) (time.Time, slot, error) {
+	l.Reclaim(rel)
+	delay, s, ok, err := l.delayPolicies(rel, p)
+	if !ok {
+		delay, s, err = l.impl.acquire(rel, p)
+	}
+	if err != nil {
+		return time.Time{}, slot{}, fmt.Errorf("Could not compute next window: %w", err)
 	}
 	if delay > 0 {
-		return rel.Add(delay), nil
+		return rel.Add(delay), s, nil
 	} else {
-		return rel, nil
+		return rel, s, nil
+	}
+}
+
+// Acquire tentatively consumes quota for an operation, as Next does, and
+// produces a reservation by which the quota may be returned if the operation
+// is abandoned before it is performed
+func (l *headers) Acquire(rel time.Time, opts ...Option) (*Reservation, error) {
+	if t, _, ok := l.held(rel, true); ok {
+		if l.impl.strict {
+			return nil, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: t})
+		}
+		return newReservation(t, nil), nil
+	}
+	o := Options{}.With(opts)
+	t, s, err := l.take(rel, o.pacing())
+	if err != nil {
+		return nil, err
+	}
+	done := l.launched(s, o)
+	res := newReservation(t, func(rel time.Time) bool {
+		if done != nil {
+			done() // the operation will never be in flight
+		}
+		return l.refund(rel, s)
+	})
+	if l.reclaim > 0 && s.taken {
+		l.rmu.Lock()
+		l.reserved = append(l.reserved, res)
+		l.rmu.Unlock()
+	}
+	return res, nil
+}
+
+// Reclaim rolls back the reservations which have been neither committed nor
+// rolled back within the limiter's ReclaimAfter duration of the time they may
+// proceed, relative to the provided time, returning their quota. This is
+// performed whenever quota is consumed; it may also be invoked periodically
+// so that quota is returned while the limiter is idle. The result is the
+// number of reservations whose quota was returned.
+func (l *headers) Reclaim(rel time.Time) int {
+	if l.reclaim <= 0 {
+		return 0
+	}
+	l.rmu.Lock()
+	defer l.rmu.Unlock()
+	var n int
+	keep := l.reserved[:0]
+	for _, e := range l.reserved {
+		if e.settled.Load() {
+			continue
+		}
+		if rel.Sub(e.Time) < l.reclaim {
+			keep = append(keep, e)
+		} else if e.Rollback(rel) {
+			n++
+		}
+	}
+	clear(l.reserved[len(keep):])
+	l.reserved = keep
+	return n
+}
+
+// Return a slot to the quota of the underlying limiter and, when the service
+// enforces several policies, to each of them, since they were all consumed
+func (l *headers) refund(rel time.Time, s slot) bool {
+	l.pmu.Lock()
+	defer l.pmu.Unlock()
+	if !l.impl.refund(rel, s) {
+		return false
+	}
+	if len(l.policies) > 1 {
+		for i, e := range l.policies {
+			l.policies[i].remaining = math.Min(float64(e.limit), e.remaining+1)
+		}
 	}
+	return true
 }
 
 func (l *headers) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
-	t, err := l.Next(rel, opts...)
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	for {
+		t, ch, ok := l.held(rel, true)
+		if !ok {
+			break
+		}
+		if err := overloaded(l.maxWait, rel, t.Sub(rel)); err != nil {
+			return time.Time{}, err
+		}
+		start := time.Now()
+		if err := l.pauseOn(cxt, t.Sub(rel), ch); err != nil {
+			return time.Time{}, err
+		}
+		rel = rel.Add(time.Since(start)) // the hold may be released early, when the probe completes
+	}
+	o := Options{}.With(opts)
+	d, _ := l.peek(rel, o.pacing())
+	if err := overloaded(l.maxWait, rel, d); err != nil {
+		return time.Time{}, err
+	}
+	t, err := l.next(rel, o)
 	if err != nil {
 		return time.Time{}, err
 	}
-	if !t.After(rel) { // the next window is at or before the reference time: don't wait
-		return rel, nil
+	return l.sleep(cxt, rel, jitter(l.jitter, rel, t))
+}
+
+// Clone produces an independent copy of the limiter and its current state,
+// including the policies the service enforces. If the quota is not yet known,
+// the copy probes for it independently.
+func (l *headers) Clone() Limiter {
+	l.pmu.Lock()
+	defer l.pmu.Unlock()
+	c := &headers{
+		dur:      l.dur,
+		reset:    l.reset,
+		shares:   l.shares,
+		maxWait:  l.maxWait,
+		jitter:   l.jitter,
+		lenient:  l.lenient,
+		classes:  l.classes,
+		reclaim:  l.reclaim,
+		policies: append([]policy(nil), l.policies...),
+		enforced: l.enforced,
+		probe:    l.probe,
+		probed:   l.probed,
 	}
-	select {
-	case <-time.After(t.Sub(rel)):
-		return t, nil
-	case <-cxt.Done():
-		return t, ErrCanceled
+	if l.known != nil {
+		c.known = make(chan struct{})
 	}
+	l.impl.cloneTo(&c.impl)
+	return c
 }
 
-func (l *headers) State(time.Time) State {
-	return l.impl.State()
+// Determine whether an operation must be held because the quota is not yet
+// known and a probe is outstanding, and if so, when another probe will be
+// permitted and the channel which is closed when the quota becomes known. If
+// no probe is outstanding and claim is true, this operation becomes the probe.
+func (l *headers) held(rel time.Time, claim bool) (time.Time, <-chan struct{}, bool) {
+	l.pmu.Lock()
+	defer l.pmu.Unlock()
+	if l.known == nil {
+		return time.Time{}, nil, false
+	}
+	if t := l.probed.Add(l.probe); l.probed.IsZero() || !rel.Before(t) {
+		if claim {
+			l.probed = rel
+			l.impl.debug("Probing for quota", "at", rel)
+		}
+		return time.Time{}, nil, false
+	} else {
+		return t, l.known, true
+	}
+}
+
+// Release the operations held while the quota was unknown, if we are probing;
+// the caller must hold the policy lock
+func (l *headers) learned() {
+	if l.known != nil {
+		close(l.known)
+		l.known = nil
+	}
+}
+
+// EstimatedWait returns the delay a new operation would incur relative to the
+// provided time, without consuming any budget.
+func (l *headers) EstimatedWait(rel time.Time) time.Duration {
+	d, _ := l.peek(rel, pacing{})
+	return d
+}
+
+func (l *headers) State(rel time.Time) State {
+	return l.impl.StateAt(rel)
+}
+
+// Stats describes the observed behavior of the limiter: the rate at which
+// operations are requested, the delay imposed on them, and how often they are
+// delayed by a backoff.
+func (l *headers) Stats() Stats {
+	return l.impl.Stats()
+}
+
+// BackoffEnd returns the time at which the current backoff period ends, which
+// may be in the past, or zero if none has been imposed
+func (l *headers) BackoffEnd() time.Time {
+	return l.impl.BackoffEnd()
+}
+
+// History returns up to n of the most recent events recorded by the limiter,
+// oldest first, or every event retained if n <= 0. Events are only recorded
+// if Config.History is set.
+func (l *headers) History(n int) []Event {
+	return l.impl.History(n)
+}
+
+// Backoff imposes an incrementally increasing backoff period relative to the
+// provided time and returns the time at which it ends. Each consecutive backoff
+// is longer than the last until an operation is permitted outside of one.
+func (l *headers) Backoff(rel time.Time) (time.Time, error) {
+	return l.impl.Backoff(rel)
+}
+
+// BackoffUntil imposes a backoff period which ends at the provided time
+func (l *headers) BackoffUntil(until time.Time) error {
+	return l.impl.BackoffUntil(until)
+}
+
+// InvalidateBackoff clears any backoff period in effect
+func (l *headers) InvalidateBackoff() error {
+	return l.impl.InvalidateBackoff()
+}
+
+// SetLowWatermark sets the proportion of remaining quota below which Meter
+// mode begins to slow down.
+func (l *headers) SetLowWatermark(v float64) {
+	l.impl.SetLowWatermark(v)
+}
+
+// SetCriticalWatermark sets the proportion of remaining quota below which
+// Meter mode stops making requests until the window resets.
+func (l *headers) SetCriticalWatermark(v float64) {
+	l.impl.SetCriticalWatermark(v)
+}
+
+// SetReserve sets the quota which is held in reserve and never consumed. A
+// value less than 1 is a proportion of the limit, otherwise it is an absolute
+// number of operations.
+func (l *headers) SetReserve(v float64) {
+	l.impl.SetReserve(v)
+}
+
+// Reserved returns the number of operations currently held in reserve
+func (l *headers) Reserved() int {
+	return l.impl.Reserved()
+}
+
+// SetBurstFraction sets the proportion of the quota which may be consumed in
+// a burst in Smooth mode before pacing the remainder of the window.
+func (l *headers) SetBurstFraction(v float64) {
+	l.impl.SetBurstFraction(v)
+}
+
+// SetTarget sets the proportion of the quota which Meter mode targets, so that
+// operations are spread out more slowly than the quota permits; zero targets
+// the entire quota.
+func (l *headers) SetTarget(v float64) {
+	l.impl.SetTarget(v)
+}
+
+// Target returns the proportion of the quota which Meter mode targets; zero
+// targets the entire quota. It changes over time when the target is tuned.
+func (l *headers) Target() float64 {
+	return l.impl.Target()
 }
 
 func (l *headers) Update(rel time.Time, opts ...Option) error {
+	_, err := l.UpdateEx(rel, opts...)
+	return err
+}
+
+// HeaderState describes the rate limit values read from response headers.
+// Fields which were not present are zero; limit and remaining are as reported
+// by the server, before any division among shares.
+type HeaderState struct {
+	Limit      int
+	Remaining  float64
+	Reset      time.Time
+	RetryAfter time.Time
+	Date       time.Time // when the response was produced, if it has a valid Date header
+	// Every policy described by structured RateLimit headers, when they are used; Limit, Remaining, and Reset describe the most constraining of them
+	Policies []PolicyState
+}
+
+// UpdateResult describes what was learned from a response and what was done
+// about it.
+type UpdateResult struct {
+	// The values parsed from the response headers
+	Headers HeaderState
+	// Whether the quota state was replaced with the parsed values
+	Applied bool
+	// Whether a backoff was imposed, because the response included Retry-After
+	Backoff bool
+	// Whether the values were disregarded because they were older than the limiter state
+	Stale bool
+	// The limiter state after the update
+	State State
+}
+
+// UpdateEx behaves like Update, but also describes what was parsed from the
+// response and how the limiter state changed. The result is populated as far
+// as parsing proceeded even when an error is returned.
+func (l *headers) UpdateEx(rel time.Time, opts ...Option) (UpdateResult, error) {
 	conf := Options{}.With(opts)
-	if conf.Attrs == nil {
-		return fmt.Errorf("%w: Header attributes are required", ErrMissingAttrs)
+	l.impl.Lock()
+	l.impl.land() // whatever the response says, the operation has completed
+	l.impl.Unlock()
+	v := conf.verdict(l.classes)
+	l.impl.tune(v.Outcome == Throttled, conf.Latency)
+	if v.Outcome == Fatal {
+		return UpdateResult{State: l.impl.State()}, nil // nothing can be learned
+	}
+	if conf.Attrs == nil && (v.Outcome != Throttled || v.RetryAfter <= 0) {
+		return UpdateResult{State: l.impl.State()}, fmt.Errorf("%w: Header attributes are required", ErrMissingAttrs)
 	}
-	return l.update(rel, conf.Attrs)
+	res, err := l.update(rel, conf.Attrs, conf.ObservedAt, v)
+	res.State = l.impl.State()
+	if err != nil && !errors.Is(err, ErrBackoff) {
+		l.impl.debug("Could not update from headers", "err", err, "lenient", l.lenient)
+		if l.lenient {
+			return res, nil // keep the previous state
+		}
+	}
+	return res, err
 }
 
-func (l *headers) update(rel time.Time, attrs Attrs) error {
-	var lim, rem int
-	var rst time.Time
+func (l *headers) update(rel time.Time, attrs Attrs, at time.Time, v Verdict) (UpdateResult, error) {
+	var res UpdateResult
 	var err error
 
-	// retry-after may be present even when other rate limit headers are not, handle it first
-	if n, v := findAttr(attrs, "X-Retry-After", "Retry-After"); v != "" {
-		x, err := strconv.Atoi(v)
-		if err != nil {
-			return fmt.Errorf("Rate limit header is invalid: %s = %s: %v", n, v, err)
-		}
-		w := time.Now().Add(l.dur.Duration(x))
+	res.Headers, err = l.parse(rel, attrs)
+	if v.Outcome == Throttled && v.RetryAfter > 0 && res.Headers.RetryAfter.IsZero() {
+		res.Headers.RetryAfter, err = rel.Add(v.RetryAfter), nil // the classifier found it elsewhere
+	}
+	if err != nil {
+		return res, err
+	}
+	if w := res.Headers.RetryAfter; !w.IsZero() {
 		l.impl.BackoffUntil(w)
-		return RetryError{
+		l.pmu.Lock()
+		l.learned() // the backoff now governs operations
+		l.pmu.Unlock()
+		res.Backoff = true
+		return res, RetryError{
 			RetryAfter: w,
 		}
 	}
 
-	if n, v := findAttr(attrs, "X-RateLimit-Limit", "ratelimit-limit"); v == "" {
-		return fmt.Errorf("No quota limit header: %w", ErrMissingHeaders)
+	lim, rem := l.share(res.Headers)
+	ps, enforced := l.quotaPolicies(rel, res.Headers)
+	l.pmu.Lock()
+	l.impl.Lock()
+	res.Applied = l.impl.setAt(lim, rem, anchor(rel, res.Headers.Reset), ext.Coalesce(at, res.Headers.Date))
+	l.impl.Unlock()
+	if res.Applied {
+		l.setPolicies(ps, enforced)
+		l.learned()
+	}
+	l.pmu.Unlock()
+	if res.Applied {
+		if l.impl.log != nil {
+			l.impl.debug("Updated state", "limit", lim, "remaining", rem, "reset", res.Headers.Reset)
+		}
 	} else {
-		lim, err = strconv.Atoi(v)
+		res.Stale = true
+		l.impl.debug("Disregarded stale update", "observed", ext.Coalesce(at, res.Headers.Date))
+	}
+
+	return res, nil
+}
+
+// UpdateBatch applies a series of observed responses, described by their
+// attributes, in order, as if each had been provided to Update. The limiter
+// state is modified under a single acquisition of its lock, so concurrent
+// operations never observe the intermediate states.
+//
+// Responses which cannot be parsed are skipped, as are those whose Date header
+// shows them to be stale. The errors produced by those
+// responses, and a RetryError for the last response which requested a
+// backoff, if any, are returned together.
+func (l *headers) UpdateBatch(rel time.Time, updates []Attrs) error {
+	var errs []error
+	parsed := make([]HeaderState, 0, len(updates))
+	for i, e := range updates {
+		p, err := l.parse(rel, e)
+		if err != nil {
+			l.impl.debug("Could not update from headers", "err", err, "index", i, "lenient", l.lenient)
+			if !l.lenient {
+				errs = append(errs, fmt.Errorf("Could not apply update #%d: %w", i, err))
+			}
+			continue
+		}
+		parsed = append(parsed, p)
+	}
+
+	var retry time.Time
+	l.pmu.Lock()
+	l.impl.Lock()
+	for range updates {
+		l.impl.land()
+	}
+	for _, e := range parsed {
+		if !e.RetryAfter.IsZero() {
+			l.impl.setBackoff(e.RetryAfter)
+			retry = e.RetryAfter
+			l.learned()
+		} else {
+			lim, rem := l.share(e)
+			if l.impl.setAt(lim, rem, anchor(rel, e.Reset), e.Date) {
+				l.setPolicies(l.quotaPolicies(rel, e))
+				l.learned()
+			}
+		}
+	}
+	l.impl.Unlock()
+	l.pmu.Unlock()
+	if l.impl.log != nil {
+		l.impl.debug("Applied updates", "count", len(parsed), "state", l.impl.State())
+	}
+
+	if !retry.IsZero() {
+		errs = append(errs, RetryError{RetryAfter: retry})
+	}
+	return errors.Join(errs...)
+}
+
+// Determine the limit and remaining quota to which we are entitled; when the
+// quota is shared, we are entitled to our share of it
+func (l *headers) share(p HeaderState) (int, float64) {
+	if l.shares > 1 {
+		return p.Limit / l.shares, p.Remaining / float64(l.shares)
+	} else {
+		return p.Limit, p.Remaining
+	}
+}
+
+// Determine the policies to which we are entitled, when several were
+// reported, and the index of the one which is most constraining
+func (l *headers) quotaPolicies(rel time.Time, p HeaderState) ([]policy, int) {
+	if len(p.Policies) < 2 {
+		return nil, 0
+	}
+	ps := policiesOf(p.Policies, l.shares)
+	for i := range ps {
+		ps[i].reset = anchor(rel, ps[i].reset)
+	}
+	return ps, binding(rel, ps)
+}
+
+// Parse rate limit headers from attributes without modifying any state
+func (l *headers) parse(rel time.Time, attrs Attrs) (HeaderState, error) {
+	res, err := parseHeaders(rel, attrs, l.dur, l.reset)
+	if errors.Is(err, errNoReset) && l.impl.restore > 0 {
+		return res, nil // a quota which regenerates continuously may not reset
+	}
+	return res, err
+}
+
+// ParseRateLimitHeaders parses rate limit headers from attributes, such as
+// the headers of a response, without reference to any limiter. This is
+// useful to record quota, e.g., in logging middleware. Values are converted
+// using the provided Durationer, or seconds if it is nil; integer reset
+// values are interpreted as epoch timestamps unless the Durationer parses
+// times itself, as DeltaSeconds does.
+//
+// If a Retry-After header is present, only it is parsed. Otherwise, the
+// limit, remaining, and reset headers are all required.
+func ParseRateLimitHeaders(attrs Attrs, dur Durationer) (HeaderState, error) {
+	if dur == nil {
+		dur = Seconds
+	}
+	return parseHeaders(time.Now(), attrs, dur, defaultResetParser)
+}
+
+// The error produced when the header describing the reset is absent
+var errNoReset = fmt.Errorf("No window reset header: %w", ErrMissingHeaders)
+
+func parseHeaders(rel time.Time, attrs Attrs, dur Durationer, rp resetParser) (HeaderState, error) {
+	var res HeaderState
+	var err error
+
+	// the date is informational, an invalid value is ignored
+	if _, v := findAttr(attrs, dateHeaders); v != "" {
+		if t, err := http.ParseTime(v); err == nil {
+			res.Date = t
+		}
+	}
+
+	// retry-after may be present even when other rate limit headers are not, handle it first
+	if n, v := findAttr(attrs, retryAfterHeaders); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
 		if err != nil {
-			return fmt.Errorf("Rate limit header is invalid: %s = %s: %v", n, v, err)
+			return res, fmt.Errorf("%w: %s = %s: %v", ErrInvalidHeaders, n, v, err)
 		}
+		res.RetryAfter = rel.Add(fracDuration(dur, x))
+		return res, nil
 	}
 
-	if n, v := findAttr(attrs, "X-RateLimit-Remaining", "ratelimit-remaining"); v == "" {
-		return fmt.Errorf("No remaining quota header: %w", ErrMissingHeaders)
+	// the structured header, which may describe several policies, is used when
+	// the individual headers are absent
+	if _, v := findAttr(attrs, limitHeaders); v == "" {
+		if _, v := findAttr(attrs, structuredHeaders); v != "" {
+			return parseStructured(rel, v, attrs, res)
+		}
+	}
+
+	if n, v := findAttr(attrs, limitHeaders); v == "" {
+		return res, fmt.Errorf("No quota limit header: %w", ErrMissingHeaders)
 	} else {
-		rem, err = strconv.Atoi(v)
+		x, err := strconv.ParseFloat(v, 64)
 		if err != nil {
-			return fmt.Errorf("Rate limit header is invalid: %s = %s: %v", n, v, err)
+			return res, fmt.Errorf("%w: %s = %s: %v", ErrInvalidHeaders, n, v, err)
 		}
+		res.Limit = int(math.Round(x))
 	}
 
-	if n, v := findAttr(attrs, "X-RateLimit-Reset", "ratelimit-reset"); v == "" {
-		return fmt.Errorf("No window reset header: %w", ErrMissingHeaders)
+	if n, v := findAttr(attrs, remainingHeaders); v == "" {
+		return res, fmt.Errorf("No remaining quota header: %w", ErrMissingHeaders)
 	} else {
-		x, err := strconv.Atoi(v)
+		res.Remaining, err = strconv.ParseFloat(v, 64)
 		if err != nil {
-			return fmt.Errorf("Rate limit header is invalid: %s = %s: %v", n, v, err)
+			return res, fmt.Errorf("%w: %s = %s: %v", ErrInvalidHeaders, n, v, err)
+		}
+	}
+
+	if n, v := findAttr(attrs, rp.names); v == "" {
+		return res, errNoReset
+	} else {
+		res.Reset, err = rp.parse(dur, rel, n, v)
+		if errors.Is(err, ErrInvalidHeaders) {
+			return res, fmt.Errorf("%s = %s: %w", n, v, err)
+		} else if err != nil {
+			return res, fmt.Errorf("%w: %s = %s: %v", ErrInvalidHeaders, n, v, err)
 		}
-		rst = l.dur.Time(x)
 	}
 
-	l.impl.Update(lim, rem, rst)
+	return res, nil
+}
 
-	return nil
+// Complete parsed headers from the structured 'RateLimit' header; the limit,
+// remaining quota, and reset describe the most constraining policy
+func parseStructured(rel time.Time, v string, attrs Attrs, res HeaderState) (HeaderState, error) {
+	ps, err := parseStructuredHeaders(rel, v, attrs)
+	if err != nil {
+		return res, err
+	}
+	if len(ps) == 0 {
+		return res, fmt.Errorf("No quota in structured header: %w", ErrMissingHeaders)
+	}
+	p := ps[binding(rel, policiesOf(ps, 1))]
+	res.Limit, res.Remaining, res.Reset = p.Limit, float64(p.Remaining), p.Reset
+	res.Policies = ps
+	return res, nil
+}
+
+// How reset headers are located and interpreted
+type resetParser struct {
+	names     []string                  // the reset headers consulted, in order of preference
+	semantics map[string]ResetSemantics // the semantics of specific headers, by canonical name
+	fallback  ResetSemantics            // the semantics of other headers
+}
+
+// Reset headers whose semantics are fixed by convention rather than by
+// configuration: Reset-After, as sent by Discord, is always relative
+var defaultResetSemantics = map[string]ResetSemantics{
+	http.CanonicalHeaderKey("X-RateLimit-Reset-After"): Delta,
+}
+
+var defaultResetParser = newResetParser(Epoch, nil)
+
+// Produce a reset parser which interprets headers according to the provided
+// semantics, unless they are overridden for specific headers. Headers named
+// in the overrides which we do not consult by default are preferred over those
+// we do.
+func newResetParser(sem ResetSemantics, overrides map[string]ResetSemantics) resetParser {
+	if len(overrides) == 0 {
+		return resetParser{names: resetHeaders, semantics: defaultResetSemantics, fallback: sem}
+	}
+	var names []string
+	semantics := make(map[string]ResetSemantics)
+	for k, v := range defaultResetSemantics {
+		semantics[k] = v
+	}
+	for k, v := range overrides {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := semantics[k]; !ok && !slices.Contains(resetHeaders, k) {
+			names = append(names, k)
+		}
+		semantics[k] = v
+	}
+	slices.Sort(names) // map order is random; keep the preference deterministic
+	return resetParser{names: append(names, resetHeaders...), semantics: semantics, fallback: sem}
+}
+
+// Parse the value of the named reset header. Headers with specific semantics
+// are interpreted as numbers according to them, even if the Durationer parses
+// times itself.
+func (p resetParser) parse(dur Durationer, rel time.Time, name, v string) (time.Time, error) {
+	if sem, ok := p.semantics[name]; ok {
+		return parseNumericTime(dur, sem, rel, v)
+	}
+	return parseTime(dur, p.fallback, rel, v)
+}
+
+// Parse a time value using the provided Durationer. If the Durationer does
+// not parse times itself, integer values are interpreted according to the
+// provided semantics.
+func parseTime(dur Durationer, sem ResetSemantics, rel time.Time, v string) (time.Time, error) {
+	if p, ok := dur.(TimeParser); ok {
+		return p.ParseTime(rel, v)
+	}
+	return parseNumericTime(dur, sem, rel, v)
+}
+
+// Parse a numeric time value according to the provided semantics
+func parseNumericTime(dur Durationer, sem ResetSemantics, rel time.Time, v string) (time.Time, error) {
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch sem {
+	case Delta:
+		return rel.Add(fracDuration(dur, x)), nil
+	case AutoDetect:
+		// a delta interpreted as a timestamp lands in the distant past; if the
+		// value looks like that, it's a delta
+		if t := fracTime(dur, x); t.After(rel.Add(-autoDetectHorizon)) {
+			return t, nil
+		} else {
+			return rel.Add(fracDuration(dur, x)), nil
+		}
+	default:
+		return fracTime(dur, x), nil
+	}
+}
+
+// Convert a possibly fractional value to a duration using a Durationer
+func fracDuration(dur Durationer, v float64) time.Duration {
+	return time.Duration(v * float64(dur.Duration(1)))
+}
+
+// Convert a possibly fractional value to a time using a Durationer
+func fracTime(dur Durationer, v float64) time.Time {
+	i, f := math.Modf(v)
+	return dur.Time(int(i)).Add(fracDuration(dur, f))
+}
+
+// The names of the headers we consult, with alternates, in canonical form so
+// that they can be looked up directly; canonicalizing a name on every lookup,
+// as http.Header.Get does, is comparatively expensive
+var (
+	retryAfterHeaders = canonicalHeaders("X-Retry-After", "Retry-After")
+	limitHeaders      = canonicalHeaders("X-RateLimit-Limit", "RateLimit-Limit")
+	remainingHeaders  = canonicalHeaders("X-RateLimit-Remaining", "RateLimit-Remaining")
+	resetHeaders      = canonicalHeaders("X-RateLimit-Reset-After", "X-RateLimit-Reset", "RateLimit-Reset")
+	globalHeaders     = canonicalHeaders("X-RateLimit-Global")
+	bucketHeaders     = canonicalHeaders("X-RateLimit-Bucket")
+	dateHeaders       = canonicalHeaders("Date")
+	structuredHeaders = canonicalHeaders("RateLimit")
+	policyHeaders     = canonicalHeaders("RateLimit-Policy")
+)
+
+func canonicalHeaders(names ...string) []string {
+	res := make([]string, len(names))
+	for i, e := range names {
+		res[i] = http.CanonicalHeaderKey(e)
+	}
+	return res
 }
 
-func findAttr(attrs Attrs, alts ...string) (string, string) {
-	for _, e := range alts {
-		if v := http.Header(attrs).Get(e); v != "" {
-			return e, v
+// Find the first of the named attributes which has a value and return its
+// name and value. Names must be in canonical form; attributes are expected to
+// be keyed by canonical names, as they are in http.Header.
+func findAttr(attrs Attrs, names []string) (string, string) {
+	for _, e := range names {
+		if v := attrs[e]; len(v) > 0 && v[0] != "" {
+			return e, v[0]
 		}
 	}
 	return "", ""
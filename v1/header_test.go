@@ -0,0 +1,325 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Attrs  Attrs
+		Dur    Durationer
+		Expect HeaderState
+		Err    error
+	}{
+		{
+			"Epoch",
+			Attrs{"X-Ratelimit-Limit": []string{"100"}, "X-Ratelimit-Remaining": []string{"42.5"}, "X-Ratelimit-Reset": []string{"1712880000"}},
+			nil,
+			HeaderState{Limit: 100, Remaining: 42.5, Reset: time.Unix(1712880000, 0)},
+			nil,
+		},
+		{
+			"Milliseconds",
+			Attrs{"Ratelimit-Limit": []string{"100"}, "Ratelimit-Remaining": []string{"0"}, "Ratelimit-Reset": []string{"1712880000500"}},
+			Milliseconds,
+			HeaderState{Limit: 100, Remaining: 0, Reset: time.UnixMilli(1712880000500)},
+			nil,
+		},
+		{
+			"Missing",
+			Attrs{"Ratelimit-Limit": []string{"100"}},
+			nil,
+			HeaderState{Limit: 100},
+			ErrMissingHeaders,
+		},
+		{
+			"Invalid",
+			Attrs{"Ratelimit-Limit": []string{"many"}},
+			nil,
+			HeaderState{},
+			ErrInvalidHeaders,
+		},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			res, err := ParseRateLimitHeaders(e.Attrs, e.Dur)
+			if e.Err != nil {
+				assert.ErrorIs(t, err, e.Err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect.Limit, res.Limit)
+				assert.Equal(t, e.Expect.Remaining, res.Remaining)
+				assert.True(t, e.Expect.Reset.Equal(res.Reset), "expected %v, got %v", e.Expect.Reset, res.Reset)
+			}
+		})
+	}
+
+	before := time.Now()
+	res, err := ParseRateLimitHeaders(Attrs{"Retry-After": []string{"30"}, "Ratelimit-Limit": []string{"many"}}, nil)
+	if assert.NoError(t, err) {
+		assert.False(t, res.RetryAfter.Before(before.Add(time.Second*30)))
+		assert.Equal(t, 0, res.Limit, "only retry-after is parsed")
+	}
+}
+
+func TestResetHeaders(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Name   string
+		Conf   Config
+		Attrs  Attrs
+		Expect time.Time
+	}{
+		{
+			"Reset-After",
+			Config{},
+			Attrs{"X-Ratelimit-Reset-After": {"4.5"}},
+			now.Add(time.Millisecond * 4500),
+		},
+		{
+			"Reset-After preferred",
+			Config{},
+			Attrs{"X-Ratelimit-Reset-After": {"4.5"}, "X-Ratelimit-Reset": {"1712880010"}},
+			now.Add(time.Millisecond * 4500),
+		},
+		{
+			"Epoch and delta",
+			Config{ResetHeaders: map[string]ResetSemantics{"RateLimit-Reset": Delta}},
+			Attrs{"Ratelimit-Reset": {"30"}},
+			now.Add(time.Second * 30),
+		},
+		{
+			"Overrides Durationer",
+			Config{Durationer: Dates, ResetHeaders: map[string]ResetSemantics{"ratelimit-reset": Delta}},
+			Attrs{"Ratelimit-Reset": {"30"}},
+			now.Add(time.Second * 30),
+		},
+		{
+			"Durationer for other headers",
+			Config{Durationer: Dates, ResetHeaders: map[string]ResetSemantics{"RateLimit-Reset": Delta}},
+			Attrs{"X-Ratelimit-Reset": {"2024-04-12T00:01:00Z"}},
+			now.Add(time.Minute),
+		},
+		{
+			"Custom header",
+			Config{ResetHeaders: map[string]ResetSemantics{"X-Rate-Limit-Reset-In": Delta}},
+			Attrs{"X-Rate-Limit-Reset-In": {"10"}, "X-Ratelimit-Reset": {"1712880030"}},
+			now.Add(time.Second * 10),
+		},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			conf := e.Conf
+			conf.Window, conf.Events = time.Minute, 10
+			attrs := Attrs{"X-Ratelimit-Limit": {"10"}, "X-Ratelimit-Remaining": {"5"}}
+			for k, v := range e.Attrs {
+				attrs[k] = v
+			}
+			res, err := NewHeaders(conf).UpdateEx(now, WithAttrs(attrs))
+			if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, res.Headers.Reset)
+			}
+		})
+	}
+}
+
+func FuzzParseRateLimitHeaders(f *testing.F) {
+	f.Add("100", "42", "1712880000", "")
+	f.Add("100", "0.5", "30", "")
+	f.Add("", "", "", "12")
+	f.Add("1e3", "-1", "Fri, 12 Apr 2024 00:00:00 GMT", "")
+	f.Add("NaN", "Inf", "99999999999999999999", "-5")
+	f.Fuzz(func(t *testing.T, lim, rem, rst, retry string) {
+		attrs := Attrs{}
+		for k, v := range map[string]string{"Ratelimit-Limit": lim, "Ratelimit-Remaining": rem, "Ratelimit-Reset": rst, "Retry-After": retry} {
+			if v != "" {
+				attrs[k] = []string{v}
+			}
+		}
+		for _, dur := range []Durationer{Seconds, Milliseconds, Dates, DeltaSeconds, Auto} {
+			res, err := ParseRateLimitHeaders(attrs, dur)
+			if err != nil {
+				assert.True(t, errors.Is(err, ErrMissingHeaders) || errors.Is(err, ErrInvalidHeaders), "unexpected error: %v", err)
+			} else if retry != "" {
+				assert.False(t, res.RetryAfter.IsZero())
+			} else {
+				assert.True(t, lim != "" && rem != "" && rst != "", "every header is required")
+			}
+		}
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Name      string
+		Reconcile Reconciliation
+		Reset     string
+		Expect    int
+	}{
+		{"TrustServer", TrustServer, "60", 8},
+		{"TrustLocalMin", TrustLocalMin, "60", 6},
+		{"TrustLocalMin/Later", TrustLocalMin, "120", 8},
+		{"Decay", Decay, "60", 4},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta, Reconcile: e.Reconcile})
+			for i := 0; i < 4; i++ {
+				_, err := lim.Next(now)
+				assert.NoError(t, err, "#%d", i)
+			}
+			err := lim.Update(now, WithObservedAt(now), WithAttrs(Attrs{
+				"X-Ratelimit-Limit":     []string{"10"},
+				"X-Ratelimit-Remaining": []string{"8"},
+				"X-Ratelimit-Reset":     []string{e.Reset},
+			}))
+			if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, lim.State(now).Remaining)
+			}
+		})
+	}
+
+	// operations consumed long before an update are presumed to be reflected in it
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta, Reconcile: Decay, ReconcileDecay: time.Second})
+	for i := 0; i < 4; i++ {
+		_, err := lim.Next(now)
+		assert.NoError(t, err, "#%d", i)
+	}
+	later := now.Add(time.Second * 10)
+	err := lim.Update(later, WithObservedAt(later), WithAttrs(Attrs{
+		"X-Ratelimit-Limit":     []string{"10"},
+		"X-Ratelimit-Remaining": []string{"6"},
+		"X-Ratelimit-Reset":     []string{"50"},
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 6, lim.State(later).Remaining)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	update := func(lim *headers, rem string) {
+		err := lim.Update(now, WithObservedAt(now), WithAttrs(Attrs{
+			"X-Ratelimit-Limit":     []string{"10"},
+			"X-Ratelimit-Remaining": []string{rem},
+			"X-Ratelimit-Reset":     []string{"60"},
+		}))
+		assert.NoError(t, err)
+	}
+
+	// operations granted without a completion function complete with an update
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta, InFlight: true})
+	for i := 0; i < 4; i++ {
+		_, err := lim.Next(now)
+		assert.NoError(t, err, "#%d", i)
+	}
+	update(lim, "9") // the first response; three remain in flight
+	assert.Equal(t, 6, lim.State(now).Remaining)
+	update(lim, "8") // the second response; two remain in flight
+	assert.Equal(t, 6, lim.State(now).Remaining)
+
+	// operations granted with a completion function complete when it is invoked
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta, InFlight: true})
+	var done [3]func()
+	for i := range done {
+		_, err := lim.Next(now, WithCompletion(&done[i]))
+		assert.NoError(t, err, "#%d", i)
+	}
+	done[0]()
+	done[0]() // completing an operation twice has no effect
+	update(lim, "9")
+	assert.Equal(t, 7, lim.State(now).Remaining)
+	done[1]()
+	done[2]()
+	update(lim, "7")
+	assert.Equal(t, 7, lim.State(now).Remaining)
+
+	// without tracking, the completion function is a no-op
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta})
+	var fn func()
+	_, err := lim.Next(now, WithCompletion(&fn))
+	if assert.NoError(t, err) {
+		fn()
+		update(lim, "9")
+		assert.Equal(t, 9, lim.State(now).Remaining)
+	}
+}
+
+func TestTuneTarget(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	attrs := Attrs{
+		"X-Ratelimit-Limit":     []string{"100"},
+		"X-Ratelimit-Remaining": []string{"50"},
+		"X-Ratelimit-Reset":     []string{"60"},
+	}
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, ResetSemantics: Delta, MaxTarget: 0.8, MinTarget: 0.2, TargetLatency: time.Second})
+	assert.Equal(t, 0.8, lim.Target())
+
+	steps := []struct {
+		Status  int
+		Latency time.Duration
+		Expect  float64
+	}{
+		{http.StatusOK, 0, 0.8}, // never beyond the maximum
+		{http.StatusTooManyRequests, 0, 0.4},
+		{http.StatusOK, time.Millisecond * 100, 0.41},
+		{http.StatusOK, time.Second * 2, 0.369},
+		{http.StatusTooManyRequests, 0, 0.2},
+		{http.StatusTooManyRequests, 0, 0.2}, // never below the minimum
+	}
+	for i, e := range steps {
+		lim.Update(now, WithAttrs(attrs), WithStatus(e.Status), WithLatency(e.Latency))
+		assert.InDelta(t, e.Expect, lim.Target(), 1e-9, "#%d", i)
+	}
+
+	// without bounds, the target is left alone
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, ResetSemantics: Delta})
+	lim.Update(now, WithAttrs(attrs), WithStatus(http.StatusTooManyRequests))
+	assert.Equal(t, 0.0, lim.Target())
+}
+
+func TestRestoreRate(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Events: 10, RestoreRate: 2, Mode: Burst})
+
+	// the quota regenerates continuously, so no reset header is required
+	err := lim.Update(now, WithObservedAt(now), WithAttrs(Attrs{
+		"X-Ratelimit-Limit":     []string{"10"},
+		"X-Ratelimit-Remaining": []string{"1"},
+	}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, State{Limit: 10, Remaining: 1, Reset: now.Add(time.Millisecond * 4500)}, lim.State(now))
+
+	// once the quota is exhausted, operations are spaced out at the restore rate
+	for i, e := range []time.Duration{0, time.Millisecond * 500, time.Second, time.Millisecond * 1500} {
+		n, err := lim.Next(now)
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, now.Add(e), n, "#%d", i)
+		}
+	}
+	assert.Equal(t, 0, lim.State(now.Add(time.Second)).Remaining)
+	assert.Equal(t, 3, lim.State(now.Add(time.Second*3)).Remaining)
+	assert.Equal(t, 10, lim.State(now.Add(time.Minute)).Remaining)
+
+	// in strict mode, operations fail rather than consume quota not yet restored
+	lim = NewHeaders(Config{Events: 10, RestoreRate: 2, Strict: true})
+	err = lim.Update(now, WithObservedAt(now), WithAttrs(Attrs{
+		"X-Ratelimit-Limit":     []string{"10"},
+		"X-Ratelimit-Remaining": []string{"0"},
+	}))
+	if assert.NoError(t, err) {
+		_, err = lim.Next(now)
+		assert.ErrorIs(t, err, ErrExhausted)
+		n, err := lim.Next(now.Add(time.Millisecond * 500))
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(time.Millisecond*500), n)
+	}
+}
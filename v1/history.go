@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// The kind of an event recorded in a limiter's history
+type EventKind int
+
+const (
+	Granted      EventKind = iota // an operation was permitted immediately
+	Delayed                       // an operation was delayed
+	Denied                        // an operation was refused because the quota was exhausted, in strict mode
+	Updated                       // the quota was updated, e.g., from response headers
+	BackedOff                     // a backoff was imposed
+	BackoffEnded                  // a backoff was invalidated
+	Refunded                      // an operation's quota was returned by Rollback
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Granted:
+		return "granted"
+	case Delayed:
+		return "delayed"
+	case Denied:
+		return "denied"
+	case Updated:
+		return "updated"
+	case BackedOff:
+		return "backed-off"
+	case BackoffEnded:
+		return "backoff-ended"
+	case Refunded:
+		return "refunded"
+	default:
+		return "unknown"
+	}
+}
+
+// An Event records something that happened to a limiter and the state of its
+// quota afterwards
+type Event struct {
+	Time      time.Time
+	Kind      EventKind
+	Delay     time.Duration // the delay imposed on an operation, or the duration of a backoff
+	Limit     int
+	Remaining float64
+	Reset     time.Time
+}
+
+// history is a fixed-size ring of the most recent events
+type history struct {
+	events []Event
+	next   int
+	full   bool
+}
+
+func newHistory(n int) *history {
+	if n <= 0 {
+		return nil
+	}
+	return &history{events: make([]Event, n)}
+}
+
+// Record an event, displacing the oldest if the history is full
+func (h *history) record(e Event) {
+	if h == nil {
+		return
+	}
+	h.events[h.next] = e
+	h.next = (h.next + 1) % len(h.events)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Produce up to n of the most recent events, oldest first; if n <= 0, every
+// event is produced
+func (h *history) recent(n int) []Event {
+	if h == nil {
+		return nil
+	}
+	c := h.next
+	if h.full {
+		c = len(h.events)
+	}
+	if n <= 0 || n > c {
+		n = c
+	}
+	res := make([]Event, n)
+	for i := 0; i < n; i++ {
+		res[i] = h.events[(h.next-n+i+len(h.events))%len(h.events)]
+	}
+	return res
+}
+
+func (h *history) clone() *history {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	c.events = append([]Event(nil), h.events...)
+	return &c
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	h := newHistory(3)
+	assert.Len(t, h.recent(0), 0)
+	for i := 0; i < 5; i++ {
+		h.record(Event{Limit: i})
+	}
+	limits := func(evts []Event) []int {
+		var res []int
+		for _, e := range evts {
+			res = append(res, e.Limit)
+		}
+		return res
+	}
+	assert.Equal(t, []int{2, 3, 4}, limits(h.recent(0)))
+	assert.Equal(t, []int{3, 4}, limits(h.recent(2)))
+	assert.Equal(t, []int{2, 3, 4}, limits(h.recent(10)))
+	assert.Nil(t, newHistory(0).recent(1))
+}
+
+func TestHeadersHistory(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 1, Mode: Burst, History: 10})
+	lim.Next(now)
+	lim.Next(now)
+	lim.impl.Update(10, 10, now.Add(time.Minute))
+	lim.BackoffUntil(time.Now().Add(time.Minute))
+	lim.InvalidateBackoff()
+
+	var kinds []EventKind
+	for _, e := range lim.History(0) {
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Equal(t, []EventKind{Granted, Delayed, Updated, BackedOff, BackoffEnded}, kinds)
+	assert.Equal(t, Event{Time: now, Kind: Delayed, Delay: time.Minute, Limit: 1, Remaining: 0, Reset: now.Add(time.Minute)}, lim.History(4)[0])
+	assert.Nil(t, NewHeaders(Config{}).History(0), "history is not recorded by default")
+}
@@ -1,22 +1,52 @@
 package ratelimit
 
 import (
+	"log/slog"
+	"math"
 	"sync"
 	"time"
+
+	"github.com/bww/go-util/v1/ext"
 )
 
 const (
-	lowThreshold = 0.05  // quota is running low when we have 5% of operations remaining
-	lowLimit     = 0.005 // stop making requests when we only have ½% of operations left
+	defaultLowWatermark      = 0.05  // quota is running low when we have 5% of operations remaining
+	defaultCriticalWatermark = 0.005 // stop making requests when we only have ½% of operations left
+	defaultBurstFraction     = 0.5   // in Smooth mode, burst through half the quota before metering
+	defaultSlices            = 60    // in Sliced mode, divide the window into 60 slices
 )
 
 const defaultBackoffPeriod = time.Minute * 3
 
+// When tuning the target, it is multiplied by these factors when a response is
+// throttled or slow, and it is otherwise raised by the step, within bounds
+const (
+	defaultMinTarget = 0.1
+	targetThrottled  = 0.5
+	targetSlow       = 0.9
+	targetStep       = 0.01
+)
+
+// In Decay reconciliation, operations are presumed to remain in flight for
+// about this long by default
+const defaultReconcileDecay = time.Second
+
+// In TrustLocalMin reconciliation, an update describes the window we are
+// already in if its reset is no more than this much later than ours, since
+// resets are commonly reported to the second
+const reconcileTolerance = time.Second
+
 // Compute the backoff duration for a period and error count
 func backoffDuration(p time.Duration, n int) time.Duration {
 	return p * time.Duration(n) * time.Duration(n)
 }
 
+// Per-operation overrides of a limiter's mode and target
+type pacing struct {
+	mode   *Mode   // the mode, if overridden
+	target float64 // the target, if > 0
+}
+
 // limiter implements the basic mechanics of a rate limiter, but it does not
 // conform to RateLimiter and its state must be updated explicitly, rather than
 // from an HTTP response. It is intended to be used as a basis for other rate
@@ -25,143 +55,683 @@ func backoffDuration(p time.Duration, n int) time.Duration {
 type limiter struct {
 	sync.Mutex
 	limit         int
-	remaining     int
+	remaining     float64
 	reset         time.Time
+	window        time.Duration // the expected duration of a window, if known
 	backoff       *time.Time
 	backoffPeriod time.Duration
 	errcount      int
 	mode          Mode
 	target        float64       // the proprortion of the total quota we target, if > 0
 	maxMeter      time.Duration // maximum delay in metered mode, if > 0
+	lowWater      float64       // the proportion of remaining quota below which we slow down
+	criticalWater float64       // the proportion of remaining quota below which we stop
+	reserve       float64       // quota we never consume; a proportion if < 1, otherwise a count
+	burst         float64       // the proportion of the quota we may burst through in Smooth mode
+	slice         time.Duration // the duration of a slice of the window in Sliced mode, if > 0
+	strict        bool          // fail rather than delay when the quota is exhausted
+	observed      time.Time     // when the information in the last update was observed, if known
+	bound         bool          // no quota delay may exceed the window, if it is known
+	soft          float64       // the proportion of the quota consumed which is a soft limit, if > 0
+	softTarget    float64       // the proportion of the rate we meter at beyond the soft limit, if > 0
+	onSoft        func(State)   // invoked when the soft limit is crossed
+	softReset     time.Time     // the reset of the window in which the soft limit was last crossed
+	history       *history      // recent events, if we are recording them
+	stats         ewma          // observed behavior
+	log           *slog.Logger  // debug logging, if any
+	gen           uint64        // incremented whenever the budget is replaced by an update
+	reconcile     Reconciliation
+	decay         time.Duration // the period over which operations are presumed in flight, in Decay reconciliation
+	inflight      float64       // the decaying count of operations consumed, in Decay reconciliation
+	inflightAt    time.Time     // when the count of operations in flight was last incremented
+	track         bool          // track the operations granted which have not yet completed
+	flying        int           // operations in flight which are completed by updates
+	held          int           // operations in flight which are completed explicitly
+	minTarget     float64       // the least the target may be tuned to
+	maxTarget     float64       // the most the target may be tuned to; if zero, it is not tuned
+	targetLatency time.Duration // the latency beyond which responses are slow, when tuning the target
+	restore       float64       // the operations restored per second, if > 0, in which case the quota regenerates continuously rather than resetting
+	restoredAt    time.Time     // when the remaining quota was last brought forward, in restore mode
+}
+
+// A slot of quota consumed from a limiter's budget, which may be refunded
+// until the budget is next replaced by an update
+type slot struct {
+	gen   uint64 // the generation of the budget the slot was consumed from
+	taken bool   // whether a slot was consumed at all
+}
+
+// Emit a debug log message, if we are logging
+func (l *limiter) debug(msg string, args ...any) {
+	if l.log != nil {
+		l.log.Debug(msg, args...)
+	}
+}
+
+// Record an event in the history, if we are keeping one; the caller must hold
+// the lock
+func (l *limiter) record(rel time.Time, k EventKind, d time.Duration) {
+	if l.history != nil {
+		l.history.record(Event{Time: rel, Kind: k, Delay: d, Limit: l.limit, Remaining: l.remaining, Reset: l.reset})
+	}
+}
+
+// History returns up to n of the most recent events, oldest first; if n <= 0,
+// every retained event is returned
+func (l *limiter) History(n int) []Event {
+	l.Lock()
+	defer l.Unlock()
+	return l.history.recent(n)
+}
+
+// Compute the number of operations held in reserve for a limit
+func reserveCount(r float64, lim int) int {
+	if r <= 0 {
+		return 0
+	} else if r < 1 {
+		return int(math.Ceil(r * float64(lim)))
+	} else {
+		return int(r)
+	}
 }
 
 func (l *limiter) State() State {
+	return l.StateAt(time.Now())
+}
+
+// StateAt describes the limiter relative to the provided time, which matters
+// only in restore mode, where the quota regenerates over time and the reset
+// is when it will have been restored in full
+func (l *limiter) StateAt(rel time.Time) State {
 	l.Lock()
 	defer l.Unlock()
+	rem, rst := l.remaining, l.reset
+	if l.restore > 0 {
+		rem = l.restored(rel)
+		rst = rel.Add(time.Duration(math.Max(0, float64(l.limit)-rem) / l.restore * float64(time.Second)))
+	}
+	var p float64
+	if l.limit > 0 {
+		p = (rem - float64(reserveCount(l.reserve, l.limit))) / float64(l.limit) // as when computing delays
+	}
 	return State{
 		Limit:     l.limit,
-		Remaining: l.remaining,
-		Reset:     l.reset,
+		Remaining: max(0, int(rem)),
+		Reset:     rst,
+		Low:       l.limit > 0 && p < l.lowWater,
+		Critical:  l.limit > 0 && p < l.criticalWater,
 	}
 }
 
-// Update remaining budget to the provided state
-func (l *limiter) Update(lim, rem int, rst time.Time) error {
+// Produce an independent copy of the limiter's state
+func (l *limiter) clone() *limiter {
+	c := &limiter{}
+	l.cloneTo(c)
+	return c
+}
+
+// Copy the limiter's state to another limiter, which must not be in use, so
+// that the copy may be embedded by value without copying the lock
+func (l *limiter) cloneTo(c *limiter) {
+	l.Lock()
+	defer l.Unlock()
+	*c = limiter{
+		limit:         l.limit,
+		remaining:     l.remaining,
+		reset:         l.reset,
+		window:        l.window,
+		backoffPeriod: l.backoffPeriod,
+		errcount:      l.errcount,
+		mode:          l.mode,
+		target:        l.target,
+		maxMeter:      l.maxMeter,
+		lowWater:      l.lowWater,
+		criticalWater: l.criticalWater,
+		reserve:       l.reserve,
+		burst:         l.burst,
+		slice:         l.slice,
+		strict:        l.strict,
+		observed:      l.observed,
+		bound:         l.bound,
+		soft:          l.soft,
+		softTarget:    l.softTarget,
+		onSoft:        l.onSoft,
+		softReset:     l.softReset,
+		history:       l.history.clone(),
+		stats:         l.stats,
+		log:           l.log,
+		gen:           l.gen,
+		reconcile:     l.reconcile,
+		decay:         l.decay,
+		inflight:      l.inflight,
+		inflightAt:    l.inflightAt,
+		track:         l.track,
+		flying:        l.flying,
+		held:          l.held,
+		minTarget:     l.minTarget,
+		maxTarget:     l.maxTarget,
+		targetLatency: l.targetLatency,
+		restore:       l.restore,
+		restoredAt:    l.restoredAt,
+	}
+	if l.backoff != nil {
+		b := *l.backoff
+		c.backoff = &b
+	}
+}
+
+// Stats describes the observed behavior of the limiter
+func (l *limiter) Stats() Stats {
+	l.Lock()
+	defer l.Unlock()
+	return l.stats.Stats()
+}
+
+// Set the proportion of remaining quota below which Meter mode slows down
+func (l *limiter) SetLowWatermark(v float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.lowWater = v
+}
+
+// Set the proportion of remaining quota below which Meter mode stops until
+// the window resets
+func (l *limiter) SetCriticalWatermark(v float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.criticalWater = v
+}
+
+// Set the proportion of the quota which may be consumed in a burst in Smooth mode
+func (l *limiter) SetBurstFraction(v float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.burst = v
+}
+
+// Set the proportion of the quota we target in Meter mode; zero targets the
+// entire quota
+func (l *limiter) SetTarget(v float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.target = v
+}
+
+// Determine the proportion of the quota we target in Meter mode
+func (l *limiter) Target() float64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.target
+}
+
+// Tune the target from the feedback provided by an update: whether the
+// response was throttled and how long it took, if known. A throttled response
+// reduces the target sharply and a slow one somewhat; any other raises it a
+// step, within bounds. If tuning is not configured, this does nothing.
+func (l *limiter) tune(throttled bool, latency time.Duration) {
+	if l.maxTarget <= 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	t := ext.Coalesce(l.target, l.maxTarget)
+	switch {
+	case throttled:
+		t *= targetThrottled
+	case l.targetLatency > 0 && latency > l.targetLatency:
+		t *= targetSlow
+	default:
+		t += targetStep
+	}
+	l.target = min(l.maxTarget, max(ext.Coalesce(l.minTarget, defaultMinTarget), t))
+}
+
+// Set the quota held in reserve; a proportion of the limit if < 1, otherwise
+// an absolute count of operations
+func (l *limiter) SetReserve(v float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.reserve = v
+}
+
+// Determine the number of operations held in reserve
+func (l *limiter) Reserved() int {
 	l.Lock()
 	defer l.Unlock()
+	return reserveCount(l.reserve, l.limit)
+}
+
+// Update remaining budget to the provided state
+func (l *limiter) Update(lim int, rem float64, rst time.Time) error {
+	l.Lock()
+	l.setAt(lim, rem, rst, time.Time{})
+	l.Unlock()
+	if l.log != nil {
+		l.debug("Updated state", "limit", lim, "remaining", rem, "reset", rst)
+	}
+	return nil
+}
+
+// Anchor a wall-clock time, such as a reset reported by a service, to the
+// monotonic clock reading of the reference time, if it has one. Comparisons
+// between the result and other times with monotonic readings, like those from
+// time.Now, are then unaffected by subsequent changes to the system clock.
+func anchor(rel, t time.Time) time.Time {
+	if t.IsZero() || rel == rel.Round(0) { // no monotonic reading
+		return t
+	}
+	return rel.Add(t.Sub(rel))
+}
+
+// Set the budget; the caller must hold the lock
+func (l *limiter) set(lim int, rem float64, rst time.Time) {
 	l.limit = lim
 	l.remaining = rem
 	l.reset = rst
-	return nil
+}
+
+// Set the budget from information observed at the provided time, unless it is
+// stale: older than the information we already have and not describing a
+// later window. Applying stale information would move the remaining quota
+// back up or the reset back. If the time is zero, the information is assumed
+// to be current. The caller must hold the lock.
+func (l *limiter) setAt(lim int, rem float64, rst, at time.Time) bool {
+	if !at.IsZero() && at.Before(l.observed) && !rst.After(l.reset) {
+		return false
+	}
+	if l.track {
+		if rst.After(l.reset.Add(reconcileTolerance)) {
+			l.flying = 0 // a new window; those which never completed are forgotten
+		}
+		rem = math.Max(0, rem-float64(l.flying+l.held))
+	}
+	l.set(lim, l.reconciled(rem, rst, ext.Coalesce(at, time.Now())), rst)
+	if l.restore > 0 {
+		l.restoredAt = ext.Coalesce(at, time.Now())
+	}
+	l.gen++
+	if at.After(l.observed) {
+		l.observed = at
+	}
+	l.record(ext.Coalesce(at, time.Now()), Updated, 0)
+	return true
+}
+
+// Reconcile the remaining budget reported by an update observed at the
+// provided time with the operations consumed locally, according to our
+// strategy; the caller must hold the lock
+func (l *limiter) reconciled(rem float64, rst, at time.Time) float64 {
+	switch l.reconcile {
+	case TrustLocalMin:
+		if !rst.After(l.reset.Add(reconcileTolerance)) {
+			return math.Min(rem, l.restored(at))
+		}
+	case Decay:
+		return math.Max(0, rem-math.Floor(l.pending(at)))
+	}
+	return rem
+}
+
+// Determine the quota remaining at the provided time, which, in restore mode,
+// has regenerated since it was last brought forward; the caller must hold the
+// lock
+func (l *limiter) restored(rel time.Time) float64 {
+	if l.restore <= 0 || l.remaining >= float64(l.limit) || !rel.After(l.restoredAt) {
+		return l.remaining
+	}
+	return math.Min(float64(l.limit), l.remaining+l.restore*rel.Sub(l.restoredAt).Seconds())
+}
+
+// Determine the number of operations presumed to be in flight at the
+// provided time, in Decay reconciliation; the caller must hold the lock
+func (l *limiter) pending(rel time.Time) float64 {
+	if l.inflight <= 0 || l.decay <= 0 {
+		return 0
+	}
+	return l.inflight * math.Exp(-float64(max(0, rel.Sub(l.inflightAt)))/float64(l.decay))
+}
+
+// Track an operation which consumed the provided slot as in flight, if we are
+// tracking them, until the function returned is invoked or, if the operation
+// is not completed explicitly, until an update is received. If the operation
+// is not tracked, the result is nil.
+func (l *limiter) launch(s slot, explicit bool) func() {
+	if !l.track || !s.taken {
+		return nil
+	}
+	l.Lock()
+	if explicit {
+		l.held++
+	} else {
+		l.flying++
+	}
+	l.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.Lock()
+			if explicit {
+				l.held = max(0, l.held-1)
+			} else {
+				l.flying = max(0, l.flying-1)
+			}
+			l.Unlock()
+		})
+	}
+}
+
+// Complete an operation in flight which is completed by an update, since one
+// has been received; the caller must hold the lock
+func (l *limiter) land() {
+	if l.track && l.flying > 0 {
+		l.flying--
+	}
 }
 
 // Decrement remaining budget if we have any
 func (l *limiter) Dec() error {
 	l.Lock()
 	defer l.Unlock()
-	if l.remaining > 0 {
-		l.remaining--
-	}
+	l.remaining = math.Max(0, l.remaining-1)
 	return nil
 }
 
 // Back off incrementally, relative to the provided time
 func (l *limiter) Backoff(rel time.Time) (time.Time, error) {
 	l.Lock()
-	defer l.Unlock()
 	l.errcount++
-	until := rel.Add(backoffDuration(l.backoffPeriod, l.errcount))
+	n := l.errcount
+	until := rel.Add(backoffDuration(l.backoffPeriod, n))
 	l.backoff = &until
+	l.record(rel, BackedOff, until.Sub(rel))
+	l.Unlock()
+	l.debug("Backing off", "until", until, "errors", n)
 	return until, nil
 }
 
 // Back off until the provided time
 func (l *limiter) BackoffUntil(until time.Time) error {
+	l.Lock()
+	l.setBackoff(until)
+	l.Unlock()
+	l.debug("Backing off", "until", until)
+	return nil
+}
+
+// Determine when the current backoff period ends; if there is none, the
+// result is zero
+func (l *limiter) BackoffEnd() time.Time {
 	l.Lock()
 	defer l.Unlock()
+	if l.backoff != nil {
+		return *l.backoff
+	}
+	return time.Time{}
+}
+
+// Set a backoff period; the caller must hold the lock
+func (l *limiter) setBackoff(until time.Time) {
 	l.backoff = &until
 	l.errcount = 1
-	return nil
+	now := time.Now()
+	l.record(now, BackedOff, until.Sub(now))
 }
 
 // Invalidate a backoff period
 func (l *limiter) InvalidateBackoff() error {
 	l.Lock()
-	defer l.Unlock()
 	l.errcount = 0
 	l.backoff = nil
+	l.record(time.Now(), BackoffEnded, 0)
+	l.Unlock()
+	l.debug("Backoff invalidated")
 	return nil
 }
 
-func (l *limiter) Delay(rel time.Time) (time.Duration, error) {
-	var (
-		d, r time.Duration
-		b    *time.Time
-		m    Mode
-		q, e int
-	)
+// Compute the delay before the next operation relative to the provided time,
+// consuming budget if there is any
+func (l *limiter) Delay(rel time.Time, p pacing) (time.Duration, error) {
+	d, _, err := l.acquire(rel, p)
+	return d, err
+}
 
-	// mutate state in one chunk
+// Compute the delay before the next operation, as Delay does, and produce the
+// slot it consumed, if any, so that it may be refunded
+func (l *limiter) acquire(rel time.Time, p pacing) (time.Duration, slot, error) {
+	d, b, x, rem, s := l.delay(rel, true, p)
+	if x && l.strict {
+		l.debug("Quota exhausted", "reset", rel.Add(d))
+		return 0, slot{}, ExhaustedError{Reset: rel.Add(d)}
+	}
+	if l.log != nil { // avoid boxing the arguments when we aren't logging
+		l.debug("Computed delay", "delay", d, "backoff", b, "remaining", rem)
+	}
+	if l.soft > 0 {
+		l.notifySoft()
+	}
+	return d, s, nil
+}
+
+// Return a slot to the budget it was consumed from, unless the budget has
+// since been replaced by an update, which already accounts for the operation
+// not having been performed. The result is true if the slot was refunded.
+func (l *limiter) refund(rel time.Time, s slot) bool {
 	l.Lock()
-	m = l.mode
-	q = l.limit
+	defer l.Unlock()
+	if !s.taken || s.gen != l.gen {
+		return false
+	}
+	l.remaining = math.Min(float64(l.limit), l.remaining+1)
+	if l.reconcile == Decay {
+		l.inflight = math.Max(0, l.inflight-1)
+	}
+	l.record(rel, Refunded, 0)
+	return true
+}
+
+// Determine if the provided consumption is beyond the soft limit; the caller
+// must hold the lock
+func (l *limiter) beyondSoft(c float64) bool {
+	return l.soft > 0 && l.limit > 0 && c/float64(l.limit) >= l.soft
+}
+
+// Invoke the soft limit callback if consumption has crossed the soft limit
+// and it has not yet been invoked in the current window
+func (l *limiter) notifySoft() {
+	l.Lock()
+	fire := l.beyondSoft(float64(l.limit)-l.remaining) && !l.softReset.Equal(l.reset)
+	if fire {
+		l.softReset = l.reset
+	}
+	l.Unlock()
+	if fire {
+		st := l.State()
+		l.debug("Soft limit crossed", "limit", st.Limit, "remaining", st.Remaining)
+		if l.onSoft != nil {
+			l.onSoft(st)
+		}
+	}
+}
+
+// Compute the delay before the next operation relative to the provided time,
+// without consuming any budget, as if the quota were the one provided, and whether it is the
+// result of the quota being exhausted. The limiter's quota is not modified.
+func (l *limiter) peekAs(rel time.Time, p pacing, lim int, rem float64, rst time.Time) (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+	limit, remaining, reset := l.limit, l.remaining, l.reset
+	l.set(lim, rem, rst)
+	d, b, x := l.compute(rel, false, p)
+	l.set(limit, remaining, reset)
+	if l.bound && !b && l.window > 0 && d > l.window {
+		d = l.window // the reset is implausible; the clock or the service is wrong
+	}
+	return d, x
+}
+
+// Compute the delay before the next operation, whether it is the result of a
+// backoff, whether it is the result of the quota being exhausted, the quota
+// remaining afterwards, and the slot consumed, if any. If consume is false,
+// the state of the limiter is not modified; otherwise the operation is also
+// recorded in the statistics.
+//
+// This is the hot path for every operation, so the entire computation is
+// performed under a single acquisition of the lock and nothing is allocated.
+func (l *limiter) delay(rel time.Time, consume bool, p pacing) (time.Duration, bool, bool, float64, slot) {
+	l.Lock()
+	defer l.Unlock()
+	before := l.remaining
+	d, b, x := l.compute(rel, consume, p)
+	if l.bound && !b && l.window > 0 && d > l.window {
+		d = l.window // the reset is implausible; the clock or the service is wrong
+	}
+	if consume && !(x && l.strict) {
+		l.stats.observe(rel, d, b)
+	}
+	taken := l.remaining < before
+	if taken && l.reconcile == Decay {
+		l.inflight, l.inflightAt = l.pending(rel)+1, rel
+	}
+	if consume && l.history != nil {
+		switch {
+		case x && l.strict:
+			l.record(rel, Denied, d)
+		case d > 0:
+			l.record(rel, Delayed, d)
+		default:
+			l.record(rel, Granted, 0)
+		}
+	}
+	return d, b, x, l.remaining, slot{gen: l.gen, taken: taken}
+}
+
+// Compute the delay before the next operation; the caller must hold the lock
+func (l *limiter) compute(rel time.Time, consume bool, p pacing) (time.Duration, bool, bool) {
+	var r time.Duration
+	var e float64
 
 	// first, check for an existing backoff period
 	if v := l.backoff; v != nil {
-		if rel.After(*v) {
+		if !rel.After(*v) {
+			return v.Sub(rel), true, false
+		} else if consume {
 			l.backoff = nil
-		} else {
-			b = v
+			if l.log != nil {
+				l.debug("Backoff ended", "at", rel)
+			}
 		}
 	}
 
-	// if we don't have one, determine if we have budget left, and if so
-	// consume a request; otherwise, the delay is until the window reset
-	if b == nil {
-		r = l.reset.Sub(rel)
-		if r < 0 {
-			r = 0 // can't have a negative reset window
+	// determine if we have budget left, and if so consume a request;
+	// otherwise, the delay is until the window reset
+	r = l.reset.Sub(rel)
+	if r < 0 {
+		r = 0 // can't have a negative reset window
+	}
+	e = l.remaining - float64(reserveCount(l.reserve, l.limit))
+	c := float64(l.limit) - l.remaining
+	if consume {
+		l.errcount = 0 // clear error count if we're not in a backoff
+	}
+	// in restore mode, the quota regenerates continuously rather than resetting
+	if l.restore > 0 {
+		return l.restoreDelay(rel, consume)
+	}
+	m := l.mode
+	if p.mode != nil {
+		m = *p.mode
+	}
+	// in Sliced mode, we wait for the slice in which our allowance permits
+	// another operation
+	if m == Sliced && e >= 1 {
+		if d := l.sliceDelay(rel, c); d > 0 {
+			return min(d, r), false, false
 		}
-		e = l.remaining
-		if l.remaining > 0 {
-			l.remaining--
-		} else {
-			d = r
+	}
+	// if we have exhausted the current window, the delay is the end of the window
+	if e < 1 {
+		if r > 0 {
+			return r, false, true
 		}
-		l.errcount = 0 // clear error count if we're not in a backoff
+	} else if consume {
+		l.remaining--
 	}
 
-	l.Unlock()
-
-	// if we are in a backoff, the delay is until the backoff period ends
-	if b != nil {
-		return (*b).Sub(rel), nil
+	// in Smooth mode, we burst until we have consumed our burst allotment
+	// and then meter the remainder of the window
+	if m == Smooth {
+		if c < l.burst*float64(l.limit) {
+			m = Burst
+		} else {
+			m = Meter
+		}
 	}
-	// if we have exhausted the current window, the delay is the end of the window
-	if d > 0 {
-		return d, nil
+	// beyond the soft limit, we may meter the remainder of the window at a
+	// reduced rate
+	tgt := ext.Coalesce(p.target, l.target)
+	if l.softTarget > 0 && l.beyondSoft(c) {
+		m, tgt = Meter, l.softTarget
 	}
 
 	// if we are using Meter mode, we attempt to spread out our requests over
 	// the entire rate-limit window rather than consuming them until we exhaust
 	// the budget and then waiting for the window to reset
 	if m == Meter && e > 0 {
-		d := r / time.Duration(e)
-		if l.target > 0 {
-			d = time.Duration(float64(d) * (1.0 / l.target))
+		d := time.Duration(float64(r) / e)
+		if tgt > 0 {
+			d = time.Duration(float64(d) * (1.0 / tgt))
 		}
 		// back off aggressively as we get close to our limit
-		if p := float64(e) / float64(q); p < lowLimit {
+		if p := e / float64(l.limit); p < l.criticalWater {
 			d = r // wait until the window resets
-		} else if p < lowThreshold {
+		} else if p < l.lowWater {
 			d = time.Duration(float64(d) * (1.0 / p / 2.0))
 		}
-		if x := l.maxMeter; x > 0 && d > x {
-			return x, nil
+		if l.maxMeter > 0 && d > l.maxMeter {
+			return l.maxMeter, false, false
 		} else {
-			return d, nil
+			return d, false, false
 		}
 	}
 
-	return 0, nil
+	return 0, false, false
+}
+
+// Compute the delay before the next operation in restore mode, where the
+// quota regenerates continuously. Operations proceed while any quota remains;
+// otherwise they are delayed until enough has been restored, and consume it in
+// advance, so that those which are delayed are spaced out at the restore rate
+// rather than all released at once. Modes do not apply. The caller must hold
+// the lock.
+func (l *limiter) restoreDelay(rel time.Time, consume bool) (time.Duration, bool, bool) {
+	rem := l.restored(rel)
+	e := rem - float64(reserveCount(l.reserve, l.limit))
+	var d time.Duration
+	if e < 1 {
+		d = time.Duration((1 - e) / l.restore * float64(time.Second))
+	}
+	if consume && !(d > 0 && l.strict) {
+		l.remaining, l.restoredAt = rem-1, later(rel, l.restoredAt)
+	}
+	return d, false, d > 0
+}
+
+// Compute the delay until the slice of the window in which our allowance
+// permits another operation, given the quota consumed, in Sliced mode. By the
+// end of each slice, we may have consumed that slice's share of the quota and
+// every earlier slice's share. If the window is not known, slices cannot be
+// determined and there is no delay. The caller must hold the lock.
+func (l *limiter) sliceDelay(rel time.Time, c float64) time.Duration {
+	slice := ext.Coalesce(l.slice, l.window/defaultSlices)
+	if l.window <= 0 || slice <= 0 || l.limit <= 0 {
+		return 0
+	}
+	start := l.reset.Add(-l.window)
+	n := math.Ceil(float64(l.window) / float64(slice))
+	j := math.Floor(float64(max(0, rel.Sub(start)))/float64(slice)) + 1
+	if c+1 <= float64(l.limit)*math.Min(j, n)/n {
+		return 0
+	}
+	k := math.Ceil((c + 1) * n / float64(l.limit)) // the first slice whose allowance permits it
+	return start.Add(time.Duration(k-1) * slice).Sub(rel)
 }
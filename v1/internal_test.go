@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func BenchmarkDelay(b *testing.B) {
+	for _, e := range []struct {
+		Name string
+		Mode Mode
+	}{
+		{"Burst", Burst},
+		{"Meter", Meter},
+	} {
+		b.Run(e.Name, func(b *testing.B) {
+			now := time.Now()
+			lim := NewHeaders(Config{Start: now, Window: time.Hour, Events: math.MaxInt32, Mode: e.Mode})
+			b.ReportAllocs()
+			b.SetParallelism(100)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					lim.impl.Delay(now, pacing{})
+				}
+			})
+		})
+	}
+}
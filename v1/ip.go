@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefixes parses CIDR prefixes, such as those of trusted proxies. Bare
+// addresses are accepted as single-address prefixes.
+func ParsePrefixes(v ...string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(v))
+	for _, e := range v {
+		if !strings.Contains(e, "/") {
+			a, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+		} else {
+			p, err := netip.ParsePrefix(e)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, p.Masked())
+		}
+	}
+	return res, nil
+}
+
+// Determine if an address is within any of the provided prefixes
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, e := range prefixes {
+		if e.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP determines the address of the client which made a request.
+//
+// Forwarding headers are easily forged, so they are only consulted when the
+// immediate peer is one of the trusted proxies. In that case, X-Forwarded-For
+// is walked from right to left, skipping trusted proxies, and the first
+// untrusted address is the client. If the request was not forwarded, the
+// X-Real-IP header is used instead. The second return value is false if no
+// address can be determined.
+func ClientIP(req *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr // no port
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	peer = peer.Unmap().WithZone("")
+	if !containsAddr(trusted, peer) {
+		return peer, true
+	}
+
+	var hops []string
+	for _, e := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(e, ",")...)
+	}
+	if len(hops) == 0 {
+		if v := req.Header.Get("X-Real-IP"); v != "" {
+			hops = []string{v}
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break // garbage; don't trust anything further left
+		}
+		client = addr.Unmap().WithZone("")
+		if !containsAddr(trusted, client) {
+			break
+		}
+	}
+	return client, true
+}
+
+// IPKey produces a function which keys requests by the address of the client
+// that made them, as determined by ClientIP, aggregated to the provided prefix
+// lengths so that, e.g., an entire IPv4 /24 or IPv6 /64 shares a limit. If
+// a prefix length is <= 0, the full address is used. Requests for which no
+// address can be determined share the empty key.
+func IPKey(trusted []netip.Prefix, v4bits, v6bits int) func(*http.Request) string {
+	return func(req *http.Request) string {
+		addr, ok := ClientIP(req, trusted)
+		if !ok {
+			return ""
+		}
+		bits := v6bits
+		if addr.Is4() {
+			bits = v4bits
+		}
+		if bits <= 0 || bits > addr.BitLen() {
+			bits = addr.BitLen()
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			return addr.String()
+		}
+		return p.String()
+	}
+}
+
+// IPBypass produces a function which exempts requests from clients, as
+// determined by ClientIP, whose addresses are within the allowed prefixes,
+// such as internal networks, and rejects those within the denied prefixes.
+// Denied prefixes take precedence. Other requests are limited as usual.
+func IPBypass(trusted, allow, deny []netip.Prefix) func(*http.Request) Decision {
+	return func(req *http.Request) Decision {
+		addr, ok := ClientIP(req, trusted)
+		switch {
+		case !ok:
+			return Enforce
+		case containsAddr(deny, addr):
+			return Reject
+		case containsAddr(allow, addr):
+			return Allow
+		default:
+			return Enforce
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParsePrefixes("10.0.0.0/8", "192.0.2.1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		Remote string
+		Header http.Header
+		Expect string
+		Key    string
+	}{
+		{"203.0.113.7:1234", nil, "203.0.113.7", "203.0.113.0/24"},
+		{"[::ffff:203.0.113.7]:1234", nil, "203.0.113.7", "203.0.113.0/24"},
+		{"[2001:db8::1]:1234", nil, "2001:db8::1", "2001:db8::/64"},
+		// untrusted peers can't forge their address
+		{"203.0.113.7:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7", "203.0.113.0/24"},
+		// trusted proxies are skipped
+		{"10.1.1.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.2.2.2"}}, "198.51.100.1", "198.51.100.0/24"},
+		{"192.0.2.1:1234", http.Header{"X-Forwarded-For": {"6.6.6.6, 198.51.100.1", "10.2.2.2"}}, "198.51.100.1", "198.51.100.0/24"},
+		// garbage stops the walk
+		{"10.1.1.1:1234", http.Header{"X-Forwarded-For": {"6.6.6.6, nonsense, 10.2.2.2"}}, "10.2.2.2", "10.2.2.0/24"},
+		{"10.1.1.1:1234", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1", "198.51.100.0/24"},
+		{"10.1.1.1:1234", nil, "10.1.1.1", "10.1.1.0/24"},
+	}
+	key := IPKey(trusted, 24, 64)
+	for i, e := range tests {
+		req := &http.Request{RemoteAddr: e.Remote, Header: e.Header}
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		addr, ok := ClientIP(req, trusted)
+		if assert.True(t, ok, "#%d", i) {
+			assert.Equal(t, e.Expect, addr.String(), "#%d", i)
+		}
+		assert.Equal(t, e.Key, key(req), "#%d", i)
+	}
+
+	_, ok := ClientIP(&http.Request{RemoteAddr: "@"}, trusted)
+	assert.False(t, ok)
+}
@@ -0,0 +1,546 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A KeyFunc derives the key an operation is limited under from its attributes
+type KeyFunc func(Attrs) string
+
+// HeaderKey produces a KeyFunc which keys operations by the value of a header
+func HeaderKey(name string) KeyFunc {
+	return func(attrs Attrs) string {
+		return http.Header(attrs).Get(name)
+	}
+}
+
+// HashedHeaderKey produces a KeyFunc which keys operations by a hash of the
+// value of a header. This is useful for sensitive values, like API tokens,
+// which should not be retained as keys.
+func HashedHeaderKey(name string) KeyFunc {
+	return func(attrs Attrs) string {
+		v := http.Header(attrs).Get(name)
+		if v == "" {
+			return ""
+		}
+		h := sha256.Sum256([]byte(v))
+		return hex.EncodeToString(h[:])
+	}
+}
+
+// A Decision determines whether an operation is limited at all
+type Decision int
+
+const (
+	Enforce Decision = iota // the operation is limited as usual
+	Allow                   // the operation bypasses limiting entirely
+	Reject                  // the operation is refused regardless of quota
+)
+
+// A BypassFunc decides, from its attributes, whether an operation is limited,
+// exempt from limiting, as for internal callers or administrators, or refused
+// outright, as for abusive callers.
+type BypassFunc func(Attrs) Decision
+
+// CompositeKey produces a key from several parts, such as a user and the
+// resource they are accessing, which is distinct for every distinct sequence
+// of parts.
+func CompositeKey(parts ...string) string {
+	esc := make([]string, len(parts))
+	for i, e := range parts {
+		esc[i] = url.QueryEscape(e)
+	}
+	return strings.Join(esc, ":")
+}
+
+// CompositeKeyFunc produces a KeyFunc which keys operations by the composite
+// of the keys derived by each of the provided functions, e.g., to limit each
+// user's access to each endpoint independently. If every part is empty, the
+// key is empty.
+func CompositeKeyFunc(fns ...KeyFunc) KeyFunc {
+	return func(attrs Attrs) string {
+		parts := make([]string, len(fns))
+		var ok bool
+		for i, fn := range fns {
+			parts[i] = fn(attrs)
+			ok = ok || parts[i] != ""
+		}
+		if !ok {
+			return ""
+		}
+		return CompositeKey(parts...)
+	}
+}
+
+// The longest a key is banned by default
+const defaultMaxBan = time.Hour * 24
+
+// Keyed limiter configuration
+type KeyedConfig struct {
+	// The maximum number of keys tracked; when exceeded, the least recently used is evicted. If <= 0, keys are not limited
+	MaxKeys int
+	// The period after which a key that has not been used is evicted; if <= 0, keys do not expire
+	TTL time.Duration
+	// The number of times a key may exceed its limit within the OffensePeriod before it is banned; if <= 0, keys are never banned
+	Offenses int
+	// The period within which a key's offenses are counted; if <= 0, offenses are counted indefinitely
+	OffensePeriod time.Duration
+	// The duration of a key's first ban, which doubles with each subsequent ban that follows within the OffensePeriod of the previous one ending
+	Ban time.Duration
+	// The longest a key may be banned; defaults to 24 hours
+	MaxBan time.Duration
+	// Invoked when a key is banned, e.g., to log it
+	OnBan func(Ban)
+}
+
+// With applies additional options to the receiver
+func (c KeyedConfig) With(opts []KeyedOption) KeyedConfig {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+// A functional keyed limiter option
+type KeyedOption func(KeyedConfig) KeyedConfig
+
+// WithMaxKeys bounds the number of keys a keyed limiter tracks
+func WithMaxKeys(n int) KeyedOption {
+	return func(c KeyedConfig) KeyedConfig {
+		c.MaxKeys = n
+		return c
+	}
+}
+
+// WithKeyTTL sets the period after which an unused key is evicted
+func WithKeyTTL(d time.Duration) KeyedOption {
+	return func(c KeyedConfig) KeyedConfig {
+		c.TTL = d
+		return c
+	}
+}
+
+// WithPenalty bans keys which exceed their limit the provided number of times
+// within a period, for the provided duration, which doubles each time a key
+// is banned again soon after its previous ban ends.
+func WithPenalty(offenses int, period, ban time.Duration) KeyedOption {
+	return func(c KeyedConfig) KeyedConfig {
+		c.Offenses, c.OffensePeriod, c.Ban = offenses, period, ban
+		return c
+	}
+}
+
+// WithMaxBan sets the longest a key may be banned
+func WithMaxBan(d time.Duration) KeyedOption {
+	return func(c KeyedConfig) KeyedConfig {
+		c.MaxBan = d
+		return c
+	}
+}
+
+// WithBanObserver sets a function which is invoked when a key is banned
+func WithBanObserver(fn func(Ban)) KeyedOption {
+	return func(c KeyedConfig) KeyedConfig {
+		c.OnBan = fn
+		return c
+	}
+}
+
+// A Ban describes a key which has been banned for repeatedly exceeding its
+// limit
+type Ban struct {
+	// The key which was banned
+	Key string
+	// The number of consecutive times the key has been banned, including this one
+	Count int
+	// When the key was banned
+	Time time.Time
+	// When the ban ends
+	Until time.Time
+}
+
+// Compute the duration of the nth consecutive ban
+func banDuration(d, limit time.Duration, n int) time.Duration {
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// KeyedStats describes the keys tracked by a keyed limiter
+type KeyedStats struct {
+	// The number of keys currently tracked
+	Keys int
+	// The number of keys evicted because too many were tracked
+	Evicted uint64
+	// The number of keys evicted because they were not used within the TTL
+	Expired uint64
+	// The number of times keys have been banned
+	Banned uint64
+}
+
+// A limiter tracked for a key
+type keyedEntry struct {
+	key      string
+	lim      Limiter
+	used     time.Time
+	offenses []time.Time // when the key exceeded its limit, within the offense period
+	bans     int         // the number of consecutive times the key has been banned
+	until    time.Time   // when the key's most recent ban ends
+}
+
+// keyed implements a rate limiter which maintains an independent limiter for
+// each key, creating them on demand. The key an operation is limited under is
+// provided via WithKey or, if none is provided and a KeyFunc is set, derived
+// from the operation's attributes. Operations without a key share the empty
+// key.
+//
+// To bound the memory used when keys are numerous, as when limiting each user
+// of each endpoint, keys may be evicted when they have not been used for a
+// period or when too many are tracked. An evicted key is recreated when it is
+// next used, so the limiters should be ones whose state survives this, like
+// those created by NewShared, or ones for which forgetting is acceptable.
+//
+// Keys which repeatedly exceed their limit may be banned via WithPenalty, so
+// that abusive callers are refused outright, for escalating periods, rather
+// than permitted the quota of each window. While a key is banned, Next
+// produces the time its ban ends, which a Gate reports in its Retry-After
+// header. A key's bans are forgotten if it is evicted.
+type keyed struct {
+	sync.Mutex
+	conf     KeyedConfig
+	create   func(string) Limiter
+	keyFunc  KeyFunc
+	bypass   BypassFunc
+	limiters map[string]*list.Element
+	lru      *list.List // entries from most to least recently used
+	evicted  uint64
+	expired  uint64
+	banned   uint64
+	waiters
+}
+
+// NewKeyed creates a keyed limiter which uses the provided function to create
+// a limiter the first time a key is encountered.
+func NewKeyed(create func(key string) Limiter, opts ...KeyedOption) *keyed {
+	return &keyed{
+		conf:     KeyedConfig{MaxBan: defaultMaxBan}.With(opts),
+		create:   create,
+		limiters: make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// SetKeyFunc sets the function used to derive keys from the attributes of
+// operations which do not provide a key explicitly.
+func (l *keyed) SetKeyFunc(fn KeyFunc) {
+	l.Lock()
+	defer l.Unlock()
+	l.keyFunc = fn
+}
+
+// SetBypass sets the function which decides whether operations are limited,
+// exempt, or refused, from their attributes. Operations which are exempt are
+// permitted immediately without consuming quota; those which are refused fail
+// with ErrRejected.
+func (l *keyed) SetBypass(fn BypassFunc) {
+	l.Lock()
+	defer l.Unlock()
+	l.bypass = fn
+}
+
+// Decide whether an operation is limited
+func (l *keyed) decide(opts []Option) Decision {
+	l.Lock()
+	fn := l.bypass
+	l.Unlock()
+	if fn == nil {
+		return Enforce
+	}
+	conf := Options{}.With(opts)
+	if conf.Attrs == nil {
+		return Enforce
+	}
+	return fn(conf.Attrs)
+}
+
+// Determine the key for an operation
+func (l *keyed) key(opts []Option) string {
+	conf := Options{}.With(opts)
+	if conf.Key != "" || conf.Attrs == nil {
+		return conf.Key
+	}
+	l.Lock()
+	fn := l.keyFunc
+	l.Unlock()
+	if fn != nil {
+		return fn(conf.Attrs)
+	}
+	return ""
+}
+
+// Limiter returns the limiter for a key, creating it if necessary
+func (l *keyed) Limiter(key string) Limiter {
+	return l.entry(key).lim
+}
+
+// Obtain the entry for a key, creating it if necessary
+func (l *keyed) entry(key string) *keyedEntry {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	l.expire(now)
+	if v, ok := l.limiters[key]; ok {
+		e := v.Value.(*keyedEntry)
+		e.used = now
+		l.lru.MoveToFront(v)
+		return e
+	}
+	e := &keyedEntry{key: key, lim: l.create(key), used: now}
+	l.limiters[key] = l.lru.PushFront(e)
+	for l.conf.MaxKeys > 0 && l.lru.Len() > l.conf.MaxKeys {
+		l.remove(l.lru.Back())
+		l.evicted++
+	}
+	return e
+}
+
+// Determine when the ban on an entry ends, if it is banned at the provided
+// time
+func (l *keyed) bannedUntil(e *keyedEntry, rel time.Time) (time.Time, bool) {
+	l.Lock()
+	defer l.Unlock()
+	return e.until, rel.Before(e.until)
+}
+
+// Record that an entry exceeded its limit, banning it if it has done so too
+// many times, in which case the ban is returned
+func (l *keyed) offend(e *keyedEntry, rel time.Time) *Ban {
+	if l.conf.Offenses <= 0 {
+		return nil
+	}
+	var ban *Ban
+	l.Lock()
+	if p := l.conf.OffensePeriod; p > 0 {
+		e.offenses = slices.DeleteFunc(e.offenses, func(t time.Time) bool { return rel.Sub(t) >= p })
+	}
+	e.offenses = append(e.offenses, rel)
+	if len(e.offenses) >= l.conf.Offenses {
+		if p := l.conf.OffensePeriod; p > 0 && rel.Sub(e.until) >= p {
+			e.bans = 0 // the key behaved after its last ban; start over
+		}
+		e.bans++
+		e.until = rel.Add(banDuration(l.conf.Ban, l.conf.MaxBan, e.bans))
+		e.offenses = e.offenses[:0]
+		l.banned++
+		ban = &Ban{Key: e.key, Count: e.bans, Time: rel, Until: e.until}
+	}
+	fn := l.conf.OnBan
+	l.Unlock()
+	if ban != nil && fn != nil {
+		fn(*ban)
+	}
+	return ban
+}
+
+// Banned reports whether a key is banned at the provided time and, if so,
+// when its ban ends
+func (l *keyed) Banned(key string, rel time.Time) (time.Time, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if v, ok := l.limiters[key]; ok {
+		if e := v.Value.(*keyedEntry); rel.Before(e.until) {
+			return e.until, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Unban lifts the ban on a key, if there is one, and forgets its offenses
+func (l *keyed) Unban(key string) {
+	l.Lock()
+	defer l.Unlock()
+	if v, ok := l.limiters[key]; ok {
+		e := v.Value.(*keyedEntry)
+		e.offenses, e.bans, e.until = nil, 0, time.Time{}
+	}
+}
+
+// Evict the keys which have not been used within the TTL; the caller must
+// hold the lock
+func (l *keyed) expire(rel time.Time) {
+	if l.conf.TTL <= 0 {
+		return
+	}
+	for v := l.lru.Back(); v != nil && rel.Sub(v.Value.(*keyedEntry).used) > l.conf.TTL; v = l.lru.Back() {
+		l.remove(v)
+		l.expired++
+	}
+}
+
+// Stop tracking an entry; the caller must hold the lock
+func (l *keyed) remove(v *list.Element) {
+	l.lru.Remove(v)
+	delete(l.limiters, v.Value.(*keyedEntry).key)
+}
+
+// Keys returns the keys which currently have a limiter
+func (l *keyed) Keys() []string {
+	l.Lock()
+	defer l.Unlock()
+	l.expire(time.Now())
+	keys := make([]string, 0, len(l.limiters))
+	for k := range l.limiters {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Evict stops tracking a key; it is recreated when it is next used
+func (l *keyed) Evict(key string) {
+	l.Lock()
+	defer l.Unlock()
+	if v, ok := l.limiters[key]; ok {
+		l.remove(v)
+	}
+}
+
+// KeyedStats describes the keys tracked and how many have been evicted
+func (l *keyed) KeyedStats() KeyedStats {
+	l.Lock()
+	defer l.Unlock()
+	l.expire(time.Now())
+	return KeyedStats{
+		Keys:    l.lru.Len(),
+		Evicted: l.evicted,
+		Expired: l.expired,
+		Banned:  l.banned,
+	}
+}
+
+func (l *keyed) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	switch l.decide(opts) {
+	case Allow:
+		return rel, nil
+	case Reject:
+		return time.Time{}, ErrRejected
+	}
+	e := l.entry(l.key(opts))
+	if until, ok := l.bannedUntil(e, rel); ok {
+		return until, nil
+	}
+	next, err := e.lim.Next(rel, opts...)
+	return l.charge(e, rel, next, err)
+}
+
+// Peek returns the time at which the next operation under a key could proceed
+// relative to the provided time, as Next does, without consuming any quota or
+// counting an offense against the key
+func (l *keyed) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	switch l.decide(opts) {
+	case Allow:
+		return rel, nil
+	case Reject:
+		return time.Time{}, ErrRejected
+	}
+	e := l.entry(l.key(opts))
+	if until, ok := l.bannedUntil(e, rel); ok {
+		return until, nil
+	}
+	return Peek(e.lim, rel, opts...)
+}
+
+// Determine when the next operation under a key could proceed, as Peek does,
+// for an operation which is refused if it cannot proceed immediately. Such a
+// refusal counts as an offense against the key if its quota is exhausted.
+func (l *keyed) refuse(rel time.Time, opts ...Option) (time.Time, error) {
+	if l.decide(opts) != Enforce {
+		return l.Peek(rel, opts...)
+	}
+	e := l.entry(l.key(opts))
+	if until, ok := l.bannedUntil(e, rel); ok {
+		return until, nil
+	}
+	next, err := Peek(e.lim, rel, opts...)
+	return l.charge(e, rel, next, err)
+}
+
+// Count an offense against an entry if an operation was delayed or refused
+// because its quota is exhausted, producing when the operation may proceed,
+// which is the end of the ban if the offense got the entry banned. Merely
+// pacing operations is not an offense.
+func (l *keyed) charge(e *keyedEntry, rel, next time.Time, err error) (time.Time, error) {
+	if errors.Is(err, ErrExhausted) || (err == nil && next.After(rel) && exhausted(e.lim, rel)) {
+		if ban := l.offend(e, rel); ban != nil {
+			return ban.Until, nil
+		}
+	}
+	return next, err
+}
+
+// Determine whether a limiter has no quota remaining at the provided time. A
+// linear limiter only paces operations and its state merely estimates the
+// quota from the time elapsed, so it is never considered exhausted.
+func exhausted(lim Limiter, rel time.Time) bool {
+	if _, ok := lim.(*linear); ok {
+		return false
+	}
+	return lim.State(rel).Remaining <= 0
+}
+
+func (l *keyed) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	switch l.decide(opts) {
+	case Allow:
+		return rel, nil
+	case Reject:
+		return time.Time{}, ErrRejected
+	}
+	e := l.entry(l.key(opts))
+	if until, ok := l.bannedUntil(e, rel); ok {
+		if rel, err = l.sleep(cxt, rel, until); err != nil {
+			return time.Time{}, err
+		}
+	}
+	cxt, cancel := l.derive(cxt)
+	defer cancel()
+	return e.lim.Wait(cxt, rel, opts...)
+}
+
+func (l *keyed) Update(rel time.Time, opts ...Option) error {
+	return l.Limiter(l.key(opts)).Update(rel, opts...)
+}
+
+// State describes the limiter for the empty key. Use KeyState to obtain the
+// state of a specific key.
+func (l *keyed) State(rel time.Time) State {
+	return l.KeyState("", rel)
+}
+
+// KeyState describes the limiter for the provided key. While the key is
+// banned, no quota remains and it resets when the ban ends.
+func (l *keyed) KeyState(key string, rel time.Time) State {
+	e := l.entry(key)
+	st := e.lim.State(rel)
+	if until, ok := l.bannedUntil(e, rel); ok {
+		st.Remaining = 0
+		if until.After(st.Reset) {
+			st.Reset = until
+		}
+	}
+	return st
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFunc(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewKeyed(func(string) Limiter {
+		return NewHeaders(Config{Start: now, Window: time.Minute, Events: 1, Mode: Burst})
+	})
+	lim.SetKeyFunc(HeaderKey("X-Tenant"))
+
+	req := func(tenant string) Option {
+		r, _ := http.NewRequest("GET", "https://example.com/", nil)
+		r.Header.Set("X-Tenant", tenant)
+		return WithRequest(r)
+	}
+	tests := []struct {
+		Opts []Option
+		Next time.Time
+	}{
+		{[]Option{req("a")}, now},
+		{[]Option{req("b")}, now},
+		{[]Option{req("a")}, now.Add(time.Minute)},
+		{[]Option{req("a"), WithKey("c")}, now}, // explicit keys take precedence
+	}
+	for i, e := range tests {
+		next, err := lim.Next(now, e.Opts...)
+		if assert.NoError(t, err) {
+			assert.Equal(t, e.Next, next, "#%d", i)
+		}
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, lim.Keys())
+
+	key := HashedHeaderKey("Authorization")(Attrs{"Authorization": []string{"Bearer secret"}})
+	assert.Len(t, key, 64)
+	assert.NotContains(t, key, "secret")
+}
+
+func TestCompositeKey(t *testing.T) {
+	assert.Equal(t, "alice:%2Fv1%2Fusers", CompositeKey("alice", "/v1/users"))
+	assert.NotEqual(t, CompositeKey("a:b", "c"), CompositeKey("a", "b:c"))
+
+	fn := CompositeKeyFunc(HeaderKey("X-User"), HeaderKey("X-Resource"))
+	assert.Equal(t, "alice:users", fn(Attrs{"X-User": []string{"alice"}, "X-Resource": []string{"users"}}))
+	assert.Equal(t, ":users", fn(Attrs{"X-Resource": []string{"users"}}))
+	assert.Equal(t, "", fn(Attrs{}))
+}
+
+func TestKeyedEviction(t *testing.T) {
+	var created []string
+	lim := NewKeyed(func(key string) Limiter {
+		created = append(created, key)
+		return NewLinear(Config{Window: time.Second, Events: 1})
+	}, WithMaxKeys(2), WithKeyTTL(time.Millisecond*50))
+
+	lim.Limiter("a")
+	lim.Limiter("b")
+	lim.Limiter("a") // a is now the most recently used
+	lim.Limiter("c") // evicts b
+	assert.ElementsMatch(t, []string{"a", "c"}, lim.Keys())
+	assert.Equal(t, KeyedStats{Keys: 2, Evicted: 1}, lim.KeyedStats())
+
+	lim.Limiter("b") // recreated, evicting a
+	assert.Equal(t, []string{"a", "b", "c", "b"}, created)
+	assert.ElementsMatch(t, []string{"b", "c"}, lim.Keys())
+
+	lim.Evict("c")
+	assert.Equal(t, []string{"b"}, lim.Keys())
+
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, KeyedStats{Keys: 0, Evicted: 2, Expired: 1}, lim.KeyedStats())
+}
+
+func TestKeyedPenalty(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	var bans []Ban
+	store := NewMemoryStore()
+	lim := NewKeyed(func(key string) Limiter {
+		return NewShared(Config{Start: now, Window: time.Minute, Events: 1}, store, key)
+	}, WithPenalty(2, time.Hour, time.Minute*10), WithMaxBan(time.Minute*30), WithBanObserver(func(b Ban) {
+		bans = append(bans, b)
+	}))
+
+	tests := []struct {
+		Rel  time.Time
+		Next time.Time
+	}{
+		{now, now},
+		{now, now.Add(time.Minute)}, // first offense
+		{now.Add(time.Second), now.Add(time.Second + time.Minute*10)},     // second offense: banned
+		{now.Add(time.Minute * 5), now.Add(time.Second + time.Minute*10)}, // still banned
+	}
+	for i, e := range tests {
+		next, err := lim.Next(e.Rel, WithKey("a"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, e.Next, next, "#%d", i)
+		}
+	}
+	next, err := lim.Next(now, WithKey("b")) // other keys are unaffected
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, next)
+	}
+
+	until, ok := lim.Banned("a", now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Second+time.Minute*10), until)
+	st := lim.KeyState("a", now.Add(time.Minute))
+	assert.Equal(t, 0, st.Remaining)
+	assert.Equal(t, until, st.Reset)
+	if assert.Len(t, bans, 1) {
+		assert.Equal(t, Ban{Key: "a", Count: 1, Time: now.Add(time.Second), Until: until}, bans[0])
+	}
+
+	// re-offending soon after the ban ends doubles it, up to the maximum
+	rel := until
+	for i := 0; i < 3; i++ { // the first is permitted by the new window
+		lim.Next(rel, WithKey("a"))
+	}
+	if assert.Len(t, bans, 2) {
+		assert.Equal(t, 2, bans[1].Count)
+		assert.Equal(t, rel.Add(time.Minute*20), bans[1].Until)
+	}
+	rel = bans[1].Until
+	for i := 0; i < 3; i++ { // the first is permitted by the new window
+		lim.Next(rel, WithKey("a"))
+	}
+	if assert.Len(t, bans, 3) {
+		assert.Equal(t, 3, bans[2].Count)
+		assert.Equal(t, rel.Add(time.Minute*30), bans[2].Until)
+	}
+	assert.Equal(t, uint64(3), lim.KeyedStats().Banned)
+
+	lim.Unban("a")
+	_, ok = lim.Banned("a", rel)
+	assert.False(t, ok)
+}
+
+func TestKeyedPenaltyPacing(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewKeyed(func(key string) Limiter {
+		return NewLinear(Config{Start: now, Window: time.Minute, Events: 60})
+	}, WithPenalty(3, time.Hour, time.Minute*10))
+
+	// a caller well within the rate is paced, but never banned
+	for i := 0; i < 60; i++ {
+		rel := now.Add(time.Second*10*time.Duration(i) + time.Millisecond*500)
+		next, err := lim.Next(rel, WithKey("a"))
+		if assert.NoError(t, err, "#%d", i) {
+			assert.True(t, next.Sub(rel) <= time.Second, "#%d", i)
+		}
+	}
+	_, ok := lim.Banned("a", now.Add(time.Minute*10))
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), lim.KeyedStats().Banned)
+}
@@ -2,7 +2,11 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -11,6 +15,10 @@ type State struct {
 	Limit     int
 	Remaining int
 	Reset     time.Time
+	// Remaining quota is below the low watermark and we are conserving it
+	Low bool
+	// Remaining quota is below the critical watermark and we are waiting for a reset
+	Critical bool
 }
 
 // Attributes which may be factored into rate limiting implementations
@@ -29,6 +37,27 @@ func AttrsFromResponse(rsp *http.Response) Attrs {
 // Options provides addional contextual details to a rate limiter
 type Options struct {
 	Attrs Attrs
+	Key   string
+	// When the information provided to Update was observed, e.g., when a response was received
+	ObservedAt time.Time
+	// The status of the response provided to Update, if known
+	Status int
+	// The mode in which this operation consumes quota, overriding the limiter's, if set
+	Mode *Mode
+	// The proportion of the rate or quota this operation targets, overriding the limiter's, if > 0
+	Target float64
+	// Receives the function which completes this operation, if the limiter tracks operations in flight
+	Completion *func()
+	// How long the operation whose response is provided to Update took, if known
+	Latency time.Duration
+	// The error which prevented the operation whose response is provided to Update from completing, if any
+	Err error
+	// The outcome of the operation whose response is provided to Update, if it has already been classified
+	Verdict *Verdict
+	// The projected cost of this operation in points, for limiters whose quotas are denominated in points, if > 0
+	Cost float64
+	// The body of the response provided to Update, or its beginning, if it is needed
+	Body []byte
 }
 
 // With applies additional options to the receiver
@@ -42,6 +71,62 @@ func (c Options) With(opts []Option) Options {
 // A functional option
 type Option func(Options) Options
 
+// Option produces an option which applies the receiver's non-zero fields
+func (c Options) Option() Option {
+	return func(o Options) Options {
+		if c.Attrs != nil {
+			o.Attrs = c.Attrs
+		}
+		if c.Key != "" {
+			o.Key = c.Key
+		}
+		if !c.ObservedAt.IsZero() {
+			o.ObservedAt = c.ObservedAt
+		}
+		if c.Status != 0 {
+			o.Status = c.Status
+		}
+		if c.Mode != nil {
+			o.Mode = c.Mode
+		}
+		if c.Target > 0 {
+			o.Target = c.Target
+		}
+		if c.Completion != nil {
+			o.Completion = c.Completion
+		}
+		if c.Latency > 0 {
+			o.Latency = c.Latency
+		}
+		if c.Err != nil {
+			o.Err = c.Err
+		}
+		if c.Verdict != nil {
+			o.Verdict = c.Verdict
+		}
+		if c.Cost > 0 {
+			o.Cost = c.Cost
+		}
+		if c.Body != nil {
+			o.Body = c.Body
+		}
+		return o
+	}
+}
+
+// Prepare evaluates options once, in advance, and produces an equivalent set
+// of options which may be reused for any number of calls. Constructing
+// options and the variadic slice that carries them allocates on every call;
+// a prepared set does not:
+//
+//	opts := Prepare(WithKey("tenant"))
+//	for ... {
+//		next, err := lim.Next(time.Now(), opts...)
+//	}
+func Prepare(opts ...Option) []Option {
+	return []Option{Options{}.With(opts).Option()}
+}
+
 // WithRequest is a convenience function which derives attributes from the
 // provided request and then applies them to the options. It is the equivalent
 // of:
@@ -51,13 +136,27 @@ func WithRequest(v *http.Request) Option {
 	return WithAttrs(AttrsFromRequest(v))
 }
 
-// WithResponse is a convenience function which derives attributes from the
-// provided response and then applies them to the options. It is the equivalent
-// of:
+// WithResponse is a convenience function which derives attributes and the
+// status from the provided response and then applies them to the options. It
+// is the equivalent of:
 //
-//	WithAttrs(AttrsFromResponse(req))
+//	WithAttrs(AttrsFromResponse(rsp)), WithStatus(rsp.StatusCode)
 func WithResponse(v *http.Response) Option {
-	return WithAttrs(AttrsFromResponse(v))
+	return func(c Options) Options {
+		c.Attrs = AttrsFromResponse(v)
+		c.Status = v.StatusCode
+		return c
+	}
+}
+
+// WithStatus sets the status of the response provided to Update. Most
+// limiters rely on headers alone, but some infer limits from responses which
+// are throttled, i.e., 429 Too Many Requests.
+func WithStatus(v int) Option {
+	return func(c Options) Options {
+		c.Status = v
+		return c
+	}
 }
 
 // WithAttrs adds attributes to a set of options
@@ -68,9 +167,125 @@ func WithAttrs(v Attrs) Option {
 	}
 }
 
+// WithKey identifies the key an operation is limited under. This is used by
+// keyed limiters to select the underlying limiter for an operation.
+func WithKey(v string) Option {
+	return func(c Options) Options {
+		c.Key = v
+		return c
+	}
+}
+
+// WithObservedAt sets the time at which the information provided to Update
+// was observed, so that information older than what the limiter already has,
+// as from responses which arrive out of order, can be disregarded.
+func WithObservedAt(v time.Time) Option {
+	return func(c Options) Options {
+		c.ObservedAt = v
+		return c
+	}
+}
+
+// WithMode consumes quota for a single operation in the provided mode, rather
+// than the limiter's, so that, e.g., urgent operations may burst while others
+// on the same limiter are metered. Not all implementations use this value.
+func WithMode(v Mode) Option {
+	return func(c Options) Options {
+		c.Mode = &v
+		return c
+	}
+}
+
+// WithTarget paces a single operation at the provided proportion of the rate
+// or quota, rather than the limiter's target. Not all implementations use
+// this value.
+func WithTarget(v float64) Option {
+	return func(c Options) Options {
+		c.Target = v
+		return c
+	}
+}
+
+// WithLatency describes how long the operation whose response is provided to
+// Update took, from when the request was sent until the response was
+// received. Limiters which tune their target from feedback, per
+// Config.TargetLatency, slow down when responses are slow.
+func WithLatency(v time.Duration) Option {
+	return func(c Options) Options {
+		c.Latency = v
+		return c
+	}
+}
+
+// WithError provides the error which prevented an operation from completing
+// to Update, so that it may be classified; see Classifier
+func WithError(v error) Option {
+	return func(c Options) Options {
+		c.Err = v
+		return c
+	}
+}
+
+// WithVerdict provides the outcome of an operation to Update when it has
+// already been classified, e.g., by a transport which examined the body of its
+// response, so that the limiter does not classify it again
+func WithVerdict(v Verdict) Option {
+	return func(c Options) Options {
+		c.Verdict = &v
+		return c
+	}
+}
+
+// WithCost provides the projected cost of an operation in points, such as
+// the requested cost of a GraphQL query, to limiters whose quotas are
+// denominated in points. Other limiters disregard it.
+func WithCost(v float64) Option {
+	return func(c Options) Options {
+		c.Cost = v
+		return c
+	}
+}
+
+// WithResponseBody provides the body of a response, or its beginning, to
+// Update, for limiters which learn their state from it rather than from
+// headers, such as GraphQL cost limiters. Other limiters disregard it.
+func WithResponseBody(v []byte) Option {
+	return func(c Options) Options {
+		c.Body = v
+		return c
+	}
+}
+
+// WithCompletion tracks an operation as in flight until the function stored
+// through the provided pointer is invoked, which the caller must do once the
+// operation completes, rather than until its response is provided to Update:
+//
+//	var done func()
+//	_, err := lim.Wait(cxt, time.Now(), WithCompletion(&done))
+//	...
+//	rsp, err := client.Do(req)
+//	done()
+//
+// The function is a no-op if the limiter does not track operations in flight,
+// and it may be invoked more than once.
+func WithCompletion(v *func()) Option {
+	*v = func() {}
+	return func(c Options) Options {
+		c.Completion = v
+		return c
+	}
+}
+
+// Determine the overrides of the limiter's pacing for an operation
+func (c Options) pacing() pacing {
+	return pacing{mode: c.Mode, target: c.Target}
+}
+
 // A general purpose rate limiter
 type Limiter interface {
-	// Next returns the time at which the next request can be executed relative to the provided time.
+	// Next returns the time at which the next request can be executed relative to the provided time,
+	// consuming quota for it: every call is assumed to be followed by a request. To forecast when a
+	// request could be executed without consuming quota, use Peek.
 	Next(time.Time, ...Option) (time.Time, error)
 	// Wait blocks until the next request can be executed.
 	Wait(context.Context, time.Time, ...Option) (time.Time, error)
@@ -80,24 +295,147 @@ type Limiter interface {
 	State(time.Time) State
 }
 
+// A Peeker is a limiter which can forecast when an operation could proceed
+// without consuming quota for it
+type Peeker interface {
+	// Peek returns the time at which the next request could be executed relative to the provided time.
+	Peek(time.Time, ...Option) (time.Time, error)
+}
+
+// Peek forecasts the time at which the next operation could proceed relative
+// to the provided time without consuming quota, e.g., to display upcoming
+// availability. If the limiter does not implement Peeker, its estimated wait
+// is used, if it provides one; otherwise, no forecast can be made and the
+// error wraps errors.ErrUnsupported.
+func Peek(lim Limiter, rel time.Time, opts ...Option) (time.Time, error) {
+	switch v := lim.(type) {
+	case Peeker:
+		return v.Peek(rel, opts...)
+	case interface{ EstimatedWait(time.Time) time.Duration }:
+		return rel.Add(v.EstimatedWait(rel)), nil
+	default:
+		return time.Time{}, fmt.Errorf("Could not peek at %T: %w", lim, errors.ErrUnsupported)
+	}
+}
+
+// Ensure our implementations conform to the Peeker interface
+var (
+	_ Peeker = (*headers)(nil)
+	_ Peeker = (*linear)(nil)
+	_ Peeker = (*keyed)(nil)
+	_ Peeker = (*shared)(nil)
+	_ Peeker = (*coordinated)(nil)
+	_ Peeker = (*estimator)(nil)
+	_ Peeker = (*none)(nil)
+	_ Peeker = (*tee)(nil)
+	_ Peeker = (*budget)(nil)
+	_ Peeker = (*graphqlCost)(nil)
+)
+
+// Ensure our implementations conform to the Limiter interface
+var (
+	_ Limiter = (*headers)(nil)
+	_ Limiter = (*linear)(nil)
+	_ Limiter = (*keyed)(nil)
+	_ Limiter = (*buckets)(nil)
+	_ Limiter = (*shared)(nil)
+	_ Limiter = (*coordinated)(nil)
+	_ Limiter = (*capped)(nil)
+	_ Limiter = (*splitChild)(nil)
+	_ Limiter = (*traced)(nil)
+	_ Limiter = (*estimator)(nil)
+	_ Limiter = (*sketch)(nil)
+	_ Limiter = (*reloadable)(nil)
+	_ Limiter = (*none)(nil)
+	_ Limiter = (*tee)(nil)
+	_ Limiter = (*budget)(nil)
+	_ Limiter = (*graphqlCost)(nil)
+)
+
 // A Durationer converts a value to a duration
 type Durationer interface {
 	Duration(int) time.Duration
 	Time(int) time.Time
 }
 
+// DurationFunc converts a value to a duration
+type DurationFunc func(int) time.Duration
+
+// TimeFunc converts a value to a time
+type TimeFunc func(int) time.Time
+
+// DurationerFunc adapts functions to the Durationer interface. If the time
+// function is nil, values are converted to times as a duration after the Unix
+// epoch.
+func DurationerFunc(d DurationFunc, t TimeFunc) Durationer {
+	if t == nil {
+		t = func(v int) time.Time { return time.Unix(0, 0).Add(d(v)) }
+	}
+	return funcDurationer{d, t}
+}
+
+type funcDurationer struct {
+	d DurationFunc
+	t TimeFunc
+}
+
+func (f funcDurationer) Duration(v int) time.Duration {
+	return f.d(v)
+}
+func (f funcDurationer) Time(v int) time.Time {
+	return f.t(v)
+}
+
+// A TimeParser is a Durationer which can interpret time values that are not
+// integers, such as timestamps or fractional deltas. When a Durationer also
+// implements this interface, it is used to parse reset values.
+type TimeParser interface {
+	ParseTime(rel time.Time, v string) (time.Time, error)
+}
+
 // Rate limiting modes
 type Mode int
 
 const (
-	Meter Mode = iota
-	Burst
+	Meter  Mode = iota // spread operations over the window
+	Burst              // consume the quota until it is exhausted, then wait for the window to reset
+	Smooth             // burst through a portion of the quota, then meter the remainder
+	Sliced             // divide the window into slices, each permitting its share of the quota plus what earlier slices left unused
+)
+
+// How the remaining quota reported by a service is reconciled with the
+// operations consumed locally since the request it responds to was made
+type Reconciliation int
+
+const (
+	TrustServer   Reconciliation = iota // the remaining quota reported replaces ours
+	TrustLocalMin                       // within a window, the lesser of the remaining quota reported and ours is kept
+	Decay                               // the operations recently consumed locally, which are presumed in flight, are deducted from the remaining quota reported
+)
+
+// How reset values are interpreted
+type ResetSemantics int
+
+const (
+	Epoch      ResetSemantics = iota // the reset value is an absolute time since the Unix epoch
+	Delta                            // the reset value is a duration after the reference time
+	AutoDetect                       // the reset value is an epoch time if it is plausible as one, otherwise a delta
 )
 
+// When auto-detecting reset semantics, an epoch time further in the past than
+// this is assumed to actually be a delta
+const autoDetectHorizon = time.Hour * 24 * 365
+
 // Common durationers
 var (
 	Seconds      = seconds{}
 	Milliseconds = milliseconds{}
+	Minutes      = DurationerFunc(func(v int) time.Duration { return time.Duration(v) * time.Minute }, nil)
+	Hours        = DurationerFunc(func(v int) time.Duration { return time.Duration(v) * time.Hour }, nil)
+	UnixMillis   = DurationerFunc(Seconds.Duration, Milliseconds.Time) // durations in seconds, times in Unix milliseconds
+	Dates        = dates{}
+	DeltaSeconds = deltaSeconds{}
+	Auto         = auto{}
 )
 
 // Interprets the value in seconds
@@ -120,18 +458,158 @@ func (d milliseconds) Time(v int) time.Time {
 	return time.Unix(int64(v)/1000, int64(v)%1000*int64(time.Millisecond))
 }
 
+// Interprets integer values in seconds and parses times as RFC 3339 or HTTP
+// dates, e.g.: 2024-05-01T00:00:00Z
+type dates struct{ seconds }
+
+func (d dates) ParseTime(rel time.Time, v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: Not a date: %q", ErrInvalidHeaders, v)
+}
+
+// Interprets integer values in seconds and parses times as a number of
+// seconds, which may be fractional, after the reference time, e.g.: 12.5
+type deltaSeconds struct{ seconds }
+
+func (d deltaSeconds) ParseTime(rel time.Time, v string) (time.Time, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return rel.Add(time.Duration(f * float64(time.Second))), nil
+}
+
+// Interprets integer values in seconds and parses times automatically: a
+// number is a Unix timestamp if it is plausible as one and otherwise a delta
+// in seconds, as with AutoDetect semantics, and anything else is expected to
+// be a date.
+type auto struct{ seconds }
+
+func (d auto) ParseTime(rel time.Time, v string) (time.Time, error) {
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return parseNumericTime(d.seconds, AutoDetect, rel, v)
+	}
+	return Dates.ParseTime(rel, v)
+}
+
+// A Limit is a number of events permitted within a window
+type Limit struct {
+	Events int
+	Window time.Duration
+}
+
 // General rate limiting configuration
 type Config struct {
 	// The initial base window reference time
 	Start time.Time
 	// The duration of a window: this is the period over which we limit the number of requests
 	Window time.Duration
+	// When set, windows are aligned to wall-clock boundaries which are a multiple of the window, such as the top of the hour or midnight UTC, rather than beginning at Start
+	Align bool
+	// When set and the window is a whole number of days, windows follow the calendar in this location, so they begin at the same local time each day across daylight-saving transitions, and are aligned to local midnight
+	Location *time.Location
 	// The number of events permitted within a single window
 	Events int
+	// Additional limits which apply at the same time as Events per Window, such as 5 per second and 10,000 per day; not all implementations use this value
+	Limits []Limit
+	// Periods during which a different number of events or window applies; not all implementations use this value
+	Schedule Schedule
 	// The mode we are using to determine how we consume capacity
 	Mode Mode
+	// Receives debug logs describing updates, delays, and backoffs; if nil, nothing is logged; not all implementations use this value
+	Logger *slog.Logger
 	// How are we converting durations; this is mainly only useful for header-based limiters
 	Durationer Durationer
+	// How integer reset values are interpreted; this is ignored when the Durationer is a TimeParser
+	ResetSemantics ResetSemantics
+	// How the values of specific reset headers, by name, are interpreted, regardless of ResetSemantics and the Durationer; names which are not consulted by default are consulted first. X-RateLimit-Reset-After is a delta unless overridden here
+	ResetHeaders map[string]ResetSemantics
+	// When set, Next fails with an ExhaustedError rather than delaying the operation until the window resets when the quota is exhausted; not all implementations use this value
+	Strict bool
+	// When set, malformed or missing rate limit headers are logged and ignored, leaving the limiter state unchanged, rather than producing an error
+	Lenient bool
+	// The quota at the outset, as observed out-of-band, such as by a HEAD request, or persisted by a previous process; if nil, the full quota is assumed to remain until the first update. Only header-based limiters use this value
+	Initial *State
+	// How Update reconciles the remaining quota reported by the service with the operations consumed locally while many are in flight; only header-based limiters use this value
+	Reconcile Reconciliation
+	// When set, operations which have been granted but have not yet completed, because their responses have not been provided to Update or they were granted via WithCompletion and have not been completed, are deducted from the remaining quota reported by each update, so that concurrent callers do not over-subscribe a window; only header-based limiters use this value
+	InFlight bool
+	// In Decay reconciliation, the period over which an operation consumed locally is presumed to remain in flight, unreflected by the service, such as the typical latency of a request; defaults to 1 second
+	ReconcileDecay time.Duration
+	// When > 0 and no quota is known, because no initial state was provided, a single operation is permitted to probe the service and others are held until an update supplies the quota or this period passes, when another probe is permitted; only header-based limiters use this value
+	Probe time.Duration
 	// The maximum delay to wait between operations; not all implementations use this value
 	MaxDelay time.Duration
+	// When set, no delay imposed by the quota, as opposed to a backoff, exceeds the window; this guards against clock steps, such as from NTP or resuming a suspended VM, and implausible reset times
+	BoundDelay bool
+	// The longest Wait will block; if an operation would be delayed longer, Wait fails immediately with ErrOverloaded
+	MaxWait time.Duration
+	// The base period of incremental backoffs, which grow with the square of the number of consecutive backoffs; defaults to 3 minutes
+	Backoff time.Duration
+	// Delays imposed by Wait are extended by a random proportion of themselves, up to this value, so that callers do not resume in lockstep; not all implementations use this value
+	Jitter float64
+	// The proportion of the quota remaining below which Meter mode begins to slow down; defaults to 5%
+	LowWatermark float64
+	// The proportion of the quota remaining below which Meter mode stops until the window resets; defaults to ½%
+	CriticalWatermark float64
+	// Quota which is never consumed, left for other clients; a proportion of the limit if < 1, otherwise a number of operations
+	Reserve float64
+	// The number of operations by which the quota of a window may be exceeded; the overage is deducted from the quota of the windows which follow, so consumption never runs more than this far ahead of the quota; not all implementations use this value, and shared limiters only honour the debt if their store retains state past its reset, as the memory store does
+	Debt int
+	// The number of identical instances sharing the quota; if > 1, each instance uses 1/Shares of it
+	Shares int
+	// The index of this instance among those sharing the quota, from zero; linear limiters use this to stagger their operations, the others only divide the quota
+	ShareIndex int
+	// The proportion of the quota which may be consumed in a burst in Smooth mode before pacing; defaults to 50%
+	BurstFraction float64
+	// The duration of each slice of the window in Sliced mode; defaults to 1/60th of the window, e.g., a minute of an hour
+	Slice time.Duration
+	// The proportion of the quota which, once consumed within a window, constitutes a soft limit; not all implementations use this value
+	SoftLimit float64
+	// Invoked, at most once per window, when consumption crosses the soft limit
+	OnSoftLimit func(State)
+	// Once the soft limit is crossed, the remainder of the window is metered at this proportion of the usual rate; if zero, pacing is unchanged
+	SoftTarget float64
+	// Decides the outcome of the operations whose responses are provided to Update, e.g., whether they were throttled; if nil, DefaultClassifier is used; not all implementations use this value
+	Classifier Classifier
+	// When > 0, the proportion of the quota Meter mode targets is tuned automatically from the feedback provided to Update, beginning at this value, which is the most it may be: throttled responses halve the target and others raise it gradually; only header-based limiters use this value
+	MaxTarget float64
+	// The least proportion of the quota to which the target may be tuned; defaults to 10%
+	MinTarget float64
+	// When tuning the target, responses which take longer than this, per WithLatency, reduce it, as a sign that the service is under strain; if zero, latency is disregarded
+	TargetLatency time.Duration
+	// When > 0, the quota regenerates continuously, restoring this many operations, or points, per second up to the limit, rather than resetting at the end of a window; header-based and GraphQL cost limiters use this value
+	RestoreRate float64
+	// Reservations made by Acquire which are neither committed nor rolled back within this duration of the time they may proceed are rolled back automatically, returning their quota, so that holders which crash or stall do not strand it; if zero, reservations are held until they are settled; only header-based limiters use this value
+	ReclaimAfter time.Duration
+	// The number of recent events, such as grants, delays, updates, and backoffs, to retain for debugging; if zero, none are retained; not all implementations use this value
+	History int
+}
+
+// Determine every limit which applies: Events per Window, if set, followed by
+// any additional Limits.
+func (c Config) limits() []Limit {
+	var res []Limit
+	if c.Events > 0 && c.Window > 0 {
+		res = append(res, Limit{Events: c.Events, Window: c.Window})
+	}
+	for _, e := range c.Limits {
+		if e.Events > 0 && e.Window > 0 {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Partition returns a copy of the configuration for one of n identical
+// instances sharing the quota, where i is the index of the instance.
+func (c Config) Partition(n, i int) Config {
+	c.Shares = n
+	c.ShareIndex = i
+	return c
 }
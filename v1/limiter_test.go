@@ -1,6 +1,12 @@
 package ratelimit
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
 	"testing"
 	"time"
 
@@ -75,3 +81,893 @@ func TestLinear(t *testing.T) {
 		assert.Equal(t, e.State, lim.State(e.When), "#%d", i)
 	}
 }
+
+func TestLinearDegenerate(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+
+	// no window is no limit
+	lim := NewLinear(Config{})
+	for i := 0; i < 3; i++ {
+		next, err := lim.Next(now)
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, now, next, "#%d", i)
+		}
+	}
+	assert.Equal(t, State{}, lim.State(now))
+
+	// a window without events permits nothing
+	lim = NewLinear(Config{Start: now, Window: time.Minute})
+	_, err := lim.Next(now, WithTarget(0.5))
+	var xerr ExhaustedError
+	if assert.ErrorAs(t, err, &xerr) {
+		assert.Equal(t, now.Add(time.Minute), xerr.Reset)
+	}
+	assert.Equal(t, State{Reset: now.Add(time.Minute)}, lim.State(now))
+
+	// rates faster than the clock's resolution do not fail
+	lim = NewLinear(Config{Start: now, Window: time.Second, Events: 10_000_000})
+	next, err := lim.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Microsecond), next)
+	}
+}
+
+func TestHeadersWatermarks(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{
+		Start:             now,
+		Window:            time.Minute,
+		Events:            1000,
+		LowWatermark:      0.1,
+		CriticalWatermark: 0.01,
+	})
+	tests := []struct {
+		Remaining float64
+		Low       bool
+		Critical  bool
+	}{
+		{500, false, false},
+		{100, false, false},
+		{99, true, false},
+		{10, true, false},
+		{9, true, true},
+	}
+	for i, e := range tests {
+		lim.impl.Update(1000, e.Remaining, now.Add(time.Minute))
+		s := lim.State(now)
+		assert.Equal(t, e.Low, s.Low, "#%d", i)
+		assert.Equal(t, e.Critical, s.Critical, "#%d", i)
+	}
+}
+
+func TestPacingOverrides(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10})
+	tests := []struct {
+		Opts []Option
+		Next time.Time
+	}{
+		{nil, now.Add(time.Minute / 10)},
+		{[]Option{WithMode(Burst)}, now},
+		{[]Option{WithMode(Burst)}, now},
+		{[]Option{WithTarget(0.5)}, now.Add(time.Minute / 7 * 2)},
+		{nil, now.Add(time.Minute / 6)},
+	}
+	for i, e := range tests {
+		next, err := lim.Next(now, e.Opts...)
+		if assert.NoError(t, err) {
+			assert.Equal(t, e.Next, next, "#%d", i)
+		}
+	}
+	// overrides do not persist, and the limiter's own mode can be overridden too
+	burst := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst})
+	next, err := burst.Next(now, WithMode(Meter))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Minute/10), next)
+	}
+	next, err = burst.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, next)
+	}
+
+	lin := NewLinear(Config{Start: now, Window: time.Minute, Events: 60})
+	next, err = lin.Next(now, WithTarget(0.5))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second*2), next)
+	}
+	next, err = lin.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second), next)
+	}
+}
+
+func TestSliced(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Hour, Events: 6000, Mode: Sliced})
+	// each minute permits 100 operations at once
+	for i := 0; i < 100; i++ {
+		next, err := lim.Next(now)
+		if assert.NoError(t, err) && !assert.Equal(t, now, next, "#%d", i) {
+			return
+		}
+	}
+	next, err := lim.Next(now.Add(time.Second * 10))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Minute), next)
+	}
+	// the allowance of slices which were not used carries over
+	rel := now.Add(time.Minute * 3)
+	for i := 0; i < 300; i++ {
+		next, err := lim.Next(rel)
+		if assert.NoError(t, err) && !assert.Equal(t, rel, next, "#%d", i) {
+			return
+		}
+	}
+	next, err = lim.Next(rel)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Minute*4), next)
+	}
+
+	// slices may be configured, and operations may be sliced on demand
+	lim = NewHeaders(Config{Start: now, Window: time.Hour, Events: 10, Slice: time.Minute * 30})
+	for i, e := range []time.Time{now, now, now, now, now, now.Add(time.Minute * 30)} {
+		next, err := lim.Next(now, WithMode(Sliced))
+		if assert.NoError(t, err) {
+			assert.Equal(t, e, next, "#%d", i)
+		}
+	}
+}
+
+func TestHeadersReserve(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	reset := now.Add(time.Minute)
+	tests := []struct {
+		Reserve float64
+		Next    []time.Time
+	}{
+		{0, []time.Time{now, now, now}},
+		{1, []time.Time{now, now, reset}},
+		{0.2, []time.Time{now, reset, reset}},
+	}
+	for i, e := range tests {
+		lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, Reserve: e.Reserve})
+		lim.impl.Update(10, 3, reset)
+		for j, x := range e.Next {
+			next, err := lim.Next(now, WithAttrs(Attrs{}))
+			if assert.NoError(t, err) {
+				assert.Equal(t, x, next, "#%d/%d", i, j)
+			}
+		}
+	}
+}
+
+func TestHeadersReserveState(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	reset := now.Add(time.Minute)
+	tests := []struct {
+		Remaining float64
+		Low       bool
+		Critical  bool
+	}{
+		{20, false, false},
+		{14, true, false}, // low once the reserve is set aside
+		{10, true, true},  // only the reserve remains
+	}
+	for i, e := range tests {
+		lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, Reserve: 10})
+		lim.impl.Update(100, e.Remaining, reset)
+		st := lim.State(now)
+		assert.Equal(t, e.Low, st.Low, "#%d", i)
+		assert.Equal(t, e.Critical, st.Critical, "#%d", i)
+	}
+}
+
+func TestHeadersSmooth(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Smooth, BurstFraction: 0.4})
+	expect := []time.Time{
+		now,
+		now,
+		now,
+		now,
+		now.Add(time.Minute / 6),
+		now.Add(time.Minute / 5),
+	}
+	for i, e := range expect {
+		next, err := lim.Next(now, WithAttrs(Attrs{}))
+		if assert.NoError(t, err) {
+			assert.Equal(t, e, next, "#%d", i)
+		}
+	}
+}
+
+func TestParseReset(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Durationer Durationer
+		Value      string
+		Reset      time.Time
+		Error      bool
+	}{
+		{Seconds, "1712880060", time.Unix(1712880060, 0), false},
+		{Seconds, "2024-05-01T00:00:00Z", time.Time{}, true},
+		{Dates, "2024-05-01T00:00:00Z", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), false},
+		{Dates, "Wed, 01 May 2024 00:00:00 GMT", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), false},
+		{Dates, "12", time.Time{}, true},
+		{DeltaSeconds, "12.5", now.Add(time.Millisecond * 12500), false},
+		{Auto, "1712880060", time.Unix(1712880060, 0), false},
+		{Auto, "12.5", now.Add(time.Millisecond * 12500), false},
+		{Auto, "30", now.Add(time.Second * 30), false}, // too small to be a timestamp
+		{Auto, "2024-05-01T00:00:00Z", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), false},
+		{Auto, "soon", time.Time{}, true},
+	}
+	for i, e := range tests {
+		lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Durationer: e.Durationer})
+		err := lim.Update(now, WithAttrs(Attrs{
+			"X-Ratelimit-Limit":     []string{"10"},
+			"X-Ratelimit-Remaining": []string{"5"},
+			"X-Ratelimit-Reset":     []string{e.Value},
+		}))
+		if e.Error {
+			assert.Error(t, err, "#%d", i)
+		} else if assert.NoError(t, err, "#%d", i) {
+			assert.True(t, e.Reset.Equal(lim.State(now).Reset), "#%d: %v != %v", i, e.Reset, lim.State(now).Reset)
+		}
+	}
+}
+
+func TestResetSemantics(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Durationer Durationer
+		Semantics  ResetSemantics
+		Value      string
+		Reset      time.Time
+	}{
+		{Seconds, Epoch, "1712880060", time.Unix(1712880060, 0)},
+		{Seconds, Epoch, "60", time.Unix(60, 0)},
+		{Seconds, Delta, "60", now.Add(time.Minute)},
+		{Milliseconds, Delta, "1500", now.Add(time.Millisecond * 1500)},
+		{Seconds, AutoDetect, "1712880060", time.Unix(1712880060, 0)},
+		{Seconds, AutoDetect, "60", now.Add(time.Minute)},
+		{Milliseconds, AutoDetect, "1712880060000", time.Unix(1712880060, 0)},
+		{Milliseconds, AutoDetect, "60000", now.Add(time.Minute)},
+		{Dates, Delta, "2024-05-01T00:00:00Z", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for i, e := range tests {
+		lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Durationer: e.Durationer, ResetSemantics: e.Semantics})
+		err := lim.Update(now, WithAttrs(Attrs{
+			"X-Ratelimit-Limit":     []string{"10"},
+			"X-Ratelimit-Remaining": []string{"5"},
+			"X-Ratelimit-Reset":     []string{e.Value},
+		}))
+		if assert.NoError(t, err, "#%d", i) {
+			assert.True(t, e.Reset.Equal(lim.State(now).Reset), "#%d: %v != %v", i, e.Reset, lim.State(now).Reset)
+		}
+	}
+}
+
+func TestHeadersFractional(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta})
+	err := lim.Update(now, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"10"},
+		"Ratelimit-Remaining": []string{"1.5"},
+		"Ratelimit-Reset":     []string{"12.5"},
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, State{Limit: 10, Remaining: 1, Reset: now.Add(time.Millisecond * 12500)}, lim.State(now))
+	}
+	// one whole operation remains, the fractional remainder is not enough for another
+	for i, e := range []time.Time{now, now.Add(time.Millisecond * 12500)} {
+		next, err := lim.Next(now, WithAttrs(Attrs{}))
+		if assert.NoError(t, err) {
+			assert.Equal(t, e, next, "#%d", i)
+		}
+	}
+}
+
+func TestHeadersLenient(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Name  string
+		Attrs Attrs
+	}{
+		{"Malformed", Attrs{"Ratelimit-Limit": []string{"ten"}, "Ratelimit-Remaining": []string{"5"}, "Ratelimit-Reset": []string{"30"}}},
+		{"Partial", Attrs{"Ratelimit-Limit": []string{"10"}, "Ratelimit-Remaining": []string{"5"}}},
+		{"Missing", Attrs{"Content-Type": []string{"text/plain"}}},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			strict := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, ResetSemantics: Delta})
+			assert.Error(t, strict.Update(now, WithAttrs(e.Attrs)))
+
+			lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, ResetSemantics: Delta, Lenient: true})
+			before := lim.State(now)
+			if assert.NoError(t, lim.Update(now, WithAttrs(e.Attrs))) {
+				assert.Equal(t, before, lim.State(now))
+			}
+		})
+	}
+	// retry-after is still reported when lenient
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Lenient: true})
+	assert.ErrorAs(t, lim.Update(now, WithAttrs(Attrs{"Retry-After": []string{"10"}})), &RetryError{})
+}
+
+func TestHeadersUpdateEx(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, ResetSemantics: Delta, Shares: 2})
+
+	res, err := lim.UpdateEx(now, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"10"},
+		"Ratelimit-Remaining": []string{"8"},
+		"Ratelimit-Reset":     []string{"30"},
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, UpdateResult{
+			Headers: HeaderState{Limit: 10, Remaining: 8, Reset: now.Add(time.Second * 30)},
+			Applied: true,
+			State:   State{Limit: 5, Remaining: 4, Reset: now.Add(time.Second * 30)},
+		}, res)
+	}
+
+	res, err = lim.UpdateEx(now, WithAttrs(Attrs{"Ratelimit-Limit": []string{"10"}}))
+	assert.ErrorIs(t, err, ErrMissingHeaders)
+	assert.False(t, res.Applied)
+	assert.Equal(t, 10, res.Headers.Limit)
+
+	res, err = lim.UpdateEx(now, WithAttrs(Attrs{"Retry-After": []string{"10"}}))
+	assert.ErrorAs(t, err, &RetryError{})
+	assert.True(t, res.Backoff)
+	assert.False(t, res.Applied)
+	assert.False(t, res.Headers.RetryAfter.IsZero())
+}
+
+func TestStrict(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	reset := now.Add(time.Minute)
+	tests := []struct {
+		Name    string
+		Limiter Limiter
+	}{
+		{"Headers", NewHeaders(Config{Start: now, Window: time.Minute, Events: 2, Mode: Burst, Strict: true})},
+		{"Shared", NewShared(Config{Start: now, Window: time.Minute, Events: 2, Strict: true}, NewMemoryStore(), "strict")},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				next, err := e.Limiter.Next(now)
+				if assert.NoError(t, err) {
+					assert.Equal(t, now, next, "#%d", i)
+				}
+			}
+			_, err := e.Limiter.Next(now)
+			var xerr ExhaustedError
+			if assert.ErrorAs(t, err, &xerr) {
+				assert.ErrorIs(t, err, ErrExhausted)
+				assert.Equal(t, reset, xerr.Reset)
+			}
+		})
+	}
+}
+
+func TestAlign(t *testing.T) {
+	start := time.Date(2024, 4, 12, 10, 17, 30, 0, time.UTC)
+	conf := Config{Start: start, Window: time.Hour, Events: 60}
+	tests := []struct {
+		Name    string
+		Limiter func(Config) Limiter
+	}{
+		{"Linear", func(c Config) Limiter { return NewLinear(c) }},
+		{"Headers", func(c Config) Limiter { return NewHeaders(c) }},
+		{"Shared", func(c Config) Limiter { return NewShared(c, NewMemoryStore(), "align") }},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			lim := e.Limiter(conf)
+			lim.Next(start)
+			assert.Equal(t, start.Add(time.Hour), lim.State(start).Reset)
+
+			aligned := conf
+			aligned.Align = true
+			lim = e.Limiter(aligned)
+			lim.Next(start)
+			assert.Equal(t, time.Date(2024, 4, 12, 11, 0, 0, 0, time.UTC), lim.State(start).Reset)
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	t.Run("Linear", func(t *testing.T) {
+		lim := NewLinear(Config{Start: now, Window: time.Second, Events: 5, Limits: []Limit{{Events: 60, Window: time.Minute}, {Events: 10000, Window: day}}})
+		next, err := lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(time.Millisecond*8640), next) // the daily limit is the slowest
+		}
+		assert.Equal(t, State{Limit: 10000, Remaining: 10000, Reset: now.Add(day)}, lim.State(now))
+	})
+	t.Run("Shared", func(t *testing.T) {
+		store := NewMemoryStore()
+		lim := NewShared(Config{Start: now, Window: time.Second, Events: 2, Limits: []Limit{{Events: 3, Window: time.Minute}}}, store, "limits")
+		tests := []struct {
+			Rel, Expect time.Time
+		}{
+			{now, now},
+			{now, now},
+			{now, now.Add(time.Second)}, // the per-second limit is exhausted
+			{now.Add(time.Second), now.Add(time.Second)},
+			{now.Add(time.Second), now.Add(time.Minute)}, // the per-minute limit is exhausted
+		}
+		for i, e := range tests {
+			next, err := lim.Next(e.Rel)
+			if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, next, "#%d", i)
+			}
+		}
+		assert.Equal(t, State{Limit: 3, Remaining: 0, Reset: now.Add(time.Minute)}, lim.State(now.Add(time.Second)))
+		// the per-second limit was refunded when the per-minute limit was exhausted
+		assert.Equal(t, State{Limit: 2, Remaining: 1, Reset: now.Add(time.Second * 2)}, store.state["limits"])
+	})
+}
+
+func TestDebt(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewShared(Config{Start: now, Window: time.Minute, Events: 2, Debt: 3}, NewMemoryStore(), "debt")
+	tests := []struct {
+		Rel, Expect time.Time
+	}{
+		{now, now},
+		{now, now},
+		{now, now}, // borrowing from the next window
+		{now, now},
+		{now, now},
+		{now, now.Add(time.Minute)}, // the debt is exhausted
+		{now.Add(time.Minute), now.Add(time.Minute)}, // the next window repays two, so two more may be borrowed
+		{now.Add(time.Minute), now.Add(time.Minute)},
+		{now.Add(time.Minute), now.Add(time.Minute * 2)},
+		{now.Add(time.Minute * 4), now.Add(time.Minute * 4)}, // idle windows repay the rest
+		{now.Add(time.Minute * 4), now.Add(time.Minute * 4)},
+	}
+	for i, e := range tests {
+		next, err := lim.Next(e.Rel)
+		if assert.NoError(t, err) {
+			assert.Equal(t, e.Expect, next, "#%d", i)
+		}
+	}
+	assert.Equal(t, State{Limit: 2, Remaining: 0, Reset: now.Add(time.Minute * 5)}, lim.State(now.Add(time.Minute*4)))
+	assert.Equal(t, time.Duration(0), lim.EstimatedWait(now.Add(time.Minute*4)), "quota may be borrowed")
+}
+
+func TestHeadersUpdateBatch(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	update := func(rem, rst string) Attrs {
+		return Attrs{"Ratelimit-Limit": []string{"100"}, "Ratelimit-Remaining": []string{rem}, "Ratelimit-Reset": []string{rst}}
+	}
+
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, ResetSemantics: Delta})
+	err := lim.UpdateBatch(now, []Attrs{update("90", "50"), update("80", "40"), update("70", "30")})
+	if assert.NoError(t, err) {
+		assert.Equal(t, State{Limit: 100, Remaining: 70, Reset: now.Add(time.Second * 30)}, lim.State(now))
+	}
+
+	// invalid updates are skipped and reported, and backoffs are reported
+	err = lim.UpdateBatch(now, []Attrs{update("60", "30"), {"Ratelimit-Limit": []string{"ten"}}, {"Retry-After": []string{"5"}}, update("50", "30")})
+	assert.ErrorIs(t, err, ErrInvalidHeaders)
+	assert.ErrorIs(t, err, ErrBackoff)
+	assert.Equal(t, State{Limit: 100, Remaining: 50, Reset: now.Add(time.Second * 30)}, lim.State(now))
+	assert.Equal(t, time.Second*5, lim.EstimatedWait(now))
+
+	// lenient limiters don't report invalid updates
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, ResetSemantics: Delta, Lenient: true})
+	assert.NoError(t, lim.UpdateBatch(now, []Attrs{{"Ratelimit-Limit": []string{"ten"}}, update("10", "30")}))
+	assert.Equal(t, 10, lim.State(now).Remaining)
+}
+
+func TestHeadersStale(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	update := func(rem, rst string) Attrs {
+		return Attrs{"Ratelimit-Limit": []string{"100"}, "Ratelimit-Remaining": []string{rem}, "Ratelimit-Reset": []string{rst}}
+	}
+	dated := func(attrs Attrs, at time.Time) Attrs {
+		attrs["Date"] = []string{at.Format(http.TimeFormat)}
+		return attrs
+	}
+	tests := []struct {
+		Name   string
+		Opts   []Option
+		Stale  bool
+		Expect State
+	}{
+		{"Older", []Option{WithAttrs(update("80", "30")), WithObservedAt(now.Add(-time.Second))}, true, State{Limit: 100, Remaining: 50, Reset: now.Add(time.Second * 30)}},
+		{"OlderDate", []Option{WithAttrs(dated(update("80", "30"), now.Add(-time.Second)))}, true, State{Limit: 100, Remaining: 50, Reset: now.Add(time.Second * 30)}},
+		{"OlderEarlierReset", []Option{WithAttrs(update("80", "20")), WithObservedAt(now.Add(-time.Second))}, true, State{Limit: 100, Remaining: 50, Reset: now.Add(time.Second * 30)}},
+		{"OlderLaterWindow", []Option{WithAttrs(update("100", "90")), WithObservedAt(now.Add(-time.Second))}, false, State{Limit: 100, Remaining: 100, Reset: now.Add(time.Second * 90)}},
+		{"Newer", []Option{WithAttrs(update("40", "30")), WithObservedAt(now.Add(time.Second))}, false, State{Limit: 100, Remaining: 40, Reset: now.Add(time.Second * 30)}},
+		{"Unknown", []Option{WithAttrs(update("80", "30"))}, false, State{Limit: 100, Remaining: 80, Reset: now.Add(time.Second * 30)}},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, ResetSemantics: Delta})
+			_, err := lim.UpdateEx(now, WithAttrs(update("50", "30")), WithObservedAt(now))
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := lim.UpdateEx(now, e.Opts...)
+			if assert.NoError(t, err) {
+				assert.Equal(t, e.Stale, res.Stale)
+				assert.Equal(t, !e.Stale, res.Applied)
+				assert.Equal(t, e.Expect, lim.State(now))
+			}
+		})
+	}
+}
+
+func TestBoundDelay(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	for _, e := range []struct {
+		Bound  bool
+		Expect time.Time
+	}{
+		{false, now.Add(time.Hour * 10)},
+		{true, now.Add(time.Minute)},
+	} {
+		lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, BoundDelay: e.Bound})
+		lim.impl.Update(10, 0, now.Add(time.Hour*10)) // an implausible reset
+		next, err := lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, e.Expect, next, "bound: %v", e.Bound)
+		}
+		// backoffs are not bounded
+		lim.BackoffUntil(now.Add(time.Hour))
+		next, err = lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(time.Hour), next, "bound: %v", e.Bound)
+		}
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	rel := time.Now()
+	reset := time.Unix(rel.Unix()+60, 0)
+	a := anchor(rel, reset)
+	assert.True(t, reset.Equal(a))
+	assert.NotEqual(t, a, a.Round(0), "the result has a monotonic reading")
+	// without a monotonic reading, the time is unchanged
+	assert.Equal(t, reset, anchor(rel.Round(0), reset))
+	assert.Equal(t, time.Time{}, anchor(rel, time.Time{}))
+}
+
+func TestSoftLimit(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	var crossed []State
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst, SoftLimit: 0.5, SoftTarget: 0.5, OnSoftLimit: func(st State) {
+		crossed = append(crossed, st)
+	}})
+	for i := 0; i < 5; i++ {
+		next, err := lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now, next, "#%d", i)
+		}
+	}
+	assert.Equal(t, []State{{Limit: 10, Remaining: 5, Reset: now.Add(time.Minute)}}, crossed)
+
+	// beyond the soft limit, the remainder of the window is metered at half the rate
+	next, err := lim.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second*24), next)
+	}
+	assert.Len(t, crossed, 1, "the callback is invoked once per window")
+
+	// a new window
+	lim.impl.Update(10, 5, now.Add(time.Minute*2))
+	lim.Next(now)
+	assert.Len(t, crossed, 2)
+}
+
+func TestBackoff(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	type backoffLimiter interface {
+		Limiter
+		Backoff(time.Time) (time.Time, error)
+		BackoffUntil(time.Time) error
+		InvalidateBackoff() error
+	}
+	for _, lim := range []backoffLimiter{
+		NewHeaders(Config{Start: now, Window: time.Minute, Events: 6, Mode: Burst}),
+		NewLinear(Config{Start: now, Window: time.Minute, Events: 6}),
+	} {
+		until, err := lim.Backoff(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(defaultBackoffPeriod), until)
+		}
+		until, err = lim.Backoff(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(defaultBackoffPeriod*4), until)
+		}
+		next, err := lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, until, next)
+		}
+
+		assert.NoError(t, lim.BackoffUntil(now.Add(time.Hour)))
+		next, err = lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(time.Hour), next)
+		}
+
+		assert.NoError(t, lim.InvalidateBackoff())
+		next, err = lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.True(t, next.Before(now.Add(time.Hour)), "%v", next)
+		}
+	}
+}
+
+func TestLinearShares(t *testing.T) {
+	start := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	conf := Config{Start: start, Window: time.Minute, Events: 6}
+	tests := []struct {
+		Index int
+		Next  []time.Time
+	}{
+		{0, []time.Time{start.Add(time.Second * 20), start.Add(time.Second * 40)}},
+		{1, []time.Time{start.Add(time.Second * 10), start.Add(time.Second * 30)}},
+	}
+	for i, e := range tests {
+		lim := NewLinear(conf.Partition(2, e.Index))
+		rel := start.Add(time.Second)
+		for j, x := range e.Next {
+			next, err := lim.Next(rel)
+			if assert.NoError(t, err) {
+				assert.Equal(t, x, next, "#%d/%d", i, j)
+			}
+			rel = next
+		}
+		assert.Equal(t, 3, lim.State(start).Limit)
+	}
+}
+
+func TestHeadersShares(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100}.Partition(4, 0))
+	assert.Equal(t, 25, lim.State(now).Limit)
+	err := lim.Update(now, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"200"},
+		"Ratelimit-Remaining": []string{"100"},
+		"Ratelimit-Reset":     []string{"60"},
+	}))
+	if assert.NoError(t, err) {
+		s := lim.State(now)
+		assert.Equal(t, 50, s.Limit)
+		assert.Equal(t, 25, s.Remaining)
+	}
+}
+
+func TestHeadersInitial(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Config Config
+		Expect State
+	}{
+		{
+			Config{Start: now, Window: time.Minute, Events: 100},
+			State{Limit: 100, Remaining: 100, Reset: now.Add(time.Minute)},
+		},
+		{
+			Config{Start: now, Window: time.Minute, Events: 100, Initial: &State{Limit: 100, Remaining: 3, Reset: now.Add(time.Second * 20)}},
+			State{Limit: 100, Remaining: 3, Reset: now.Add(time.Second * 20), Low: true},
+		},
+		{
+			Config{Start: now, Window: time.Minute, Events: 100, Initial: &State{Limit: 100, Remaining: 0}},
+			State{Limit: 100, Remaining: 0, Reset: now.Add(time.Minute), Low: true, Critical: true},
+		},
+		{
+			Config{Start: now, Window: time.Minute, Events: 100, Shares: 2, Initial: &State{Limit: 200, Remaining: 50, Reset: now.Add(time.Second * 30)}},
+			State{Limit: 100, Remaining: 25, Reset: now.Add(time.Second * 30)},
+		},
+	}
+	for i, e := range tests {
+		lim := NewHeaders(e.Config)
+		assert.Equal(t, e.Expect, lim.State(now), "#%d", i)
+	}
+}
+
+func TestHeadersProbe(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, Mode: Burst, Probe: time.Second * 10})
+
+	// the first operation probes; others are held until the probe expires
+	next, err := lim.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, next)
+	}
+	next, err = lim.Next(now.Add(time.Second))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second*10), next)
+	}
+	// once the probe expires, another is permitted
+	next, err = lim.Next(now.Add(time.Second * 10))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second*10), next)
+	}
+
+	// a waiter is released as soon as the quota is known
+	rel := time.Now()
+	lim = NewHeaders(Config{Start: rel, Window: time.Minute, Events: 100, Mode: Burst, Probe: time.Minute})
+	_, err = lim.Next(rel)
+	assert.NoError(t, err)
+	res := make(chan error)
+	go func() {
+		_, err := lim.Wait(context.Background(), rel)
+		res <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	err = lim.Update(rel, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"100"},
+		"Ratelimit-Remaining": []string{"50"},
+		"Ratelimit-Reset":     []string{"60"},
+	}))
+	if assert.NoError(t, err) {
+		select {
+		case err := <-res:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			assert.Fail(t, "Waiter was not released")
+		}
+	}
+	next, err = lim.Next(rel)
+	if assert.NoError(t, err) {
+		assert.Equal(t, rel, next)
+	}
+
+	// a waiter which probes is not held by its own probe
+	lim = NewHeaders(Config{Start: rel, Window: time.Minute, Events: 100, Mode: Burst, Probe: time.Minute})
+	cxt, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	next, err = lim.Wait(cxt, rel)
+	if assert.NoError(t, err) {
+		assert.Equal(t, rel, next)
+	}
+
+	// with an initial state, there is nothing to probe
+	lim = NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, Mode: Burst, Probe: time.Second * 10, Initial: &State{Limit: 100, Remaining: 100}})
+	for i := 0; i < 3; i++ {
+		next, err = lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now, next, "#%d", i)
+		}
+	}
+}
+
+func TestDurationers(t *testing.T) {
+	tests := []struct {
+		Durationer Durationer
+		Value      int
+		Duration   time.Duration
+		Time       time.Time
+	}{
+		{Seconds, 90, time.Second * 90, time.Unix(90, 0)},
+		{Milliseconds, 1500, time.Millisecond * 1500, time.Unix(1, int64(time.Millisecond*500))},
+		{Minutes, 2, time.Minute * 2, time.Unix(120, 0)},
+		{Hours, 2, time.Hour * 2, time.Unix(7200, 0)},
+		{UnixMillis, 1500, time.Second * 1500, time.Unix(1, int64(time.Millisecond*500))},
+		{DurationerFunc(func(v int) time.Duration { return time.Duration(v) }, func(v int) time.Time { return time.Time{} }), 5, 5, time.Time{}},
+	}
+	for i, e := range tests {
+		assert.Equal(t, e.Duration, e.Durationer.Duration(e.Value), "#%d", i)
+		assert.True(t, e.Time.Equal(e.Durationer.Time(e.Value)), "#%d", i)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	log := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Logger: log})
+
+	lim.Next(now)
+	lim.Update(now, WithAttrs(Attrs{"Ratelimit-Limit": []string{"ten"}}))
+	lim.Update(now, WithAttrs(Attrs{"Retry-After": []string{"10"}}))
+	lim.InvalidateBackoff()
+
+	out := buf.String()
+	assert.Contains(t, out, `msg="Computed delay"`)
+	assert.Contains(t, out, `msg="Could not update from headers"`)
+	assert.Contains(t, out, `msg="Backing off"`)
+	assert.Contains(t, out, `msg="Backoff invalidated"`)
+}
+
+func TestPrepare(t *testing.T) {
+	attrs := Attrs{"X-Tenant": []string{"a"}}
+	opts := Prepare(WithKey("tenant"), WithAttrs(attrs))
+	assert.Len(t, opts, 1)
+	assert.Equal(t, Options{Key: "tenant", Attrs: attrs}, Options{}.With(opts))
+	// prepared options only override what they set
+	assert.Equal(t, Options{Key: "other", Attrs: attrs}, Options{}.With(append(Prepare(WithAttrs(attrs)), WithKey("other"))))
+	assert.Equal(t, Options{Key: "tenant", Attrs: attrs}, Options{Attrs: attrs}.With(Prepare(WithKey("tenant"))))
+}
+
+func BenchmarkOptions(b *testing.B) {
+	now := time.Now()
+	lim := NewKeyed(func(string) Limiter {
+		return NewHeaders(Config{Start: now, Window: time.Hour, Events: math.MaxInt32, Mode: Burst})
+	})
+	b.Run("Inline", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lim.Next(now, WithKey("tenant"))
+		}
+	})
+	b.Run("Prepared", func(b *testing.B) {
+		opts := Prepare(WithKey("tenant"))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lim.Next(now, opts...)
+		}
+	})
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	now := time.Now()
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 1000, ResetSemantics: Delta})
+	opts := Prepare(WithAttrs(Attrs{
+		"Content-Type":          []string{"application/json"},
+		"X-Ratelimit-Limit":     []string{"1000"},
+		"X-Ratelimit-Remaining": []string{"998"},
+		"X-Ratelimit-Reset":     []string{"60"},
+	}))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lim.Update(now, opts...)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Limiter Limiter
+		Expect  time.Time
+		Error   error
+	}{
+		{
+			NewHeaders(Config{Start: now, Window: time.Minute, Events: 2, Mode: Burst}),
+			now, nil,
+		},
+		{
+			NewHeaders(Config{Start: now, Window: time.Minute, Events: 0, Mode: Burst}),
+			now.Add(time.Minute), nil,
+		},
+		{
+			NewHeaders(Config{Start: now, Window: time.Minute, Events: 0, Mode: Burst, Strict: true}),
+			time.Time{}, ErrExhausted,
+		},
+		{
+			NewLinear(Config{Start: now, Window: time.Minute, Events: 60}),
+			now.Add(time.Second), nil,
+		},
+		{
+			None(),
+			now, nil,
+		},
+		{
+			Tee(NewHeaders(Config{Start: now, Window: time.Minute, Events: 0, Mode: Burst})),
+			now.Add(time.Minute), nil,
+		},
+	}
+	for i, e := range tests {
+		before := e.Limiter.State(now)
+		for j := 0; j < 3; j++ {
+			next, err := Peek(e.Limiter, now)
+			if e.Error != nil {
+				assert.ErrorIs(t, err, e.Error, "#%d/%d", i, j)
+			} else if assert.NoError(t, err, "#%d/%d", i, j) {
+				assert.Equal(t, e.Expect, next, "#%d/%d", i, j)
+			}
+		}
+		assert.Equal(t, before, e.Limiter.State(now), "#%d: peeking must not consume quota", i)
+	}
+
+	_, err := Peek(struct{ Limiter }{None()}, now)
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
@@ -2,64 +2,230 @@ package ratelimit
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
+
+	"github.com/bww/go-util/v1/ext"
 )
 
 // linear implements a rate limiter which spreads out requests evenly
-// over the window period.
+// over the window period. If the configuration includes a schedule, the rate
+// varies according to the period in effect. If it includes several limits,
+// requests are spread out at the slowest of their rates.
+//
+// If no window is in effect, operations are not limited; if a window is in
+// effect but permits no events, every operation is refused with an
+// ExhaustedError.
 type linear struct {
 	Config
-	base  time.Time
-	delay time.Duration
+	base time.Time
+	waiters
+
+	sync.Mutex
+	backoff  time.Time
+	errcount int
+	target   float64 // the proportion of the rate we target, if > 0
 }
 
 func NewLinear(conf Config) *linear {
-	var when time.Time
-	if !conf.Start.IsZero() {
-		when = conf.Start
-	} else {
-		when = time.Now()
-	}
 	return &linear{
 		Config: conf,
-		base:   when,
-		delay:  conf.Window / time.Duration(conf.Events),
+		base:   conf.origin(),
+	}
+}
+
+// MustLinear creates a linear limiter, as NewLinear does, and panics if the
+// configuration is invalid, per Config.Validate. It is intended for
+// configurations which are constants.
+func MustLinear(conf Config) *linear {
+	if err := conf.Validate(); err != nil {
+		panic(err)
+	}
+	return NewLinear(conf)
+}
+
+// Clone produces an independent copy of the limiter and its current state,
+// including any backoff in effect
+func (l *linear) Clone() Limiter {
+	l.Lock()
+	defer l.Unlock()
+	return &linear{
+		Config:   l.Config,
+		base:     l.base,
+		backoff:  l.backoff,
+		errcount: l.errcount,
+		target:   l.target,
+	}
+}
+
+// Produce a copy of the limiter and its current state which permits the
+// specified number of events per window
+func (l *linear) withEvents(events int) *linear {
+	c := l.Clone().(*linear)
+	c.Events = events
+	return c
+}
+
+// Determine the rate in effect at the reference time: the events permitted to
+// this instance, the window, the delay between events, and the offset of this
+// instance's events when the quota is shared. If the target is > 0, it
+// overrides the limiter's.
+func (l *linear) rate(rel time.Time, target float64) (int, time.Duration, time.Duration, time.Duration) {
+	events, window := l.Events, l.Window
+	if p, ok := l.Schedule.At(rel); ok {
+		events, window = p.Events, p.Window
+	}
+	// when several limits apply, spreading events at the slowest rate satisfies them all
+	for _, e := range l.Limits {
+		if e.Events > 0 && (events <= 0 || e.Window*time.Duration(events) > window*time.Duration(e.Events)) {
+			events, window = e.Events, e.Window
+		}
+	}
+	l.Lock()
+	tgt := ext.Coalesce(target, l.target)
+	l.Unlock()
+	if events <= 0 || window <= 0 {
+		return events, window, 0, 0 // unlimited, or nothing is permitted
+	}
+	if tgt > 0 {
+		events = max(1, int(float64(events)*tgt))
 	}
+	var offset time.Duration
+	if l.Shares > 1 {
+		// each share takes every Nth slot of the full quota, offset by its index
+		offset = time.Duration(l.ShareIndex%l.Shares) * (window / time.Duration(events))
+		events = max(1, events/l.Shares)
+	}
+	return events, window, window / time.Duration(events), offset
 }
 
 func (l *linear) State(rel time.Time) State {
-	var (
-		nwin  = rel.Sub(l.base) / l.Window
-		start = l.base.Add(nwin * l.Window)
-		reset = start.Add(l.Window)
-		curr  = rel.Sub(start)
-	)
+	events, window, _, _ := l.rate(rel, 0)
+	if window <= 0 {
+		return State{Limit: events, Remaining: events} // unlimited
+	}
+	start, reset := l.bounds(l.base, rel, window)
+	curr := rel.Sub(start)
 	return State{
-		Limit:     l.Events,
-		Remaining: int((1 - (float64(curr) / float64(l.Window))) * float64(l.Events)),
+		Limit:     max(0, events),
+		Remaining: int((1 - (float64(curr) / float64(reset.Sub(start)))) * float64(max(0, events))),
 		Reset:     reset,
 	}
 }
 
 func (l *linear) Next(rel time.Time, opts ...Option) (time.Time, error) {
-	dm := int64(l.delay / 1000)
-	return time.UnixMicro(((rel.UnixMicro() / dm) * dm) + int64(l.delay/1000)).UTC(), nil
+	l.Lock()
+	b := l.backoff
+	if !b.After(rel) {
+		l.errcount = 0 // clear error count if we're not in a backoff
+	}
+	l.Unlock()
+	if b.After(rel) {
+		return b, nil
+	}
+	return l.next(rel, Options{}.With(opts).Target)
+}
+
+// Peek returns the time at which the next operation could proceed relative to
+// the provided time, as Next does. A linear limiter's slots are determined by
+// time alone, so neither consumes any quota.
+func (l *linear) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	l.Lock()
+	b := l.backoff
+	l.Unlock()
+	if b.After(rel) {
+		return b, nil
+	}
+	return l.next(rel, Options{}.With(opts).Target)
+}
+
+// Compute the slot in which the next operation may proceed, outside of a
+// backoff, given the target
+func (l *linear) next(rel time.Time, target float64) (time.Time, error) {
+	events, window, delay, offset := l.rate(rel, target)
+	if window <= 0 {
+		return rel, nil
+	} else if events <= 0 {
+		_, reset := l.bounds(l.base, rel, window)
+		return time.Time{}, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: reset})
+	}
+	dm, om := max(1, int64(delay/1000)), int64(offset/1000)
+	return time.UnixMicro((((rel.UnixMicro() - om) / dm) * dm) + dm + om).UTC(), nil
 }
 
 func (l *linear) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
 	t, err := l.Next(rel, opts...)
 	if err != nil {
 		return time.Time{}, err
 	}
-	select {
-	case <-time.After(t.Sub(rel)):
-		return t, nil
-	case <-cxt.Done():
-		return t, ErrCanceled
+	if err := overloaded(l.MaxWait, rel, t.Sub(rel)); err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, jitter(l.Jitter, rel, t))
+}
+
+// EstimatedWait returns the delay a new operation would incur relative to the
+// provided time.
+func (l *linear) EstimatedWait(rel time.Time) time.Duration {
+	t, err := l.Next(rel)
+	if err != nil {
+		return 0
 	}
+	return t.Sub(rel)
 }
 
 func (l *linear) Update(rel time.Time, opts ...Option) error {
 	// Linear implementation does not use post-operation state
 	return nil
 }
+
+// Backoff imposes an incrementally increasing backoff period relative to the
+// provided time and returns the time at which it ends. Each consecutive backoff
+// is longer than the last until an operation is permitted outside of one.
+func (l *linear) Backoff(rel time.Time) (time.Time, error) {
+	l.Lock()
+	defer l.Unlock()
+	l.errcount++
+	l.backoff = rel.Add(backoffDuration(ext.Coalesce(l.Config.Backoff, defaultBackoffPeriod), l.errcount))
+	return l.backoff, nil
+}
+
+// BackoffUntil imposes a backoff period which ends at the provided time
+func (l *linear) BackoffUntil(until time.Time) error {
+	l.Lock()
+	defer l.Unlock()
+	l.backoff = until
+	l.errcount = 1
+	return nil
+}
+
+// BackoffEnd returns the time at which the current backoff period ends, which
+// may be in the past, or zero if none has been imposed
+func (l *linear) BackoffEnd() time.Time {
+	l.Lock()
+	defer l.Unlock()
+	return l.backoff
+}
+
+// SetTarget sets the proportion of the configured rate at which operations are
+// spread out; zero restores the configured rate
+func (l *linear) SetTarget(v float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.target = v
+}
+
+// InvalidateBackoff clears any backoff period in effect
+func (l *linear) InvalidateBackoff() error {
+	l.Lock()
+	defer l.Unlock()
+	l.backoff = time.Time{}
+	l.errcount = 0
+	return nil
+}
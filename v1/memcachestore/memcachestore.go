@@ -0,0 +1,113 @@
+// Package memcachestore implements a rate limiter Store backed by memcached.
+// State is modified with compare-and-swap, so concurrent decrements from
+// different processes never overwrite one another.
+//
+// State expires shortly after its window resets, so any debt a shared limiter
+// incurred by borrowing from subsequent windows (see Config.Debt) is forgiven
+// once the window it was incurred in has reset.
+package memcachestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+const defaultPrefix = "ratelimit:"
+
+// The longest relative expiration memcached accepts; longer values are
+// interpreted as absolute times
+const maxExpiration = 60 * 60 * 24 * 30
+
+// Client is the subset of a memcached client used by the store; it is
+// satisfied by *memcache.Client.
+type Client interface {
+	Get(key string) (*memcache.Item, error)
+	Add(item *memcache.Item) error
+	CompareAndSwap(item *memcache.Item) error
+}
+
+// Store persists limiter state in memcached
+type Store struct {
+	client Client
+	prefix string
+}
+
+// New creates a store using the provided client. Keys are prefixed with the
+// provided prefix or, if it is empty, a default prefix.
+func New(client Client, prefix string) *Store {
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return &Store{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Modify atomically reads, modifies, and persists the state for a key. If the
+// state is modified concurrently by another client, the operation is retried
+// until it succeeds or the context is canceled. Entries expire when the window
+// they describe resets.
+func (s *Store) Modify(cxt context.Context, key string, fn func(*ratelimit.State) error) error {
+	key = s.prefix + url.QueryEscape(key)
+	for {
+		if err := cxt.Err(); err != nil {
+			return ratelimit.ErrCanceled
+		}
+
+		var state ratelimit.State
+		item, err := s.client.Get(key)
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			item = nil
+		} else if err != nil {
+			return fmt.Errorf("Could not read state: %w", err)
+		} else {
+			err = json.Unmarshal(item.Value, &state)
+			if err != nil {
+				return fmt.Errorf("Could not decode state: %w", err)
+			}
+		}
+
+		err = fn(&state)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("Could not encode state: %w", err)
+		}
+
+		if item == nil {
+			err = s.client.Add(&memcache.Item{Key: key, Value: data, Expiration: expiration(state)})
+		} else {
+			item.Value = data
+			item.Expiration = expiration(state)
+			err = s.client.CompareAndSwap(item)
+		}
+		if errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCASConflict) {
+			continue // somebody else got there first; try again
+		} else if err != nil {
+			return fmt.Errorf("Could not write state: %w", err)
+		}
+		return nil
+	}
+}
+
+// Determine the relative expiration for a state, in seconds
+func expiration(s ratelimit.State) int32 {
+	d := int64(time.Until(s.Reset)/time.Second) + 1
+	if d <= 0 {
+		return 1
+	} else if d > maxExpiration {
+		return maxExpiration
+	} else {
+		return int32(d)
+	}
+}
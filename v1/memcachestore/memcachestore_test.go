@@ -0,0 +1,82 @@
+package memcachestore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// A trivial in-memory memcached supporting compare-and-swap
+type fakeClient struct {
+	sync.Mutex
+	items map[string]memcache.Item
+	casid uint64
+}
+
+func (c *fakeClient) Get(key string) (*memcache.Item, error) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	return &v, nil
+}
+
+func (c *fakeClient) Add(item *memcache.Item) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.items[item.Key]; ok {
+		return memcache.ErrNotStored
+	}
+	c.casid++
+	item.CasID = c.casid
+	c.items[item.Key] = *item
+	return nil
+}
+
+func (c *fakeClient) CompareAndSwap(item *memcache.Item) error {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.items[item.Key]
+	if !ok {
+		return memcache.ErrNotStored
+	} else if v.CasID != item.CasID {
+		return memcache.ErrCASConflict
+	}
+	c.casid++
+	item.CasID = c.casid
+	c.items[item.Key] = *item
+	return nil
+}
+
+func TestShared(t *testing.T) {
+	store := New(&fakeClient{items: make(map[string]memcache.Item)}, "")
+	conf := ratelimit.Config{Window: time.Minute, Events: 10}
+	now := time.Date(2024, 4, 12, 0, 0, 30, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var permitted int
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lim := ratelimit.NewShared(conf, store, "example")
+			for j := 0; j < 4; j++ {
+				next, err := lim.Next(now)
+				if assert.NoError(t, err) && next.Equal(now) {
+					mu.Lock()
+					permitted++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, permitted)
+}
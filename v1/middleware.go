@@ -0,0 +1,271 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Server middleware configuration
+type GateConfig struct {
+	// Derives the key a request is limited under; if nil, requests are not keyed
+	Key func(*http.Request) string
+	// Decides whether a request is limited, exempt, or rejected outright; if nil, every request is limited
+	Bypass func(*http.Request) Decision
+	// Writes the body of responses to requests which are denied; if nil, a plain text body is written
+	Body BodyWriter
+	// Writes the entire response to requests which are denied; if set, it takes precedence over Body
+	Deny DenyHandler
+	// Admits requests which would be denied, so that limits may be evaluated before they are enforced
+	Shadow bool
+	// Observes the decision made for every request, e.g., to record metrics
+	Observe func(req *http.Request, st State, denied bool)
+}
+
+// With applies additional options to the receiver
+func (c GateConfig) With(opts []GateOption) GateConfig {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+// A functional gate option
+type GateOption func(GateConfig) GateConfig
+
+// WithRequestKey sets the function used to derive the key a request is
+// limited under, e.g., IPKey
+func WithRequestKey(fn func(*http.Request) string) GateOption {
+	return func(c GateConfig) GateConfig {
+		c.Key = fn
+		return c
+	}
+}
+
+// WithBypass sets the function which decides whether a request is limited,
+// exempt, or rejected outright, e.g., IPBypass
+func WithBypass(fn func(*http.Request) Decision) GateOption {
+	return func(c GateConfig) GateConfig {
+		c.Bypass = fn
+		return c
+	}
+}
+
+// WithBody sets the writer used to produce the body of denied responses
+func WithBody(b BodyWriter) GateOption {
+	return func(c GateConfig) GateConfig {
+		c.Body = b
+		return c
+	}
+}
+
+// WithDenyHandler sets the handler which writes the response to denied
+// requests, e.g., to use an established error envelope or another status
+func WithDenyHandler(h DenyHandler) GateOption {
+	return func(c GateConfig) GateConfig {
+		c.Deny = h
+		return c
+	}
+}
+
+// WithShadow puts a gate in shadow mode, in which decisions are made and
+// observed as usual, and admitted requests carry rate limit headers, but
+// requests which would be denied are admitted. Use WithObserver or a gate's
+// Stats to evaluate the limits.
+func WithShadow() GateOption {
+	return func(c GateConfig) GateConfig {
+		c.Shadow = true
+		return c
+	}
+}
+
+// WithObserver sets a function which observes the decision made for every
+// request, and whether it was denied, or would have been in shadow mode
+func WithObserver(fn func(req *http.Request, st State, denied bool)) GateOption {
+	return func(c GateConfig) GateConfig {
+		c.Observe = fn
+		return c
+	}
+}
+
+// GateStats counts the decisions made by a gate
+type GateStats struct {
+	// The number of requests the limiter permitted
+	Admitted uint64
+	// The number of requests the limiter denied, or which were rejected, including those admitted anyway in shadow mode
+	Denied uint64
+	// The number of requests which were exempt from limiting
+	Bypassed uint64
+}
+
+// A Gate admits or denies incoming HTTP requests under a limiter. A request is
+// admitted if the limiter permits an operation immediately; otherwise it is
+// denied with a 429 response, or whatever response the DenyHandler set via
+// WithDenyHandler writes. Either way, the response carries rate limit headers
+// describing the limiter's state.
+//
+// The limiter should be one that refreshes its own quota, such as one
+// created by NewShared with a memory store, optionally keyed:
+//
+//	store := NewMemoryStore()
+//	lim := NewKeyed(func(key string) Limiter {
+//		return NewShared(conf, store, key)
+//	})
+//	mux := Middleware(lim, WithRequestKey(IPKey(nil, 24, 64)))(mux)
+//
+// To evaluate limits against real traffic before enforcing them, a gate may
+// be run in shadow mode via WithShadow, in which every request is admitted.
+//
+// Requests may be exempted from limiting, or rejected outright with a 403
+// response, via WithBypass. Requests are also rejected when the limiter fails
+// with ErrRejected, as a keyed limiter with a bypass function does.
+type Gate struct {
+	lim      Limiter
+	conf     GateConfig
+	admitted atomic.Uint64
+	denied   atomic.Uint64
+	bypassed atomic.Uint64
+}
+
+func NewGate(lim Limiter, opts ...GateOption) *Gate {
+	conf := GateConfig{}.With(opts)
+	if conf.Body == nil {
+		conf.Body = defaultBody
+	}
+	return &Gate{
+		lim:  lim,
+		conf: conf,
+	}
+}
+
+// Describe the state of the limiter for a key
+func (g *Gate) state(key string, rel time.Time) State {
+	if k, ok := g.lim.(interface{ KeyState(string, time.Time) State }); ok {
+		return k.KeyState(key, rel)
+	}
+	return g.lim.State(rel)
+}
+
+// Admit determines if a request may proceed. If it may not, a response is
+// written and false is returned; the caller must not write to the response.
+// If the limiter fails, the request is admitted.
+func (g *Gate) Admit(w http.ResponseWriter, req *http.Request) bool {
+	decision := Enforce
+	if g.conf.Bypass != nil {
+		decision = g.conf.Bypass(req)
+	}
+	if decision == Allow {
+		g.bypassed.Add(1)
+		if g.conf.Observe != nil {
+			g.conf.Observe(req, State{}, false)
+		}
+		return true
+	}
+	var key string
+	if g.conf.Key != nil {
+		key = g.conf.Key(req)
+	}
+	now := time.Now()
+	var next time.Time
+	if decision != Reject {
+		var err error
+		next, err = g.next(now, key, req)
+		if errors.Is(err, ErrRejected) {
+			decision = Reject
+		} else if err != nil {
+			return true // fail open
+		}
+	}
+	if decision == Reject {
+		return g.reject(w, req)
+	}
+	st := g.state(key, now)
+	denied := next.After(now)
+	if denied {
+		g.denied.Add(1)
+	} else {
+		g.admitted.Add(1)
+	}
+	if g.conf.Observe != nil {
+		g.conf.Observe(req, st, denied)
+	}
+	if !denied || g.conf.Shadow {
+		WriteHeaders(w.Header(), now, st)
+		return true
+	}
+	if next.After(st.Reset) {
+		st.Reset = next // we are waiting for something other than a reset, like a backoff
+	}
+	if g.conf.Deny != nil {
+		WriteHeaders(w.Header(), now, st)
+		w.Header().Set("Retry-After", strconv.FormatInt(secondsUntil(now, next), 10))
+		g.conf.Deny(w, req, st, next)
+	} else {
+		WriteLimitExceededWith(w, st, g.conf.Body)
+	}
+	return false
+}
+
+// A limiter which counts the operations it would refuse against their keys,
+// as a keyed limiter with a penalty does
+type refuser interface {
+	refuse(rel time.Time, opts ...Option) (time.Time, error)
+}
+
+// Determine when a request may proceed. Quota is only consumed for requests
+// which may proceed immediately: when the limiter can, we peek first so that
+// requests which are denied do not spend quota needed by those which follow.
+// Denying a request still counts as an offense where the limiter keeps track.
+func (g *Gate) next(now time.Time, key string, req *http.Request) (time.Time, error) {
+	if p, ok := g.lim.(Peeker); ok {
+		peek := p.Peek
+		if r, ok := g.lim.(refuser); ok {
+			peek = r.refuse
+		}
+		next, err := peek(now, WithKey(key), WithRequest(req))
+		if err != nil || next.After(now) {
+			return next, err
+		}
+	}
+	return g.lim.Next(now, WithKey(key), WithRequest(req))
+}
+
+// Reject a request outright, regardless of quota, unless we are in shadow
+// mode
+func (g *Gate) reject(w http.ResponseWriter, req *http.Request) bool {
+	g.denied.Add(1)
+	if g.conf.Observe != nil {
+		g.conf.Observe(req, State{}, true)
+	}
+	if g.conf.Shadow {
+		return true
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// Stats counts the decisions the gate has made
+func (g *Gate) Stats() GateStats {
+	return GateStats{
+		Admitted: g.admitted.Load(),
+		Denied:   g.denied.Load(),
+		Bypassed: g.bypassed.Load(),
+	}
+}
+
+// Handler wraps an HTTP handler so that requests to it are limited
+func (g *Gate) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if g.Admit(w, req) {
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
+// Middleware produces standard HTTP middleware which limits requests under
+// the provided limiter. See Gate for details.
+func Middleware(lim Limiter, opts ...GateOption) func(http.Handler) http.Handler {
+	return NewGate(lim, opts...).Handler
+}
@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	store := NewMemoryStore()
+	lim := NewKeyed(func(key string) Limiter {
+		return NewShared(Config{Window: time.Hour, Events: 2}, store, key)
+	})
+	h := Middleware(lim, WithRequestKey(IPKey(nil, 24, 64)))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		Remote    string
+		Status    int
+		Remaining string
+	}{
+		{"203.0.113.1:1000", http.StatusNoContent, "1"},
+		{"203.0.113.2:1000", http.StatusNoContent, "0"},
+		{"203.0.113.3:1000", http.StatusTooManyRequests, "0"},
+		{"198.51.100.1:1000", http.StatusNoContent, "1"},
+	}
+	for i, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.Remote
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, req)
+		assert.Equal(t, e.Status, rsp.Code, "#%d", i)
+		assert.Equal(t, "2", rsp.Header().Get("RateLimit-Limit"), "#%d", i)
+		assert.Equal(t, e.Remaining, rsp.Header().Get("RateLimit-Remaining"), "#%d", i)
+		if e.Status == http.StatusTooManyRequests {
+			assert.NotEmpty(t, rsp.Header().Get("Retry-After"), "#%d", i)
+		}
+	}
+}
+
+func TestDenyHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		Deny        DenyHandler
+		Status      int
+		ContentType string
+		Body        string
+	}{
+		{nil, http.StatusTooManyRequests, "text/plain; charset=utf-8", "Too Many Requests\n"},
+		{DenyStatus(http.StatusServiceUnavailable, nil), http.StatusServiceUnavailable, "text/plain; charset=utf-8", "Service Unavailable\n"},
+		{DenyProblem(0, "Slow down"), http.StatusTooManyRequests, "application/problem+json", `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"Slow down","instance":"/things","retry_after":3600}` + "\n"},
+		{func(w http.ResponseWriter, req *http.Request, st State, retryAfter time.Time) {
+			w.Header().Del("Retry-After")
+			w.WriteHeader(http.StatusTeapot)
+		}, http.StatusTeapot, "", ""},
+	}
+	for i, e := range tests {
+		lim := NewShared(Config{Start: time.Now(), Window: time.Hour, Events: 1}, NewMemoryStore(), "")
+		h := Middleware(lim, WithDenyHandler(e.Deny))(ok)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/things", nil))
+
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, httptest.NewRequest("GET", "/things", nil))
+		assert.Equal(t, e.Status, rsp.Code, "#%d", i)
+		assert.Equal(t, e.ContentType, rsp.Header().Get("Content-Type"), "#%d", i)
+		assert.Equal(t, e.Body, rsp.Body.String(), "#%d", i)
+		assert.Equal(t, "0", rsp.Header().Get("RateLimit-Remaining"), "#%d", i)
+		if e.Status != http.StatusTeapot {
+			assert.Equal(t, "3600", rsp.Header().Get("Retry-After"), "#%d", i)
+		}
+	}
+}
+
+func TestShadow(t *testing.T) {
+	lim := NewShared(Config{Start: time.Now(), Window: time.Hour, Events: 1}, NewMemoryStore(), "")
+	var observed []bool
+	gate := NewGate(lim, WithShadow(), WithObserver(func(req *http.Request, st State, denied bool) {
+		observed = append(observed, denied)
+	}))
+	h := gate.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for i := 0; i < 3; i++ {
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusNoContent, rsp.Code, "#%d", i)
+		assert.Equal(t, "0", rsp.Header().Get("RateLimit-Remaining"), "#%d", i)
+		assert.Empty(t, rsp.Header().Get("Retry-After"), "#%d", i)
+	}
+	assert.Equal(t, []bool{false, true, true}, observed)
+	assert.Equal(t, GateStats{Admitted: 1, Denied: 2}, gate.Stats())
+}
+
+func TestBypass(t *testing.T) {
+	internal, _ := ParsePrefixes("10.0.0.0/8")
+	blocked, _ := ParsePrefixes("203.0.113.0/24")
+	lim := NewShared(Config{Start: time.Now(), Window: time.Hour, Events: 1}, NewMemoryStore(), "")
+	gate := NewGate(lim, WithBypass(IPBypass(nil, internal, blocked)))
+	h := gate.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		Remote string
+		Status int
+	}{
+		{"10.1.1.1:1234", http.StatusNoContent}, // exempt, consuming no quota
+		{"198.51.100.1:1234", http.StatusNoContent},
+		{"198.51.100.1:1234", http.StatusTooManyRequests},
+		{"10.1.1.1:1234", http.StatusNoContent}, // exempt, regardless of quota
+		{"203.0.113.7:1234", http.StatusForbidden},
+	}
+	for i, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.Remote
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, req)
+		assert.Equal(t, e.Status, rsp.Code, "#%d", i)
+	}
+	assert.Equal(t, GateStats{Admitted: 1, Denied: 2, Bypassed: 2}, gate.Stats())
+
+	// a keyed limiter may decide for itself
+	keyed := NewKeyed(func(key string) Limiter {
+		return NewShared(Config{Start: time.Now(), Window: time.Hour, Events: 1}, NewMemoryStore(), key)
+	})
+	keyed.SetBypass(func(attrs Attrs) Decision {
+		switch http.Header(attrs).Get("Authorization") {
+		case "admin":
+			return Allow
+		case "banned":
+			return Reject
+		default:
+			return Enforce
+		}
+	})
+	h = NewGate(keyed).Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for i, e := range []struct {
+		Auth   string
+		Status int
+	}{
+		{"admin", http.StatusNoContent},
+		{"admin", http.StatusNoContent},
+		{"banned", http.StatusForbidden},
+		{"", http.StatusNoContent},
+		{"", http.StatusTooManyRequests},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", e.Auth)
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, req)
+		assert.Equal(t, e.Status, rsp.Code, "#%d", i)
+	}
+}
+
+// Counts the operations which consume quota
+type consuming struct {
+	*shared
+	consumed int
+}
+
+func (l *consuming) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	l.consumed++
+	return l.shared.Next(rel, opts...)
+}
+
+func TestGateConsumesOnAdmit(t *testing.T) {
+	lim := &consuming{shared: NewShared(Config{Start: time.Now(), Window: time.Hour, Events: 1}, NewMemoryStore(), "")}
+	gate := NewGate(lim)
+	h := gate.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for i, e := range []int{http.StatusNoContent, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, e, rsp.Code, "#%d", i)
+	}
+	// requests which were denied did not consume quota
+	assert.Equal(t, 1, lim.consumed)
+	assert.Equal(t, GateStats{Admitted: 1, Denied: 2}, gate.Stats())
+}
+
+func TestGatePenalty(t *testing.T) {
+	start := time.Now()
+	lim := NewKeyed(func(key string) Limiter {
+		return NewShared(Config{Start: start, Window: time.Hour, Events: 1}, NewMemoryStore(), key)
+	}, WithPenalty(3, time.Hour, time.Hour))
+	gate := NewGate(lim, WithRequestKey(func(req *http.Request) string { return "a" }))
+	h := gate.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for i, e := range []int{http.StatusNoContent, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		rsp := httptest.NewRecorder()
+		h.ServeHTTP(rsp, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, e, rsp.Code, "#%d", i)
+	}
+	// requests which were denied count as offenses, which get the key banned
+	_, ok := lim.Banned("a", time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), lim.KeyedStats().Banned)
+}
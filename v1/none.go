@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// none implements a rate limiter which imposes no limit: every operation may
+// proceed immediately and updates are disregarded. It is useful where a
+// limiter is required but limiting is disabled, so that callers need not check
+// for one.
+type none struct{}
+
+// None creates a limiter which imposes no limit
+func None() *none {
+	return &none{}
+}
+
+// Clone produces another limiter which imposes no limit
+func (l *none) Clone() Limiter {
+	return None()
+}
+
+// If produces the provided limiter if limiting is enabled, and otherwise one
+// which imposes no limit, as None does, so that limiting can be toggled by
+// configuration:
+//
+//	lim := If(conf.Enabled, NewLinear(conf.Rate))
+//
+// A nil limiter also imposes no limit.
+func If(enabled bool, l Limiter) Limiter {
+	if !enabled || l == nil {
+		return None()
+	}
+	return l
+}
+
+func (l *none) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return rel, nil
+}
+
+func (l *none) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	return rel, nil
+}
+
+func (l *none) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	return rel, nil
+}
+
+func (l *none) Update(rel time.Time, opts ...Option) error {
+	return nil
+}
+
+// State describes no quota, since there is none
+func (l *none) State(time.Time) State {
+	return State{}
+}
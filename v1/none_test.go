@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNone(t *testing.T) {
+	now := time.Now()
+	lim := NewLinear(Config{Start: now, Window: time.Hour, Events: 1})
+	assert.Same(t, lim, If(true, lim))
+	assert.IsType(t, None(), If(false, lim))
+	assert.IsType(t, None(), If(true, nil))
+
+	off := If(false, lim)
+	for i := 0; i < 3; i++ {
+		next, err := off.Next(now)
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, now, next, "#%d", i)
+		}
+		next, err = off.Wait(context.Background(), now)
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, now, next, "#%d", i)
+		}
+		assert.NoError(t, off.Update(now), "#%d", i)
+	}
+	assert.Equal(t, State{}, off.State(now))
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A Pager paces a crawl over an estimated number of pages, such as a
+// paginated API listing, using a limiter. Each call to Next waits until the
+// next page may be fetched; responses are fed back via Update so that the
+// pacing of the remaining pages adjusts to what the service reports. The
+// estimate may be revised as the crawl proceeds, e.g., once a response
+// includes a total count.
+//
+//	pg := NewPager(lim, 100)
+//	for pg.More() {
+//		page, err := pg.Next(cxt)
+//		...
+//		pg.Update(time.Now(), WithResponse(rsp))
+//	}
+type Pager struct {
+	sync.Mutex
+	lim   Limiter
+	pages int
+	done  int
+}
+
+// NewPager creates a pager for the estimated number of pages
+func NewPager(lim Limiter, pages int) *Pager {
+	return &Pager{
+		lim:   lim,
+		pages: pages,
+	}
+}
+
+// SetPages revises the estimated number of pages in the crawl
+func (p *Pager) SetPages(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.pages = n
+}
+
+// Remaining returns the number of pages estimated to remain in the crawl
+func (p *Pager) Remaining() int {
+	p.Lock()
+	defer p.Unlock()
+	return max(0, p.pages-p.done)
+}
+
+// More reports whether pages are estimated to remain in the crawl
+func (p *Pager) More() bool {
+	return p.Remaining() > 0
+}
+
+// Next waits until the next page may be fetched and returns its index, from
+// zero.
+func (p *Pager) Next(cxt context.Context) (int, error) {
+	_, err := p.lim.Wait(cxt, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	n := p.done
+	p.done++
+	return n, nil
+}
+
+// Update delivers the result of fetching a page to the limiter
+func (p *Pager) Update(rel time.Time, opts ...Option) error {
+	return p.lim.Update(rel, opts...)
+}
+
+// Schedule returns the times at which the remaining pages are expected to be
+// fetched, relative to the provided time, given what is currently known about
+// the quota. If the limiter is not a Planner, no schedule can be produced and
+// nil is returned.
+func (p *Pager) Schedule(rel time.Time) []time.Time {
+	pl, ok := p.lim.(Planner)
+	if !ok {
+		return nil
+	}
+	times, _ := pl.Plan(rel, p.Remaining(), rel)
+	return times
+}
+
+// ETA returns the time at which the crawl is expected to complete, relative
+// to the provided time, and whether it could be estimated.
+func (p *Pager) ETA(rel time.Time) (time.Time, bool) {
+	if _, ok := p.lim.(Planner); !ok {
+		return time.Time{}, false
+	}
+	if times := p.Schedule(rel); len(times) > 0 {
+		return times[len(times)-1], true
+	} else {
+		return rel, true
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPager(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 100, Mode: Burst, ResetSemantics: Delta})
+	pg := NewPager(lim, 3)
+
+	var pages []int
+	for pg.More() {
+		n, err := pg.Next(context.Background())
+		if !assert.NoError(t, err) {
+			return
+		}
+		pages = append(pages, n)
+	}
+	assert.Equal(t, []int{0, 1, 2}, pages)
+	assert.Equal(t, 0, pg.Remaining())
+
+	// the estimate is revised and the service reports that little quota remains
+	now := time.Now()
+	pg.SetPages(5)
+	err := pg.Update(now, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"100"},
+		"Ratelimit-Remaining": []string{"1"},
+		"Ratelimit-Reset":     []string{"30"},
+	}))
+	if assert.NoError(t, err) {
+		reset := now.Add(time.Second * 30)
+		assert.Equal(t, []time.Time{now, reset}, pg.Schedule(now))
+		eta, ok := pg.ETA(now)
+		assert.True(t, ok)
+		assert.Equal(t, reset, eta)
+	}
+
+	_, ok := NewPager(NewShared(Config{Window: time.Minute, Events: 1}, NewMemoryStore(), "pager"), 1).ETA(now)
+	assert.False(t, ok, "a shared limiter cannot plan")
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// A Planner can determine when a batch of operations could be executed under
+// its current pacing without actually consuming any budget.
+//
+// Plan returns the times at which n operations would be permitted, starting
+// at the reference time, and whether all of them would be permitted by the
+// deadline.
+type Planner interface {
+	Plan(rel time.Time, n int, deadline time.Time) ([]time.Time, bool)
+}
+
+var (
+	_ Planner = (*headers)(nil)
+	_ Planner = (*linear)(nil)
+)
+
+// Plan simulates n operations against a copy of the limiter's state. When the
+// simulation passes the end of the current window, the quota is assumed to be
+// replenished in full, as if the service had reported a new window.
+func (l *headers) Plan(rel time.Time, n int, deadline time.Time) ([]time.Time, bool) {
+	sim := l.impl.clone()
+	times := make([]time.Time, 0, n)
+	t := rel
+	for i := 0; i < n; i++ {
+		for {
+			if sim.window > 0 && !t.Before(sim.reset) {
+				for !t.Before(sim.reset) {
+					sim.reset = sim.reset.Add(sim.window)
+				}
+				sim.remaining = float64(sim.limit)
+			}
+			r := sim.remaining
+			d, _, _, _, _ := sim.delay(t, true, pacing{})
+			t = t.Add(d)
+			// if nothing was consumed, we were waiting on a reset or backoff and
+			// must try again once it has passed
+			if d == 0 || sim.remaining < r {
+				break
+			}
+		}
+		times = append(times, t)
+	}
+	return times, n == 0 || !times[n-1].After(deadline)
+}
+
+// Plan computes the times at which n operations would be permitted, which are
+// successive slots after the reference time.
+func (l *linear) Plan(rel time.Time, n int, deadline time.Time) ([]time.Time, bool) {
+	times := make([]time.Time, 0, n)
+	t := rel
+	for i := 0; i < n; i++ {
+		var err error
+		t, err = l.Next(t)
+		if err != nil {
+			return times, false
+		}
+		times = append(times, t)
+	}
+	return times, n == 0 || !times[n-1].After(deadline)
+}
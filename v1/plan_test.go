@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 2, Mode: Burst})
+	times, ok := lim.Plan(now, 5, now.Add(time.Minute))
+	assert.Equal(t, []time.Time{now, now, now.Add(time.Minute), now.Add(time.Minute), now.Add(time.Minute * 2)}, times)
+	assert.False(t, ok)
+	_, ok = lim.Plan(now, 4, now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 2, lim.State(now).Remaining) // planning consumes nothing
+
+	lin := NewLinear(Config{Start: now, Window: time.Minute, Events: 6})
+	times, ok = lin.Plan(now, 3, now.Add(time.Second*30))
+	assert.Equal(t, []time.Time{now.Add(time.Second * 10), now.Add(time.Second * 20), now.Add(time.Second * 30)}, times)
+	assert.True(t, ok)
+}
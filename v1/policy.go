@@ -0,0 +1,362 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// A RateLimitPolicy describes a quota policy advertised by a service in the
+// 'RateLimit-Policy' header, which is a structured field (RFC 8941):
+//
+//	RateLimit-Policy: "burst";q=100;w=60, "daily";q=1000;w=86400
+//
+// The forms used by earlier revisions of the draft, in which a policy is an
+// unnamed quota or a dictionary member, are also understood:
+//
+//	RateLimit-Policy: 100;w=60, 1000;w=86400
+//	RateLimit-Policy: burst;q=100;w=60
+type RateLimitPolicy struct {
+	// The name of the policy, if it is named
+	Name string
+	// The quota permitted in each window
+	Quota int
+	// The duration of a window, if provided
+	Window time.Duration
+	// The unit of the quota, e.g., 'requests' or 'content-bytes', if provided
+	QuotaUnit string
+	// The partition key, which identifies the client's partition of the quota, if provided
+	PartitionKey []byte
+	// A comment describing the policy, if provided
+	Comment string
+	// Every parameter of the policy, including those not described above
+	Params map[string]any
+}
+
+// A RateLimitQuota describes the state of a quota reported by a service in
+// the 'RateLimit' header, which is a structured field (RFC 8941):
+//
+//	RateLimit: "default";r=50;t=30
+//
+// The form used by earlier revisions of the draft, in which the limit, the
+// remaining quota, and the reset are members of a single dictionary, is also
+// understood:
+//
+//	RateLimit: limit=100, remaining=50, reset=30
+type RateLimitQuota struct {
+	// The name of the policy the quota is governed by, if it is named
+	Policy string
+	// The quota permitted in each window, if provided
+	Limit int
+	// The quota remaining in the current window
+	Remaining int
+	// The time until the window resets
+	Reset time.Duration
+	// The partition key, which identifies the client's partition of the quota, if provided
+	PartitionKey []byte
+	// A comment describing the quota, if provided
+	Comment string
+	// Every parameter of the quota, including those not described above
+	Params map[string]any
+}
+
+// Parameter values are interpreted leniently: an integer may also be provided
+// as a decimal, and a string as a token
+func sfInt(v any) (int, bool) {
+	switch x := v.(type) {
+	case int64:
+		return int(x), true
+	case float64:
+		return int(x), true
+	}
+	return 0, false
+}
+
+func sfSeconds(v any) (time.Duration, bool) {
+	switch x := v.(type) {
+	case int64:
+		return time.Duration(x) * time.Second, true
+	case float64:
+		return time.Duration(x * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+func sfString(v any) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case sfToken:
+		return string(x), true
+	}
+	return "", false
+}
+
+// Parse a header which may be either a dictionary or a list. A dictionary is
+// attempted first, since list members which happen to look like dictionary
+// members are unusual, whereas a dictionary never parses as a list.
+func parseSFMembers(v string) ([]sfMember, error) {
+	if m, err := parseSFDictionary(v); err == nil {
+		return m, nil
+	}
+	return parseSFList(v)
+}
+
+// Determine the name of a member: its key, for a dictionary member, or its
+// value, for a list member which is a string or token
+func (m sfMember) name() string {
+	if m.key != "" {
+		return m.key
+	}
+	s, _ := sfString(m.value)
+	return s
+}
+
+// ParseRateLimitPolicy parses the value of a 'RateLimit-Policy' header
+func ParseRateLimitPolicy(v string) ([]RateLimitPolicy, error) {
+	members, err := parseSFMembers(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: RateLimit-Policy = %s: %v", ErrInvalidHeaders, v, err)
+	}
+	res := make([]RateLimitPolicy, 0, len(members))
+	for _, e := range members {
+		p := RateLimitPolicy{Name: e.name(), Params: e.params}
+		var ok bool
+		if p.Quota, ok = sfInt(e.value); ok {
+			p.Name = e.key
+		} else if p.Quota, ok = sfInt(e.params["q"]); !ok {
+			return nil, fmt.Errorf("%w: RateLimit-Policy = %s: policy %q has no quota", ErrInvalidHeaders, v, p.Name)
+		}
+		p.Window, _ = sfSeconds(e.params["w"])
+		p.QuotaUnit, _ = sfString(e.params["qu"])
+		p.PartitionKey, _ = e.params["pk"].([]byte)
+		p.Comment, _ = sfString(e.params["comment"])
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// ParseRateLimit parses the value of a 'RateLimit' header
+func ParseRateLimit(v string) ([]RateLimitQuota, error) {
+	members, err := parseSFMembers(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: RateLimit = %s: %v", ErrInvalidHeaders, v, err)
+	}
+
+	// the earlier form describes a single quota as a dictionary
+	if i := sfIndex(members, "remaining"); i >= 0 {
+		var q RateLimitQuota
+		var ok bool
+		if q.Remaining, ok = sfInt(members[i].value); !ok {
+			return nil, fmt.Errorf("%w: RateLimit = %s: remaining quota is not a number", ErrInvalidHeaders, v)
+		}
+		if i := sfIndex(members, "limit"); i >= 0 {
+			q.Limit, _ = sfInt(members[i].value)
+		}
+		if i := sfIndex(members, "reset"); i >= 0 {
+			q.Reset, _ = sfSeconds(members[i].value)
+		}
+		return []RateLimitQuota{q}, nil
+	}
+
+	res := make([]RateLimitQuota, 0, len(members))
+	for _, e := range members {
+		q := RateLimitQuota{Policy: e.name(), Params: e.params}
+		var ok bool
+		if q.Remaining, ok = sfInt(e.params["r"]); !ok {
+			return nil, fmt.Errorf("%w: RateLimit = %s: quota %q has no remaining value", ErrInvalidHeaders, v, q.Policy)
+		}
+		q.Limit, _ = sfInt(e.params["q"])
+		q.Reset, _ = sfSeconds(e.params["t"])
+		q.PartitionKey, _ = e.params["pk"].([]byte)
+		q.Comment, _ = sfString(e.params["comment"])
+		res = append(res, q)
+	}
+	return res, nil
+}
+
+// PolicyState describes the state of one of several quota policies which a
+// service enforces at the same time
+type PolicyState struct {
+	// The name of the policy, if it is named
+	Name string
+	// The duration of the policy's window, if known
+	Window time.Duration
+	State
+}
+
+// The quota policy state tracked by a limiter
+type policy struct {
+	name      string
+	window    time.Duration
+	limit     int
+	remaining float64
+	reset     time.Time
+}
+
+// Parse the structured 'RateLimit' header and, if present, the
+// 'RateLimit-Policy' header which describes the policies it refers to. The
+// quotas are matched to policies by name, or by position when unnamed.
+func parseStructuredHeaders(rel time.Time, v string, attrs Attrs) ([]PolicyState, error) {
+	quotas, err := ParseRateLimit(v)
+	if err != nil {
+		return nil, err
+	}
+	var defs []RateLimitPolicy
+	if _, v := findAttr(attrs, policyHeaders); v != "" {
+		defs, err = ParseRateLimitPolicy(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res := make([]PolicyState, 0, len(quotas))
+	for i, e := range quotas {
+		p := PolicyState{Name: e.Policy, State: State{Limit: e.Limit, Remaining: e.Remaining, Reset: rel.Add(e.Reset)}}
+		for j, d := range defs {
+			if (e.Policy != "" && d.Name == e.Policy) || (e.Policy == "" && d.Name == "" && i == j) {
+				p.Window = d.Window
+				if p.Limit == 0 {
+					p.Limit = d.Quota
+				}
+				break
+			}
+		}
+		if p.Limit == 0 {
+			p.Limit = e.Remaining // the limit is unknown; assume none of the quota has been consumed
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// Produce the policy state tracked by a limiter from reported policies; when
+// the quota is shared, we are entitled to our share of each
+func policiesOf(ps []PolicyState, shares int) []policy {
+	res := make([]policy, len(ps))
+	for i, e := range ps {
+		res[i] = policy{name: e.Name, window: e.Window, limit: e.Limit / shares, remaining: float64(e.Remaining) / float64(shares), reset: e.Reset}
+	}
+	return res
+}
+
+// Determine the policy which constrains us most at the reference time: one
+// which is exhausted, with the latest reset among those, or otherwise the one
+// which permits the lowest rate over the remainder of its window. Policies
+// whose windows have ended are not considered unless all have.
+func binding(rel time.Time, ps []policy) int {
+	b, rate := -1, math.Inf(1)
+	for i, e := range ps {
+		r := e.reset.Sub(rel)
+		if r <= 0 {
+			continue
+		}
+		v := math.Max(0, e.remaining) / r.Seconds()
+		if v < rate || (v == rate && v == 0 && e.reset.After(ps[b].reset)) {
+			b, rate = i, v
+		}
+	}
+	return max(b, 0)
+}
+
+// Replace the policies we track with those provided, when there are several
+// of them; the caller must hold the policy lock
+func (l *headers) setPolicies(ps []policy, enforced int) {
+	if len(ps) > 1 {
+		l.policies, l.enforced = ps, enforced
+	} else {
+		l.policies, l.enforced = nil, 0
+	}
+}
+
+// Compute the delay before the next operation when several policies apply.
+// The policy which is most constraining is enforced by the underlying
+// limiter; when another becomes more constraining, as their windows progress
+// and the operations we perform consume all of them, we switch to it. If
+// several policies do not apply, this does nothing and reports as much.
+func (l *headers) delayPolicies(rel time.Time, pc pacing) (time.Duration, slot, bool, error) {
+	l.pmu.Lock()
+	defer l.pmu.Unlock()
+	if len(l.policies) < 2 {
+		return 0, slot{}, false, nil
+	}
+
+	// the underlying limiter's state is authoritative for the policy it enforces
+	l.impl.Lock()
+	l.policies[l.enforced].remaining = l.impl.remaining
+	l.impl.Unlock()
+	replenish(rel, l.policies)
+	l.enforced = binding(rel, l.policies)
+	p := l.policies[l.enforced]
+	l.impl.Lock()
+	l.impl.set(p.limit, p.remaining, p.reset)
+	l.impl.Unlock()
+
+	d, s, err := l.impl.acquire(rel, pc)
+	if err != nil {
+		return 0, slot{}, true, err
+	}
+	// an operation consumes every policy, not just the one we enforce
+	if s.taken {
+		for i := range l.policies {
+			l.policies[i].remaining = math.Max(0, l.policies[i].remaining-1)
+		}
+	}
+	return d, s, true, nil
+}
+
+// Compute the delay before the next operation when several policies apply, as
+// delayPolicies does, and whether it is the result of the quota being
+// exhausted, without consuming any quota or switching the policy which is
+// enforced. If several policies do not apply, this does nothing and reports
+// as much.
+func (l *headers) peekPolicies(rel time.Time, pc pacing) (time.Duration, bool, bool) {
+	l.pmu.Lock()
+	defer l.pmu.Unlock()
+	if len(l.policies) < 2 {
+		return 0, false, false
+	}
+
+	ps := make([]policy, len(l.policies))
+	copy(ps, l.policies)
+	l.impl.Lock()
+	ps[l.enforced].remaining = l.impl.remaining
+	l.impl.Unlock()
+	replenish(rel, ps)
+	p := ps[binding(rel, ps)]
+
+	d, x := l.impl.peekAs(rel, pc, p.limit, p.remaining, p.reset)
+	return d, x, true
+}
+
+// Replenish the policies whose windows have ended at the reference time, if
+// we know their windows
+func replenish(rel time.Time, ps []policy) {
+	for i, e := range ps {
+		if e.window > 0 && !e.reset.After(rel) {
+			n := rel.Sub(e.reset)/e.window + 1
+			ps[i].reset = e.reset.Add(n * e.window)
+			ps[i].remaining = float64(e.limit)
+		}
+	}
+}
+
+// Policies describes each of the quota policies the service reported in its
+// most recent update, when it reported several; the most constraining of
+// them is enforced and is the one described by State. If the service reported
+// one policy or none, the result is empty.
+func (l *headers) Policies(rel time.Time) []PolicyState {
+	l.pmu.Lock()
+	defer l.pmu.Unlock()
+	if len(l.policies) == 0 {
+		return nil
+	}
+	res := make([]PolicyState, len(l.policies))
+	for i, e := range l.policies {
+		st := State{Limit: e.limit, Remaining: int(e.remaining), Reset: e.reset}
+		if i == l.enforced {
+			st = l.impl.State()
+		}
+		res[i] = PolicyState{Name: e.name, Window: e.window, State: st}
+	}
+	return res
+}
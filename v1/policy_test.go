@@ -0,0 +1,222 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStructuredFields(t *testing.T) {
+	tests := []struct {
+		Value  string
+		Dict   bool
+		Expect []sfMember
+		Err    bool
+	}{
+		{`1, 2.5, "a \"b\"", tok/en:x, :aGk=:, ?0`, false, []sfMember{
+			{sfItem: sfItem{value: int64(1)}},
+			{sfItem: sfItem{value: 2.5}},
+			{sfItem: sfItem{value: `a "b"`}},
+			{sfItem: sfItem{value: sfToken("tok/en:x")}},
+			{sfItem: sfItem{value: []byte("hi")}},
+			{sfItem: sfItem{value: false}},
+		}, false},
+		{`(1 2);a;b=?1, -3;c="x"`, false, []sfMember{
+			{sfItem: sfItem{value: []sfItem{{value: int64(1)}, {value: int64(2)}}, params: map[string]any{"a": true, "b": true}}},
+			{sfItem: sfItem{value: int64(-3), params: map[string]any{"c": "x"}}},
+		}, false},
+		{`a=1, b;x=2, a=3`, true, []sfMember{
+			{key: "a", sfItem: sfItem{value: int64(3)}},
+			{key: "b", sfItem: sfItem{value: true, params: map[string]any{"x": int64(2)}}},
+		}, false},
+		{``, false, nil, false},
+		{`1,`, false, nil, true},
+		{`1 2`, false, nil, true},
+		{`"unterminated`, false, nil, true},
+		{`1.2345`, false, nil, true},
+		{`1234567890123456`, false, nil, true},
+		{`:not base64:`, false, nil, true},
+		{`(1 2`, false, nil, true},
+		{`A=1`, true, nil, true},
+	}
+	for _, e := range tests {
+		t.Run(e.Value, func(t *testing.T) {
+			var res []sfMember
+			var err error
+			if e.Dict {
+				res, err = parseSFDictionary(e.Value)
+			} else {
+				res, err = parseSFList(e.Value)
+			}
+			if e.Err {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, res)
+			}
+		})
+	}
+}
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	tests := []struct {
+		Value  string
+		Expect []RateLimitPolicy
+		Err    error
+	}{
+		{
+			`"burst";q=100;w=60, "daily";q=1000;w=86400;pk=:cHsx:;comment="per day"`,
+			[]RateLimitPolicy{
+				{Name: "burst", Quota: 100, Window: time.Minute, Params: map[string]any{"q": int64(100), "w": int64(60)}},
+				{Name: "daily", Quota: 1000, Window: time.Hour * 24, PartitionKey: []byte("p{1"), Comment: "per day", Params: map[string]any{"q": int64(1000), "w": int64(86400), "pk": []byte("p{1"), "comment": "per day"}},
+			},
+			nil,
+		},
+		{
+			`100;w=60, 5000;w=3600;qu="requests"`,
+			[]RateLimitPolicy{
+				{Quota: 100, Window: time.Minute, Params: map[string]any{"w": int64(60)}},
+				{Quota: 5000, Window: time.Hour, QuotaUnit: "requests", Params: map[string]any{"w": int64(3600), "qu": "requests"}},
+			},
+			nil,
+		},
+		{
+			`burst;q=100;w=60`,
+			[]RateLimitPolicy{{Name: "burst", Quota: 100, Window: time.Minute, Params: map[string]any{"q": int64(100), "w": int64(60)}}},
+			nil,
+		},
+		{`"burst";w=60`, nil, ErrInvalidHeaders},
+		{`100;w=`, nil, ErrInvalidHeaders},
+	}
+	for _, e := range tests {
+		t.Run(e.Value, func(t *testing.T) {
+			res, err := ParseRateLimitPolicy(e.Value)
+			if e.Err != nil {
+				assert.ErrorIs(t, err, e.Err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, res)
+			}
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		Value  string
+		Expect []RateLimitQuota
+		Err    error
+	}{
+		{
+			`"default";r=50;t=30, "daily";r=900;t=3600`,
+			[]RateLimitQuota{
+				{Policy: "default", Remaining: 50, Reset: time.Second * 30, Params: map[string]any{"r": int64(50), "t": int64(30)}},
+				{Policy: "daily", Remaining: 900, Reset: time.Hour, Params: map[string]any{"r": int64(900), "t": int64(3600)}},
+			},
+			nil,
+		},
+		{
+			`limit=100, remaining=50, reset=2.5`,
+			[]RateLimitQuota{{Limit: 100, Remaining: 50, Reset: time.Millisecond * 2500}},
+			nil,
+		},
+		{`"default";t=30`, nil, ErrInvalidHeaders},
+		{`remaining="lots"`, nil, ErrInvalidHeaders},
+		{`"default";r=50,`, nil, ErrInvalidHeaders},
+	}
+	for _, e := range tests {
+		t.Run(e.Value, func(t *testing.T) {
+			res, err := ParseRateLimit(e.Value)
+			if e.Err != nil {
+				assert.ErrorIs(t, err, e.Err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, res)
+			}
+		})
+	}
+}
+
+func FuzzParseRateLimit(f *testing.F) {
+	f.Add(`"default";r=50;t=30`)
+	f.Add(`limit=100, remaining=50, reset=30`)
+	f.Add(`100;w=60, 5000;w=3600;pk=:cHsx:`)
+	f.Add(`(1 "a" tok);x=?1, -1.5`)
+	f.Fuzz(func(t *testing.T, v string) {
+		// any input is either parsed or rejected; nothing panics
+		ParseRateLimit(v)
+		ParseRateLimitPolicy(v)
+	})
+}
+
+func TestHeadersPolicies(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst})
+	res, err := lim.UpdateEx(now, WithAttrs(Attrs{
+		"Ratelimit-Policy": {`"minute";q=10;w=60, "hour";q=100;w=3600`},
+		"Ratelimit":        {`"minute";r=2;t=30, "hour";r=50;t=1800`},
+	}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, res.Headers.Policies, 2)
+	// the hourly policy permits the lowest rate over the remainder of its window
+	assert.Equal(t, State{Limit: 100, Remaining: 50, Reset: now.Add(time.Second * 1800)}, lim.State(now))
+
+	tests := []struct {
+		Rel    time.Time
+		Expect time.Time
+	}{
+		{now, now},
+		{now, now},
+		{now, now.Add(time.Second * 30)}, // the minute policy is exhausted and is now enforced
+		{now.Add(time.Second * 31), now.Add(time.Second * 31)},
+	}
+	for i, e := range tests {
+		next, err := lim.Next(e.Rel)
+		if assert.NoError(t, err) {
+			assert.Equal(t, e.Expect, next, "#%d", i)
+		}
+	}
+
+	// every policy is consumed; the minute policy was replenished when its window ended
+	assert.Equal(t, []PolicyState{
+		{Name: "minute", Window: time.Minute, State: State{Limit: 10, Remaining: 9, Reset: now.Add(time.Second * 90)}},
+		{Name: "hour", Window: time.Hour, State: State{Limit: 100, Remaining: 47, Reset: now.Add(time.Second * 1800)}},
+	}, lim.Policies(now.Add(time.Second*31)))
+
+	// a single policy is tracked as usual
+	_, err = lim.UpdateEx(now, WithAttrs(Attrs{"Ratelimit": {`limit=10, remaining=5, reset=30`}}))
+	assert.NoError(t, err)
+	assert.Nil(t, lim.Policies(now))
+	assert.Equal(t, State{Limit: 10, Remaining: 5, Reset: now.Add(time.Second * 30)}, lim.State(now))
+}
+
+func TestHeadersPoliciesPeek(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst, MaxWait: time.Second * 10})
+	err := lim.Update(now, WithAttrs(Attrs{
+		"Ratelimit-Policy": {`"minute";q=10;w=60, "hour";q=100;w=3600`},
+		"Ratelimit":        {`"minute";r=2;t=30, "hour";r=50;t=1800`},
+	}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		next, err := lim.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now, next, "#%d", i)
+		}
+	}
+
+	// the hourly policy is enforced, but the minute policy is exhausted and binds
+	next, err := lim.Peek(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second*30), next)
+	}
+	assert.Equal(t, time.Second*30, lim.EstimatedWait(now))
+	_, err = lim.Wait(context.Background(), now)
+	assert.ErrorIs(t, err, ErrOverloaded)
+
+	// peeking neither consumed quota nor switched the policy enforced
+	assert.Equal(t, State{Limit: 100, Remaining: 48, Reset: now.Add(time.Second * 1800)}, lim.State(now))
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// A Projector can project its state to a future time, assuming that no
+// operations are performed in the meantime.
+type Projector interface {
+	Project(t time.Time) State
+}
+
+var (
+	_ Projector = (*headers)(nil)
+	_ Projector = (*linear)(nil)
+	_ Projector = (*shared)(nil)
+)
+
+// Project returns the state as it would be at the provided time if no
+// operations were performed in the meantime. If the time is after the reset,
+// the quota is assumed to be replenished in full and the reset is advanced by
+// whole windows of the provided duration. If the window is not known, the
+// reset cannot be advanced and remains as it was.
+func (s State) Project(t time.Time, window time.Duration) State {
+	if t.Before(s.Reset) {
+		return s
+	}
+	p := State{Limit: s.Limit, Remaining: s.Limit, Reset: s.Reset}
+	if window > 0 {
+		n := t.Sub(s.Reset)/window + 1
+		p.Reset = s.Reset.Add(n * window)
+	}
+	return p
+}
+
+// Capacity returns the number of operations which could be performed from
+// now until the provided time: those remaining in the current window plus
+// the full quota of every window which begins by that time.
+func (s State) Capacity(t time.Time, window time.Duration) int {
+	if t.Before(s.Reset) {
+		return s.Remaining
+	}
+	n := 1
+	if window > 0 {
+		n += int(t.Sub(s.Reset) / window)
+	}
+	return s.Remaining + n*s.Limit
+}
+
+// Project returns the state as it would be at the provided time. The quota
+// is assumed to be replenished at the end of each window, as if the service
+// had reported a new window.
+func (l *headers) Project(t time.Time) State {
+	l.impl.Lock()
+	w := l.impl.window
+	l.impl.Unlock()
+	return l.impl.State().Project(t, w)
+}
+
+// Project returns the state as it would be at the provided time
+func (l *linear) Project(t time.Time) State {
+	return l.State(t)
+}
+
+// Project returns the state as it would be at the provided time. If the
+// state cannot be read from the store, a zero State is returned.
+func (l *shared) Project(t time.Time) State {
+	return l.State(t)
+}
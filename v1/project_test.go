@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProject(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	reset := now.Add(time.Minute)
+	st := State{Limit: 100, Remaining: 10, Reset: reset, Low: true}
+	tests := []struct {
+		Name     string
+		When     time.Time
+		Window   time.Duration
+		Expect   State
+		Capacity int
+	}{
+		{"Current", now.Add(time.Second * 30), time.Minute, st, 10},
+		{"Reset", reset, time.Minute, State{Limit: 100, Remaining: 100, Reset: reset.Add(time.Minute)}, 110},
+		{"Later", now.Add(time.Minute * 10), time.Minute, State{Limit: 100, Remaining: 100, Reset: now.Add(time.Minute * 11)}, 1010},
+		{"Unknown", now.Add(time.Minute * 10), 0, State{Limit: 100, Remaining: 100, Reset: reset}, 110},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			assert.Equal(t, e.Expect, st.Project(e.When, e.Window))
+			assert.Equal(t, e.Capacity, st.Capacity(e.When, e.Window))
+		})
+	}
+
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100})
+	lim.impl.Update(100, 10, reset)
+	assert.Equal(t, State{Limit: 100, Remaining: 100, Reset: now.Add(time.Minute * 3)}, lim.Project(now.Add(time.Minute*2)))
+}
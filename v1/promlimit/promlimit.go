@@ -0,0 +1,156 @@
+// Package promlimit adapts rate limiters to Prometheus. A Collector reports
+// the state of every limiter in a registry when it is scraped, and counts the
+// waits, delays, backoffs, and throttled responses of limiters it instruments,
+// under standard metric names labeled by limiter name, so that dashboards are
+// portable:
+//
+//	coll := promlimit.NewCollector(nil)
+//	prometheus.MustRegister(coll)
+//	ratelimit.Register("github", coll.Instrument("github", ratelimit.NewHeaders(conf)))
+package promlimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The label which identifies a limiter by name
+const labelLimiter = "limiter"
+
+// The bounds of the buckets into which delays are counted, in seconds, from a
+// millisecond to several minutes
+var delayBuckets = prometheus.ExponentialBuckets(0.001, 4, 10)
+
+// A Collector is a prometheus.Collector which reports the state of the
+// limiters in a registry, as gauges, every time it is collected:
+//
+//	ratelimit_limit              the events permitted in the current window
+//	ratelimit_remaining          the events remaining in the current window
+//	ratelimit_reset_seconds      the seconds until the window resets
+//
+// and counts the operations performed on limiters instrumented via
+// Instrument:
+//
+//	ratelimit_waits_total                the calls to Wait
+//	ratelimit_delay_seconds              the delay imposed by each call to Wait
+//	ratelimit_backoffs_total             the updates which requested a backoff
+//	ratelimit_throttled_updates_total    the updates from 429 responses
+type Collector struct {
+	reg       *ratelimit.Registry
+	limit     *prometheus.Desc
+	remaining *prometheus.Desc
+	reset     *prometheus.Desc
+	waits     *prometheus.CounterVec
+	delay     *prometheus.HistogramVec
+	backoffs  *prometheus.CounterVec
+	throttled *prometheus.CounterVec
+}
+
+// NewCollector creates a collector which reports the state of the limiters
+// in the provided registry, or the default registry if it is nil
+func NewCollector(reg *ratelimit.Registry) *Collector {
+	if reg == nil {
+		reg = ratelimit.DefaultRegistry
+	}
+	labels := []string{labelLimiter}
+	return &Collector{
+		reg:       reg,
+		limit:     prometheus.NewDesc("ratelimit_limit", "The events permitted in the current window.", labels, nil),
+		remaining: prometheus.NewDesc("ratelimit_remaining", "The events remaining in the current window.", labels, nil),
+		reset:     prometheus.NewDesc("ratelimit_reset_seconds", "The seconds until the current window resets.", labels, nil),
+		waits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ratelimit_waits_total",
+			Help: "The number of calls to Wait.",
+		}, labels),
+		delay: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ratelimit_delay_seconds",
+			Help:    "The delay imposed on operations by Wait.",
+			Buckets: delayBuckets,
+		}, labels),
+		backoffs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ratelimit_backoffs_total",
+			Help: "The number of updates which requested a backoff.",
+		}, labels),
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ratelimit_throttled_updates_total",
+			Help: "The number of updates from responses with status 429 Too Many Requests.",
+		}, labels),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.limit
+	ch <- c.remaining
+	ch <- c.reset
+	c.waits.Describe(ch)
+	c.delay.Describe(ch)
+	c.backoffs.Describe(ch)
+	c.throttled.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, e := range c.reg.Limiters() {
+		st := e.Limiter.State(now)
+		ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, float64(st.Limit), e.Name)
+		ch <- prometheus.MustNewConstMetric(c.remaining, prometheus.GaugeValue, float64(max(0, st.Remaining)), e.Name)
+		var reset float64
+		if !st.Reset.IsZero() {
+			reset = max(0, st.Reset.Sub(now).Seconds())
+		}
+		ch <- prometheus.MustNewConstMetric(c.reset, prometheus.GaugeValue, reset, e.Name)
+	}
+	c.waits.Collect(ch)
+	c.delay.Collect(ch)
+	c.backoffs.Collect(ch)
+	c.throttled.Collect(ch)
+}
+
+// Instrument wraps a limiter so that the operations performed on it are
+// counted under the provided name, which should be the name it is registered
+// under, if any. The wrapper provides only the Limiter interface.
+func (c *Collector) Instrument(name string, lim ratelimit.Limiter) ratelimit.Limiter {
+	return &instrumented{
+		Limiter:   lim,
+		waits:     c.waits.WithLabelValues(name),
+		delay:     c.delay.WithLabelValues(name),
+		backoffs:  c.backoffs.WithLabelValues(name),
+		throttled: c.throttled.WithLabelValues(name),
+	}
+}
+
+// instrumented wraps a limiter and counts the operations performed on it
+type instrumented struct {
+	ratelimit.Limiter
+	waits     prometheus.Counter
+	delay     prometheus.Observer
+	backoffs  prometheus.Counter
+	throttled prometheus.Counter
+}
+
+func (l *instrumented) Wait(cxt context.Context, rel time.Time, opts ...ratelimit.Option) (time.Time, error) {
+	l.waits.Inc()
+	t, err := l.Limiter.Wait(cxt, rel, opts...)
+	if err == nil {
+		l.delay.Observe(max(0, t.Sub(rel).Seconds()))
+	}
+	return t, err
+}
+
+func (l *instrumented) Update(rel time.Time, opts ...ratelimit.Option) error {
+	if (ratelimit.Options{}).With(opts).Status == http.StatusTooManyRequests {
+		l.throttled.Inc()
+	}
+	err := l.Limiter.Update(rel, opts...)
+	if errors.Is(err, ratelimit.ErrBackoff) {
+		l.backoffs.Inc()
+	}
+	return err
+}
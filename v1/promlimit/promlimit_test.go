@@ -0,0 +1,47 @@
+package promlimit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	now := time.Now()
+	reg := ratelimit.NewRegistry()
+	coll := NewCollector(reg)
+	lim := coll.Instrument("example", ratelimit.NewHeaders(ratelimit.Config{Start: now, Window: time.Minute, Events: 10, Mode: ratelimit.Burst}))
+	reg.Register("example", lim)
+
+	for i := 0; i < 3; i++ {
+		_, err := lim.Wait(context.Background(), time.Now())
+		assert.NoError(t, err, "#%d", i)
+	}
+	err := lim.Update(now, ratelimit.WithStatus(http.StatusTooManyRequests), ratelimit.WithAttrs(ratelimit.Attrs{
+		"Retry-After": []string{"1"},
+	}))
+	assert.ErrorIs(t, err, ratelimit.ErrBackoff)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(coll.waits))
+	assert.Equal(t, 1.0, testutil.ToFloat64(coll.backoffs))
+	assert.Equal(t, 1.0, testutil.ToFloat64(coll.throttled))
+
+	expect := `
+# HELP ratelimit_limit The events permitted in the current window.
+# TYPE ratelimit_limit gauge
+ratelimit_limit{limiter="example"} 10
+# HELP ratelimit_remaining The events remaining in the current window.
+# TYPE ratelimit_remaining gauge
+ratelimit_remaining{limiter="example"} 7
+`
+	err = testutil.CollectAndCompare(coll, strings.NewReader(expect), "ratelimit_limit", "ratelimit_remaining")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(coll, "ratelimit_reset_seconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(coll, "ratelimit_delay_seconds"))
+}
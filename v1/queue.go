@@ -0,0 +1,264 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+)
+
+// What a queue does with an item which is enqueued when it is full
+type Overflow int
+
+const (
+	OverflowError Overflow = iota // the item is rejected and Enqueue fails with ErrQueueFull
+	DropOldest                    // the oldest queued item is dropped, failing with ErrDropped, to make room
+	DropNewest                    // the item is dropped and fails with ErrDropped, but Enqueue succeeds
+)
+
+// Queue configuration
+type QueueConfig struct {
+	// The maximum number of items waiting to be dispatched; if <= 0, the queue is unbounded
+	MaxLength int
+	// What happens when an item is enqueued when the queue is full
+	Overflow Overflow
+	// The maximum number of items which may execute concurrently; defaults to 1
+	Concurrency int
+	// The maximum number of times an item is attempted when it is rate limited; if <= 0, there is no limit
+	Attempts int
+}
+
+// With applies additional options to the receiver
+func (c QueueConfig) With(opts []QueueOption) QueueConfig {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+// A functional queue option
+type QueueOption func(QueueConfig) QueueConfig
+
+// WithMaxLength bounds the number of items waiting to be dispatched and sets
+// what happens when an item is enqueued when the queue is full
+func WithMaxLength(n int, overflow Overflow) QueueOption {
+	return func(c QueueConfig) QueueConfig {
+		c.MaxLength = n
+		c.Overflow = overflow
+		return c
+	}
+}
+
+// WithQueueConcurrency sets the number of items which may execute concurrently
+func WithQueueConcurrency(n int) QueueOption {
+	return func(c QueueConfig) QueueConfig {
+		c.Concurrency = n
+		return c
+	}
+}
+
+// WithQueueAttempts bounds the number of times a rate limited item is attempted
+func WithQueueAttempts(n int) QueueOption {
+	return func(c QueueConfig) QueueConfig {
+		c.Attempts = n
+		return c
+	}
+}
+
+// An item waiting in a queue
+type queued struct {
+	key  string
+	fn   Operation
+	done chan error
+}
+
+// A Queue accepts operations and dispatches them in the order they were
+// enqueued at the rate permitted by a limiter, so that producers need not
+// wait on the limiter themselves. Each operation is executed as if by Do: the
+// attributes it produces update the limiter, and it is retried when it fails
+// with a RetryError.
+//
+//	q := NewQueue(cxt, lim, WithMaxLength(1000, DropOldest))
+//	res, err := q.Enqueue(func(cxt context.Context) (Attrs, error) { ... })
+//	...
+//	err = <-res
+//
+// With a concurrency greater than one, the queue is a worker pool. Operations
+// enqueued with a key by EnqueueKey are executed serially, in the order they
+// were enqueued, with respect to others with the same key, while operations
+// with different keys execute concurrently. The pool as a whole obeys the
+// limiter.
+type Queue struct {
+	lim    Limiter
+	conf   ExecutorConfig
+	max    int
+	policy Overflow
+	wg     sync.WaitGroup
+
+	sync.Mutex
+	items  []queued
+	busy   map[string]struct{} // the keys of items which are executing
+	ready  chan struct{}       // signaled when an item may be ready to dispatch or the queue is closed
+	closed bool
+}
+
+// NewQueue creates a queue and starts dispatching the operations enqueued on
+// it, until the context is canceled or the queue is closed.
+func NewQueue(cxt context.Context, lim Limiter, opts ...QueueOption) *Queue {
+	conf := QueueConfig{}.With(opts)
+	q := &Queue{
+		lim:    lim,
+		conf:   ExecutorConfig{Attempts: conf.Attempts},
+		max:    conf.MaxLength,
+		policy: conf.Overflow,
+		busy:   make(map[string]struct{}),
+		ready:  make(chan struct{}, 1),
+	}
+	n := max(1, conf.Concurrency)
+	q.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go q.dispatch(cxt)
+	}
+	return q
+}
+
+// Enqueue adds an operation to the queue. The result of the operation is
+// delivered on the returned channel once it has executed, or ErrDropped if
+// it is dropped from the queue, or ErrCanceled if the queue's context is
+// canceled before it executes. If the queue is full and its overflow policy
+// is OverflowError, Enqueue fails with ErrQueueFull; if the queue is closed,
+// it fails with ErrDraining.
+func (q *Queue) Enqueue(fn Operation) (<-chan error, error) {
+	return q.EnqueueKey("", fn)
+}
+
+// EnqueueKey adds an operation to the queue, like Enqueue, which will not
+// execute until every operation enqueued before it with the same key has
+// completed. The key is also provided to the limiter, via WithKey, so that a
+// keyed limiter may limit each key independently. An empty key imposes no
+// ordering.
+func (q *Queue) EnqueueKey(key string, fn Operation) (<-chan error, error) {
+	e := queued{key: key, fn: fn, done: make(chan error, 1)}
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return nil, ErrDraining
+	}
+	if q.max > 0 && len(q.items) >= q.max {
+		switch q.policy {
+		case DropOldest:
+			q.items[0].done <- ErrDropped
+			q.items = q.items[1:]
+		case DropNewest:
+			e.done <- ErrDropped
+			return e.done, nil
+		default:
+			return nil, ErrQueueFull
+		}
+	}
+	q.items = append(q.items, e)
+	q.signal()
+	return e.done, nil
+}
+
+// Len returns the number of items waiting to be dispatched
+func (q *Queue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items)
+}
+
+// Close stops accepting new items and blocks until every item already
+// enqueued has executed, or the queue's context is canceled.
+func (q *Queue) Close() {
+	q.Lock()
+	q.closed = true
+	q.signal()
+	q.Unlock()
+	q.wg.Wait()
+}
+
+// Wake a dispatcher; the caller must hold the lock
+func (q *Queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Take the next item which may be dispatched: the first whose key is not
+// already executing. This blocks until one is available; if the queue is
+// closed and empty or the context is canceled, the result is false.
+func (q *Queue) next(cxt context.Context) (queued, bool) {
+	for cxt.Err() == nil {
+		q.Lock()
+		for i, e := range q.items {
+			if _, ok := q.busy[e.key]; e.key != "" && ok {
+				continue
+			}
+			copy(q.items[i:], q.items[i+1:])
+			q.items[len(q.items)-1] = queued{}
+			q.items = q.items[:len(q.items)-1]
+			if e.key != "" {
+				q.busy[e.key] = struct{}{}
+			}
+			if len(q.items) > 0 {
+				q.signal() // pass the baton to another dispatcher
+			}
+			q.Unlock()
+			return e, true
+		}
+		if q.closed && len(q.items) == 0 {
+			q.signal()
+			q.Unlock()
+			return queued{}, false
+		}
+		q.Unlock()
+		select {
+		case <-q.ready:
+		case <-cxt.Done():
+		}
+	}
+	return queued{}, false
+}
+
+// Release the key of an item which has completed, permitting the next item
+// with the same key to be dispatched
+func (q *Queue) release(key string) {
+	if key == "" {
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	delete(q.busy, key)
+	q.signal()
+}
+
+// Dispatch items until the queue is closed and empty or the context is canceled
+func (q *Queue) dispatch(cxt context.Context) {
+	defer q.wg.Done()
+	for {
+		e, ok := q.next(cxt)
+		if !ok {
+			break
+		}
+		if e.key != "" {
+			e.done <- do(cxt, q.lim, q.conf, e.fn, WithKey(e.key))
+		} else {
+			e.done <- do(cxt, q.lim, q.conf, e.fn)
+		}
+		q.release(e.key)
+	}
+	if cxt.Err() != nil {
+		q.cancel()
+	}
+}
+
+// Fail every item remaining in the queue because its context was canceled
+func (q *Queue) cancel() {
+	q.Lock()
+	defer q.Unlock()
+	q.closed = true
+	for _, e := range q.items {
+		e.done <- ErrCanceled
+	}
+	q.items = nil
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 1000, Mode: Burst})
+	q := NewQueue(context.Background(), lim, WithQueueConcurrency(1))
+
+	var order []int
+	var results []<-chan error
+	for i := 0; i < 5; i++ {
+		res, err := q.Enqueue(func(cxt context.Context) (Attrs, error) {
+			order = append(order, i)
+			return nil, nil
+		})
+		if assert.NoError(t, err) {
+			results = append(results, res)
+		}
+	}
+	q.Close()
+	for _, e := range results {
+		assert.NoError(t, <-e)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+	assert.Equal(t, 995, lim.State(time.Now()).Remaining)
+
+	_, err := q.Enqueue(func(context.Context) (Attrs, error) { return nil, nil })
+	assert.ErrorIs(t, err, ErrDraining)
+}
+
+func TestQueueOverflow(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 1000, Mode: Burst})
+	noop := func(context.Context) (Attrs, error) { return nil, nil }
+	tests := []struct {
+		Overflow Overflow
+		Enqueue  error   // the error produced enqueuing the overflowing item
+		Expect   []error // the results of the queued items, then the overflowing item
+	}{
+		{OverflowError, ErrQueueFull, []error{nil, nil}},
+		{DropOldest, nil, []error{ErrDropped, nil, nil}},
+		{DropNewest, nil, []error{nil, nil, ErrDropped}},
+	}
+	for _, e := range tests {
+		t.Run("", func(t *testing.T) {
+			q := NewQueue(context.Background(), lim, WithMaxLength(2, e.Overflow))
+			// block the dispatcher so that items accumulate
+			block, started := make(chan struct{}), make(chan struct{})
+			first, err := q.Enqueue(func(context.Context) (Attrs, error) {
+				close(started)
+				<-block
+				return nil, nil
+			})
+			assert.NoError(t, err)
+			<-started
+
+			var results []<-chan error
+			for i := 0; i < 2; i++ {
+				res, err := q.Enqueue(noop)
+				assert.NoError(t, err)
+				results = append(results, res)
+			}
+			res, err := q.Enqueue(noop)
+			assert.ErrorIs(t, err, e.Enqueue)
+			if err == nil {
+				results = append(results, res)
+			}
+			assert.Equal(t, 2, q.Len())
+
+			close(block)
+			q.Close()
+			assert.NoError(t, <-first)
+			var actual []error
+			for _, r := range results {
+				actual = append(actual, <-r)
+			}
+			assert.Equal(t, e.Expect, actual)
+		})
+	}
+}
+
+func TestQueueCanceled(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 1000, Mode: Burst})
+	cxt, cancel := context.WithCancel(context.Background())
+	q := NewQueue(cxt, lim)
+
+	block, started := make(chan struct{}), make(chan struct{})
+	first, _ := q.Enqueue(func(cxt context.Context) (Attrs, error) {
+		close(started)
+		<-block
+		return nil, cxt.Err()
+	})
+	<-started
+	second, _ := q.Enqueue(func(context.Context) (Attrs, error) { return nil, nil })
+	cancel()
+	close(block)
+	q.Close()
+	assert.ErrorIs(t, <-first, context.Canceled)
+	assert.ErrorIs(t, <-second, ErrCanceled)
+}
+
+func TestQueueKeys(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 1000, Mode: Burst})
+	q := NewQueue(context.Background(), lim, WithQueueConcurrency(4))
+
+	var mu sync.Mutex
+	order := make(map[string][]int)
+	running := make(map[string]int)
+	var total, peak, overlap int
+	var results []<-chan error
+	for i := 0; i < 15; i++ {
+		key := []string{"a", "b", "c"}[i%3]
+		res, err := q.EnqueueKey(key, func(cxt context.Context) (Attrs, error) {
+			mu.Lock()
+			running[key]++
+			total++
+			overlap = max(overlap, running[key])
+			peak = max(peak, total)
+			order[key] = append(order[key], i)
+			mu.Unlock()
+			time.Sleep(time.Millisecond * 2)
+			mu.Lock()
+			running[key]--
+			total--
+			mu.Unlock()
+			return nil, nil
+		})
+		if assert.NoError(t, err) {
+			results = append(results, res)
+		}
+	}
+	q.Close()
+	for _, e := range results {
+		assert.NoError(t, <-e)
+	}
+
+	// items with the same key run serially, in order, while different keys run concurrently
+	assert.Equal(t, map[string][]int{"a": {0, 3, 6, 9, 12}, "b": {1, 4, 7, 10, 13}, "c": {2, 5, 8, 11, 14}}, order)
+	assert.Equal(t, 1, overlap)
+	assert.Greater(t, peak, 1)
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The names of the units in which windows may be expressed in rates
+var rateUnits = map[string]time.Duration{
+	"ms":     time.Millisecond,
+	"s":      time.Second,
+	"sec":    time.Second,
+	"second": time.Second,
+	"m":      time.Minute,
+	"min":    time.Minute,
+	"minute": time.Minute,
+	"h":      time.Hour,
+	"hr":     time.Hour,
+	"hour":   time.Hour,
+	"d":      time.Hour * 24,
+	"day":    time.Hour * 24,
+	"w":      time.Hour * 24 * 7,
+	"wk":     time.Hour * 24 * 7,
+	"week":   time.Hour * 24 * 7,
+}
+
+// ParseRate produces a configuration from a compact rate expression, of the
+// form "<events>/<window> [burst <n>]", as is convenient in environment
+// variables and flags. The window is a unit, like "s", "min", "hour", or
+// "day", optionally preceded by a multiple, or a duration:
+//
+//	10/s
+//	600/10m
+//	1000/day
+//	100 per minute
+//	10/s burst 50
+//
+// Without a burst, operations are metered over the window. With one, up to
+// that many operations may proceed at once, as long as the rate is sustained
+// on average: the configuration permits the burst in Burst mode, over the
+// window in which the rate permits that many events, so "10/s burst 50" is
+// 50 events per 5 seconds. Errors wrap ErrInvalidConfig.
+func ParseRate(s string) (Config, error) {
+	expr, burst := s, ""
+	fields := strings.Fields(strings.ToLower(s))
+	for i, e := range fields {
+		if e == "burst" {
+			if i+2 != len(fields) {
+				return Config{}, fmt.Errorf("%w: Invalid rate %q; expected a number after \"burst\"", ErrInvalidConfig, s)
+			}
+			expr, burst = strings.Join(fields[:i], " "), fields[i+1]
+		}
+	}
+	events, window, err := parseRate(expr)
+	if err != nil {
+		return Config{}, err
+	}
+	if burst == "" {
+		return Config{Events: events, Window: window}, nil
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil || n <= 0 {
+		return Config{}, fmt.Errorf("%w: Invalid rate %q; the burst must be a positive integer", ErrInvalidConfig, s)
+	}
+	if events == 0 {
+		return Config{}, fmt.Errorf("%w: Invalid rate %q; a burst requires a positive rate", ErrInvalidConfig, s)
+	}
+	return Config{
+		Events: n,
+		Window: time.Duration(float64(window) * float64(n) / float64(events)),
+		Mode:   Burst,
+	}, nil
+}
+
+// MustRate produces a configuration from a compact rate expression, as
+// ParseRate does, and panics if it is invalid. It is intended for rates which
+// are constants:
+//
+//	lim := NewLinear(MustRate("10/s"))
+func MustRate(s string) Config {
+	conf, err := ParseRate(s)
+	if err != nil {
+		panic(err)
+	}
+	return conf
+}
+
+// Parse a rate of the form "<events>/<window>", like "100/min" or "600/10m",
+// or "<events> per <window>", producing the events and the window
+func parseRate(s string) (int, time.Duration, error) {
+	s = strings.TrimSpace(s)
+	n, w, ok := strings.Cut(s, "/")
+	if !ok {
+		n, w, ok = strings.Cut(s, " per ")
+	}
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: Invalid rate %q; expected a value like \"100/min\"", ErrInvalidConfig, s)
+	}
+	events, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || events < 0 {
+		return 0, 0, fmt.Errorf("%w: Invalid rate %q; the number of events must be a non-negative integer", ErrInvalidConfig, s)
+	}
+	window, err := parseRateWindow(strings.TrimSpace(w))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: Invalid rate %q; %v", ErrInvalidConfig, s, err)
+	}
+	return events, window, nil
+}
+
+// Parse the window of a rate, which is a unit, like "min", optionally preceded
+// by a multiple, like "10min", or a duration, like "1h30m"
+func parseRateWindow(s string) (time.Duration, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		return 0, fmt.Errorf("the window %q has no unit", s)
+	}
+	unit := strings.ToLower(strings.TrimSpace(s[i:]))
+	d, ok := rateUnits[unit]
+	if !ok && len(unit) > 2 {
+		d, ok = rateUnits[strings.TrimSuffix(unit, "s")] // plurals, like "minutes"
+	}
+	if ok {
+		mult := 1
+		if i > 0 {
+			mult, _ = strconv.Atoi(s[:i])
+		}
+		if mult <= 0 {
+			return 0, fmt.Errorf("the window %q must be positive", s)
+		}
+		return d * time.Duration(mult), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("the window %q is not a unit, like \"min\", or a duration, like \"10m\"", s)
+	}
+	return d, nil
+}
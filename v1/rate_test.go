@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		Rate   string
+		Expect Config
+		Err    string
+	}{
+		{"100/min", Config{Events: 100, Window: time.Minute}, ""},
+		{"10/s", Config{Events: 10, Window: time.Second}, ""},
+		{"600/10m", Config{Events: 600, Window: time.Minute * 10}, ""},
+		{"600/10min", Config{Events: 600, Window: time.Minute * 10}, ""},
+		{"1000/day", Config{Events: 1000, Window: time.Hour * 24}, ""},
+		{"5 / 2 hours", Config{Events: 5, Window: time.Hour * 2}, ""},
+		{"100 per minute", Config{Events: 100, Window: time.Minute}, ""},
+		{"3/1h30m", Config{Events: 3, Window: time.Minute * 90}, ""},
+		{"50/250ms", Config{Events: 50, Window: time.Millisecond * 250}, ""},
+		{"10/s burst 50", Config{Events: 50, Window: time.Second * 5, Mode: Burst}, ""},
+		{"100/min BURST 10", Config{Events: 10, Window: time.Second * 6, Mode: Burst}, ""},
+		{"100", Config{}, `expected a value like "100/min"`},
+		{"x/min", Config{}, "must be a non-negative integer"},
+		{"-1/min", Config{}, "must be a non-negative integer"},
+		{"100/fortnight", Config{}, "is not a unit"},
+		{"100/0min", Config{}, "must be positive"},
+		{"100/10", Config{}, "has no unit"},
+		{"10/s burst", Config{}, `expected a number after "burst"`},
+		{"10/s burst 5 now", Config{}, `expected a number after "burst"`},
+		{"10/s burst 0", Config{}, "the burst must be a positive integer"},
+		{"0/s burst 5", Config{}, "a burst requires a positive rate"},
+	}
+	for i, e := range tests {
+		conf, err := ParseRate(e.Rate)
+		if e.Err != "" {
+			if assert.ErrorIs(t, err, ErrInvalidConfig, "#%d", i) {
+				assert.Contains(t, err.Error(), e.Err, "#%d", i)
+			}
+		} else if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, e.Expect, conf, "#%d", i)
+		}
+	}
+
+	assert.Equal(t, Config{Events: 10, Window: time.Second}, MustRate("10/s"))
+	assert.Panics(t, func() { MustRate("often") })
+
+	conf, err := ParseConfig([]byte("rate: 10/s burst 50\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, Config{Events: 50, Window: time.Second * 5, Mode: Burst}, conf)
+	}
+	_, err = ParseConfig([]byte("rate: 10/s burst 50\nmode: smooth\n"))
+	assert.ErrorContains(t, err, "mode: Must not be combined")
+	_, err = ParseConfig([]byte("rate: 10/fortnight\n"))
+	assert.ErrorContains(t, err, `rate: Invalid rate "10/fortnight"`)
+}
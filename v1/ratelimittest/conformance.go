@@ -0,0 +1,133 @@
+package ratelimittest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+// The number of randomized cases each property is checked against
+const conformanceCases = 100
+
+// ConformanceTest exercises a Limiter implementation against the invariants
+// that every implementation is expected to uphold:
+//
+//   - Next never permits an operation before the reference time
+//   - Wait returns promptly with ErrCanceled, rather than blocking, when its
+//     context is canceled before the operation is permitted
+//   - State reports remaining quota between zero and the limit, when the
+//     limit is known
+//   - applying the same Update twice leaves the limiter in the same state as
+//     applying it once
+//   - the limiter may be used concurrently
+//
+// A new limiter is created for each property. Reference times are generated
+// pseudo-randomly from a fixed seed, so failures are reproducible. Run with
+// -race to detect unsynchronized access.
+func ConformanceTest(t *testing.T, newLimiter func() ratelimit.Limiter) {
+	t.Run("NextNotBeforeReference", func(t *testing.T) {
+		lim, rnd := newLimiter(), rand.New(rand.NewSource(1))
+		rel := time.Now()
+		for i := 0; i < conformanceCases; i++ {
+			rel = rel.Add(time.Duration(rnd.Int63n(int64(time.Second))))
+			next, err := lim.Next(rel)
+			if err != nil {
+				if !expected(err) {
+					t.Errorf("Next #%d produced an unexpected error: %v", i, err)
+				}
+				continue
+			}
+			if next.Before(rel) {
+				t.Errorf("Next #%d permitted an operation at %v, %v before the reference time", i, next, rel.Sub(next))
+			}
+		}
+	})
+
+	t.Run("WaitHonorsCancellation", func(t *testing.T) {
+		lim := newLimiter()
+		cxt, cancel := context.WithCancel(context.Background())
+		cancel()
+		rel := time.Now()
+		for i := 0; i < conformanceCases; i++ {
+			start := time.Now()
+			next, err := lim.Wait(cxt, rel)
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("Wait #%d blocked for %v with a canceled context", i, d)
+				return
+			}
+			if err == nil && next.After(rel) {
+				t.Errorf("Wait #%d reported waiting until %v with a canceled context", i, next)
+			} else if err != nil && !expected(err) {
+				t.Errorf("Wait #%d produced an unexpected error: %v", i, err)
+			}
+		}
+	})
+
+	t.Run("StateConsistent", func(t *testing.T) {
+		lim, rnd := newLimiter(), rand.New(rand.NewSource(2))
+		rel := time.Now()
+		for i := 0; i < conformanceCases; i++ {
+			rel = rel.Add(time.Duration(rnd.Int63n(int64(time.Second))))
+			lim.Next(rel)
+			if st := lim.State(rel); st.Limit > 0 && (st.Remaining < 0 || st.Remaining > st.Limit) {
+				t.Errorf("State #%d reports %d remaining of a limit of %d", i, st.Remaining, st.Limit)
+			}
+		}
+	})
+
+	t.Run("UpdateIdempotent", func(t *testing.T) {
+		lim, rnd := newLimiter(), rand.New(rand.NewSource(3))
+		rel := time.Now()
+		for i := 0; i < conformanceCases; i++ {
+			limit := 1 + rnd.Intn(1000)
+			attrs := ratelimit.Attrs(http.Header{
+				"X-Ratelimit-Limit":     {strconv.Itoa(limit)},
+				"X-Ratelimit-Remaining": {strconv.Itoa(rnd.Intn(limit + 1))},
+				"X-Ratelimit-Reset":     {strconv.FormatInt(rel.Add(time.Duration(rnd.Int63n(int64(time.Hour)))).Unix(), 10)},
+			})
+			lim.Update(rel, ratelimit.WithAttrs(attrs))
+			once := lim.State(rel)
+			lim.Update(rel, ratelimit.WithAttrs(attrs))
+			if twice := lim.State(rel); once != twice {
+				t.Errorf("Update #%d applied twice produced %+v; applied once, %+v", i, twice, once)
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		lim := newLimiter()
+		cxt, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < conformanceCases/10; j++ {
+					rel := time.Now()
+					lim.Next(rel)
+					lim.Wait(cxt, rel)
+					lim.Update(rel)
+					lim.State(rel)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+// Determine if an error is one a limiter may reasonably produce in place of
+// permitting an operation
+func expected(err error) bool {
+	return errors.Is(err, ratelimit.ErrCanceled) ||
+		errors.Is(err, ratelimit.ErrOverloaded) ||
+		errors.Is(err, ratelimit.ErrExhausted) ||
+		errors.Is(err, ratelimit.ErrDraining)
+}
@@ -0,0 +1,41 @@
+package ratelimittest
+
+import (
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+func TestConformance(t *testing.T) {
+	conf := ratelimit.Config{Window: time.Minute, Events: 100}
+	tests := []struct {
+		Name string
+		New  func() ratelimit.Limiter
+	}{
+		{"Headers", func() ratelimit.Limiter { return ratelimit.NewHeaders(conf) }},
+		{"Metered", func() ratelimit.Limiter {
+			return ratelimit.NewHeaders(ratelimit.Config{Window: time.Minute, Events: 100, Mode: ratelimit.Meter})
+		}},
+		{"Linear", func() ratelimit.Limiter { return ratelimit.NewLinear(conf) }},
+		{"Keyed", func() ratelimit.Limiter {
+			return ratelimit.NewKeyed(func(string) ratelimit.Limiter { return ratelimit.NewHeaders(conf) })
+		}},
+		{"Buckets", func() ratelimit.Limiter { return ratelimit.NewBuckets(conf) }},
+		{"Shared", func() ratelimit.Limiter { return ratelimit.NewShared(conf, ratelimit.NewMemoryStore(), "example") }},
+		{"Capped", func() ratelimit.Limiter { return ratelimit.CappedBy(ratelimit.NewHeaders(conf), conf) }},
+		{"Estimator", func() ratelimit.Limiter { return ratelimit.NewEstimator(conf) }},
+		{"Sketch", func() ratelimit.Limiter { return ratelimit.NewSketch(conf) }},
+		{"None", func() ratelimit.Limiter { return ratelimit.None() }},
+		{"Fake", func() ratelimit.Limiter {
+			f := NewFake()
+			f.PermitWhenExhausted(true)
+			return f
+		}},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			ConformanceTest(t, e.New)
+		})
+	}
+}
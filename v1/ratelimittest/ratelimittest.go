@@ -0,0 +1,284 @@
+// Package ratelimittest provides utilities for testing code which is built on
+// rate limiters: a scripted fake limiter, a wrapper which records the calls
+// made to a limiter, and assertions which verify that callers respected the
+// schedule a limiter imposed.
+package ratelimittest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+// ErrScriptExhausted is returned by a fake limiter when every scripted result
+// has been consumed
+var ErrScriptExhausted = errors.New("Script exhausted")
+
+// A Result is the scripted outcome of a call to Next or Wait on a fake limiter
+type Result struct {
+	// The time at which the operation is permitted; if zero, the reference time plus Delay
+	At time.Time
+	// The delay relative to the reference time, used when At is zero
+	Delay time.Duration
+	// The error produced, if any
+	Err error
+}
+
+// Permit produces a result which permits an operation after the provided
+// delay relative to the reference time
+func Permit(d time.Duration) Result {
+	return Result{Delay: d}
+}
+
+// PermitAt produces a result which permits an operation at the provided time
+func PermitAt(t time.Time) Result {
+	return Result{At: t}
+}
+
+// Fail produces a result which fails with the provided error
+func Fail(err error) Result {
+	return Result{Err: err}
+}
+
+// FakeLimiter is a limiter whose Next and Wait methods produce scripted
+// results, in order. Wait does not block, except that it fails if its context
+// is already canceled. Once the script is exhausted, calls fail with
+// ErrScriptExhausted unless the limiter was configured to permit them.
+type FakeLimiter struct {
+	sync.Mutex
+	results   []Result
+	permit    bool
+	state     ratelimit.State
+	updateErr error
+	updates   []ratelimit.Options
+}
+
+// NewFake creates a fake limiter which produces the provided results
+func NewFake(results ...Result) *FakeLimiter {
+	return &FakeLimiter{results: results}
+}
+
+// Push appends results to the script
+func (f *FakeLimiter) Push(results ...Result) {
+	f.Lock()
+	defer f.Unlock()
+	f.results = append(f.results, results...)
+}
+
+// Remaining returns the number of scripted results which have not been consumed
+func (f *FakeLimiter) Remaining() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.results)
+}
+
+// PermitWhenExhausted sets whether operations are permitted immediately, rather
+// than failing, once the script is exhausted
+func (f *FakeLimiter) PermitWhenExhausted(v bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.permit = v
+}
+
+// SetState sets the state reported by State
+func (f *FakeLimiter) SetState(v ratelimit.State) {
+	f.Lock()
+	defer f.Unlock()
+	f.state = v
+}
+
+// SetUpdateError sets the error returned by Update
+func (f *FakeLimiter) SetUpdateError(err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.updateErr = err
+}
+
+// Updates returns the options provided to every call to Update, in order
+func (f *FakeLimiter) Updates() []ratelimit.Options {
+	f.Lock()
+	defer f.Unlock()
+	return append([]ratelimit.Options(nil), f.updates...)
+}
+
+// Consume the next result in the script
+func (f *FakeLimiter) next(rel time.Time) (time.Time, error) {
+	f.Lock()
+	defer f.Unlock()
+	if len(f.results) == 0 {
+		if f.permit {
+			return rel, nil
+		}
+		return time.Time{}, ErrScriptExhausted
+	}
+	r := f.results[0]
+	f.results = f.results[1:]
+	if r.Err != nil {
+		return time.Time{}, r.Err
+	}
+	if !r.At.IsZero() {
+		return r.At, nil
+	}
+	return rel.Add(r.Delay), nil
+}
+
+func (f *FakeLimiter) Next(rel time.Time, opts ...ratelimit.Option) (time.Time, error) {
+	return f.next(rel)
+}
+
+func (f *FakeLimiter) Wait(cxt context.Context, rel time.Time, opts ...ratelimit.Option) (time.Time, error) {
+	if cxt.Err() != nil {
+		return time.Time{}, ratelimit.ErrCanceled
+	}
+	return f.next(rel)
+}
+
+func (f *FakeLimiter) Update(rel time.Time, opts ...ratelimit.Option) error {
+	f.Lock()
+	defer f.Unlock()
+	f.updates = append(f.updates, ratelimit.Options{}.With(opts))
+	return f.updateErr
+}
+
+func (f *FakeLimiter) State(time.Time) ratelimit.State {
+	f.Lock()
+	defer f.Unlock()
+	return f.state
+}
+
+// A Call describes a call made to a recorded limiter
+type Call struct {
+	// The method called: Next, Wait, Update, or State
+	Method string
+	// The reference time provided
+	Rel time.Time
+	// The options provided
+	Options ratelimit.Options
+	// The time produced by Next or Wait
+	Result time.Time
+	// The error produced, if any
+	Err error
+}
+
+// Recorder wraps a limiter and records every call made to it
+type Recorder struct {
+	ratelimit.Limiter
+	sync.Mutex
+	calls []Call
+}
+
+// Record wraps a limiter in a recorder
+func Record(lim ratelimit.Limiter) *Recorder {
+	return &Recorder{Limiter: lim}
+}
+
+// Calls returns every call recorded, in order
+func (r *Recorder) Calls() []Call {
+	r.Lock()
+	defer r.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Permitted returns the times at which operations were permitted by calls to
+// Next or Wait which succeeded, in order
+func (r *Recorder) Permitted() []time.Time {
+	r.Lock()
+	defer r.Unlock()
+	var res []time.Time
+	for _, e := range r.calls {
+		if (e.Method == "Next" || e.Method == "Wait") && e.Err == nil {
+			res = append(res, e.Result)
+		}
+	}
+	return res
+}
+
+func (r *Recorder) record(c Call) {
+	r.Lock()
+	defer r.Unlock()
+	r.calls = append(r.calls, c)
+}
+
+func (r *Recorder) Next(rel time.Time, opts ...ratelimit.Option) (time.Time, error) {
+	t, err := r.Limiter.Next(rel, opts...)
+	r.record(Call{Method: "Next", Rel: rel, Options: ratelimit.Options{}.With(opts), Result: t, Err: err})
+	return t, err
+}
+
+func (r *Recorder) Wait(cxt context.Context, rel time.Time, opts ...ratelimit.Option) (time.Time, error) {
+	t, err := r.Limiter.Wait(cxt, rel, opts...)
+	r.record(Call{Method: "Wait", Rel: rel, Options: ratelimit.Options{}.With(opts), Result: t, Err: err})
+	return t, err
+}
+
+func (r *Recorder) Update(rel time.Time, opts ...ratelimit.Option) error {
+	err := r.Limiter.Update(rel, opts...)
+	r.record(Call{Method: "Update", Rel: rel, Options: ratelimit.Options{}.With(opts), Err: err})
+	return err
+}
+
+func (r *Recorder) State(rel time.Time) ratelimit.State {
+	st := r.Limiter.State(rel)
+	r.record(Call{Method: "State", Rel: rel})
+	return st
+}
+
+// TestingT is the subset of testing.TB used by the assertions
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// AssertRespected verifies that each operation was performed no earlier than
+// the time at which the corresponding operation was permitted. The nth
+// operation corresponds to the nth permitted time, as produced by
+// Recorder.Permitted.
+func AssertRespected(t TestingT, permitted, ops []time.Time) bool {
+	t.Helper()
+	if len(ops) > len(permitted) {
+		t.Errorf("%d operations were performed but only %d were permitted", len(ops), len(permitted))
+		return false
+	}
+	ok := true
+	for i, e := range ops {
+		if e.Before(permitted[i]) {
+			t.Errorf("Operation #%d was performed at %v, %v before it was permitted", i, e, permitted[i].Sub(e))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertSpacing verifies that consecutive operations were performed at least
+// the provided interval apart
+func AssertSpacing(t TestingT, ops []time.Time, min time.Duration) bool {
+	t.Helper()
+	ok := true
+	for i := 1; i < len(ops); i++ {
+		if d := ops[i].Sub(ops[i-1]); d < min {
+			t.Errorf("Operation #%d was performed %v after the previous one; expected at least %v", i, d, min)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertRate verifies that no more than the provided number of events were
+// performed in any window of the provided duration. Operations must be in
+// chronological order.
+func AssertRate(t TestingT, ops []time.Time, events int, window time.Duration) bool {
+	t.Helper()
+	for i, j := 0, 0; j < len(ops); j++ {
+		for ops[j].Sub(ops[i]) >= window {
+			i++
+		}
+		if n := j - i + 1; n > events {
+			t.Errorf("%d operations were performed between %v and %v; expected at most %d per %v", n, ops[i], ops[j], events, window)
+			return false
+		}
+	}
+	return true
+}
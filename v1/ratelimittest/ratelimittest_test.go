@@ -0,0 +1,105 @@
+package ratelimittest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// Collects assertion failures rather than failing the test
+type recorder struct {
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestFakeLimiter(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	fail := fmt.Errorf("Failed")
+	lim := NewFake(Permit(time.Second), PermitAt(now.Add(time.Minute)), Fail(fail))
+
+	next, err := lim.Next(now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Second), next)
+	next, err = lim.Wait(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), next)
+	_, err = lim.Next(now)
+	assert.ErrorIs(t, err, fail)
+	_, err = lim.Next(now)
+	assert.ErrorIs(t, err, ErrScriptExhausted)
+
+	lim.PermitWhenExhausted(true)
+	next, err = lim.Next(now)
+	assert.NoError(t, err)
+	assert.Equal(t, now, next)
+
+	lim.Push(Permit(0))
+	cxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = lim.Wait(cxt, now)
+	assert.ErrorIs(t, err, ratelimit.ErrCanceled)
+	assert.Equal(t, 1, lim.Remaining())
+
+	attrs := ratelimit.Attrs{"X-Ratelimit-Remaining": {"1"}}
+	lim.SetUpdateError(ratelimit.ErrMissingHeaders)
+	assert.ErrorIs(t, lim.Update(now, ratelimit.WithAttrs(attrs)), ratelimit.ErrMissingHeaders)
+	assert.Equal(t, []ratelimit.Options{{Attrs: attrs}}, lim.Updates())
+}
+
+func TestRecorder(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	rec := Record(NewFake(Permit(0), Permit(time.Second), Fail(ratelimit.ErrOverloaded)))
+	rec.Next(now, ratelimit.WithKey("a"))
+	rec.Wait(context.Background(), now)
+	rec.Next(now)
+	rec.Update(now)
+
+	calls := rec.Calls()
+	if assert.Len(t, calls, 4) {
+		assert.Equal(t, Call{Method: "Next", Rel: now, Options: ratelimit.Options{Key: "a"}, Result: now}, calls[0])
+		assert.Equal(t, "Wait", calls[1].Method)
+		assert.ErrorIs(t, calls[2].Err, ratelimit.ErrOverloaded)
+		assert.Equal(t, "Update", calls[3].Method)
+	}
+	assert.Equal(t, []time.Time{now, now.Add(time.Second)}, rec.Permitted())
+}
+
+func TestAssertions(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	at := func(d ...time.Duration) []time.Time {
+		res := make([]time.Time, len(d))
+		for i, e := range d {
+			res[i] = now.Add(e)
+		}
+		return res
+	}
+	tests := []struct {
+		Name   string
+		Assert func(TestingT) bool
+		Expect bool
+	}{
+		{"Respected", func(t TestingT) bool { return AssertRespected(t, at(0, time.Second), at(0, time.Second*2)) }, true},
+		{"Early", func(t TestingT) bool { return AssertRespected(t, at(0, time.Second), at(0, time.Millisecond)) }, false},
+		{"Unpermitted", func(t TestingT) bool { return AssertRespected(t, at(0), at(0, time.Second)) }, false},
+		{"Spaced", func(t TestingT) bool { return AssertSpacing(t, at(0, time.Second, time.Second*2), time.Second) }, true},
+		{"Crowded", func(t TestingT) bool { return AssertSpacing(t, at(0, time.Second, time.Second+1), time.Second) }, false},
+		{"Rate", func(t TestingT) bool { return AssertRate(t, at(0, 0, time.Minute, time.Minute), 2, time.Minute) }, true},
+		{"Exceeded", func(t TestingT) bool { return AssertRate(t, at(0, time.Second*30, time.Second*59), 2, time.Minute) }, false},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			r := &recorder{}
+			assert.Equal(t, e.Expect, e.Assert(r))
+			assert.Equal(t, e.Expect, len(r.failures) == 0, r.failures)
+		})
+	}
+}
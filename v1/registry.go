@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Registry is a set of named limiters, so that instrumentation, debugging
+// endpoints, and administrative tools can discover the limiters in a process.
+type Registry struct {
+	sync.RWMutex
+	limiters map[string]Limiter
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		limiters: make(map[string]Limiter),
+	}
+}
+
+// Register adds a limiter to the registry under the provided name, replacing
+// any limiter already registered under it
+func (r *Registry) Register(name string, l Limiter) {
+	r.Lock()
+	defer r.Unlock()
+	r.limiters[name] = l
+}
+
+// Unregister removes the limiter registered under the provided name, if any
+func (r *Registry) Unregister(name string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.limiters, name)
+}
+
+// Get returns the limiter registered under the provided name, if any
+func (r *Registry) Get(name string) (Limiter, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	l, ok := r.limiters[name]
+	return l, ok
+}
+
+// Limiters returns every registered limiter, ordered by name
+func (r *Registry) Limiters() []NamedLimiter {
+	r.RLock()
+	res := make([]NamedLimiter, 0, len(r.limiters))
+	for k, v := range r.limiters {
+		res = append(res, NamedLimiter{Name: k, Limiter: v})
+	}
+	r.RUnlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// The default, process-wide registry
+var DefaultRegistry = NewRegistry()
+
+// Register adds a limiter to the default registry
+func Register(name string, l Limiter) {
+	DefaultRegistry.Register(name, l)
+}
+
+// Unregister removes a limiter from the default registry
+func Unregister(name string) {
+	DefaultRegistry.Unregister(name)
+}
+
+// Get returns a limiter from the default registry
+func Get(name string) (Limiter, bool) {
+	return DefaultRegistry.Get(name)
+}
+
+// Registered returns every limiter in the default registry, ordered by name
+func Registered() []NamedLimiter {
+	return DefaultRegistry.Limiters()
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	a := NewLinear(Config{Window: time.Minute, Events: 10})
+	b := NewHeaders(Config{Window: time.Minute, Events: 10})
+	Register("b", b)
+	Register("a", a)
+	defer Unregister("a")
+	defer Unregister("b")
+
+	l, ok := Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, Limiter(a), l)
+	_, ok = Get("c")
+	assert.False(t, ok)
+	assert.Equal(t, []NamedLimiter{{"a", a}, {"b", b}}, Registered())
+
+	// the debug handler serves the default registry if no limiters are provided
+	rsp := httptest.NewRecorder()
+	DebugHandler().ServeHTTP(rsp, httptest.NewRequest("GET", "/", nil))
+	var res struct{ Limiters []struct{ Name string } }
+	if assert.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &res)) {
+		assert.Len(t, res.Limiters, 2)
+	}
+
+	Unregister("a")
+	assert.Equal(t, []NamedLimiter{{"b", b}}, Registered())
+}
+
+func TestSnapshot(t *testing.T) {
+	now := time.Now()
+	reg := NewRegistry()
+	a := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst})
+	reg.Register("a", a)
+	for i := 0; i < 4; i++ {
+		_, err := a.Next(now)
+		assert.NoError(t, err, "#%d", i)
+	}
+	a.Pause()
+
+	snap := reg.Snapshot(now)
+	assert.Equal(t, Snapshot{
+		Version: SnapshotVersion,
+		Time:    now,
+		Limiters: []LimiterSnapshot{
+			{Name: "a", Limit: 10, Remaining: 6, Utilization: 0.4, Reset: now.Add(time.Minute), ResetSeconds: 60, Paused: true},
+		},
+	}, snap)
+
+	data, err := json.Marshal(snap)
+	if assert.NoError(t, err) {
+		var res struct {
+			Version  int
+			Limiters []map[string]any
+		}
+		if assert.NoError(t, json.Unmarshal(data, &res)) && assert.Len(t, res.Limiters, 1) {
+			assert.Equal(t, SnapshotVersion, res.Version)
+			for _, k := range []string{"name", "limit", "remaining", "utilization", "reset", "reset_seconds", "low", "critical", "paused", "pending", "backoff_seconds"} {
+				assert.Contains(t, res.Limiters[0], k)
+			}
+		}
+	}
+}
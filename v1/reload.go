@@ -0,0 +1,203 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The interval at which configuration files are checked for changes by default
+const defaultWatchInterval = time.Second * 10
+
+// The limiter in effect for a reloadable limiter, and the configuration it
+// was created from
+type reloaded struct {
+	conf Config
+	lim  Limiter
+}
+
+// reloadable implements a rate limiter whose configuration may be replaced
+// while it is in use. Each configuration produces a new underlying limiter;
+// callers already waiting on the previous one are unaffected and complete as
+// they would have, while new callers use the new one. The state of the
+// previous limiter is not carried over, so limiters whose state lives
+// elsewhere, like those created by NewShared, reload most smoothly.
+type reloadable struct {
+	create func(Config) Limiter
+	curr   atomic.Pointer[reloaded]
+	waiters
+}
+
+// NewReloadable creates a reloadable limiter which uses the provided function
+// to create its underlying limiter from the initial configuration and from
+// each subsequent one, e.g., NewShared with a common store:
+//
+//	lim := NewReloadable(conf, func(conf Config) Limiter {
+//		return NewShared(conf, store, "uploads")
+//	})
+//	Register("uploads", lim)
+func NewReloadable(conf Config, create func(Config) Limiter) *reloadable {
+	l := &reloadable{create: create}
+	l.curr.Store(&reloaded{conf: conf, lim: create(conf)})
+	return l
+}
+
+// Config returns the configuration currently in effect
+func (l *reloadable) Config() Config {
+	return l.curr.Load().conf
+}
+
+// Limiter returns the underlying limiter currently in effect
+func (l *reloadable) Limiter() Limiter {
+	return l.curr.Load().lim
+}
+
+// Reconfigure replaces the underlying limiter with one created from the
+// provided configuration
+func (l *reloadable) Reconfigure(conf Config) error {
+	l.curr.Store(&reloaded{conf: conf, lim: l.create(conf)})
+	return nil
+}
+
+func (l *reloadable) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.Limiter().Next(rel, opts...)
+}
+
+func (l *reloadable) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	cxt, cancel := l.derive(cxt)
+	defer cancel()
+	return l.Limiter().Wait(cxt, rel, opts...)
+}
+
+func (l *reloadable) Update(rel time.Time, opts ...Option) error {
+	return l.Limiter().Update(rel, opts...)
+}
+
+func (l *reloadable) State(rel time.Time) State {
+	return l.Limiter().State(rel)
+}
+
+// Reconfigure applies a configuration to the limiter registered under the
+// provided name, which must support it, as a reloadable limiter does
+func (r *Registry) Reconfigure(name string, conf Config) error {
+	l, ok := r.Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotRegistered, name)
+	}
+	c, ok := l.(interface{ Reconfigure(Config) error })
+	if !ok {
+		return fmt.Errorf("Limiter cannot be reconfigured: %s: %w", name, errors.ErrUnsupported)
+	}
+	return c.Reconfigure(conf)
+}
+
+// A ConfigSource produces sets of configurations, by limiter name, as they
+// change
+type ConfigSource interface {
+	// Next blocks until a new set of configurations is available or the
+	// context is canceled. The first call produces the current set.
+	Next(cxt context.Context) (map[string]Config, error)
+}
+
+// ConfigChan produces a ConfigSource from a channel on which sets of
+// configurations are sent. When the channel is closed, the source fails with
+// io.EOF.
+func ConfigChan(ch <-chan map[string]Config) ConfigSource {
+	return chanSource(ch)
+}
+
+type chanSource <-chan map[string]Config
+
+func (s chanSource) Next(cxt context.Context) (map[string]Config, error) {
+	select {
+	case v, ok := <-s:
+		if !ok {
+			return nil, io.EOF
+		}
+		return v, nil
+	case <-cxt.Done():
+		return nil, ErrCanceled
+	}
+}
+
+// ConfigFile produces a ConfigSource which reads a JSON or YAML file of named
+// configurations, as they are expressed for ParseConfigs, and checks it for
+// changes at the provided interval, or every 10 seconds if it is zero. A file
+// which cannot be read or parsed is an error, but the source may be used
+// again once it has been corrected.
+func ConfigFile(path string, interval time.Duration) ConfigSource {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &fileSource{path: path, interval: interval}
+}
+
+type fileSource struct {
+	path     string
+	interval time.Duration
+	last     []byte // the contents most recently produced
+	read     bool   // whether the file has been read at all
+}
+
+func (s *fileSource) Next(cxt context.Context) (map[string]Config, error) {
+	for {
+		if s.read {
+			if err := pause(cxt, s.interval); err != nil {
+				return nil, err
+			}
+		}
+		s.read = true
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read configuration: %w", err)
+		}
+		if s.last != nil && bytes.Equal(data, s.last) {
+			continue // unchanged
+		}
+		confs, err := ParseConfigs(data)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse configuration: %s: %w", s.path, err)
+		}
+		s.last = data
+		return confs, nil
+	}
+}
+
+// Watch applies each set of configurations produced by the source to the
+// limiters registered under their names in the registry, or the default
+// registry if it is nil, until the context is canceled. Failures to apply a
+// configuration, or to obtain one from the source, are provided to the
+// report function, if it is not nil, and watching continues until the source
+// fails with io.EOF.
+func Watch(cxt context.Context, reg *Registry, src ConfigSource, report func(error)) {
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	if report == nil {
+		report = func(error) {}
+	}
+	for {
+		confs, err := src.Next(cxt)
+		if cxt.Err() != nil || errors.Is(err, io.EOF) {
+			return
+		} else if err != nil {
+			report(err)
+			continue
+		}
+		for name, conf := range confs {
+			if err := reg.Reconfigure(name, conf); err != nil {
+				report(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadable(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewReloadable(Config{Start: now, Window: time.Minute, Events: 60}, func(conf Config) Limiter {
+		return NewLinear(conf)
+	})
+	next, err := lim.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second), next)
+	}
+
+	// a waiter on the original limiter is not disturbed by reconfiguration
+	done := make(chan error, 1)
+	go func() {
+		_, err := lim.Wait(context.Background(), time.Now())
+		done <- err
+	}()
+	for lim.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.NoError(t, lim.Reconfigure(Config{Start: now, Window: time.Minute, Events: 6}))
+	assert.Equal(t, 6, lim.Config().Events)
+	next, err = lim.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Second*10), next)
+	}
+	assert.NoError(t, <-done)
+}
+
+func TestWatch(t *testing.T) {
+	reg := NewRegistry()
+	create := func(conf Config) Limiter { return NewLinear(conf) }
+	a := NewReloadable(Config{Window: time.Minute, Events: 1}, create)
+	reg.Register("a", a)
+	reg.Register("fixed", NewLinear(Config{Window: time.Minute, Events: 1}))
+
+	ch := make(chan map[string]Config)
+	var reported []error
+	done := make(chan struct{})
+	go func() {
+		Watch(context.Background(), reg, ConfigChan(ch), func(err error) {
+			reported = append(reported, err)
+		})
+		close(done)
+	}()
+	ch <- map[string]Config{"a": {Window: time.Minute, Events: 10}}
+	ch <- map[string]Config{"fixed": {Window: time.Minute, Events: 10}, "missing": {Window: time.Minute, Events: 10}}
+	close(ch)
+	<-done
+
+	assert.Equal(t, 10, a.Config().Events)
+	if assert.Len(t, reported, 2) {
+		var unsupported, missing bool
+		for _, e := range reported {
+			unsupported = unsupported || errors.Is(e, errors.ErrUnsupported)
+			missing = missing || errors.Is(e, ErrNotRegistered)
+		}
+		assert.True(t, unsupported)
+		assert.True(t, missing)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("a:\n  window: 1m\n  events: 10\n"), 0o644))
+
+	src := ConfigFile(path, time.Millisecond*5)
+	confs, err := src.Next(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]Config{"a": {Window: time.Minute, Events: 10}}, confs)
+	}
+
+	// an unchanged file produces nothing until it changes
+	cxt, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	_, err = src.Next(cxt)
+	cancel()
+	assert.ErrorIs(t, err, ErrCanceled)
+
+	assert.NoError(t, os.WriteFile(path, []byte("a:\n  window: 1m\n  events: 0\n  mode: fast\n"), 0o644))
+	_, err = src.Next(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "a: ")
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"a": {"window": "1m", "events": 20}}`), 0o644))
+	confs, err = src.Next(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]Config{"a": {Window: time.Minute, Events: 20}}, confs)
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"slices"
+	"time"
+)
+
+// A Period overrides the configured rate limit during part of the day or
+// week, e.g., to throttle harder during a service's business hours.
+type Period struct {
+	// The days on which the period applies; if empty, it applies every day
+	Days []time.Weekday
+	// The time of day at which the period begins, as a duration since midnight
+	From time.Duration
+	// The time of day at which the period ends, as a duration since midnight; if this is not after From, the period spans midnight
+	Until time.Duration
+	// The number of events permitted within a single window during the period
+	Events int
+	// The duration of a window during the period
+	Window time.Duration
+}
+
+// Determine if the period is in effect at the provided time
+func (p Period) Contains(t time.Time) bool {
+	y, m, d := t.Date()
+	tod := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	day := t.Weekday()
+	if p.Until <= p.From { // spans midnight; the early part belongs to the previous day
+		if tod < p.Until {
+			day = (day + 6) % 7
+		} else if tod < p.From {
+			return false
+		}
+	} else if tod < p.From || tod >= p.Until {
+		return false
+	}
+	return len(p.Days) == 0 || slices.Contains(p.Days, day)
+}
+
+// A Schedule is a set of periods which override the configured rate limit.
+// When periods overlap, the first one in effect applies. Times of day are
+// evaluated in the location of the reference time.
+type Schedule []Period
+
+// At returns the period in effect at the provided time, if any
+func (s Schedule) At(t time.Time) (Period, bool) {
+	for _, e := range s {
+		if e.Contains(t) {
+			return e, true
+		}
+	}
+	return Period{}, false
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule(t *testing.T) {
+	business := Period{
+		Days:   []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		From:   time.Hour * 9,
+		Until:  time.Hour * 17,
+		Events: 100,
+		Window: time.Hour,
+	}
+	overnight := Period{
+		From:   time.Hour * 22,
+		Until:  time.Hour * 6,
+		Events: 10,
+		Window: time.Hour,
+	}
+	sched := Schedule{business, overnight}
+	tests := []struct {
+		When   time.Time
+		Events int
+		OK     bool
+	}{
+		{time.Date(2024, 4, 12, 9, 0, 0, 0, time.UTC), 100, true}, // friday morning
+		{time.Date(2024, 4, 12, 17, 0, 0, 0, time.UTC), 0, false}, // friday evening
+		{time.Date(2024, 4, 13, 12, 0, 0, 0, time.UTC), 0, false}, // saturday
+		{time.Date(2024, 4, 12, 23, 0, 0, 0, time.UTC), 10, true}, // friday night
+		{time.Date(2024, 4, 13, 5, 59, 0, 0, time.UTC), 10, true}, // saturday, early
+		{time.Date(2024, 4, 13, 6, 0, 0, 0, time.UTC), 0, false},  // saturday morning
+		{time.Date(2024, 4, 15, 12, 0, 0, 0, time.FixedZone("X", -3600)), 100, true},
+	}
+	for i, e := range tests {
+		p, ok := sched.At(e.When)
+		assert.Equal(t, e.OK, ok, "#%d", i)
+		assert.Equal(t, e.Events, p.Events, "#%d", i)
+	}
+}
+
+func TestLinearSchedule(t *testing.T) {
+	start := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewLinear(Config{
+		Start:  start,
+		Window: time.Hour,
+		Events: 3600,
+		Schedule: Schedule{
+			{From: 0, Until: time.Hour * 6, Events: 60, Window: time.Hour},
+		},
+	})
+	next, err := lim.Next(start.Add(time.Hour * 5))
+	if assert.NoError(t, err) {
+		assert.Equal(t, start.Add(time.Hour*5+time.Minute), next)
+	}
+	assert.Equal(t, 60, lim.State(start.Add(time.Hour*5)).Limit)
+	next, err = lim.Next(start.Add(time.Hour * 6))
+	if assert.NoError(t, err) {
+		assert.Equal(t, start.Add(time.Hour*6+time.Second), next)
+	}
+	assert.Equal(t, 3600, lim.State(start.Add(time.Hour*6)).Limit)
+}
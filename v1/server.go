@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bww/go-util/v1/ext"
+)
+
+// A BodyWriter writes the body of a response to a request which exceeded a
+// rate limit. The status and headers have already been written.
+type BodyWriter interface {
+	WriteBody(w io.Writer, st State) error
+}
+
+// BodyWriterFunc adapts a function to the BodyWriter interface
+type BodyWriterFunc func(io.Writer, State) error
+
+func (f BodyWriterFunc) WriteBody(w io.Writer, st State) error {
+	return f(w, st)
+}
+
+// The default response body is plain text
+var defaultBody = BodyWriterFunc(func(w io.Writer, st State) error {
+	_, err := fmt.Fprintln(w, http.StatusText(http.StatusTooManyRequests))
+	return err
+})
+
+// A DenyHandler writes the response to a request which is denied by a Gate,
+// including its status. The rate limit headers and a Retry-After header have
+// already been set, and may be changed. The request may be retried at the
+// provided time.
+type DenyHandler func(w http.ResponseWriter, req *http.Request, st State, retryAfter time.Time)
+
+// DenyStatus produces a DenyHandler which responds with the provided status,
+// e.g., 503 Service Unavailable, and a body written by the provided
+// BodyWriter, or a plain text body if it is nil.
+func DenyStatus(status int, body BodyWriter) DenyHandler {
+	if body == nil {
+		body = BodyWriterFunc(func(w io.Writer, st State) error {
+			_, err := fmt.Fprintln(w, http.StatusText(status))
+			return err
+		})
+	}
+	return func(w http.ResponseWriter, req *http.Request, st State, retryAfter time.Time) {
+		if h := w.Header(); h.Get("Content-Type") == "" {
+			h.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.WriteHeader(status)
+		body.WriteBody(w, st)
+	}
+}
+
+// A problem details object, per RFC 9457
+type problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	Instance   string `json:"instance,omitempty"`
+	RetryAfter int64  `json:"retry_after"`
+}
+
+// DenyProblem produces a DenyHandler which responds with the provided status
+// and an RFC 9457 problem details body, which includes the number of seconds
+// after which the request may be retried as the 'retry_after' extension
+// member. If the status is zero, 429 Too Many Requests is used.
+func DenyProblem(status int, detail string) DenyHandler {
+	status = ext.Coalesce(status, http.StatusTooManyRequests)
+	return func(w http.ResponseWriter, req *http.Request, st State, retryAfter time.Time) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(problem{
+			Type:       "about:blank",
+			Title:      http.StatusText(status),
+			Status:     status,
+			Detail:     detail,
+			Instance:   req.URL.Path,
+			RetryAfter: secondsUntil(time.Now(), retryAfter),
+		})
+	}
+}
+
+// Compute the whole number of seconds until a time, rounding up
+func secondsUntil(rel, t time.Time) int64 {
+	return int64(math.Max(0, math.Ceil(t.Sub(rel).Seconds())))
+}
+
+// WriteHeaders sets the 'RateLimit Fields for HTTP' headers describing the
+// provided state on a response. The reset time is expressed as delta seconds
+// relative to the reference time.
+//
+// https://datatracker.ietf.org/doc/html/draft-ietf-httpapi-ratelimit-headers
+func WriteHeaders(h http.Header, rel time.Time, st State) {
+	h.Set("RateLimit-Limit", strconv.Itoa(st.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(max(0, st.Remaining)))
+	h.Set("RateLimit-Reset", strconv.FormatInt(secondsUntil(rel, st.Reset), 10))
+}
+
+// ProxyState describes the quota a proxy may offer its own clients from the
+// quota of the upstream service tracked by the provided limiter: the
+// upstream's state, less the operations the limiter holds in reserve, as a
+// header-based limiter does when configured with a Reserve.
+func ProxyState(lim Limiter, rel time.Time) State {
+	st := lim.State(rel)
+	if r, ok := lim.(interface{ Reserved() int }); ok {
+		n := r.Reserved()
+		st.Limit = max(0, st.Limit-n)
+		st.Remaining = max(0, st.Remaining-n)
+	}
+	return st
+}
+
+// ProxyResponse produces a function, suitable for the ModifyResponse field of
+// an httputil.ReverseProxy, which updates the provided limiter from each
+// upstream response and replaces the upstream's rate limit headers with ones
+// describing the quota the proxy offers its own clients, per ProxyState.
+// Otherwise, clients would see the upstream's quota, which they do not have
+// to themselves.
+//
+// Responses which do not carry rate limit headers leave the limiter as it
+// was, and a Retry-After header from the upstream is passed through as it is.
+func ProxyResponse(lim Limiter) func(*http.Response) error {
+	return func(rsp *http.Response) error {
+		now := time.Now()
+		lim.Update(now, WithResponse(rsp)) // failures leave the state as it was
+		for k := range rsp.Header {
+			if c := strings.ToLower(k); strings.HasPrefix(c, "ratelimit") || strings.HasPrefix(c, "x-ratelimit") {
+				rsp.Header.Del(k)
+			}
+		}
+		WriteHeaders(rsp.Header, now, ProxyState(lim, now))
+		return nil
+	}
+}
+
+// WriteLimitExceeded writes a 429 Too Many Requests response describing the
+// provided state, including the rate limit headers and a Retry-After header
+// indicating when the limit resets.
+func WriteLimitExceeded(w http.ResponseWriter, st State) error {
+	return WriteLimitExceededWith(w, st, defaultBody)
+}
+
+// WriteLimitExceededWith writes a 429 Too Many Requests response like
+// WriteLimitExceeded, using the provided BodyWriter to produce its body.
+func WriteLimitExceededWith(w http.ResponseWriter, st State, body BodyWriter) error {
+	now := time.Now()
+	h := w.Header()
+	WriteHeaders(h, now, st)
+	h.Set("Retry-After", strconv.FormatInt(secondsUntil(now, st.Reset), 10))
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	return body.WriteBody(w, st)
+}
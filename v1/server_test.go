@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteLimitExceeded(t *testing.T) {
+	st := State{Limit: 100, Remaining: 0, Reset: time.Now().Add(time.Second*30 - time.Millisecond)}
+
+	rsp := httptest.NewRecorder()
+	assert.NoError(t, WriteLimitExceeded(rsp, st))
+	assert.Equal(t, http.StatusTooManyRequests, rsp.Code)
+	assert.Equal(t, "100", rsp.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rsp.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rsp.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "30", rsp.Header().Get("Retry-After"))
+	assert.Equal(t, "Too Many Requests\n", rsp.Body.String())
+
+	// the headers we produce are the headers we consume
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, ResetSemantics: Delta})
+	hdr := rsp.Header().Clone()
+	hdr.Del("Retry-After")
+	assert.NoError(t, lim.Update(time.Now(), WithAttrs(Attrs(hdr))))
+	assert.Equal(t, 100, lim.State(time.Now()).Limit)
+
+	rsp = httptest.NewRecorder()
+	rsp.Header().Set("Content-Type", "application/json")
+	assert.NoError(t, WriteLimitExceededWith(rsp, st, BodyWriterFunc(func(w io.Writer, st State) error {
+		return json.NewEncoder(w).Encode(map[string]int{"limit": st.Limit})
+	})))
+	assert.Equal(t, "application/json", rsp.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"limit":100}`, rsp.Body.String())
+}
+
+func TestProxyResponse(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 100, Reserve: 10, ResetSemantics: Delta})
+	modify := ProxyResponse(lim)
+
+	rsp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"Ratelimit-Limit":       {"100"},
+		"Ratelimit-Remaining":   {"40"},
+		"Ratelimit-Reset":       {"30"},
+		"X-Ratelimit-Remaining": {"40"},
+		"Content-Type":          {"text/plain"},
+	}}
+	if assert.NoError(t, modify(rsp)) {
+		assert.Equal(t, "90", rsp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, "30", rsp.Header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", rsp.Header.Get("RateLimit-Reset"))
+		assert.Empty(t, rsp.Header.Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "text/plain", rsp.Header.Get("Content-Type"))
+	}
+
+	// the reserve is never offered
+	rsp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"Ratelimit-Limit":     {"100"},
+		"Ratelimit-Remaining": {"5"},
+		"Ratelimit-Reset":     {"30"},
+	}}
+	if assert.NoError(t, modify(rsp)) {
+		assert.Equal(t, "0", rsp.Header.Get("RateLimit-Remaining"))
+	}
+
+	// responses without headers describe the state as it was
+	rsp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	if assert.NoError(t, modify(rsp)) {
+		assert.Equal(t, "90", rsp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, "0", rsp.Header.Get("RateLimit-Remaining"))
+	}
+}
@@ -0,0 +1,355 @@
+package ratelimit
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file implements parsing of Structured Field Values for HTTP, as
+// specified by RFC 8941, which the RateLimit and RateLimit-Policy headers use.
+// Only parsing is supported; bare items are represented as:
+//
+//   - Integer: int64
+//   - Decimal: float64
+//   - String: string
+//   - Token: sfToken
+//   - Byte Sequence: []byte
+//   - Boolean: bool
+//
+// An inner list is represented as []sfItem.
+
+// A token, distinguished from a string
+type sfToken string
+
+// An item or inner list and its parameters
+type sfItem struct {
+	value  any
+	params map[string]any
+}
+
+// A member of a list or dictionary; for list members, the key is empty
+type sfMember struct {
+	key string
+	sfItem
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("Invalid structured field at offset %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *sfParser) skipSP() {
+	for !p.eof() && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// Parse a structured field list
+func parseSFList(v string) ([]sfMember, error) {
+	p := &sfParser{s: v}
+	p.skipSP()
+	var res []sfMember
+	for !p.eof() {
+		m, err := p.itemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sfMember{sfItem: m})
+		if err := p.separator(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Parse a structured field dictionary
+func parseSFDictionary(v string) ([]sfMember, error) {
+	p := &sfParser{s: v}
+	p.skipSP()
+	var res []sfMember
+	for !p.eof() {
+		k, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var m sfItem
+		if p.peek() == '=' {
+			p.i++
+			m, err = p.itemOrInnerList()
+		} else {
+			m.value = true
+			m.params, err = p.params()
+		}
+		if err != nil {
+			return nil, err
+		}
+		// a duplicate key replaces the earlier value, but keeps its position
+		if i := sfIndex(res, k); i >= 0 {
+			res[i].sfItem = m
+		} else {
+			res = append(res, sfMember{key: k, sfItem: m})
+		}
+		if err := p.separator(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func sfIndex(m []sfMember, k string) int {
+	for i, e := range m {
+		if e.key == k {
+			return i
+		}
+	}
+	return -1
+}
+
+// Consume the separator following a list or dictionary member, if any
+func (p *sfParser) separator() error {
+	p.skipOWS()
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != ',' {
+		return p.errorf("expected ',', found %q", p.peek())
+	}
+	p.i++
+	p.skipOWS()
+	if p.eof() {
+		return p.errorf("trailing comma")
+	}
+	return nil
+}
+
+func (p *sfParser) itemOrInnerList() (sfItem, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *sfParser) innerList() (sfItem, error) {
+	p.i++ // consume '('
+	var items []sfItem
+	for !p.eof() {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.i++
+			params, err := p.params()
+			if err != nil {
+				return sfItem{}, err
+			}
+			return sfItem{value: items, params: params}, nil
+		}
+		m, err := p.item()
+		if err != nil {
+			return sfItem{}, err
+		}
+		items = append(items, m)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return sfItem{}, p.errorf("expected ' ' or ')' in inner list, found %q", c)
+		}
+	}
+	return sfItem{}, p.errorf("unterminated inner list")
+}
+
+func (p *sfParser) item() (sfItem, error) {
+	v, err := p.bareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	params, err := p.params()
+	if err != nil {
+		return sfItem{}, err
+	}
+	return sfItem{value: v, params: params}, nil
+}
+
+func (p *sfParser) params() (map[string]any, error) {
+	var res map[string]any
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		k, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var v any = true
+		if p.peek() == '=' {
+			p.i++
+			v, err = p.bareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if res == nil {
+			res = make(map[string]any)
+		}
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (p *sfParser) key() (string, error) {
+	c := p.peek()
+	if !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", p.errorf("expected a key, found %q", c)
+	}
+	start := p.i
+	for !p.eof() {
+		c := p.s[p.i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && !strings.ContainsRune("_-.*", rune(c)) {
+			break
+		}
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return p.token(), nil
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		return p.boolean()
+	default:
+		return nil, p.errorf("expected an item, found %q", c)
+	}
+}
+
+func (p *sfParser) number() (any, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	if c := p.peek(); c < '0' || c > '9' {
+		return nil, p.errorf("expected a digit, found %q", c)
+	}
+	digits, point := 0, -1
+	for !p.eof() {
+		c := p.s[p.i]
+		if c >= '0' && c <= '9' {
+			digits++
+		} else if c == '.' && point < 0 {
+			if digits > 12 {
+				return nil, p.errorf("decimal has too many integer digits")
+			}
+			point = digits
+		} else {
+			break
+		}
+		p.i++
+		if digits > 15 {
+			return nil, p.errorf("number has too many digits")
+		}
+	}
+	v := p.s[start:p.i]
+	if point < 0 {
+		return strconv.ParseInt(v, 10, 64)
+	}
+	if f := digits - point; f < 1 || f > 3 {
+		return nil, p.errorf("decimal must have between 1 and 3 fractional digits")
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+func (p *sfParser) string() (any, error) {
+	p.i++ // consume '"'
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.eof() || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+				return nil, p.errorf("invalid escape in string")
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return nil, p.errorf("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, p.errorf("unterminated string")
+}
+
+func (p *sfParser) token() sfToken {
+	start := p.i
+	p.i++
+	for !p.eof() {
+		c := p.s[p.i]
+		if !isTChar(c) && c != ':' && c != '/' {
+			break
+		}
+		p.i++
+	}
+	return sfToken(p.s[start:p.i])
+}
+
+// Determine if a character is a tchar, as defined by RFC 9110
+func isTChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func (p *sfParser) byteSequence() (any, error) {
+	p.i++ // consume ':'
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	v := p.s[p.i : p.i+end]
+	p.i += end + 1
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, p.errorf("invalid byte sequence: %v", err)
+	}
+	return b, nil
+}
+
+func (p *sfParser) boolean() (any, error) {
+	p.i++ // consume '?'
+	switch p.peek() {
+	case '0':
+		p.i++
+		return false, nil
+	case '1':
+		p.i++
+		return true, nil
+	default:
+		return nil, p.errorf("invalid boolean")
+	}
+}
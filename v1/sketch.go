@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+// Defaults for the accuracy of a sketch
+const (
+	defaultSketchEpsilon = 0.001
+	defaultSketchDelta   = 0.01
+)
+
+// Sketch limiter configuration
+type SketchConfig struct {
+	// The error in a key's count, as a proportion of all operations in the window; smaller values use more memory
+	Epsilon float64
+	// The probability that a key's count exceeds the error bound; smaller values use more memory
+	Delta float64
+}
+
+// With applies additional options to the receiver
+func (c SketchConfig) With(opts []SketchOption) SketchConfig {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+// A functional sketch limiter option
+type SketchOption func(SketchConfig) SketchConfig
+
+// WithSketchError sets the accuracy of a sketch limiter: with probability
+// 1 - delta, a key's count overestimates its true count by no more than
+// epsilon times the number of operations in the window.
+func WithSketchError(epsilon, delta float64) SketchOption {
+	return func(c SketchConfig) SketchConfig {
+		c.Epsilon, c.Delta = epsilon, delta
+		return c
+	}
+}
+
+// sketch implements a keyed rate limiter which approximates the count of
+// operations for each key in a count-min sketch, so that any number of keys,
+// such as client IPs or tokens, may be limited in bounded memory. Each key is
+// permitted the configured events per window, as by a keyed limiter over
+// NewShared, except that counts may be overestimated, so a key may be limited
+// somewhat early, but never late. Keys are provided as they are to a keyed
+// limiter.
+//
+// The memory used depends only on the accuracy configured via
+// WithSketchError; by default, about 55KB.
+type sketch struct {
+	Config
+	width int
+	depth int
+	seed  maphash.Seed
+	waiters
+
+	sync.Mutex
+	keyFunc KeyFunc
+	counts  []uint32 // depth rows of width counters
+	reset   time.Time
+}
+
+// NewSketch creates a sketch limiter which permits each key the events per
+// window described by the configuration.
+func NewSketch(conf Config, opts ...SketchOption) *sketch {
+	sc := SketchConfig{Epsilon: defaultSketchEpsilon, Delta: defaultSketchDelta}.With(opts)
+	width := max(1, int(math.Ceil(math.E/sc.Epsilon)))
+	depth := max(1, int(math.Ceil(math.Log(1/sc.Delta))))
+	return &sketch{
+		Config: conf,
+		width:  width,
+		depth:  depth,
+		seed:   maphash.MakeSeed(),
+		counts: make([]uint32, width*depth),
+	}
+}
+
+// SetKeyFunc sets the function used to derive keys from the attributes of
+// operations which do not provide a key explicitly.
+func (l *sketch) SetKeyFunc(fn KeyFunc) {
+	l.Lock()
+	defer l.Unlock()
+	l.keyFunc = fn
+}
+
+// Determine the key for an operation
+func (l *sketch) key(opts []Option) string {
+	conf := Options{}.With(opts)
+	if conf.Key != "" || conf.Attrs == nil {
+		return conf.Key
+	}
+	l.Lock()
+	fn := l.keyFunc
+	l.Unlock()
+	if fn != nil {
+		return fn(conf.Attrs)
+	}
+	return ""
+}
+
+// Determine the counters for a key, one in each row
+func (l *sketch) cells(key string) []int {
+	h := maphash.String(l.seed, key)
+	h1, h2 := h&math.MaxUint32, h>>32|1 // derive each row's hash from two, per Kirsch and Mitzenmacher
+	cells := make([]int, l.depth)
+	for i := range cells {
+		cells[i] = i*l.width + int((h1+uint64(i)*h2)%uint64(l.width))
+	}
+	return cells
+}
+
+// Clear the counters if the window has reset; the caller must hold the lock
+func (l *sketch) roll(rel time.Time) {
+	if rel.Before(l.reset) {
+		return
+	}
+	clear(l.counts)
+	c := l.Config
+	if c.Start.IsZero() {
+		c.Start, c.Align = rel, true
+	}
+	_, l.reset = c.bounds(c.origin(), rel, c.Window)
+}
+
+// Estimate the count for a key; the caller must hold the lock
+func (l *sketch) count(cells []int) uint32 {
+	n := uint32(math.MaxUint32)
+	for _, e := range cells {
+		n = min(n, l.counts[e])
+	}
+	return n
+}
+
+func (l *sketch) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.next(rel, l.key(opts))
+}
+
+// Consume one operation for a key, if its quota is not exhausted
+func (l *sketch) next(rel time.Time, key string) (time.Time, error) {
+	cells := l.cells(key)
+	l.Lock()
+	defer l.Unlock()
+	l.roll(rel)
+	n := l.count(cells)
+	if int(n) >= l.Events {
+		if l.Strict {
+			return time.Time{}, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: l.reset})
+		}
+		return l.reset, nil
+	}
+	for _, e := range cells {
+		if l.counts[e] == n { // conservative update: only the minimal counters need to grow
+			l.counts[e]++
+		}
+	}
+	return rel, nil
+}
+
+func (l *sketch) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	key := l.key(opts)
+	var d time.Duration
+	if st := l.KeyState(key, rel); st.Remaining <= 0 {
+		d = st.Reset.Sub(rel)
+	}
+	if err := overloaded(l.MaxWait, rel, d); err != nil {
+		return time.Time{}, err
+	}
+	t, err := l.next(rel, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, jitter(l.Jitter, rel, t))
+}
+
+func (l *sketch) Update(rel time.Time, opts ...Option) error {
+	// Sketch implementation does not use post-operation state
+	return nil
+}
+
+// State describes the quota of the empty key. Use KeyState to obtain the
+// state of a specific key.
+func (l *sketch) State(rel time.Time) State {
+	return l.KeyState("", rel)
+}
+
+// KeyState describes the estimated quota remaining for the provided key
+func (l *sketch) KeyState(key string, rel time.Time) State {
+	cells := l.cells(key)
+	l.Lock()
+	defer l.Unlock()
+	l.roll(rel)
+	return State{
+		Limit:     l.Events,
+		Remaining: max(0, l.Events-int(l.count(cells))),
+		Reset:     l.reset,
+	}
+}
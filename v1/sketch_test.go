@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSketch(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewSketch(Config{Start: now, Window: time.Minute, Events: 2})
+
+	tests := []struct {
+		Key  string
+		Next time.Time
+	}{
+		{"a", now},
+		{"b", now},
+		{"a", now},
+		{"a", now.Add(time.Minute)},
+		{"b", now},
+		{"b", now.Add(time.Minute)},
+	}
+	for i, e := range tests {
+		next, err := lim.Next(now, WithKey(e.Key))
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, e.Next, next, "#%d", i)
+		}
+	}
+	assert.Equal(t, State{Limit: 2, Remaining: 0, Reset: now.Add(time.Minute)}, lim.KeyState("a", now))
+	assert.Equal(t, State{Limit: 2, Remaining: 2, Reset: now.Add(time.Minute)}, lim.KeyState("c", now))
+
+	// the window resets
+	next, err := lim.Next(now.Add(time.Minute), WithKey("a"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, now.Add(time.Minute), next)
+	}
+	assert.Equal(t, 1, lim.KeyState("a", now.Add(time.Minute)).Remaining)
+
+	lim = NewSketch(Config{Start: now, Window: time.Minute, Events: 1, Strict: true})
+	_, err = lim.Next(now)
+	assert.NoError(t, err)
+	_, err = lim.Next(now)
+	assert.ErrorIs(t, err, ErrExhausted)
+}
+
+func TestSketchError(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	// with 5000 operations in the window, counts are overestimated by no more
+	// than 5 with high probability, well within each key's quota
+	lim := NewSketch(Config{Start: now, Window: time.Minute, Events: 10}, WithSketchError(0.001, 0.01))
+
+	var limited int
+	const n = 5000
+	for i := 0; i < n; i++ {
+		next, err := lim.Next(now, WithKey(fmt.Sprint(i)))
+		if assert.NoError(t, err) && next.After(now) {
+			limited++
+		}
+	}
+	// each key is seen once, so any limiting is due to overestimation
+	assert.Less(t, limited, n/100, "too many keys were limited early")
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// The version of the snapshot schema. It is incremented only when fields are
+// removed or their meaning changes; fields may be added without notice.
+const SnapshotVersion = 1
+
+// A Snapshot is the state of every limiter in a registry at a point in time.
+// Its JSON representation is a stable schema suitable for periodically
+// exporting to logs or object storage for long-term analysis, e.g., of quota
+// utilization trends. Durations are expressed in seconds and every field is
+// always present, so that records may be charted without transformation.
+type Snapshot struct {
+	Version  int               `json:"version"`
+	Time     time.Time         `json:"time"`
+	Limiters []LimiterSnapshot `json:"limiters"`
+}
+
+// A LimiterSnapshot is the state of a single named limiter
+type LimiterSnapshot struct {
+	Name           string    `json:"name"`            // the name the limiter is registered under
+	Limit          int       `json:"limit"`           // events permitted in the current window
+	Remaining      int       `json:"remaining"`       // events remaining in the current window
+	Utilization    float64   `json:"utilization"`     // the fraction of the limit consumed, from 0 to 1
+	Reset          time.Time `json:"reset"`           // when the current window resets
+	ResetSeconds   float64   `json:"reset_seconds"`   // the seconds until the current window resets
+	Low            bool      `json:"low"`             // remaining quota is below the low watermark
+	Critical       bool      `json:"critical"`        // remaining quota is below the critical watermark
+	Paused         bool      `json:"paused"`          // the limiter is paused
+	Pending        int       `json:"pending"`         // callers currently blocked in Wait
+	BackoffSeconds float64   `json:"backoff_seconds"` // the seconds until a backoff ends
+}
+
+// Snapshot captures the state of every registered limiter relative to the
+// provided time, ordered by name
+func (r *Registry) Snapshot(rel time.Time) Snapshot {
+	limiters := r.Limiters()
+	res := Snapshot{
+		Version:  SnapshotVersion,
+		Time:     rel,
+		Limiters: make([]LimiterSnapshot, 0, len(limiters)),
+	}
+	for _, e := range limiters {
+		res.Limiters = append(res.Limiters, snapshotOf(e, rel))
+	}
+	return res
+}
+
+// SnapshotAll captures the state of every limiter in the default registry
+func SnapshotAll() Snapshot {
+	return DefaultRegistry.Snapshot(time.Now())
+}
+
+// Produce the snapshot of a limiter, including whatever optional information
+// it provides
+func snapshotOf(e NamedLimiter, rel time.Time) LimiterSnapshot {
+	st := e.Limiter.State(rel)
+	res := LimiterSnapshot{
+		Name:      e.Name,
+		Limit:     st.Limit,
+		Remaining: st.Remaining,
+		Reset:     st.Reset,
+		Low:       st.Low,
+		Critical:  st.Critical,
+	}
+	if st.Limit > 0 {
+		res.Utilization = min(1, max(0, float64(st.Limit-st.Remaining)/float64(st.Limit)))
+	}
+	if !st.Reset.IsZero() {
+		res.ResetSeconds = max(0, st.Reset.Sub(rel).Seconds())
+	}
+	if v, ok := e.Limiter.(interface{ Paused() bool }); ok {
+		res.Paused = v.Paused()
+	}
+	if v, ok := e.Limiter.(interface{ Pending() int }); ok {
+		res.Pending = v.Pending()
+	}
+	if v, ok := e.Limiter.(interface{ BackoffEnd() time.Time }); ok {
+		res.BackoffSeconds = max(0, v.BackoffEnd().Sub(rel).Seconds())
+	}
+	return res
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// split divides the quota of a parent limiter among named consumers in
+// proportion to their weights. A consumer which has used its share of the
+// current window may borrow quota which the other consumers have not claimed.
+// Only consumers which have been active in the current or previous window
+// claim their unused share.
+type split struct {
+	sync.Mutex
+	parent  Limiter
+	weights map[string]float64
+	total   float64
+	used    map[string]int
+	prev    map[string]int // consumption in the previous window
+	window  time.Time      // the reset time of the window consumption is counted in
+}
+
+// splitChild is the limiter provided to an individual consumer of a split
+type splitChild struct {
+	split *split
+	name  string
+	waiters
+}
+
+// Split creates a limiter for each named consumer which shares the quota of
+// the parent limiter according to the provided weights. Operations are paced
+// by the parent, but a consumer which has used its proportion of the parent's
+// window is delayed until the window resets unless the quota it needs is not
+// claimed by the other consumers, which is the case when they have not used
+// their share recently. The parent should not be used directly once it has
+// been split.
+func Split(lim Limiter, weights map[string]float64) map[string]Limiter {
+	s := &split{
+		parent:  lim,
+		weights: make(map[string]float64),
+		used:    make(map[string]int),
+		prev:    make(map[string]int),
+	}
+	for k, v := range weights {
+		if v > 0 {
+			s.weights[k] = v
+			s.total += v
+		}
+	}
+	res := make(map[string]Limiter)
+	for k := range s.weights {
+		res[k] = &splitChild{split: s, name: k}
+	}
+	return res
+}
+
+// The share of the provided limit to which a consumer is entitled; the caller
+// must hold the lock.
+func (s *split) share(name string, limit int) float64 {
+	return float64(limit) * s.weights[name] / s.total
+}
+
+// Reset consumption when the parent's window has turned over; the caller must
+// hold the lock.
+func (s *split) roll(st State) {
+	if !st.Reset.Equal(s.window) {
+		s.prev, s.used = s.used, s.prev
+		clear(s.used)
+		s.window = st.Reset
+	}
+}
+
+func (s *split) next(name string, rel time.Time, opts []Option) (time.Time, error) {
+	s.Lock()
+	defer s.Unlock()
+	st := s.parent.State(rel)
+	s.roll(st)
+	if float64(s.used[name]) >= s.share(name, st.Limit) {
+		var claimed float64
+		for k := range s.weights {
+			if k != name && (s.used[k] > 0 || s.prev[k] > 0) {
+				claimed += max(0, s.share(k, st.Limit)-float64(s.used[k]))
+			}
+		}
+		if float64(st.Remaining)-claimed < 1 {
+			return st.Reset, nil // nothing to borrow; wait for the next window
+		}
+	}
+	t, err := s.parent.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	s.used[name]++
+	return t, nil
+}
+
+func (s *split) state(name string, rel time.Time) State {
+	s.Lock()
+	defer s.Unlock()
+	st := s.parent.State(rel)
+	s.roll(st)
+	share := int(s.share(name, st.Limit))
+	return State{
+		Limit:     share,
+		Remaining: max(0, min(share-s.used[name], st.Remaining)),
+		Reset:     st.Reset,
+		Low:       st.Low,
+		Critical:  st.Critical,
+	}
+}
+
+func (l *splitChild) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.split.next(l.name, rel, opts)
+}
+
+func (l *splitChild) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	t, err := l.Next(rel, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, t)
+}
+
+func (l *splitChild) Update(rel time.Time, opts ...Option) error {
+	return l.split.parent.Update(rel, opts...)
+}
+
+// State returns the consumer's share of the parent's quota and how much of it
+// remains in the current window.
+func (l *splitChild) State(rel time.Time) State {
+	return l.split.state(l.name, rel)
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	reset := now.Add(time.Minute)
+	parent := NewHeaders(Config{Start: now, Window: time.Minute, Events: 8, Mode: Burst, ResetSemantics: Delta})
+	lim := Split(parent, map[string]float64{"a": 3, "b": 1, "c": 4})
+	a, b := lim["a"], lim["b"]
+
+	// c is never active and its share may be borrowed; a is active and claims its share
+	parent.impl.Update(16, 16, reset)
+	tests := []struct {
+		Limiter Limiter
+		Count   int
+		Expect  time.Time
+	}{
+		{a, 1, now},   // a becomes active
+		{b, 2, now},   // b uses its share of 2
+		{b, 8, now},   // b borrows c's unused share
+		{b, 1, reset}, // b cannot borrow the rest of a's share
+		{a, 5, now},   // a receives the rest of its share
+		{a, 1, reset}, // the quota is exhausted
+	}
+	for i, e := range tests {
+		for j := 0; j < e.Count; j++ {
+			next, err := e.Limiter.Next(now)
+			if assert.NoError(t, err) {
+				assert.Equal(t, e.Expect, next, "#%d.%d", i, j)
+			}
+		}
+	}
+	assert.Equal(t, State{Limit: 6, Remaining: 0, Reset: reset, Low: true, Critical: true}, a.State(now))
+
+	// a new window begins; a was active in the last one and still claims its share
+	err := b.Update(now, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"16"},
+		"Ratelimit-Remaining": []string{"16"},
+		"Ratelimit-Reset":     []string{"120"},
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, b.State(now).Remaining)
+		for i := 0; i < 10; i++ {
+			next, err := b.Next(now)
+			if assert.NoError(t, err) {
+				assert.Equal(t, now, next, "#%d", i)
+			}
+		}
+		next, err := b.Next(now)
+		if assert.NoError(t, err) {
+			assert.Equal(t, now.Add(time.Minute*2), next)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package sqllimit adapts rate limiters to database/sql drivers, so that the
+// rate at which statements are executed against a database, or at which
+// connections are opened to it, is limited. A database handle is opened with
+// a limiter in place of sql.Open:
+//
+//	db, err := sqllimit.Open("postgres", dsn, lim, sqllimit.WithKey(tenantFromContext))
+//
+// Drivers which provide a connector, such as pgx via stdlib.GetConnector, are
+// wrapped directly and opened with sql.OpenDB:
+//
+//	db := sql.OpenDB(sqllimit.WrapConnector(stdlib.GetConnector(*conf), lim))
+package sqllimit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+)
+
+// What is limited
+type Scope int
+
+const (
+	// Each statement executed or query performed waits on the limiter
+	Statement Scope = iota
+	// Each connection opened waits on the limiter; statements are not limited
+	Connection
+)
+
+// Driver wrapper configuration
+type Config struct {
+	// What is limited; statements, by default
+	Scope Scope
+	// Derives the key an operation is limited under from its context, e.g., to
+	// limit each tenant separately; if nil, operations are not keyed
+	Key func(context.Context) string
+}
+
+// With applies additional options to the receiver
+func (c Config) With(opts []Option) Config {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+// A functional driver wrapper option
+type Option func(Config) Config
+
+// WithScope sets what is limited
+func WithScope(s Scope) Option {
+	return func(c Config) Config {
+		c.Scope = s
+		return c
+	}
+}
+
+// WithKey sets the function used to derive the key an operation is limited
+// under from its context
+func WithKey(fn func(context.Context) string) Option {
+	return func(c Config) Config {
+		c.Key = fn
+		return c
+	}
+}
+
+// A limit shared by the components of a wrapped driver
+type limit struct {
+	lim  ratelimit.Limiter
+	conf Config
+}
+
+// Wait on the limiter for an operation in the provided scope
+func (l limit) wait(cxt context.Context, scope Scope) error {
+	if scope != l.conf.Scope {
+		return nil
+	}
+	var key string
+	if l.conf.Key != nil {
+		key = l.conf.Key(cxt)
+	}
+	_, err := l.lim.Wait(cxt, time.Now(), ratelimit.WithKey(key))
+	return err
+}
+
+// Open opens a database handle for the named driver, as sql.Open does, whose
+// operations are limited under the provided limiter.
+func Open(name, dsn string, lim ratelimit.Limiter, opts ...Option) (*sql.DB, error) {
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	db.Close() // we only needed it to find the driver; no connections were opened
+	if dc, ok := d.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create connector: %w", err)
+		}
+		return sql.OpenDB(WrapConnector(c, lim, opts...)), nil
+	}
+	return sql.OpenDB(WrapConnector(dsnConnector{dsn: dsn, driver: d}, lim, opts...)), nil
+}
+
+// A connector for drivers which do not provide their own
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Wrap produces a driver whose operations are limited under the provided
+// limiter. It may be registered with sql.Register in place of the original.
+func Wrap(d driver.Driver, lim ratelimit.Limiter, opts ...Option) driver.Driver {
+	return &wrappedDriver{
+		Driver: d,
+		limit:  limit{lim: lim, conf: Config{}.With(opts)},
+	}
+}
+
+type wrappedDriver struct {
+	driver.Driver
+	limit limit
+}
+
+// Open opens a connection. Since no context is available, waiting for a
+// connection cannot be canceled; prefer WrapConnector.
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	if err := d.limit.wait(context.Background(), Connection); err != nil {
+		return nil, err
+	}
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, limit: d.limit}, nil
+}
+
+// WrapConnector produces a connector whose connections and their operations
+// are limited under the provided limiter.
+func WrapConnector(c driver.Connector, lim ratelimit.Limiter, opts ...Option) driver.Connector {
+	l := limit{lim: lim, conf: Config{}.With(opts)}
+	return &connector{
+		Connector: c,
+		driver:    &wrappedDriver{Driver: c.Driver(), limit: l},
+		limit:     l,
+	}
+}
+
+type connector struct {
+	driver.Connector
+	driver driver.Driver
+	limit  limit
+}
+
+func (c *connector) Connect(cxt context.Context) (driver.Conn, error) {
+	if err := c.limit.wait(cxt, Connection); err != nil {
+		return nil, err
+	}
+	n, err := c.Connector.Connect(cxt)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: n, limit: c.limit}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// conn limits the statements executed on a connection. It implements the
+// context-aware driver interfaces, returning driver.ErrSkip where the
+// underlying connection does not, so that database/sql falls back to
+// preparing statements, which are also limited.
+type conn struct {
+	driver.Conn
+	limit limit
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(cxt context.Context, query string) (driver.Stmt, error) {
+	var s driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = p.PrepareContext(cxt, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, conn: c}, nil
+}
+
+func (c *conn) ExecContext(cxt context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.limit.wait(cxt, Statement); err != nil {
+		return nil, err
+	}
+	return e.ExecContext(cxt, query, args)
+}
+
+func (c *conn) QueryContext(cxt context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.limit.wait(cxt, Statement); err != nil {
+		return nil, err
+	}
+	return q.QueryContext(cxt, query, args)
+}
+
+func (c *conn) BeginTx(cxt context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(cxt, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) || opts.ReadOnly {
+		return nil, errors.New("Driver does not support transaction options")
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) Ping(cxt context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(cxt)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(cxt context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(cxt)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip // use the default conversion
+}
+
+// stmt limits the executions of a prepared statement
+type stmt struct {
+	driver.Stmt
+	conn *conn
+}
+
+func (s *stmt) ExecContext(cxt context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.conn.limit.wait(cxt, Statement); err != nil {
+		return nil, err
+	}
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(cxt, args)
+	}
+	vals, err := values(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(vals)
+}
+
+func (s *stmt) QueryContext(cxt context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.conn.limit.wait(cxt, Statement); err != nil {
+		return nil, err
+	}
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(cxt, args)
+	}
+	vals, err := values(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(vals)
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	if c, ok := s.Stmt.(driver.ColumnConverter); ok {
+		v, err := c.ColumnConverter(nv.Ordinal - 1).ConvertValue(nv.Value)
+		if err != nil {
+			return err
+		}
+		nv.Value = v
+		return nil
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+// Convert arguments for drivers which only accept positional values
+func values(args []driver.NamedValue) ([]driver.Value, error) {
+	vals := make([]driver.Value, len(args))
+	for i, e := range args {
+		if e.Name != "" {
+			return nil, errors.New("Driver does not support named parameters")
+		}
+		vals[i] = e.Value
+	}
+	return vals, nil
+}
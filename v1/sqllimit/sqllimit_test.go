@@ -0,0 +1,139 @@
+package sqllimit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/bww/go-ratelimit/v1/ratelimittest"
+	"github.com/stretchr/testify/assert"
+)
+
+type tenantKey struct{}
+
+func tenant(cxt context.Context) string {
+	v, _ := cxt.Value(tenantKey{}).(string)
+	return v
+}
+
+// A driver which accepts any statement and produces no rows. When legacy is
+// set, its connections do not implement the context-aware interfaces, so that
+// database/sql prepares statements instead.
+type fakeDriver struct {
+	legacy bool
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	if d.legacy {
+		return legacyConn{}, nil
+	}
+	return fakeConn{}, nil
+}
+
+type legacyConn struct{}
+
+func (c legacyConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (c legacyConn) Close() error                        { return nil }
+func (c legacyConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+type fakeConn struct {
+	legacyConn
+}
+
+func (c fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeStmt struct{}
+
+func (s fakeStmt) Close() error                               { return nil }
+func (s fakeStmt) NumInput() int                              { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeTx struct{}
+
+func (t fakeTx) Commit() error   { return nil }
+func (t fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (r fakeRows) Columns() []string         { return nil }
+func (r fakeRows) Close() error              { return nil }
+func (r fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func init() {
+	sql.Register("sqllimit-fake", fakeDriver{})
+}
+
+func TestStatements(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		rec := ratelimittest.Record(ratelimittest.NewFake(ratelimittest.Permit(0), ratelimittest.Permit(0), ratelimittest.Permit(0)))
+		db := sql.OpenDB(WrapConnector(dsnConnector{driver: fakeDriver{legacy: legacy}}, rec, WithKey(tenant)))
+
+		cxt := context.WithValue(context.Background(), tenantKey{}, "acme")
+		_, err := db.ExecContext(cxt, "UPDATE a SET b = ?", 1)
+		assert.NoError(t, err, "legacy: %v", legacy)
+		rows, err := db.QueryContext(cxt, "SELECT a FROM b")
+		if assert.NoError(t, err, "legacy: %v", legacy) {
+			rows.Close()
+		}
+		_, err = db.Exec("DELETE FROM a")
+		assert.NoError(t, err, "legacy: %v", legacy)
+
+		// the script is exhausted, so the next statement is refused
+		_, err = db.Exec("DELETE FROM a")
+		assert.ErrorIs(t, err, ratelimittest.ErrScriptExhausted, "legacy: %v", legacy)
+
+		calls := rec.Calls()
+		if assert.Len(t, calls, 4, "legacy: %v", legacy) {
+			for i, e := range []string{"acme", "acme", "", ""} {
+				assert.Equal(t, e, calls[i].Options.Key, "legacy: %v #%d", legacy, i)
+			}
+		}
+		db.Close()
+	}
+}
+
+func TestConnections(t *testing.T) {
+	rec := ratelimittest.Record(ratelimittest.NewFake(ratelimittest.Permit(0)))
+	db := sql.OpenDB(WrapConnector(dsnConnector{driver: fakeDriver{}}, rec, WithScope(Connection)))
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 3; i++ {
+		_, err := db.Exec("DELETE FROM a")
+		assert.NoError(t, err, "#%d", i)
+	}
+	assert.Len(t, rec.Calls(), 1) // the connection is reused
+}
+
+func TestOpen(t *testing.T) {
+	lim := ratelimit.NewLinear(ratelimit.Config{Window: time.Second, Events: 20})
+	db, err := Open("sqllimit-fake", "", lim)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	var ops []time.Time
+	for i := 0; i < 4; i++ {
+		_, err := db.Exec("DELETE FROM a")
+		assert.NoError(t, err, "#%d", i)
+		ops = append(ops, time.Now())
+	}
+	ratelimittest.AssertSpacing(t, ops[1:], time.Millisecond*40)
+
+	cxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.ExecContext(cxt, "DELETE FROM a")
+	assert.Error(t, err)
+}
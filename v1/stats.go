@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// The period over which statistics are smoothed; observations older than this
+// carry roughly a third of the weight of new ones
+const statsPeriod = time.Minute
+
+// Stats describes the observed behavior of a limiter, exponentially smoothed
+// over roughly the last minute
+type Stats struct {
+	// The rate at which operations are being requested, per second
+	Rate float64
+	// The average delay imposed on operations
+	Delay time.Duration
+	// The proportion of operations which were delayed by a backoff
+	Backoff float64
+}
+
+// ewma maintains exponentially weighted moving averages of limiter behavior.
+// Averages decay with time rather than per-observation so that they reflect
+// the same period regardless of how frequently operations occur.
+type ewma struct {
+	last    time.Time
+	rate    float64 // operations per second
+	delay   float64 // nanoseconds
+	backoff float64 // proportion
+}
+
+// Record an operation at the provided time
+func (s *ewma) observe(rel time.Time, d time.Duration, b bool) {
+	var x float64
+	if b {
+		x = 1
+	}
+	if s.last.IsZero() {
+		s.last = rel
+		s.rate = 1 / statsPeriod.Seconds()
+		s.delay = float64(d)
+		s.backoff = x
+		return
+	}
+	dt := rel.Sub(s.last)
+	if dt < 0 {
+		dt = 0 // observations may arrive slightly out of order
+	} else {
+		s.last = rel
+	}
+	w := math.Exp(-float64(dt) / float64(statsPeriod))
+	s.rate = s.rate*w + 1/statsPeriod.Seconds()
+	s.delay = s.delay*w + float64(d)*(1-w)
+	s.backoff = s.backoff*w + x*(1-w)
+}
+
+// Produce a snapshot of the current averages
+func (s *ewma) Stats() Stats {
+	return Stats{
+		Rate:    s.rate,
+		Delay:   time.Duration(s.delay),
+		Backoff: s.backoff,
+	}
+}
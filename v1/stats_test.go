@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Hour, Events: 100000, Mode: Burst})
+
+	// one operation per second, none of which are delayed
+	for i := 0; i < 600; i++ {
+		_, err := lim.Next(now.Add(time.Second*time.Duration(i)), WithAttrs(Attrs{}))
+		assert.NoError(t, err)
+	}
+	s := lim.Stats()
+	assert.InDelta(t, 1.0, s.Rate, 0.05)
+	assert.Equal(t, time.Duration(0), s.Delay)
+	assert.Equal(t, 0.0, s.Backoff)
+
+	// a backoff delays every subsequent operation
+	now = now.Add(time.Second * 600)
+	lim.impl.BackoffUntil(now.Add(time.Hour))
+	for i := 0; i < 600; i++ {
+		_, err := lim.Next(now.Add(time.Second*time.Duration(i)), WithAttrs(Attrs{}))
+		assert.NoError(t, err)
+	}
+	s = lim.Stats()
+	assert.InDelta(t, 1.0, s.Rate, 0.05)
+	assert.InDelta(t, 1.0, s.Backoff, 0.01)
+	assert.InDelta(t, float64(time.Minute*51), float64(s.Delay), float64(time.Minute))
+}
@@ -0,0 +1,233 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A Store persists limiter state so that a quota can be shared by limiters in
+// different processes.
+type Store interface {
+	// Modify atomically reads the state for a key, applies the function to it,
+	// and persists the result. If no state exists for the key, the function
+	// receives a zero State. If the function returns an error, nothing is
+	// persisted and the error is returned.
+	Modify(cxt context.Context, key string, fn func(*State) error) error
+}
+
+// memoryStore is a Store which keeps state in memory. It is useful for
+// fixed-window limiting within a single process, such as in server
+// middleware.
+type memoryStore struct {
+	sync.Mutex
+	state map[string]State
+}
+
+// NewMemoryStore creates a Store which keeps state in memory
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{
+		state: make(map[string]State),
+	}
+}
+
+func (s *memoryStore) Modify(cxt context.Context, key string, fn func(*State) error) error {
+	s.Lock()
+	defer s.Unlock()
+	v := s.state[key]
+	if err := fn(&v); err != nil {
+		return err
+	}
+	s.state[key] = v
+	return nil
+}
+
+// shared implements a fixed-window rate limiter whose state is kept in a
+// Store, so that every limiter using the same store and key draws from the
+// same quota. Windows begin at multiples of the window duration after the
+// configured start time, or after the zero time if no start is configured, so
+// that independent processes agree on where windows fall.
+//
+// Operations are permitted as long as the window has budget remaining, after
+// which they are delayed until the window resets, as in Burst mode. When
+// several limits are configured, each is counted separately and operations
+// are permitted only when every one of them has budget remaining.
+type shared struct {
+	Config
+	store Store
+	key   string
+	waiters
+}
+
+func NewShared(conf Config, store Store, key string) *shared {
+	return &shared{
+		Config: conf,
+		store:  store,
+		key:    key,
+	}
+}
+
+// Determine the end of the window of the provided duration containing the
+// reference time
+func (l *shared) reset(rel time.Time, window time.Duration) time.Time {
+	c := l.Config
+	c.Window = window
+	if c.Start.IsZero() {
+		c.Start, c.Align = rel, true // without a common start, instances align their windows
+	}
+	_, end := c.bounds(c.origin(), rel, window)
+	return end
+}
+
+// Determine the store key under which consumption of a limit is counted; the
+// first limit uses the configured key itself
+func (l *shared) limitKey(i int, lim Limit) string {
+	if i == 0 {
+		return l.key
+	} else {
+		return fmt.Sprintf("%s/%v", l.key, lim.Window)
+	}
+}
+
+func (l *shared) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.next(context.Background(), rel)
+}
+
+// Consume one operation from every limit. If any limit is exhausted, the
+// operations already consumed from the others are returned to them.
+func (l *shared) next(cxt context.Context, rel time.Time) (time.Time, error) {
+	limits := l.limits()
+	var next time.Time
+	for i, lim := range limits {
+		var consumed bool
+		err := l.store.Modify(cxt, l.limitKey(i, lim), func(s *State) error {
+			if !rel.Before(s.Reset) { // the window has reset
+				*s = l.roll(*s, rel, lim)
+			}
+			if s.Remaining > -max(0, l.Debt) { // we may borrow from subsequent windows
+				s.Remaining--
+				consumed = true
+			} else {
+				next = s.Reset
+			}
+			return nil
+		})
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Could not compute next window: %w", err)
+		}
+		if !consumed {
+			l.refund(cxt, rel, limits[:i])
+			if l.BoundDelay && next.Sub(rel) > lim.Window {
+				next = rel.Add(lim.Window) // the stored reset is implausible
+			}
+			if l.Strict {
+				return time.Time{}, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: next})
+			}
+			return next, nil
+		}
+	}
+	return rel, nil
+}
+
+// Produce the state of a limit in the window containing the reference time,
+// which follows the window the provided state describes. Any debt incurred in
+// earlier windows is repaid from the quota of those which have since begun.
+func (l *shared) roll(s State, rel time.Time, lim Limit) State {
+	remaining := lim.Events
+	if s.Remaining < 0 && !s.Reset.IsZero() {
+		n := 1 + int64(rel.Sub(s.Reset)/lim.Window) // the number of windows which have begun since
+		remaining = int(min(int64(lim.Events), int64(s.Remaining)+n*int64(lim.Events)))
+	}
+	return State{Limit: lim.Events, Remaining: remaining, Reset: l.reset(rel, lim.Window)}
+}
+
+// Return an operation to each of the provided limits, unless its window has
+// since reset. This is best-effort; failures are ignored.
+func (l *shared) refund(cxt context.Context, rel time.Time, limits []Limit) {
+	for i, lim := range limits {
+		l.store.Modify(cxt, l.limitKey(i, lim), func(s *State) error {
+			if rel.Before(s.Reset) && s.Remaining < s.Limit {
+				s.Remaining++
+			}
+			return nil
+		})
+	}
+}
+
+func (l *shared) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	if err := overloaded(l.MaxWait, rel, l.EstimatedWait(rel)); err != nil {
+		return time.Time{}, err
+	}
+	t, err := l.next(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.sleep(cxt, rel, jitter(l.Jitter, rel, t))
+}
+
+// EstimatedWait returns the delay a new operation would incur relative to the
+// provided time, without consuming any budget. If the state cannot be read
+// from the store, the estimate is zero.
+func (l *shared) EstimatedWait(rel time.Time) time.Duration {
+	if st := l.state(rel); st.Remaining > -max(0, l.Debt) {
+		return 0
+	} else {
+		return st.Reset.Sub(rel)
+	}
+}
+
+// Peek returns the time at which the next operation could proceed relative to
+// the provided time, as Next does, without consuming any budget. If the state
+// cannot be read from the store, no delay is forecast.
+func (l *shared) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	t := rel.Add(l.EstimatedWait(rel))
+	if l.Strict && t.After(rel) {
+		return time.Time{}, fmt.Errorf("Could not compute next window: %w", ExhaustedError{Reset: t})
+	}
+	return t, nil
+}
+
+func (l *shared) Update(rel time.Time, opts ...Option) error {
+	// Shared implementation does not use post-operation state
+	return nil
+}
+
+// State describes the shared quota. When several limits apply, the state of
+// the most constrained is returned. If the state cannot be read from the
+// store, a zero State is returned. While quota is borrowed from subsequent
+// windows, none is remaining.
+func (l *shared) State(rel time.Time) State {
+	st := l.state(rel)
+	st.Remaining = max(0, st.Remaining)
+	return st
+}
+
+// Describe the shared quota, in which the quota remaining is negative while
+// it is borrowed from subsequent windows
+func (l *shared) state(rel time.Time) State {
+	var state State
+	for i, lim := range l.limits() {
+		var curr State
+		err := l.store.Modify(context.Background(), l.limitKey(i, lim), func(s *State) error {
+			if !rel.Before(s.Reset) {
+				curr = l.roll(*s, rel, lim)
+			} else {
+				curr = *s
+			}
+			return nil
+		})
+		if err != nil {
+			return State{}
+		}
+		if i == 0 || curr.Remaining < state.Remaining || (curr.Remaining == state.Remaining && curr.Reset.After(state.Reset)) {
+			state = curr
+		}
+	}
+	return state
+}
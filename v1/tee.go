@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// tee implements a rate limiter which delivers updates to several limiters,
+// e.g., both a per-endpoint and a global limiter, but is paced by only the
+// first of them, the primary.
+type tee struct {
+	limiters []Limiter
+	waiters
+}
+
+// Tee creates a limiter which answers Next, Wait, and State from the first of
+// the provided limiters, the primary, and delivers every Update to all of
+// them, so that one response informs each limiter it bears on:
+//
+//	global := NewHeaders(conf)
+//	endpoint := NewHeaders(conf)
+//	lim := Tee(endpoint, global)
+//
+// The other limiters are not consulted when operations are paced; to honor
+// them as well, pace operations with them separately, or cap the primary. If
+// no limiters are provided, no limit is imposed, as by None.
+func Tee(limiters ...Limiter) *tee {
+	if len(limiters) == 0 {
+		limiters = []Limiter{None()}
+	}
+	return &tee{limiters: limiters}
+}
+
+// Primary returns the limiter which paces operations
+func (l *tee) Primary() Limiter {
+	return l.limiters[0]
+}
+
+func (l *tee) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	return l.Primary().Next(rel, opts...)
+}
+
+// Peek forecasts the next operation on the primary limiter; see Peek
+func (l *tee) Peek(rel time.Time, opts ...Option) (time.Time, error) {
+	return Peek(l.Primary(), rel, opts...)
+}
+
+func (l *tee) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	rel, err := l.enter(cxt, rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer l.leave()
+	cxt, cancel := l.derive(cxt)
+	defer cancel()
+	return l.Primary().Wait(cxt, rel, opts...)
+}
+
+// Update delivers the update to every limiter, even if some fail. The errors
+// produced, such as a RetryError from each limiter which was asked to back
+// off, are returned together.
+func (l *tee) Update(rel time.Time, opts ...Option) error {
+	var errs []error
+	for _, e := range l.limiters {
+		if err := e.Update(rel, opts...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *tee) State(rel time.Time) State {
+	return l.Primary().State(rel)
+}
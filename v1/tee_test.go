@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTee(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	endpoint := NewHeaders(Config{Start: now, Window: time.Minute, Events: 10, Mode: Burst})
+	global := NewHeaders(Config{Start: now, Window: time.Minute, Events: 100, Mode: Burst})
+	lim := Tee(endpoint, global)
+
+	next, err := lim.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, next)
+	}
+	assert.Equal(t, 9, lim.State(now).Remaining)
+	assert.Equal(t, 100, global.State(now).Remaining) // only the primary paces
+
+	err = lim.Update(now, WithAttrs(Attrs{
+		"Ratelimit-Limit":     []string{"50"},
+		"Ratelimit-Remaining": []string{"20"},
+		"Ratelimit-Reset":     []string{"30"},
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 20, endpoint.State(now).Remaining)
+		assert.Equal(t, 20, global.State(now).Remaining)
+	}
+
+	// every limiter is updated, even when some fail
+	err = lim.Update(now, WithAttrs(Attrs{"Retry-After": []string{"10"}}))
+	var rerr RetryError
+	if assert.True(t, errors.As(err, &rerr)) {
+		assert.Equal(t, now.Add(time.Second*10), rerr.RetryAfter)
+	}
+	assert.Equal(t, now.Add(time.Second*10), endpoint.BackoffEnd())
+	assert.Equal(t, now.Add(time.Second*10), global.BackoffEnd())
+
+	none := Tee()
+	next, err = none.Next(now)
+	if assert.NoError(t, err) {
+		assert.Equal(t, now, next)
+	}
+}
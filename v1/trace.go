@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The operations recorded in a trace
+const (
+	TraceNext   = "next"
+	TraceWait   = "wait"
+	TraceUpdate = "update"
+)
+
+// A TraceEntry records an operation on a limiter and the inputs provided to
+// it, so that the operation can be replayed
+type TraceEntry struct {
+	Op         string    `json:"op"`
+	Time       time.Time `json:"time"`
+	Key        string    `json:"key,omitempty"`
+	Attrs      Attrs     `json:"attrs,omitempty"`
+	ObservedAt time.Time `json:"observed_at,omitempty"`
+	Status     int       `json:"status,omitempty"`
+}
+
+// Produce the options recorded in an entry
+func (e TraceEntry) options() []Option {
+	return []Option{Options{Attrs: e.Attrs, Key: e.Key, ObservedAt: e.ObservedAt, Status: e.Status}.Option()}
+}
+
+// traced wraps a limiter and records the operations performed on it to a
+// writer, one JSON entry per line, so that they can be replayed later with
+// Replay to reproduce its behavior.
+type traced struct {
+	Limiter
+	sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// Trace wraps a limiter so that the operations performed on it are recorded
+// to the provided writer. Writes are serialized, but the writer is not
+// buffered or flushed; the first error produced writing is retained and
+// reported by Err, after which no further entries are written.
+func Trace(lim Limiter, w io.Writer) *traced {
+	return &traced{Limiter: lim, enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error produced writing the trace, if any
+func (l *traced) Err() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.err
+}
+
+func (l *traced) record(op string, rel time.Time, opts []Option) {
+	conf := Options{}.With(opts)
+	l.Lock()
+	defer l.Unlock()
+	if l.err != nil {
+		return
+	}
+	err := l.enc.Encode(TraceEntry{Op: op, Time: rel, Key: conf.Key, Attrs: conf.Attrs, ObservedAt: conf.ObservedAt, Status: conf.Status})
+	if err != nil {
+		l.err = fmt.Errorf("Could not write trace: %w", err)
+	}
+}
+
+func (l *traced) Next(rel time.Time, opts ...Option) (time.Time, error) {
+	l.record(TraceNext, rel, opts)
+	return l.Limiter.Next(rel, opts...)
+}
+
+func (l *traced) Wait(cxt context.Context, rel time.Time, opts ...Option) (time.Time, error) {
+	l.record(TraceWait, rel, opts)
+	return l.Limiter.Wait(cxt, rel, opts...)
+}
+
+func (l *traced) Update(rel time.Time, opts ...Option) error {
+	l.record(TraceUpdate, rel, opts)
+	return l.Limiter.Update(rel, opts...)
+}
+
+// A ReplayResult describes the outcome of a replayed operation
+type ReplayResult struct {
+	Entry TraceEntry
+	// The time at which the operation was permitted, for Next and Wait
+	Next time.Time
+	// The error produced by the operation, if any
+	Err error
+}
+
+// Replay reads a trace produced by Trace and performs the recorded operations
+// on the provided limiter, in order, at the times they were recorded. Waits
+// are replayed with Next, so that replay never blocks; since times are taken
+// from the trace rather than the clock, a replay is deterministic. The outcome
+// of every operation is returned.
+func Replay(r io.Reader, lim Limiter) ([]ReplayResult, error) {
+	var res []ReplayResult
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var e TraceEntry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		} else if err != nil {
+			return res, fmt.Errorf("Could not read trace entry #%d: %w", len(res), err)
+		}
+		x := ReplayResult{Entry: e}
+		switch e.Op {
+		case TraceNext, TraceWait:
+			x.Next, x.Err = lim.Next(e.Time, e.options()...)
+		case TraceUpdate:
+			x.Err = lim.Update(e.Time, e.options()...)
+		default:
+			return res, fmt.Errorf("Invalid operation in trace entry #%d: %s", len(res), e.Op)
+		}
+		res = append(res, x)
+	}
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceReplay(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	conf := Config{Start: now, Window: time.Minute, Events: 10, Mode: Meter, ResetSemantics: Delta}
+	update := func(rem int) Attrs {
+		return Attrs{"Ratelimit-Limit": {"10"}, "Ratelimit-Remaining": {strconv.Itoa(rem)}, "Ratelimit-Reset": {"60"}}
+	}
+
+	// record a run, including a backoff requested by the service
+	buf := &bytes.Buffer{}
+	lim := Trace(NewHeaders(conf), buf)
+	var expect []time.Time
+	for i := 0; i < 5; i++ {
+		rel := now.Add(time.Second * time.Duration(i*5))
+		next, err := lim.Next(rel, WithKey("a"))
+		assert.NoError(t, err)
+		expect = append(expect, next)
+		lim.Update(next, WithAttrs(update(8-i)))
+	}
+	lim.Update(now.Add(time.Second*30), WithAttrs(Attrs{"Retry-After": {"10"}}))
+	next, err := lim.Wait(context.Background(), now.Add(time.Second*40))
+	assert.NoError(t, err)
+	expect = append(expect, next)
+	assert.NoError(t, lim.Err())
+
+	// replaying the trace against a new limiter reproduces the schedule
+	res, err := Replay(bytes.NewReader(buf.Bytes()), NewHeaders(conf))
+	if assert.NoError(t, err) && assert.Len(t, res, 12) {
+		var actual []time.Time
+		for _, e := range res {
+			if e.Entry.Op != TraceUpdate {
+				actual = append(actual, e.Next)
+			}
+		}
+		assert.Equal(t, expect, actual)
+		assert.Equal(t, "a", res[0].Entry.Key)
+		assert.ErrorIs(t, res[10].Err, ErrBackoff)
+		assert.Equal(t, now.Add(time.Second*40), res[11].Next)
+	}
+
+	// invalid traces are reported
+	_, err = Replay(strings.NewReader(`{"op":"explode"}`), NewHeaders(conf))
+	assert.ErrorContains(t, err, "Invalid operation")
+	_, err = Replay(strings.NewReader(`{"op":`), NewHeaders(conf))
+	assert.ErrorContains(t, err, "Could not read trace entry")
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"net/http"
+	"time"
+)
+
+// Transport configuration
+type TransportConfig struct {
+	// Classifies the outcome of each request before it is provided to the limiter; if nil, the limiter classifies it
+	Classifier Classifier
+}
+
+// With applies additional options to the receiver
+func (c TransportConfig) With(opts []TransportOption) TransportConfig {
+	for _, opt := range opts {
+		c = opt(c)
+	}
+	return c
+}
+
+// A functional transport option
+type TransportOption func(TransportConfig) TransportConfig
+
+// WithClassifier sets the classifier which decides the outcome of each
+// request, which is provided to the limiter via WithVerdict. If it is a
+// BodyClassifier, it is also provided the beginning of each response body.
+func WithClassifier(c Classifier) TransportOption {
+	return func(conf TransportConfig) TransportConfig {
+		conf.Classifier = c
+		return conf
+	}
+}
+
+// transport implements an http.RoundTripper which paces the requests it
+// performs with a limiter and updates the limiter from their responses
+type transport struct {
+	lim     Limiter
+	base    http.RoundTripper
+	classes Classifier
+}
+
+// NewTransport creates an http.RoundTripper which waits on the limiter before
+// each request it performs, using the request's context, and then provides
+// the response to Update, including the beginning of its body, if the limiter
+// learns from it, as GraphQL cost limiters do. Each request is tracked as in flight, if the
+// limiter tracks them, until its response is received.
+//
+// A request the limiter refuses fails with the limiter's error. Errors from
+// Update, such as a RetryError for a throttled response, do not cause the
+// request to fail; the response is returned as usual. A request which fails
+// is provided to Update via WithError. If the base transport is nil,
+// http.DefaultTransport is used.
+func NewTransport(lim Limiter, base http.RoundTripper, opts ...TransportOption) *transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	conf := TransportConfig{}.With(opts)
+	return &transport{lim: lim, base: base, classes: conf.Classifier}
+}
+
+// Limiters which learn their state from response bodies
+type bodyUpdater interface {
+	updatesFromBody()
+}
+
+// Determine whether the beginning of each response body must be read, for
+// the classifier or the limiter
+func (t *transport) reads() bool {
+	if _, ok := t.classes.(BodyClassifier); ok {
+		return true
+	}
+	_, ok := t.lim.(bodyUpdater)
+	return ok
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var done func()
+	_, err := t.lim.Wait(req.Context(), time.Now(), WithRequest(req), WithCompletion(&done))
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	rsp, err := t.base.RoundTrip(req)
+	done() // the response, if any, now reflects the request
+	opts := []Option{WithLatency(time.Since(start))}
+	if err != nil {
+		opts = append(opts, WithError(err))
+	} else {
+		opts = append(opts, WithResponse(rsp))
+	}
+	var body []byte
+	if rsp != nil && t.reads() {
+		body = peekBody(rsp)
+		opts = append(opts, WithResponseBody(body))
+	}
+	if t.classes != nil {
+		var attrs Attrs
+		var status int
+		if rsp != nil {
+			attrs, status = AttrsFromResponse(rsp), rsp.StatusCode
+		}
+		var v Verdict
+		if b, ok := t.classes.(BodyClassifier); ok && rsp != nil {
+			v = b.ClassifyBody(attrs, status, body, err)
+		} else {
+			v = t.classes.Classify(attrs, status, err)
+		}
+		opts = append(opts, WithVerdict(v))
+	}
+	t.lim.Update(time.Now(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
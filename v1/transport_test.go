@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Ratelimit-Limit", "10")
+		w.Header().Set("X-Ratelimit-Remaining", strconv.Itoa(10-int(n.Add(1))))
+		w.Header().Set("X-Ratelimit-Reset", "60")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst, ResetSemantics: Delta, InFlight: true})
+	client := &http.Client{Transport: NewTransport(lim, nil)}
+	for i := 0; i < 3; i++ {
+		rsp, err := client.Get(srv.URL)
+		if assert.NoError(t, err, "#%d", i) {
+			rsp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, rsp.StatusCode, "#%d", i)
+			assert.Equal(t, 10-(i+1), lim.State(time.Now()).Remaining, "#%d", i)
+		}
+	}
+	lim.impl.Lock()
+	assert.Equal(t, 0, lim.impl.flying+lim.impl.held)
+	lim.impl.Unlock()
+
+	// requests the limiter refuses are never performed
+	lim = NewHeaders(Config{Window: time.Minute, Events: 0, Mode: Burst, Strict: true})
+	client = &http.Client{Transport: NewTransport(lim, nil)}
+	_, err := client.Get(srv.URL)
+	assert.ErrorIs(t, err, ErrExhausted)
+	assert.Equal(t, int32(3), n.Load())
+}
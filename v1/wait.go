@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// waiters tracks the callers blocked in a limiter's Wait method so that the
+// limiter can be drained: once draining, new callers are turned away while
+// those already waiting are permitted to complete. It also holds new callers
+// while the limiter is paused, and wakes every caller when it is closed.
+// Limiters embed it to provide Drain, Pending, Pause, Resume, Paused, Close,
+// and Closed.
+type waiters struct {
+	mu       sync.Mutex
+	count    int
+	draining bool
+	idle     chan struct{} // closed when the last waiter leaves while draining
+	paused   chan struct{} // closed when resumed, if we are paused
+	closed   bool
+	done     context.Context // canceled, with ErrClosed as its cause, when we are closed
+	close    context.CancelCauseFunc
+}
+
+// Produce the context which is canceled when we are closed; the caller must
+// hold the lock
+func (w *waiters) closing() context.Context {
+	if w.done == nil {
+		w.done, w.close = context.WithCancelCause(context.Background())
+	}
+	return w.done
+}
+
+// Admit a caller, unless we are draining or closed; the caller must leave
+// once it is done waiting. If we are paused, the caller is held until we are
+// resumed, we are closed, or the context is canceled; the reference time is
+// advanced by the time spent held.
+//
+// This is on the path of every wait, so nothing is allocated: callers which
+// wait wake when we are closed by blocking via sleep or pauseOn, rather than
+// on a context derived from their own.
+func (w *waiters) enter(cxt context.Context, rel time.Time) (time.Time, error) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return rel, ErrClosed
+	} else if w.draining {
+		w.mu.Unlock()
+		return rel, ErrDraining
+	}
+	w.count++
+	p, done := w.paused, w.closing().Done()
+	w.mu.Unlock()
+
+	if p != nil {
+		start := time.Now()
+		select {
+		case <-p:
+			rel = rel.Add(time.Since(start))
+		case <-done:
+			w.leave()
+			return rel, ErrClosed
+		case <-cxt.Done():
+			w.leave()
+			return rel, canceled(cxt)
+		}
+	}
+	return rel, nil
+}
+
+// Release a caller previously admitted
+func (w *waiters) leave() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.count--
+	if w.count == 0 && w.idle != nil {
+		close(w.idle)
+		w.idle = nil
+	}
+}
+
+// Derive a context for a caller which waits on another limiter, which is
+// canceled, with ErrClosed as its cause, if we are closed while the caller
+// waits; the caller must cancel it once it is done waiting.
+func (w *waiters) derive(cxt context.Context) (context.Context, context.CancelFunc) {
+	w.mu.Lock()
+	done := w.closing()
+	w.mu.Unlock()
+	wc, cancel := context.WithCancelCause(cxt)
+	stop := context.AfterFunc(done, func() { cancel(ErrClosed) })
+	return wc, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// Block until the provided time, relative to the reference time, until the
+// context is canceled, or until we are closed, in which case the result is
+// ErrClosed
+func (w *waiters) sleep(cxt context.Context, rel, t time.Time) (time.Time, error) {
+	if !t.After(rel) { // the next window is at or before the reference time: don't wait
+		return rel, nil
+	}
+	if err := w.pauseOn(cxt, t.Sub(rel), nil); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// Block for the provided duration, until the provided channel is closed, until
+// the context is canceled, or until we are closed, in which case the result is
+// ErrClosed
+func (w *waiters) pauseOn(cxt context.Context, d time.Duration, ch <-chan struct{}) error {
+	w.mu.Lock()
+	done := w.closing().Done()
+	w.mu.Unlock()
+	return block(cxt, d, ch, done)
+}
+
+// Close wakes the callers blocked in Wait, which fail with ErrClosed, and
+// turns new callers away, so that none outlive the limiter, as when they wait
+// with background contexts at shutdown.
+func (w *waiters) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.closing()
+	w.close(ErrClosed)
+	return nil
+}
+
+// Closed reports whether the limiter is closed
+func (w *waiters) Closed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closed
+}
+
+// Determine the error describing why a wait was interrupted: ErrClosed if the
+// limiter was closed, otherwise ErrCanceled
+func canceled(cxt context.Context) error {
+	if errors.Is(context.Cause(cxt), ErrClosed) {
+		return ErrClosed
+	}
+	return ErrCanceled
+}
+
+// Pending returns the number of callers currently blocked in Wait
+func (w *waiters) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Drain stops admitting new callers to Wait, which fail with ErrDraining, and
+// blocks until the callers already waiting have completed or the context is
+// canceled.
+func (w *waiters) Drain(cxt context.Context) error {
+	w.mu.Lock()
+	w.draining = true
+	if w.count == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	if w.idle == nil {
+		w.idle = make(chan struct{})
+	}
+	idle := w.idle
+	w.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-cxt.Done():
+		return ErrCanceled
+	}
+}
+
+// Pause holds new callers to Wait until the limiter is resumed. Callers which
+// are already waiting are unaffected.
+func (w *waiters) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused == nil {
+		w.paused = make(chan struct{})
+	}
+}
+
+// Resume releases the callers held while the limiter was paused
+func (w *waiters) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused != nil {
+		close(w.paused)
+		w.paused = nil
+	}
+}
+
+// Paused reports whether the limiter is paused
+func (w *waiters) Paused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paused != nil
+}
+
+// Reject an operation which would wait longer than the maximum, if there is one
+func overloaded(max time.Duration, rel time.Time, d time.Duration) error {
+	if max > 0 && d > max {
+		return OverloadError{Next: rel.Add(d)}
+	}
+	return nil
+}
+
+// Extend the delay until a time by a random proportion of itself, up to the
+// provided maximum
+func jitter(p float64, rel, t time.Time) time.Time {
+	if p <= 0 || !t.After(rel) {
+		return t
+	}
+	return t.Add(time.Duration(rand.Float64() * p * float64(t.Sub(rel))))
+}
+
+// Timers are pooled so that waits which are canceled early, which are common
+// when callers have deadlines, neither allocate a new timer nor leave one
+// running until it would have fired.
+var timers = sync.Pool{
+	New: func() any {
+		t := time.NewTimer(time.Hour)
+		t.Stop()
+		return t
+	},
+}
+
+// Block for the provided duration or until the context is canceled
+func pause(cxt context.Context, d time.Duration) error {
+	return block(cxt, d, nil, nil)
+}
+
+// Block for the provided duration, until the provided channel is closed, or
+// until the context is canceled or the done channel is closed, in which case
+// the result is ErrClosed
+func block(cxt context.Context, d time.Duration, ch, done <-chan struct{}) error {
+	t := timers.Get().(*time.Timer)
+	t.Reset(d)
+	defer func() {
+		if !t.Stop() {
+			select { // drain the channel if the timer fired but was not received
+			case <-t.C:
+			default:
+			}
+		}
+		timers.Put(t)
+	}()
+	select {
+	case <-t.C:
+		return nil
+	case <-ch:
+		return nil
+	case <-done:
+		return ErrClosed
+	case <-cxt.Done():
+		return canceled(cxt)
+	}
+}
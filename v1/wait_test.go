@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrain(t *testing.T) {
+	lim := NewHeaders(Config{Window: time.Minute, Events: 10, Mode: Burst})
+	lim.impl.Update(10, 0, time.Now().Add(time.Millisecond*50))
+
+	done := make(chan error)
+	go func() {
+		_, err := lim.Wait(context.Background(), time.Now())
+		done <- err
+	}()
+	until := func(f func() bool) {
+		for {
+			lim.waiters.mu.Lock()
+			ok := f()
+			lim.waiters.mu.Unlock()
+			if ok {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	until(func() bool { return lim.waiters.count > 0 }) // wait for the caller to be admitted
+
+	drained := make(chan error)
+	go func() {
+		drained <- lim.Drain(context.Background())
+	}()
+	until(func() bool { return lim.waiters.draining })
+
+	_, err := lim.Wait(context.Background(), time.Now())
+	assert.ErrorIs(t, err, ErrDraining)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-drained)
+
+	// draining with nobody waiting completes immediately
+	assert.NoError(t, lim.Drain(context.Background()))
+}
+
+func TestClose(t *testing.T) {
+	lims := []interface {
+		Limiter
+		Close() error
+		Closed() bool
+		Pending() int
+	}{
+		NewHeaders(Config{Window: time.Hour, Events: 1, Mode: Burst}),
+		NewLinear(Config{Window: time.Hour, Events: 1}),
+		NewKeyed(func(string) Limiter {
+			return NewLinear(Config{Window: time.Hour, Events: 1})
+		}),
+	}
+	for i, lim := range lims {
+		lim.Next(time.Now())
+		done := make(chan error)
+		go func() {
+			_, err := lim.Wait(context.Background(), time.Now())
+			done <- err
+		}()
+		for lim.Pending() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		assert.NoError(t, lim.Close(), "#%d", i)
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, ErrClosed, "#%d", i)
+		case <-time.After(time.Second):
+			assert.Fail(t, "Waiter was not woken", "#%d", i)
+		}
+		assert.True(t, lim.Closed(), "#%d", i)
+		assert.Equal(t, 0, lim.Pending(), "#%d", i)
+		_, err := lim.Wait(context.Background(), time.Now())
+		assert.ErrorIs(t, err, ErrClosed, "#%d", i)
+	}
+
+	// canceling a context is still reported as such
+	lim := NewLinear(Config{Window: time.Hour, Events: 1})
+	lim.Next(time.Now())
+	cxt, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := lim.Wait(cxt, time.Now())
+	assert.ErrorIs(t, err, ErrCanceled)
+}
+
+func TestEstimatedWait(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC)
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 2, Mode: Burst})
+	for i := 0; i < 5; i++ { // estimating doesn't consume anything
+		assert.Equal(t, time.Duration(0), lim.EstimatedWait(now))
+	}
+	lim.Next(now)
+	lim.Next(now)
+	assert.Equal(t, time.Minute, lim.EstimatedWait(now))
+	assert.Equal(t, 0, lim.Pending())
+
+	lin := NewLinear(Config{Start: now, Window: time.Minute, Events: 6})
+	assert.Equal(t, time.Second*9, lin.EstimatedWait(now.Add(time.Second)))
+}
+
+func TestMaxWait(t *testing.T) {
+	now := time.Now()
+	lim := NewHeaders(Config{Start: now, Window: time.Minute, Events: 1, Mode: Burst, MaxWait: time.Second})
+	_, err := lim.Wait(context.Background(), now)
+	assert.NoError(t, err)
+	_, err = lim.Wait(context.Background(), now)
+	var oerr OverloadError
+	if assert.ErrorAs(t, err, &oerr) {
+		assert.ErrorIs(t, err, ErrOverloaded)
+		assert.Equal(t, now.Add(time.Minute), oerr.Next)
+	}
+
+	lin := NewLinear(Config{Window: time.Hour, Events: 1, MaxWait: time.Second})
+	_, err = lin.Wait(context.Background(), time.Now())
+	assert.ErrorIs(t, err, ErrOverloaded)
+}
+
+func BenchmarkSleepCanceled(b *testing.B) {
+	cxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	now := time.Now()
+	w := &waiters{}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.sleep(cxt, now, now.Add(time.Hour))
+		}
+	})
+}
+
+func BenchmarkWait(b *testing.B) {
+	now := time.Now()
+	lim := NewHeaders(Config{Start: now, Window: time.Hour, Events: math.MaxInt32, Mode: Burst})
+	cxt := context.Background()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lim.Wait(cxt, now)
+		}
+	})
+}
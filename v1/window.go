@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/bww/go-util/v1/ext"
+)
+
+const day = time.Hour * 24
+
+// Determine if windows of the provided duration follow the calendar in a
+// particular location, which is the case when a location is configured and
+// the window is a whole number of days.
+func (c Config) calendar(window time.Duration) bool {
+	return c.Location != nil && window > 0 && window%day == 0
+}
+
+// Determine the beginning of the first window; this is Start, or the current
+// time if Start is not set, which is moved back to a window boundary if
+// windows are aligned.
+func (c Config) origin() time.Time {
+	t := ext.Coalesce(c.Start, time.Now())
+	if c.calendar(c.Window) {
+		if c.Align {
+			y, m, d := t.In(c.Location).Date()
+			t = time.Date(y, m, d, 0, 0, 0, 0, c.Location)
+		}
+	} else if c.Align {
+		t = t.Truncate(c.Window)
+	}
+	return t
+}
+
+// Determine the end of the first window
+func (c Config) firstReset() time.Time {
+	t := c.origin()
+	_, end := c.bounds(t, t, c.Window)
+	return end
+}
+
+// Determine the beginning and end of the window of the provided duration
+// containing the reference time, where windows begin at the base time.
+func (c Config) bounds(base, rel time.Time, window time.Duration) (time.Time, time.Time) {
+	if window <= 0 {
+		return base, base
+	} else if !c.calendar(window) {
+		n := rel.Sub(base) / window
+		if rel.Before(base) && rel.Sub(base)%window != 0 {
+			n--
+		}
+		start := base.Add(n * window)
+		return start, start.Add(window)
+	}
+	// count whole calendar days, which vary in length across daylight-saving
+	// transitions, and find the window in terms of days from the base
+	days := int(window / day)
+	b := base.In(c.Location)
+	start := func(n int) time.Time {
+		return time.Date(b.Year(), b.Month(), b.Day()+n*days, b.Hour(), b.Minute(), b.Second(), b.Nanosecond(), c.Location)
+	}
+	r := rel.In(c.Location)
+	by, bm, bd := b.Date()
+	ry, rm, rd := r.Date()
+	diff := int(time.Date(ry, rm, rd, 0, 0, 0, 0, time.UTC).Sub(time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC)) / day)
+	n := diff / days
+	if diff%days != 0 && diff < 0 {
+		n--
+	}
+	// the window may begin later in the day than the reference time
+	for s := start(n); s.After(rel); s = start(n) {
+		n--
+	}
+	return start(n), start(n + 1)
+}
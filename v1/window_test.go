@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBounds(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if !assert.NoError(t, err) {
+		return
+	}
+	at := func(d, h int) time.Time { return time.Date(2024, 3, d, h, 0, 0, 0, pacific) }
+	tests := []struct {
+		Name        string
+		Location    *time.Location
+		Window      time.Duration
+		Base, Rel   time.Time
+		Start, Stop time.Time
+	}{
+		{"Fixed", nil, time.Hour, at(9, 0), at(9, 5).Add(time.Minute * 30), at(9, 5), at(9, 6)},
+		{"FixedBefore", nil, time.Hour, at(9, 0), at(8, 23).Add(time.Minute * 30), at(8, 23), at(9, 0)},
+		{"Naive", nil, day, at(9, 0), at(11, 12), at(11, 1), at(12, 1)}, // drifts an hour after the transition
+		{"CalendarShort", pacific, day, at(9, 0), at(10, 12), at(10, 0), at(10, 0).Add(time.Hour * 23)},
+		{"CalendarAfter", pacific, day, at(9, 0), at(11, 12), at(11, 0), at(12, 0)},
+		{"CalendarOffset", pacific, day, at(9, 3), at(11, 1), at(10, 3), at(11, 3)},
+		{"CalendarMultiple", pacific, day * 2, at(8, 0), at(11, 12), at(10, 0), at(12, 0)},
+		{"CalendarBefore", pacific, day, at(9, 0), at(7, 12), at(7, 0), at(8, 0)},
+	}
+	for _, e := range tests {
+		t.Run(e.Name, func(t *testing.T) {
+			start, stop := Config{Location: e.Location}.bounds(e.Base, e.Rel, e.Window)
+			assert.True(t, e.Start.Equal(start), "start: expected %v, got %v", e.Start, start)
+			assert.True(t, e.Stop.Equal(stop), "stop: expected %v, got %v", e.Stop, stop)
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if !assert.NoError(t, err) {
+		return
+	}
+	start := time.Date(2024, 3, 9, 15, 30, 0, 0, pacific)
+	conf := Config{Start: start, Window: day, Events: 10000, Align: true, Location: pacific}
+	rel := time.Date(2024, 3, 10, 12, 0, 0, 0, pacific)
+	reset := time.Date(2024, 3, 11, 0, 0, 0, 0, pacific)
+
+	assert.True(t, reset.Equal(NewLinear(conf).State(rel).Reset))
+	assert.True(t, time.Date(2024, 3, 10, 0, 0, 0, 0, pacific).Equal(NewHeaders(conf).State(start).Reset))
+	shared := NewShared(conf, NewMemoryStore(), "location")
+	shared.Next(rel)
+	assert.True(t, reset.Equal(shared.State(rel).Reset))
+}
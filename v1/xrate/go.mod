@@ -0,0 +1,18 @@
+module github.com/bww/go-ratelimit/v1/xrate
+
+go 1.22.3
+
+require (
+	github.com/bww/go-ratelimit v0.0.0
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/time v0.10.0
+)
+
+require (
+	github.com/bww/go-util v1.34.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/bww/go-ratelimit => ../..
@@ -0,0 +1,119 @@
+// Package xrate adapts between rate limiters from this module and token
+// bucket limiters from golang.org/x/time/rate, so that code which is built on
+// either can use the other:
+//
+//	lim := xrate.From(rate.NewLimiter(10, 1))    // a ratelimit.Limiter
+//	bucket := xrate.To(ratelimit.NewLinear(conf)) // a *rate.Limiter
+package xrate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"golang.org/x/time/rate"
+)
+
+// limiter adapts a token bucket limiter to the Limiter interface
+type limiter struct {
+	lim *rate.Limiter
+}
+
+// From adapts a token bucket limiter to the Limiter interface. Each operation
+// consumes one token; Update has no effect, since the token bucket is not
+// informed by the results of operations.
+func From(lim *rate.Limiter) ratelimit.Limiter {
+	return limiter{lim: lim}
+}
+
+func (l limiter) Next(rel time.Time, opts ...ratelimit.Option) (time.Time, error) {
+	r := l.lim.ReserveN(rel, 1)
+	if !r.OK() {
+		return time.Time{}, fmt.Errorf("%w: The token bucket has no capacity", ratelimit.ErrExhausted)
+	}
+	return rel.Add(r.DelayFrom(rel)), nil
+}
+
+func (l limiter) Wait(cxt context.Context, rel time.Time, opts ...ratelimit.Option) (time.Time, error) {
+	r := l.lim.ReserveN(rel, 1)
+	if !r.OK() {
+		return time.Time{}, fmt.Errorf("%w: The token bucket has no capacity", ratelimit.ErrExhausted)
+	}
+	d := r.DelayFrom(rel)
+	if d <= 0 {
+		return rel, nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return rel.Add(d), nil
+	case <-cxt.Done():
+		r.Cancel() // return the token for others to use
+		return rel.Add(d), ratelimit.ErrCanceled
+	}
+}
+
+func (l limiter) Update(time.Time, ...ratelimit.Option) error {
+	return nil
+}
+
+// State describes the token bucket: the limit is its burst, the remaining
+// quota is the number of whole tokens available, and the reset is when the
+// bucket will be full.
+func (l limiter) State(rel time.Time) ratelimit.State {
+	burst, tokens := l.lim.Burst(), l.lim.TokensAt(rel)
+	var reset time.Time
+	if r := l.lim.Limit(); r == rate.Inf {
+		reset = rel
+	} else if r > 0 && tokens < float64(burst) {
+		reset = rel.Add(time.Duration((float64(burst) - tokens) / float64(r) * float64(time.Second)))
+	} else {
+		reset = rel
+	}
+	return ratelimit.State{
+		Limit:     burst,
+		Remaining: max(0, int(tokens)),
+		Reset:     reset,
+	}
+}
+
+// To produces a token bucket limiter which approximates the rate currently
+// permitted by a limiter: its remaining quota spread over the time until its
+// window resets, with a burst of the remaining quota. This is a snapshot; as
+// the state of the limiter changes, e.g., when a header-driven limiter is
+// updated, use Sync to adjust the token bucket to match.
+func To(lim ratelimit.Limiter) *rate.Limiter {
+	r, b := approximate(lim.State(time.Now()), time.Now())
+	return rate.NewLimiter(r, b)
+}
+
+// Sync adjusts a token bucket limiter to approximate the rate currently
+// permitted by a limiter, as To does. Tokens in excess of the limiter's
+// remaining quota are discarded.
+func Sync(bucket *rate.Limiter, lim ratelimit.Limiter) {
+	now := time.Now()
+	st := lim.State(now)
+	r, b := approximate(st, now)
+	bucket.SetLimitAt(now, r)
+	bucket.SetBurstAt(now, b)
+	if st.Limit > 0 {
+		if n := int(bucket.TokensAt(now)) - max(0, st.Remaining); n > 0 {
+			bucket.ReserveN(now, n)
+		}
+	}
+}
+
+// Determine the token bucket rate and burst which approximate a limiter's state
+func approximate(st ratelimit.State, rel time.Time) (rate.Limit, int) {
+	d := st.Reset.Sub(rel)
+	if st.Limit <= 0 || d <= 0 {
+		return rate.Inf, max(1, st.Limit) // we know of no constraint
+	}
+	if st.Remaining <= 0 {
+		return rate.Limit(math.SmallestNonzeroFloat64), 1 // nothing is permitted until the reset
+	}
+	return rate.Limit(float64(st.Remaining) / d.Seconds()), st.Remaining
+}
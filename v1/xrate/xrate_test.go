@@ -0,0 +1,73 @@
+package xrate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ratelimit "github.com/bww/go-ratelimit/v1"
+	"github.com/bww/go-ratelimit/v1/ratelimittest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestFrom(t *testing.T) {
+	now := time.Now()
+	lim := From(rate.NewLimiter(rate.Every(time.Second), 2))
+
+	st := lim.State(now)
+	assert.Equal(t, 2, st.Limit)
+	assert.Equal(t, 2, st.Remaining)
+	assert.Equal(t, now, st.Reset)
+
+	for i, e := range []time.Time{
+		now,
+		now,
+		now.Add(time.Second),
+		now.Add(time.Second * 2),
+	} {
+		n, err := lim.Next(now)
+		if assert.NoError(t, err, "#%d", i) {
+			assert.Equal(t, e, n, "#%d", i)
+		}
+	}
+
+	st = lim.State(now)
+	assert.Equal(t, 0, st.Remaining)
+	assert.Equal(t, now.Add(time.Second*4), st.Reset)
+
+	_, err := From(rate.NewLimiter(0, 0)).Next(now)
+	assert.ErrorIs(t, err, ratelimit.ErrExhausted)
+}
+
+func TestFromWait(t *testing.T) {
+	x := rate.NewLimiter(rate.Every(time.Hour), 1)
+	lim := From(x)
+
+	now := time.Now()
+	n, err := lim.Wait(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, now, n)
+
+	cxt, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err = lim.Wait(cxt, time.Now())
+	assert.ErrorIs(t, err, ratelimit.ErrCanceled)
+	assert.InDelta(t, 0, x.Tokens(), 0.01) // the canceled reservation was returned
+}
+
+func TestTo(t *testing.T) {
+	lim := ratelimit.NewLinear(ratelimit.Config{Window: time.Hour, Events: 3600})
+	x := To(lim)
+	assert.InDelta(t, 1, float64(x.Limit()), 0.1)
+	assert.LessOrEqual(t, x.Burst(), 3600)
+	assert.Greater(t, x.Burst(), 0)
+
+	x = To(From(rate.NewLimiter(rate.Inf, 0)))
+	assert.Equal(t, rate.Inf, x.Limit())
+
+	fake := ratelimittest.NewFake()
+	fake.SetState(ratelimit.State{Limit: 10, Remaining: 0, Reset: time.Now().Add(time.Minute)})
+	Sync(x, fake)
+	assert.False(t, x.Allow())
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/bww/go-ratelimit/v1"
+)
+
+// FromV1 adapts a v1 limiter to the v2 interface. Reference times which are
+// not provided are taken from the clock; if the clock is nil, the system clock
+// is used.
+func FromV1(l v1.Limiter, c Clock) Limiter {
+	if c == nil {
+		c = System
+	}
+	return fromV1{l, c}
+}
+
+type fromV1 struct {
+	impl  v1.Limiter
+	clock Clock
+}
+
+func (l fromV1) rel(opts Options) time.Time {
+	if !opts.Time.IsZero() {
+		return opts.Time
+	} else {
+		return l.clock.Now()
+	}
+}
+
+func (l fromV1) opts(opts Options) []v1.Option {
+	if opts.Attrs != nil {
+		return []v1.Option{v1.WithAttrs(opts.Attrs)}
+	} else {
+		return nil
+	}
+}
+
+func (l fromV1) Next(cxt context.Context, opts Options) (time.Time, error) {
+	if err := cxt.Err(); err != nil {
+		return time.Time{}, ErrCanceled
+	}
+	return l.impl.Next(l.rel(opts), l.opts(opts)...)
+}
+
+func (l fromV1) Wait(cxt context.Context, opts Options) (time.Time, error) {
+	return l.impl.Wait(cxt, l.rel(opts), l.opts(opts)...)
+}
+
+func (l fromV1) Update(cxt context.Context, opts Options) error {
+	return l.impl.Update(l.rel(opts), l.opts(opts)...)
+}
+
+func (l fromV1) State(cxt context.Context, opts Options) State {
+	return l.impl.State(l.rel(opts))
+}
+
+// ToV1 adapts a v2 limiter to the v1 interface. Since v1 methods other than
+// Wait do not accept a context, a background context is used for them.
+func ToV1(l Limiter) v1.Limiter {
+	if f, ok := l.(fromV1); ok {
+		return f.impl // unwrap rather than adapting twice
+	}
+	return toV1{l}
+}
+
+type toV1 struct {
+	impl Limiter
+}
+
+func (l toV1) opts(rel time.Time, opts []v1.Option) Options {
+	return Options{
+		Time:  rel,
+		Attrs: v1.Options{}.With(opts).Attrs,
+	}
+}
+
+func (l toV1) Next(rel time.Time, opts ...v1.Option) (time.Time, error) {
+	return l.impl.Next(context.Background(), l.opts(rel, opts))
+}
+
+func (l toV1) Wait(cxt context.Context, rel time.Time, opts ...v1.Option) (time.Time, error) {
+	return l.impl.Wait(cxt, l.opts(rel, opts))
+}
+
+func (l toV1) Update(rel time.Time, opts ...v1.Option) error {
+	return l.impl.Update(context.Background(), l.opts(rel, opts))
+}
+
+func (l toV1) State(rel time.Time) State {
+	return l.impl.State(context.Background(), Options{Time: rel})
+}
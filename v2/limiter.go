@@ -0,0 +1,86 @@
+// Package ratelimit (v2) is a context-first API over the v1 rate limiters.
+// Every method takes a context first, the reference time is optional and
+// defaults to the limiter's clock, and options are provided as a struct rather
+// than as variadic closures.
+//
+// The limiter implementations are shared with v1; adapters are provided in
+// both directions so the two APIs can be used together during migration.
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/bww/go-ratelimit/v1"
+)
+
+// Types shared with v1
+type (
+	State      = v1.State
+	Attrs      = v1.Attrs
+	Config     = v1.Config
+	Mode       = v1.Mode
+	Durationer = v1.Durationer
+	RetryError = v1.RetryError
+)
+
+// Modes shared with v1
+const (
+	Meter  = v1.Meter
+	Burst  = v1.Burst
+	Smooth = v1.Smooth
+)
+
+// Errors shared with v1
+var (
+	ErrCanceled       = v1.ErrCanceled
+	ErrMissingAttrs   = v1.ErrMissingAttrs
+	ErrMissingHeaders = v1.ErrMissingHeaders
+)
+
+// A Clock provides the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to the Clock interface
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// The system clock
+var System Clock = ClockFunc(time.Now)
+
+// Options provides addional contextual details to a rate limiter
+type Options struct {
+	// The reference time; if this is zero the limiter's clock is used
+	Time time.Time
+	// Attributes which may be factored into rate limiting
+	Attrs Attrs
+}
+
+// A general purpose rate limiter
+type Limiter interface {
+	// Next returns the time at which the next request can be executed relative to the reference time.
+	Next(context.Context, Options) (time.Time, error)
+	// Wait blocks until the next request can be executed.
+	Wait(context.Context, Options) (time.Time, error)
+	// Update provides post-operation feedback to the rate limiter. An implementation may use this context or not.
+	Update(context.Context, Options) error
+	// State provides a snapshot of the rate limiter's general state. Not all implementations can fully describe this state.
+	State(context.Context, Options) State
+}
+
+// NewHeaders creates a header-driven limiter using the system clock. See the
+// v1 package for details.
+func NewHeaders(conf Config) Limiter {
+	return FromV1(v1.NewHeaders(conf), nil)
+}
+
+// NewLinear creates a linear limiter using the system clock. See the v1
+// package for details.
+func NewLinear(conf Config) Limiter {
+	return FromV1(v1.NewLinear(conf), nil)
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/bww/go-ratelimit/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	now := time.Date(2024, 4, 12, 0, 0, 1, 0, time.UTC)
+	conf := Config{
+		Start:  time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC),
+		Window: time.Minute,
+		Events: 6,
+	}
+	lim := FromV1(v1.NewLinear(conf), ClockFunc(func() time.Time { return now }))
+
+	next, err := lim.Next(context.Background(), Options{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Date(2024, 4, 12, 0, 0, 10, 0, time.UTC), next)
+	}
+	next, err = lim.Next(context.Background(), Options{Time: now.Add(time.Second * 10)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Date(2024, 4, 12, 0, 0, 20, 0, time.UTC), next)
+	}
+	assert.Equal(t, 5, lim.State(context.Background(), Options{}).Remaining)
+
+	cxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = lim.Next(cxt, Options{})
+	assert.ErrorIs(t, err, ErrCanceled)
+}
+
+func TestCompat(t *testing.T) {
+	conf := Config{
+		Start:  time.Date(2024, 4, 12, 0, 0, 0, 0, time.UTC),
+		Window: time.Minute,
+		Events: 6,
+	}
+	v := v1.NewLinear(conf)
+	assert.Equal(t, v, ToV1(FromV1(v, nil)))
+
+	lim := toV1{FromV1(v, nil)} // exercise the adapter rather than unwrapping
+	next, err := lim.Next(time.Date(2024, 4, 12, 0, 0, 1, 0, time.UTC))
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Date(2024, 4, 12, 0, 0, 10, 0, time.UTC), next)
+	}
+}